@@ -1,15 +1,28 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/spf13/viper"
+	"google.golang.org/grpc"
 
 	"wallet-api-server/internal/api"
+	"wallet-api-server/internal/auth"
 	"wallet-api-server/internal/cache"
 	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/events"
+	"wallet-api-server/internal/grpcapi"
+	"wallet-api-server/internal/idempotency"
+	"wallet-api-server/internal/ledger"
+	"wallet-api-server/internal/models"
+	"wallet-api-server/internal/openapi"
 	"wallet-api-server/internal/queue"
+	"wallet-api-server/internal/scheduler"
+	"wallet-api-server/internal/webhook"
 )
 
 func main() {
@@ -29,13 +42,128 @@ func main() {
 		log.Fatalf("Initializing DB tables error: %v", err)
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	idempotency.StartJanitor(ctx, dbProvider, time.Hour)
+	ledger.StartSnapshots(ctx, dbProvider, 15*time.Minute)
+
 	cacheInstance := &cache.BalanceCache{}
 	queueManager := queue.NewQueueManager(cacheInstance, dbProvider)
+	hub := events.NewHub(dbProvider)
+	queueManager.Events = hub
+	viper.SetDefault("EVENTS_RESYNC_INTERVAL", 5)
+	hub.Run(ctx, time.Duration(viper.GetInt("EVENTS_RESYNC_INTERVAL"))*time.Second)
+	queueManager.StartHoldExpiry(ctx, time.Minute)
+	viper.SetDefault("SCHEDULER_INTERVAL", 5)
+	scheduler.Start(ctx, dbProvider, queueManager, time.Duration(viper.GetInt("SCHEDULER_INTERVAL"))*time.Second)
+	viper.SetDefault("WEBHOOK_INTERVAL", 2)
+	webhook.Start(ctx, dbProvider, time.Duration(viper.GetInt("WEBHOOK_INTERVAL"))*time.Second)
 	handler := api.NewHandler(cacheInstance, queueManager, dbProvider)
+	handler.Events = hub
+
+	spec, err := openapi.Load()
+	if err != nil {
+		log.Fatalf("Failed to load the API specification: %v", err)
+	}
+
+	authenticator, err := auth.NewAuthenticator(dbProvider)
+	if err != nil {
+		log.Fatalf("Failed to load the JWT keys: %v", err)
+	}
 
 	r := gin.Default()
-	r.POST("/api/v1/wallet", handler.HandleWalletOperation)
-	r.GET("/api/v1/wallets/:walletId", handler.HandleGetBalance)
+	// The specification stays readable without credentials
+	r.GET("/openapi.json", spec.Serve)
+	if authenticator.Enabled() {
+		r.Use(auth.Middleware(authenticator))
+		// Keys with a signing secret have to sign every request
+		r.Use(auth.VerifySignature(dbProvider))
+		auth.StartNonceJanitor(ctx, dbProvider, time.Hour)
+	}
+	r.Use(spec.Validate())
+	// Wallets of other tenants look like wallets that do not exist
+	r.Use(handler.RequireTenantWallet)
+
+	// Without authentication every route is open. Handlers of operations
+	// that name their wallets in the body check the wallets themselves
+	read := auth.Require(models.SCOPE_BALANCE_READ)
+	admin := auth.Require(models.SCOPE_ADMIN)
+	operate := auth.RequireScope(models.SCOPE_DEPOSIT, models.SCOPE_WITHDRAW)
+	withdraw := auth.RequireScope(models.SCOPE_WITHDRAW)
+	operator := auth.RequireOperator()
+	r.POST("/api/v1/wallet", operate, handler.HandleWalletOperation)
+	r.POST("/api/v1/wallet/batch", operate, handler.HandleBatch)
+	r.POST("/api/v1/transfer", withdraw, handler.HandleTransfer)
+	r.POST("/api/v1/wallets", admin, handler.HandleCreateWallet)
+	r.GET("/api/v1/wallets", auth.RequireScope(models.SCOPE_BALANCE_READ), handler.HandleListWallets)
+	r.GET("/api/v1/wallets/:walletId", read, handler.HandleGetBalance)
+	r.POST("/api/v1/wallets/:walletId/freeze", admin, handler.HandleFreezeWallet)
+	r.POST("/api/v1/wallets/:walletId/unfreeze", admin, handler.HandleUnfreezeWallet)
+	r.POST("/api/v1/wallets/:walletId/close", admin, handler.HandleCloseWallet)
+	r.PUT("/api/v1/admin/wallets/:walletId/owner", admin, handler.HandleSetOwner)
+	r.PUT("/api/v1/admin/wallets/:walletId/credit-limit", admin, handler.HandleSetCreditLimit)
+	r.GET("/api/v1/admin/wallets/:walletId/credit-limit/changes", admin, handler.HandleListCreditLimitChanges)
+	r.GET("/api/v1/admin/wallets/:walletId/limits", admin, handler.HandleGetLimits)
+	r.PUT("/api/v1/admin/wallets/:walletId/limits", admin, handler.HandleSetLimits)
+	r.POST("/api/v1/admin/api-keys", admin, handler.HandleCreateAPIKey)
+	r.GET("/api/v1/admin/api-keys", admin, handler.HandleListAPIKeys)
+	r.POST("/api/v1/admin/api-keys/:id/rotate", admin, handler.HandleRotateAPIKey)
+	r.DELETE("/api/v1/admin/api-keys/:id", admin, handler.HandleRevokeAPIKey)
+	r.POST("/api/v1/admin/tenants", admin, operator, handler.HandleCreateTenant)
+	r.GET("/api/v1/admin/tenants", admin, operator, handler.HandleListTenants)
+	r.GET("/api/v1/admin/tenants/:tenantId", admin, operator, handler.HandleGetTenant)
+	r.PUT("/api/v1/admin/tenants/:tenantId", admin, operator, handler.HandleUpdateTenant)
+	r.POST("/api/v1/admin/tenants/:tenantId/api-keys", admin, operator, handler.HandleCreateTenantAPIKey)
+	r.GET("/api/v1/wallets/:walletId/transactions", read, handler.HandleListTransactions)
+	r.GET("/api/v1/wallets/:walletId/statement", read, handler.HandleStatement)
+	r.GET("/api/v1/wallets/:walletId/events", read, handler.HandleWalletEvents)
+	r.POST("/api/v1/operations/:operationId/reverse", admin, handler.HandleReverseOperation)
+	r.POST("/api/v1/scheduled", admin, handler.HandleScheduleOperation)
+	r.GET("/api/v1/scheduled", admin, handler.HandleListScheduled)
+	r.GET("/api/v1/scheduled/:id", admin, handler.HandleGetScheduled)
+	r.POST("/api/v1/scheduled/:id/cancel", admin, handler.HandleCancelScheduled)
+	r.POST("/api/v1/standing-orders", admin, handler.HandleCreateStandingOrder)
+	r.GET("/api/v1/standing-orders", admin, handler.HandleListStandingOrders)
+	r.GET("/api/v1/standing-orders/:id", admin, handler.HandleGetStandingOrder)
+	r.GET("/api/v1/standing-orders/:id/executions", admin, handler.HandleListStandingOrderExecutions)
+	r.POST("/api/v1/standing-orders/:id/pause", admin, handler.HandlePauseStandingOrder)
+	r.POST("/api/v1/standing-orders/:id/resume", admin, handler.HandleResumeStandingOrder)
+	r.DELETE("/api/v1/standing-orders/:id", admin, handler.HandleDeleteStandingOrder)
+	r.POST("/api/v1/webhooks", admin, handler.HandleCreateWebhook)
+	r.GET("/api/v1/webhooks", admin, handler.HandleListWebhooks)
+	r.DELETE("/api/v1/webhooks/:id", admin, handler.HandleDeleteWebhook)
+	r.GET("/api/v1/webhooks/:id/deliveries", admin, handler.HandleListWebhookDeliveries)
+	r.POST("/api/v1/webhooks/:id/deliveries/:deliveryId/redeliver", admin, handler.HandleRedeliverWebhook)
+	r.POST("/api/v1/holds", withdraw, handler.HandleCreateHold)
+	// The hold routes name no wallet, their handlers check the wallet of the hold
+	r.GET("/api/v1/holds/:holdId", auth.RequireScope(models.SCOPE_BALANCE_READ, models.SCOPE_WITHDRAW), handler.HandleGetHold)
+	r.POST("/api/v1/holds/:holdId/capture", withdraw, handler.HandleCaptureHold)
+	r.POST("/api/v1/holds/:holdId/release", withdraw, handler.HandleReleaseHold)
+
+	// v2 takes and returns amounts as strings or minor units, v1 stays as it is
+	v2 := r.Group("/api/v2")
+	v2.POST("/wallet", operate, handler.HandleWalletOperationV2)
+	v2.POST("/transfer", withdraw, handler.HandleTransferV2)
+	v2.GET("/wallets/:walletId", read, handler.HandleGetBalanceV2)
+	v2.GET("/wallets/:walletId/transactions", read, handler.HandleListTransactionsV2)
+
+	var grpcOptions []grpc.ServerOption
+	if authenticator.Enabled() {
+		grpcOptions = append(grpcOptions, grpcapi.AuthInterceptors(authenticator)...)
+	}
+	grpcServer := grpc.NewServer(grpcOptions...)
+	grpcapi.Register(grpcServer, grpcapi.NewServer(cacheInstance, queueManager, dbProvider, hub))
+	viper.SetDefault("GRPC_PORT", "9090")
+	lis, err := net.Listen("tcp", ":"+viper.GetString("GRPC_PORT"))
+	if err != nil {
+		log.Fatalf("Failed to listen for gRPC: %v", err)
+	}
+	go func() {
+		if err := grpcServer.Serve(lis); err != nil {
+			log.Fatalf("Failed to start gRPC server: %v", err)
+		}
+	}()
+	defer grpcServer.GracefulStop()
 
 	viper.SetDefault("HTTP_PORT", "8080")
 	httpPort := viper.GetString("HTTP_PORT")
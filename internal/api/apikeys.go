@@ -0,0 +1,70 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"wallet-api-server/internal/auth"
+	"wallet-api-server/internal/models"
+)
+
+func (h *Handler) HandleCreateAPIKey(c *gin.Context) {
+	var req models.APIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err.Error())
+		return
+	}
+	k, err := auth.CreateKey(c, h.DB, auth.TenantId(c.Request.Context()), req)
+	if err != nil {
+		internalError(c, "Failed to create API key")
+		return
+	}
+	c.JSON(http.StatusCreated, k)
+}
+
+func (h *Handler) HandleListAPIKeys(c *gin.Context) {
+	keys, err := auth.ListKeys(c, h.DB, auth.TenantId(c.Request.Context()))
+	if err != nil {
+		internalError(c, "Failed to read API keys")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"apiKeys": keys})
+}
+
+func (h *Handler) HandleRotateAPIKey(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		badRequest(c, "Invalid id format")
+		return
+	}
+	k, err := auth.RotateKey(c, h.DB, auth.TenantId(c.Request.Context()), id)
+	if err != nil {
+		if errors.Is(err, auth.ErrKeyNotFound) {
+			writeError(c, err, "API key not found", nil)
+		} else {
+			internalError(c, "Failed to rotate API key")
+		}
+		return
+	}
+	c.JSON(http.StatusOK, k)
+}
+
+func (h *Handler) HandleRevokeAPIKey(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		badRequest(c, "Invalid id format")
+		return
+	}
+	if err = auth.RevokeKey(c, h.DB, auth.TenantId(c.Request.Context()), id); err != nil {
+		if errors.Is(err, auth.ErrKeyNotFound) {
+			writeError(c, err, "API key not found", nil)
+		} else {
+			internalError(c, "Failed to revoke API key")
+		}
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
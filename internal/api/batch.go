@@ -0,0 +1,105 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/spf13/viper"
+
+	"wallet-api-server/internal/auth"
+	"wallet-api-server/internal/models"
+	"wallet-api-server/internal/problem"
+	"wallet-api-server/internal/queue"
+	"wallet-api-server/internal/tenant"
+)
+
+type batchItemResult struct {
+	Index       int              `json:"index"`
+	WalletId    string           `json:"walletId"`
+	Status      int              `json:"status"`
+	OperationId *uuid.UUID       `json:"operationId,omitempty"`
+	Balance     *decimal.Decimal `json:"balance,omitempty"`
+	Currency    string           `json:"currency,omitempty"`
+	Error       string           `json:"error,omitempty"`
+	Code        string           `json:"code,omitempty"`
+}
+
+func batchMaxOperations() int {
+	viper.AutomaticEnv()
+	viper.SetDefault("BATCH_MAX_OPERATIONS", 1000)
+	return viper.GetInt("BATCH_MAX_OPERATIONS")
+}
+
+func (h *Handler) HandleBatch(c *gin.Context) {
+	var req models.BatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err.Error())
+		return
+	}
+	if limit := batchMaxOperations(); len(req.Operations) > limit {
+		badRequest(c, fmt.Sprintf("A batch can hold at most %d operations", limit))
+		return
+	}
+	for i, op := range req.Operations {
+		if !op.Amount.IsPositive() {
+			problem.Write(c, http.StatusBadRequest, problem.CodeInvalidRequest, "Amount must be positive", gin.H{"index": i})
+			return
+		}
+		if !auth.Allows(c, models.OperationScope(op.OperationType), uuid.MustParse(op.WalletId)) {
+			problem.Write(c, http.StatusForbidden, problem.CodeForbidden, "The API key does not allow this operation", gin.H{"index": i})
+			return
+		}
+		if tenantOp := models.TenantOperationOf(op.OperationType); !auth.AllowsOperation(c.Request.Context(), tenantOp) {
+			status, code := errorProblem(tenant.ErrOperationNotAllowed)
+			problem.Write(c, status, code, "The tenant is not allowed to use "+string(tenantOp)+" operations", gin.H{"index": i})
+			return
+		}
+		req.Operations[i].ApiKeyId = auth.KeyId(c.Request.Context())
+		req.Operations[i].TenantId = auth.TenantId(c.Request.Context())
+	}
+
+	if req.Mode == models.BATCH_BEST_EFFORT {
+		results := h.Queue.BatchBestEffort(req.Operations)
+		items := make([]batchItemResult, len(results))
+		failed := 0
+		for i, res := range results {
+			items[i] = newBatchItemResult(i, req.Operations[i], res)
+			if res.Err != nil {
+				failed++
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"mode": req.Mode, "succeeded": len(results) - failed, "failed": failed, "results": items})
+		return
+	}
+
+	res := h.Queue.BatchAtomic(req.Operations)
+	if res.Err != nil {
+		ext := gin.H{"mode": req.Mode}
+		if res.FailedIndex >= 0 {
+			ext["index"] = res.FailedIndex
+		}
+		writeError(c, res.Err, res.Msg, ext)
+		return
+	}
+	items := make([]batchItemResult, len(res.Results))
+	for i, r := range res.Results {
+		items[i] = newBatchItemResult(i, req.Operations[i], r)
+	}
+	c.JSON(http.StatusOK, gin.H{"mode": req.Mode, "succeeded": len(items), "failed": 0, "results": items})
+}
+
+func newBatchItemResult(i int, op models.WalletOperationRequest, res queue.OpResult) batchItemResult {
+	item := batchItemResult{Index: i, WalletId: op.WalletId, Status: http.StatusOK}
+	if res.Err != nil {
+		item.Status, item.Code = errorProblem(res.Err)
+		item.Error = res.Msg
+		return item
+	}
+	item.OperationId = &res.OperationId
+	item.Balance = &res.Balance
+	item.Currency = res.Currency
+	return item
+}
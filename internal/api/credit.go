@@ -0,0 +1,54 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"wallet-api-server/internal/auth"
+	"wallet-api-server/internal/models"
+	"wallet-api-server/internal/queue"
+)
+
+func (h *Handler) HandleSetCreditLimit(c *gin.Context) {
+	walletId, err := uuid.Parse(c.Param("walletId"))
+	if err != nil {
+		badRequest(c, "Invalid walletId format")
+		return
+	}
+	var req models.CreditLimitRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err.Error())
+		return
+	}
+	if req.CreditLimit == nil {
+		badRequest(c, "creditLimit is required")
+		return
+	}
+	if req.CreditLimit.IsNegative() {
+		badRequest(c, "Credit limit must not be negative")
+		return
+	}
+	req.TenantId = auth.TenantId(c.Request.Context())
+	res := h.Queue.SetCreditLimit(walletId, req)
+	if res.Err != nil {
+		writeError(c, res.Err, res.Msg, nil)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"walletId": walletId, "balance": res.Balance, "change": res.Change})
+}
+
+func (h *Handler) HandleListCreditLimitChanges(c *gin.Context) {
+	walletId, err := uuid.Parse(c.Param("walletId"))
+	if err != nil {
+		badRequest(c, "Invalid walletId format")
+		return
+	}
+	changes, err := queue.ListCreditLimitChanges(c, h.DB, auth.TenantId(c.Request.Context()), walletId)
+	if err != nil {
+		internalError(c, "Failed to read credit limit changes")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"walletId": walletId, "changes": changes})
+}
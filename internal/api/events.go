@@ -0,0 +1,95 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"wallet-api-server/internal/auth"
+	"wallet-api-server/internal/ledger"
+	"wallet-api-server/internal/models"
+	"wallet-api-server/internal/problem"
+)
+
+// keepAliveInterval keeps proxies from closing idle event streams
+const keepAliveInterval = 30 * time.Second
+
+// HandleWalletEvents streams a balance.changed event for every ledger entry of the
+// wallet. The event id is the ledger position, a client reconnecting with
+// Last-Event-ID first gets the entries it missed
+func (h *Handler) HandleWalletEvents(c *gin.Context) {
+	walletId, err := uuid.Parse(c.Param("walletId"))
+	if err != nil {
+		badRequest(c, "Invalid walletId format")
+		return
+	}
+	lastEventId := c.GetHeader("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = c.Query("lastEventId")
+	}
+	var after int64
+	if lastEventId != "" {
+		if after, err = strconv.ParseInt(lastEventId, 10, 64); err != nil || after < 0 {
+			badRequest(c, "Invalid Last-Event-ID")
+			return
+		}
+	}
+	if h.Events == nil {
+		problem.Write(c, http.StatusServiceUnavailable, problem.CodeUnavailable, "Event streams are not available", nil)
+		return
+	}
+
+	tenantId := auth.TenantId(c.Request.Context())
+	sub, err := h.Events.Subscribe(c, tenantId, walletId)
+	if err != nil {
+		internalError(c, "Failed to subscribe to wallet events")
+		return
+	}
+	defer h.Events.Unsubscribe(sub)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	send := func(t models.Transaction) error {
+		return sse.Encode(c.Writer, sse.Event{
+			Id:    strconv.FormatInt(t.Id, 10),
+			Event: models.EVENT_BALANCE_CHANGED,
+			Data:  models.NewBalanceChangedEvent(t),
+		})
+	}
+	if lastEventId != "" && after < sub.Cursor {
+		if err = ledger.StreamRange(c, h.DB, tenantId, walletId, after, sub.Cursor, send); err != nil {
+			return
+		}
+	}
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case t, ok := <-sub.C:
+			if !ok {
+				// Dropped for falling behind, the client reconnects and resumes
+				return
+			}
+			if send(t) != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := c.Writer.WriteString(": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
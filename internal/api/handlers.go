@@ -2,10 +2,16 @@ package api
 
 import (
 	"net/http"
+	"strings"
+	"time"
 
+	"wallet-api-server/internal/auth"
 	"wallet-api-server/internal/cache"
 	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/events"
+	"wallet-api-server/internal/ledger"
 	"wallet-api-server/internal/models"
+	"wallet-api-server/internal/problem"
 	"wallet-api-server/internal/queue"
 
 	"github.com/gin-gonic/gin"
@@ -16,6 +22,8 @@ type Handler struct {
 	Cache *cache.BalanceCache
 	Queue *queue.QueueManager
 	DB    db.DBProvider
+	// Events serves the wallet event streams, they are unavailable without it
+	Events *events.Hub
 }
 
 func NewHandler(c *cache.BalanceCache, q *queue.QueueManager, dbProvider db.DBProvider) *Handler {
@@ -25,43 +33,181 @@ func NewHandler(c *cache.BalanceCache, q *queue.QueueManager, dbProvider db.DBPr
 func (h *Handler) HandleWalletOperation(c *gin.Context) {
 	var req models.WalletOperationRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		badRequest(c, err.Error())
+		return
+	}
+	if !req.Amount.IsPositive() {
+		badRequest(c, "Amount must be positive")
 		return
 	}
 	walletUUID, err := uuid.Parse(req.WalletId)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid walletId format"})
+		badRequest(c, "Invalid walletId format")
+		return
+	}
+	if !auth.Allows(c, models.OperationScope(req.OperationType), walletUUID) {
+		auth.Forbid(c)
+		return
+	}
+	if !allowsOperation(c, models.TenantOperationOf(req.OperationType)) {
+		return
+	}
+	req.ApiKeyId = auth.KeyId(c.Request.Context())
+	req.TenantId = auth.TenantId(c.Request.Context())
+	if !bindIdempotencyKey(c, &req.IdempotencyKey) {
 		return
 	}
 	res := h.Queue.Enqueue(walletUUID, req)
+	if res.Replayed {
+		c.Header("Idempotent-Replayed", "true")
+	}
 	if res.Err != nil {
-		if res.Msg == "Insufficient funds" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": res.Msg})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": res.Msg})
-		}
+		writeOpError(c, res)
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"walletId": req.WalletId, "balance": res.Balance})
+	c.JSON(http.StatusOK, gin.H{"walletId": req.WalletId, "balance": res.Balance, "available": res.Available, "currency": res.Currency, "operationId": res.OperationId})
 }
 
 func (h *Handler) HandleGetBalance(c *gin.Context) {
-	walletIdStr := c.Param("walletId")
-	walletId, err := uuid.Parse(walletIdStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid walletId format"})
+	walletId, currency, asOf, ok := bindBalanceQuery(c)
+	if !ok {
+		return
+	}
+
+	if asOf != nil {
+		balances, ok := h.balancesAt(c, walletId, *asOf)
+		if !ok {
+			return
+		}
+		selected, found := pickBalance(balances, currency, func(b models.PointInTimeBalance) string { return b.Currency })
+		if !found {
+			problem.Write(c, http.StatusNotFound, codeBalanceNotFound, "Wallet had no balance in "+currency+" at asOf", nil)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"walletId": walletId,
+			"asOf":     asOf.UTC(),
+			"balance":  selected.Balance,
+			"currency": selected.Currency,
+			"balances": balances,
+		})
 		return
 	}
-	if balance, ok := h.Cache.Get(walletId); ok {
-		c.JSON(http.StatusOK, gin.H{"walletId": walletId, "balance": balance, "cached": true})
+
+	balances, cached, ok := h.balances(c, walletId)
+	if !ok {
 		return
 	}
-	var balance models.Wallet
-	err = h.DB.QueryRow(c, "SELECT balance FROM wallets WHERE wallet_id=$1", walletId).Scan(&balance.Balance)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Wallet not found"})
+	// balance and currency keep describing a single balance for single-currency clients
+	selected, found := pickBalance(balances, currency, func(b models.Balance) string { return b.Currency })
+	if !found {
+		problem.Write(c, http.StatusNotFound, codeBalanceNotFound, "Wallet has no balance in "+currency, nil)
 		return
 	}
-	h.Cache.Set(walletId, balance.Balance)
-	c.JSON(http.StatusOK, gin.H{"walletId": walletId, "balance": balance.Balance, "cached": false})
+	c.JSON(http.StatusOK, gin.H{
+		"walletId":        walletId,
+		"balance":         selected.Balance,
+		"available":       selected.Available,
+		"creditLimit":     selected.CreditLimit,
+		"availableCredit": selected.AvailableCredit,
+		"currency":        selected.Currency,
+		"balances":        balances,
+		"cached":          cached,
+	})
+}
+
+// bindBalanceQuery reads the wallet id, currency and asOf of a balance request,
+// it writes a 400 response and returns false when one of them is invalid
+func bindBalanceQuery(c *gin.Context) (walletId uuid.UUID, currency string, asOf *time.Time, ok bool) {
+	walletId, err := uuid.Parse(c.Param("walletId"))
+	if err != nil {
+		badRequest(c, "Invalid walletId format")
+		return walletId, "", nil, false
+	}
+	currency = strings.ToUpper(c.Query("currency"))
+	if currency != "" && !models.IsCurrencyCode(currency) {
+		badRequest(c, "Invalid currency")
+		return walletId, "", nil, false
+	}
+	if v := c.Query("asOf"); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			badRequest(c, "Invalid asOf, expected an RFC 3339 timestamp")
+			return walletId, "", nil, false
+		}
+		if t.After(time.Now()) {
+			badRequest(c, "asOf must not be in the future")
+			return walletId, "", nil, false
+		}
+		asOf = &t
+	}
+	return walletId, currency, asOf, true
+}
+
+// balances reads the wallet's current balances through the cache,
+// it writes the error response and returns false when they cannot be read
+func (h *Handler) balances(c *gin.Context, walletId uuid.UUID) (balances []models.Balance, cached, ok bool) {
+	tenantId := auth.TenantId(c.Request.Context())
+	balances, cached = h.Cache.Get(tenantId, walletId)
+	if cached {
+		return balances, true, true
+	}
+	balances, err := queue.LoadBalances(c, h.DB, tenantId, walletId)
+	if err != nil {
+		internalError(c, "Failed to read balance")
+		return nil, false, false
+	}
+	if len(balances) == 0 {
+		writeError(c, queue.ErrWalletNotFound, "Wallet not found", nil)
+		return nil, false, false
+	}
+	h.Cache.Set(tenantId, walletId, balances, cache.TTL(auth.CacheTTLSeconds(c.Request.Context())))
+	return balances, false, true
+}
+
+// balancesAt answers from the ledger alone, the cache only knows the current balance
+func (h *Handler) balancesAt(c *gin.Context, walletId uuid.UUID, asOf time.Time) ([]models.PointInTimeBalance, bool) {
+	balances, err := ledger.BalancesAt(c, h.DB, auth.TenantId(c.Request.Context()), walletId, asOf)
+	if err != nil {
+		internalError(c, "Failed to read balance")
+		return nil, false
+	}
+	if len(balances) == 0 {
+		writeError(c, queue.ErrWalletNotFound, "Wallet did not exist at asOf", nil)
+		return nil, false
+	}
+	return balances, true
+}
+
+// pickBalance returns the balance in currency, or the primary one when currency is empty
+func pickBalance[B any](balances []B, currency string, currencyOf func(B) string) (B, bool) {
+	if currency == "" {
+		return balances[0], true
+	}
+	for _, b := range balances {
+		if currencyOf(b) == currency {
+			return b, true
+		}
+	}
+	var none B
+	return none, false
+}
+
+// bindIdempotencyKey merges the Idempotency-Key header into the request field,
+// it writes a 400 response and returns false when they disagree
+func bindIdempotencyKey(c *gin.Context, field *string) bool {
+	key := c.GetHeader("Idempotency-Key")
+	if key == "" {
+		return true
+	}
+	if *field != "" && *field != key {
+		badRequest(c, "Idempotency-Key header does not match idempotencyKey")
+		return false
+	}
+	if len(key) > 255 {
+		badRequest(c, "Idempotency-Key is too long")
+		return false
+	}
+	*field = key
+	return true
 }
@@ -3,9 +3,13 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"context"
 
@@ -15,18 +19,30 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 
+	"wallet-api-server/internal/auth"
 	"wallet-api-server/internal/cache"
 	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/db/dbtest"
+	"wallet-api-server/internal/models"
 	"wallet-api-server/internal/queue"
 )
 
 type mockDBProvider struct{ mock.Mock }
 type mockRowScanner struct{ mock.Mock }
+type mockRows struct {
+	mock.Mock
+	rows [][]interface{}
+	pos  int
+}
 
 func (m *mockDBProvider) QueryRow(ctx context.Context, query string, args ...interface{}) db.RowScanner {
 	argsM := m.Called(ctx, query, args)
 	return argsM.Get(0).(db.RowScanner)
 }
+func (m *mockDBProvider) Query(ctx context.Context, query string, args ...interface{}) (db.Rows, error) {
+	argsM := m.Called(ctx, query, args)
+	return argsM.Get(0).(db.Rows), argsM.Error(1)
+}
 func (m *mockDBProvider) Exec(ctx context.Context, query string, args ...interface{}) (interface{}, error) {
 	argsM := m.Called(ctx, query, args)
 	return argsM.Get(0), argsM.Error(1)
@@ -45,6 +61,19 @@ func (m *mockRowScanner) Scan(dest ...interface{}) error {
 	return argsM.Error(0)
 }
 
+func (m *mockRows) Next() bool {
+	m.pos++
+	return m.pos <= len(m.rows)
+}
+func (m *mockRows) Scan(dest ...interface{}) error {
+	for i, v := range m.rows[m.pos-1] {
+		reflect.ValueOf(dest[i]).Elem().Set(reflect.ValueOf(v))
+	}
+	return nil
+}
+func (m *mockRows) Err() error { return nil }
+func (m *mockRows) Close()     {}
+
 func TestHandleWalletOperation_BadRequest(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	c := &cache.BalanceCache{}
@@ -60,6 +89,53 @@ func TestHandleWalletOperation_BadRequest(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, w.Code)
 }
 
+func TestHandleWalletOperation_NonPositiveAmount(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c := &cache.BalanceCache{}
+	q := &queue.QueueManager{Cache: c}
+	mdb := new(mockDBProvider)
+	h := NewHandler(c, q, mdb)
+	r := gin.Default()
+	r.POST("/wallet", h.HandleWalletOperation)
+	w := httptest.NewRecorder()
+	body := bytes.NewBufferString(`{"walletId":"` + uuid.New().String() + `","operationType":"WITHDRAW","amount":"-5"}`)
+	req, _ := http.NewRequest("POST", "/wallet", body)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mdb.AssertNotCalled(t, "Begin", mock.Anything)
+}
+
+func TestHandleWalletOperation_InvalidDetails(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c := &cache.BalanceCache{}
+	mdb := new(mockDBProvider)
+	h := NewHandler(c, &queue.QueueManager{Cache: c}, mdb)
+	r := gin.New()
+	r.POST("/wallet", h.HandleWalletOperation)
+
+	tooMany := map[string]string{}
+	for i := 0; i < 21; i++ {
+		tooMany[fmt.Sprintf("key%d", i)] = "v"
+	}
+	for _, details := range []map[string]interface{}{
+		{"reference": strings.Repeat("r", 129)},
+		{"description": strings.Repeat("d", 256)},
+		{"metadata": tooMany},
+		{"metadata": map[string]string{strings.Repeat("k", 65): "v"}},
+		{"metadata": map[string]string{"k": strings.Repeat("v", 513)}},
+	} {
+		details["walletId"] = uuid.NewString()
+		details["operationType"] = "DEPOSIT"
+		details["amount"] = "1"
+		body, _ := json.Marshal(details)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/wallet", bytes.NewReader(body))
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, string(body))
+	}
+	mdb.AssertNotCalled(t, "Begin", mock.Anything)
+}
+
 func TestHandleGetBalance_InvalidUUID(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	c := &cache.BalanceCache{}
@@ -78,7 +154,7 @@ func TestHandleGetBalance_CacheHit(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	c := &cache.BalanceCache{}
 	id := uuid.New()
-	c.Set(id, decimal.NewFromInt(100))
+	c.Set(models.DefaultTenant, id, []models.Balance{{Currency: "USD", Balance: decimal.NewFromInt(100)}}, time.Minute)
 	q := &queue.QueueManager{Cache: c}
 	mdb := new(mockDBProvider)
 	h := NewHandler(c, q, mdb)
@@ -101,18 +177,9 @@ func TestHandleGetBalance_DB(t *testing.T) {
 	c := &cache.BalanceCache{}
 	q := &queue.QueueManager{Cache: c}
 	mdb := new(mockDBProvider)
-	mrow := new(mockRowScanner)
 	id := uuid.New()
-	mdb.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(mrow)
-	mrow.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
-		if dest, ok := args.Get(0).([]interface{}); ok {
-			if len(dest) > 0 {
-				if ptr, ok := dest[0].(*decimal.Decimal); ok {
-					*ptr = decimal.NewFromInt(123)
-				}
-			}
-		}
-	})
+	rows := &mockRows{rows: [][]interface{}{{"USD", decimal.NewFromInt(123), decimal.NewFromInt(23), decimal.Zero}}}
+	mdb.On("Query", mock.Anything, mock.Anything, mock.Anything).Return(rows, nil)
 	h := NewHandler(c, q, mdb)
 	r := gin.Default()
 	r.GET("/wallet/:walletId", h.HandleGetBalance)
@@ -127,4 +194,449 @@ func TestHandleGetBalance_DB(t *testing.T) {
 	assert.Equal(t, false, resp["cached"])
 	assert.Equal(t, id.String(), resp["walletId"].(string))
 	assert.Equal(t, "123", resp["balance"])
+	assert.Equal(t, "USD", resp["currency"])
+	assert.Equal(t, "100", resp["available"])
+}
+
+func TestHandleGetBalance_CreditLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c := &cache.BalanceCache{}
+	q := &queue.QueueManager{Cache: c}
+	mdb := new(mockDBProvider)
+	// 30 of the 50 credit line are drawn
+	rows := &mockRows{rows: [][]interface{}{{"USD", decimal.NewFromInt(-30), decimal.Zero, decimal.NewFromInt(50)}}}
+	mdb.On("Query", mock.Anything, mock.Anything, mock.Anything).Return(rows, nil)
+	h := NewHandler(c, q, mdb)
+	r := gin.Default()
+	r.GET("/wallet/:walletId", h.HandleGetBalance)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/wallet/"+uuid.New().String(), nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	assert.Equal(t, "-30", resp["balance"])
+	assert.Equal(t, "20", resp["available"])
+	assert.Equal(t, "50", resp["creditLimit"])
+	assert.Equal(t, "20", resp["availableCredit"])
+}
+
+func TestHandleSetCreditLimit_Validation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c := &cache.BalanceCache{}
+	mdb := new(mockDBProvider)
+	h := NewHandler(c, &queue.QueueManager{Cache: c}, mdb)
+	r := gin.New()
+	r.PUT("/wallets/:walletId/credit-limit", h.HandleSetCreditLimit)
+
+	for _, body := range []string{`{}`, `{"reason":"annual review"}`, `{"creditLimit":null}`, `{"creditLimit":"-1"}`} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/wallets/"+uuid.NewString()+"/credit-limit", strings.NewReader(body))
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+	mdb.AssertNotCalled(t, "Begin", mock.Anything)
+}
+
+func TestHandleGetBalance_MultiCurrency(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c := &cache.BalanceCache{}
+	q := &queue.QueueManager{Cache: c}
+	mdb := new(mockDBProvider)
+	id := uuid.New()
+	rows := &mockRows{rows: [][]interface{}{{"USD", decimal.NewFromInt(10), decimal.Zero, decimal.Zero}, {"EUR", decimal.NewFromInt(20), decimal.Zero, decimal.Zero}}}
+	mdb.On("Query", mock.Anything, mock.Anything, mock.Anything).Return(rows, nil).Once()
+	h := NewHandler(c, q, mdb)
+	r := gin.Default()
+	r.GET("/wallet/:walletId", h.HandleGetBalance)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/wallet/"+id.String()+"?currency=eur", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Balance  decimal.Decimal  `json:"balance"`
+		Currency string           `json:"currency"`
+		Balances []models.Balance `json:"balances"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	assert.Equal(t, "EUR", resp.Currency)
+	assert.True(t, decimal.NewFromInt(20).Equal(resp.Balance))
+	assert.Len(t, resp.Balances, 2)
+
+	// served from cache, the wallet holds no GBP
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/wallet/"+id.String()+"?currency=GBP", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandleGetBalance_NotFound(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c := &cache.BalanceCache{}
+	q := &queue.QueueManager{Cache: c}
+	mdb := new(mockDBProvider)
+	mdb.On("Query", mock.Anything, mock.Anything, mock.Anything).Return(&mockRows{}, nil)
+	h := NewHandler(c, q, mdb)
+	r := gin.Default()
+	r.GET("/wallet/:walletId", h.HandleGetBalance)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/wallet/"+uuid.New().String(), nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandleListTransactions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c := &cache.BalanceCache{}
+	q := &queue.QueueManager{Cache: c}
+	mdb := new(mockDBProvider)
+	id := uuid.New()
+	now := time.Now().UTC()
+	rows := &mockRows{rows: [][]interface{}{
+		{int64(3), uuid.New(), id, models.WITHDRAW, "USD", decimal.NewFromInt(5), decimal.NewFromInt(15), (*uuid.UUID)(nil), (*uuid.UUID)(nil), (*uuid.UUID)(nil), now},
+		{int64(2), uuid.New(), id, models.DEPOSIT, "USD", decimal.NewFromInt(10), decimal.NewFromInt(20), (*uuid.UUID)(nil), (*uuid.UUID)(nil), (*uuid.UUID)(nil), now},
+		{int64(1), uuid.New(), id, models.DEPOSIT, "USD", decimal.NewFromInt(10), decimal.NewFromInt(10), (*uuid.UUID)(nil), (*uuid.UUID)(nil), (*uuid.UUID)(nil), now},
+	}}
+	mdb.On("Query", mock.Anything, mock.Anything, mock.Anything).Return(rows, nil)
+	h := NewHandler(c, q, mdb)
+	r := gin.Default()
+	r.GET("/wallet/:walletId/transactions", h.HandleListTransactions)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/wallet/"+id.String()+"/transactions?limit=2", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Transactions []models.Transaction `json:"transactions"`
+		NextCursor   string               `json:"nextCursor"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	assert.Len(t, resp.Transactions, 2)
+	assert.Equal(t, "2", resp.NextCursor)
+	assert.Equal(t, models.WITHDRAW, resp.Transactions[0].OperationType)
+}
+
+func TestHandleListTransactions_InvalidFilter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c := &cache.BalanceCache{}
+	q := &queue.QueueManager{Cache: c}
+	mdb := new(mockDBProvider)
+	h := NewHandler(c, q, mdb)
+	r := gin.Default()
+	r.GET("/wallet/:walletId/transactions", h.HandleListTransactions)
+	for _, query := range []string{"type=FOO", "from=yesterday", "limit=-1"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/wallet/"+uuid.New().String()+"/transactions?"+query, nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+	mdb.AssertNotCalled(t, "Query", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestHandleTransfer_BadRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c := &cache.BalanceCache{}
+	q := &queue.QueueManager{Cache: c}
+	mdb := new(mockDBProvider)
+	h := NewHandler(c, q, mdb)
+	r := gin.Default()
+	r.POST("/transfer", h.HandleTransfer)
+	id := uuid.New().String()
+	for _, body := range []string{
+		`{"fromWalletId":"` + id + `","toWalletId":"` + id + `","amount":"10"}`,
+		`{"fromWalletId":"` + id + `","toWalletId":"` + uuid.New().String() + `","amount":"-10"}`,
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/transfer", bytes.NewBufferString(body))
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+	mdb.AssertNotCalled(t, "Begin", mock.Anything)
+}
+
+func TestHandleBatch_Validation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c := &cache.BalanceCache{}
+	q := &queue.QueueManager{Cache: c}
+	mdb := new(mockDBProvider)
+	h := NewHandler(c, q, mdb)
+	r := gin.Default()
+	r.POST("/wallet/batch", h.HandleBatch)
+	id := uuid.New().String()
+	for _, body := range []string{
+		`{"mode":"sometimes","operations":[{"walletId":"` + id + `","operationType":"DEPOSIT","amount":"1"}]}`,
+		`{"mode":"best-effort","operations":[]}`,
+		`{"mode":"best-effort","operations":[{"walletId":"` + id + `","operationType":"REFUND","amount":"1"}]}`,
+		`{"mode":"all-or-nothing","operations":[{"walletId":"` + id + `","operationType":"DEPOSIT","amount":"-1"}]}`,
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/wallet/batch", bytes.NewBufferString(body))
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+	mdb.AssertNotCalled(t, "Begin", mock.Anything)
+}
+
+func TestHandleGetBalance_AsOfBypassesCache(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c := &cache.BalanceCache{}
+	id := uuid.New()
+	c.Set(models.DefaultTenant, id, []models.Balance{{Currency: "USD", Balance: decimal.NewFromInt(100)}}, time.Minute)
+	q := &queue.QueueManager{Cache: c}
+	mdb := new(mockDBProvider)
+	mdb.On("Query", mock.Anything, mock.Anything, mock.Anything).Return(&mockRows{rows: [][]interface{}{{"USD"}}}, nil)
+	past := new(mockRowScanner)
+	past.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		*args.Get(0).([]interface{})[0].(*decimal.Decimal) = decimal.NewFromInt(40)
+	})
+	mdb.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(past)
+	h := NewHandler(c, q, mdb)
+	r := gin.Default()
+	r.GET("/wallet/:walletId", h.HandleGetBalance)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/wallet/"+id.String()+"?asOf=2024-01-01T00:00:00Z", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	assert.Equal(t, "40", resp["balance"])
+	assert.Equal(t, "2024-01-01T00:00:00Z", resp["asOf"])
+	assert.NotContains(t, resp, "cached")
+}
+
+func TestHandleGetBalance_AsOfInvalid(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c := &cache.BalanceCache{}
+	h := NewHandler(c, &queue.QueueManager{Cache: c}, new(mockDBProvider))
+	r := gin.Default()
+	r.GET("/wallet/:walletId", h.HandleGetBalance)
+	for _, asOf := range []string{"yesterday", time.Now().Add(time.Hour).UTC().Format(time.RFC3339)} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/wallet/"+uuid.New().String()+"?asOf="+asOf, nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, asOf)
+	}
+}
+
+func TestHandleWalletOperation_KeyScopes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c := &cache.BalanceCache{}
+	mdb := new(mockDBProvider)
+	h := NewHandler(c, &queue.QueueManager{Cache: c}, mdb)
+	walletId := uuid.New()
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		p := &auth.Principal{Scopes: []models.Scope{models.SCOPE_DEPOSIT}, WalletIds: []uuid.UUID{walletId}}
+		c.Request = c.Request.WithContext(auth.NewContext(c.Request.Context(), p))
+	})
+	r.POST("/wallet", h.HandleWalletOperation)
+	r.POST("/transfer", h.HandleTransfer)
+
+	for _, tt := range []struct{ path, body string }{
+		// The key may deposit to its wallet but not withdraw from it
+		{"/wallet", `{"walletId":"` + walletId.String() + `","operationType":"WITHDRAW","amount":"1"}`},
+		{"/wallet", `{"walletId":"` + uuid.NewString() + `","operationType":"DEPOSIT","amount":"1"}`},
+		{"/transfer", `{"fromWalletId":"` + walletId.String() + `","toWalletId":"` + uuid.NewString() + `","amount":"1"}`},
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Code, tt.body)
+		var resp map[string]interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, "FORBIDDEN", resp["code"])
+	}
+	mdb.AssertNotCalled(t, "Begin", mock.Anything)
+}
+
+func TestHandleHolds_RestrictedPrincipals(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	own, foreign := uuid.New(), uuid.New()
+	ownHold, foreignHold := uuid.New(), uuid.New()
+	keyId := uuid.New()
+	for name, p := range map[string]*auth.Principal{
+		"key": {KeyId: &keyId, Scopes: []models.Scope{models.SCOPE_WITHDRAW}, WalletIds: []uuid.UUID{own},
+			Tenant: models.Tenant{Id: models.DefaultTenant}},
+		"jwt": {Owner: "user-1", Scopes: []models.Scope{models.SCOPE_BALANCE_READ, models.SCOPE_WITHDRAW}, WalletIds: []uuid.UUID{own},
+			Tenant: models.Tenant{Id: models.DefaultTenant}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := &cache.BalanceCache{}
+			mdb := new(dbtest.DB)
+			for holdId, walletId := range map[uuid.UUID]uuid.UUID{ownHold: own, foreignHold: foreign} {
+				mdb.On("QueryRow", mock.Anything, dbtest.IsQuery("SELECT hold_id"), []interface{}{holdId, models.DefaultTenant}).Return(dbtest.NewRow(
+					holdId, walletId, "USD", decimal.NewFromInt(10), decimal.NewFromInt(10), models.HOLD_ACTIVE, time.Now().Add(time.Hour), time.Now(),
+				))
+			}
+			h := NewHandler(c, &queue.QueueManager{Cache: c, DB: mdb}, mdb)
+			r := gin.New()
+			r.Use(func(c *gin.Context) {
+				c.Request = c.Request.WithContext(auth.NewContext(c.Request.Context(), p))
+			})
+			r.GET("/holds/:holdId", auth.RequireScope(models.SCOPE_BALANCE_READ, models.SCOPE_WITHDRAW), h.HandleGetHold)
+			r.POST("/holds/:holdId/capture", auth.RequireScope(models.SCOPE_WITHDRAW), h.HandleCaptureHold)
+			r.POST("/holds/:holdId/release", auth.RequireScope(models.SCOPE_WITHDRAW), h.HandleReleaseHold)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/holds/"+ownHold.String(), nil)
+			r.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code)
+
+			// The hold exists but belongs to a wallet the principal is not allowed
+			for _, tt := range []struct{ method, path string }{
+				{"GET", "/holds/" + foreignHold.String()},
+				{"POST", "/holds/" + foreignHold.String() + "/capture"},
+				{"POST", "/holds/" + foreignHold.String() + "/release"},
+			} {
+				w := httptest.NewRecorder()
+				req, _ := http.NewRequest(tt.method, tt.path, strings.NewReader(`{}`))
+				r.ServeHTTP(w, req)
+				assert.Equal(t, http.StatusForbidden, w.Code, tt.path)
+			}
+			mdb.AssertNotCalled(t, "Begin", mock.Anything)
+		})
+	}
+}
+
+func TestHandleListWallets_Owner(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c := &cache.BalanceCache{}
+	mdb := new(mockDBProvider)
+	h := NewHandler(c, &queue.QueueManager{Cache: c}, mdb)
+	walletId := uuid.New()
+	mdb.On("Query", mock.Anything, mock.Anything, []interface{}{"user-1", models.DefaultTenant}).Return(&mockRows{rows: [][]interface{}{
+		{walletId, models.WALLET_ACTIVE, "USD", decimal.NewFromInt(10), decimal.Zero, decimal.Zero, int64(2), time.Now()},
+		{walletId, models.WALLET_ACTIVE, "EUR", decimal.NewFromInt(5), decimal.Zero, decimal.Zero, int64(1), time.Now()},
+	}}, nil)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		p := &auth.Principal{Owner: "user-1", Scopes: []models.Scope{models.SCOPE_BALANCE_READ}, WalletIds: []uuid.UUID{walletId},
+			Tenant: models.Tenant{Id: models.DefaultTenant}}
+		c.Request = c.Request.WithContext(auth.NewContext(c.Request.Context(), p))
+	})
+	r.GET("/wallets", h.HandleListWallets)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/wallets", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Owner   string               `json:"owner"`
+		Wallets []models.WalletState `json:"wallets"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "user-1", resp.Owner)
+	assert.Len(t, resp.Wallets, 1)
+	assert.Len(t, resp.Wallets[0].Balances, 2)
+
+	// A token cannot list the wallets of another owner
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/wallets?owner=user-2", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	mdb.AssertNumberOfCalls(t, "Query", 1)
+}
+
+func TestRequireTenantWallet(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c := &cache.BalanceCache{}
+	mdb := new(mockDBProvider)
+	h := NewHandler(c, &queue.QueueManager{Cache: c}, mdb)
+	own, foreign := uuid.New(), uuid.New()
+	owner := func(tenantId string) *mockRowScanner {
+		row := new(mockRowScanner)
+		row.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			*args.Get(0).([]interface{})[0].(*string) = tenantId
+		})
+		return row
+	}
+	missing := new(mockRowScanner)
+	missing.On("Scan", mock.Anything).Return(db.ErrNoRows)
+	mdb.On("QueryRow", mock.Anything, mock.Anything, []interface{}{own}).Return(owner("brand-a"))
+	mdb.On("QueryRow", mock.Anything, mock.Anything, []interface{}{foreign}).Return(owner("brand-b"))
+	mdb.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(missing)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		p := &auth.Principal{Scopes: []models.Scope{models.SCOPE_ADMIN}, Tenant: models.Tenant{Id: "brand-a"}}
+		c.Request = c.Request.WithContext(auth.NewContext(c.Request.Context(), p))
+	})
+	r.Use(h.RequireTenantWallet)
+	r.GET("/wallets/:walletId", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for walletId, status := range map[string]int{
+		own.String():     http.StatusOK,
+		uuid.NewString(): http.StatusOK,
+		"not-a-uuid":     http.StatusOK,
+		// A known UUID of another tenant is indistinguishable from an unknown one
+		foreign.String(): http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/wallets/"+walletId, nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, status, w.Code, walletId)
+	}
+}
+
+func TestHandleSetLimits_UnknownWallet(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c := &cache.BalanceCache{}
+	mdb := new(mockDBProvider)
+	walletId := uuid.New()
+	// The wallet has no balances in the caller's tenant
+	mdb.On("Query", mock.Anything, mock.Anything, []interface{}{walletId, "brand-a"}).Return(&mockRows{}, nil)
+	h := NewHandler(c, &queue.QueueManager{Cache: c}, mdb)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		p := &auth.Principal{Scopes: []models.Scope{models.SCOPE_ADMIN}, Tenant: models.Tenant{Id: "brand-a"}}
+		c.Request = c.Request.WithContext(auth.NewContext(c.Request.Context(), p))
+	})
+	r.GET("/wallets/:walletId/limits", h.HandleGetLimits)
+	r.PUT("/wallets/:walletId/limits", h.HandleSetLimits)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/wallets/"+walletId.String()+"/limits", strings.NewReader(`{"maxOperationAmount":"10"}`))
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/wallets/"+walletId.String()+"/limits", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	mdb.AssertNotCalled(t, "Exec", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestHandleWalletOperation_TenantOperations(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c := &cache.BalanceCache{}
+	mdb := new(mockDBProvider)
+	h := NewHandler(c, &queue.QueueManager{Cache: c}, mdb)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		p := &auth.Principal{Scopes: []models.Scope{models.SCOPE_ADMIN},
+			Tenant: models.Tenant{Id: "brand-a", AllowedOperations: []models.TenantOperation{models.TENANT_DEPOSIT}}}
+		c.Request = c.Request.WithContext(auth.NewContext(c.Request.Context(), p))
+	})
+	r.POST("/wallet", h.HandleWalletOperation)
+	r.POST("/transfer", h.HandleTransfer)
+
+	for _, tt := range []struct{ path, body string }{
+		{"/wallet", `{"walletId":"` + uuid.NewString() + `","operationType":"WITHDRAW","amount":"1"}`},
+		{"/transfer", `{"fromWalletId":"` + uuid.NewString() + `","toWalletId":"` + uuid.NewString() + `","amount":"1"}`},
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Code, tt.body)
+		var resp map[string]interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, "OPERATION_NOT_ALLOWED", resp["code"])
+	}
+	mdb.AssertNotCalled(t, "Begin", mock.Anything)
 }
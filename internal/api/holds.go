@@ -0,0 +1,134 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"wallet-api-server/internal/auth"
+	"wallet-api-server/internal/models"
+	"wallet-api-server/internal/queue"
+)
+
+func (h *Handler) HandleCreateHold(c *gin.Context) {
+	var req models.HoldRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err.Error())
+		return
+	}
+	if !req.Amount.IsPositive() {
+		badRequest(c, "Amount must be positive")
+		return
+	}
+	if !auth.Allows(c, models.SCOPE_WITHDRAW, uuid.MustParse(req.WalletId)) {
+		auth.Forbid(c)
+		return
+	}
+	if !allowsOperation(c, models.TENANT_HOLD) {
+		return
+	}
+	req.TenantId = auth.TenantId(c.Request.Context())
+	res := h.Queue.CreateHold(req)
+	if res.Err != nil {
+		writeHoldError(c, res)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"hold": res.Hold, "balance": res.Balance, "available": res.Available})
+}
+
+func (h *Handler) HandleGetHold(c *gin.Context) {
+	holdId, err := uuid.Parse(c.Param("holdId"))
+	if err != nil {
+		badRequest(c, "Invalid holdId format")
+		return
+	}
+	hold, ok := h.authorizeHold(c, holdId, models.SCOPE_BALANCE_READ, models.SCOPE_WITHDRAW)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"hold": hold})
+}
+
+func (h *Handler) HandleCaptureHold(c *gin.Context) {
+	holdId, err := uuid.Parse(c.Param("holdId"))
+	if err != nil {
+		badRequest(c, "Invalid holdId format")
+		return
+	}
+	// An empty body captures everything that is still held
+	var req models.CaptureRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		badRequest(c, err.Error())
+		return
+	}
+	if req.Amount != nil && !req.Amount.IsPositive() {
+		badRequest(c, "Amount must be positive")
+		return
+	}
+	if _, ok := h.authorizeHold(c, holdId, models.SCOPE_WITHDRAW); !ok {
+		return
+	}
+	if !allowsOperation(c, models.TENANT_HOLD) {
+		return
+	}
+	req.ApiKeyId = auth.KeyId(c.Request.Context())
+	req.TenantId = auth.TenantId(c.Request.Context())
+	res := h.Queue.CaptureHold(holdId, req)
+	if res.Err != nil {
+		writeHoldError(c, res)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"hold": res.Hold, "operationId": res.OperationId, "balance": res.Balance, "available": res.Available})
+}
+
+func (h *Handler) HandleReleaseHold(c *gin.Context) {
+	holdId, err := uuid.Parse(c.Param("holdId"))
+	if err != nil {
+		badRequest(c, "Invalid holdId format")
+		return
+	}
+	if _, ok := h.authorizeHold(c, holdId, models.SCOPE_WITHDRAW); !ok {
+		return
+	}
+	res := h.Queue.ReleaseHold(auth.TenantId(c.Request.Context()), holdId)
+	if res.Err != nil {
+		writeHoldError(c, res)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"hold": res.Hold, "balance": res.Balance, "available": res.Available})
+}
+
+// authorizeHold loads a hold of the route and checks that the request may use any of the
+// scopes on its wallet, the routes name no wallet for Require to check. It has responded when ok is false
+func (h *Handler) authorizeHold(c *gin.Context, holdId uuid.UUID, scopes ...models.Scope) (hold models.Hold, ok bool) {
+	hold, err := queue.LoadHold(c, h.DB, auth.TenantId(c.Request.Context()), holdId)
+	if err != nil {
+		if errors.Is(err, queue.ErrHoldNotFound) {
+			writeError(c, err, "Hold not found", nil)
+		} else {
+			internalError(c, "Failed to read hold")
+		}
+		return hold, false
+	}
+	for _, scope := range scopes {
+		if auth.Allows(c, scope, hold.WalletId) {
+			return hold, true
+		}
+	}
+	auth.Forbid(c)
+	return hold, false
+}
+
+func writeHoldError(c *gin.Context, res queue.OpResult) {
+	var ext gin.H
+	switch {
+	case errors.Is(res.Err, queue.ErrHoldNotActive), errors.Is(res.Err, queue.ErrHoldExpired):
+		ext = gin.H{"hold": res.Hold}
+	case errors.Is(res.Err, queue.ErrCurrencyMismatch):
+		ext = gin.H{"currency": res.Currency}
+	}
+	writeError(c, res.Err, res.Msg, ext)
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	"wallet-api-server/internal/auth"
+	"wallet-api-server/internal/limits"
+	"wallet-api-server/internal/models"
+)
+
+func (h *Handler) HandleGetLimits(c *gin.Context) {
+	walletId, err := uuid.Parse(c.Param("walletId"))
+	if err != nil {
+		badRequest(c, "Invalid walletId format")
+		return
+	}
+	if _, _, ok := h.balances(c, walletId); !ok {
+		return
+	}
+	tenantId := auth.TenantId(c.Request.Context())
+	defaults, err := limits.TenantDefaults(c, h.DB, tenantId)
+	if err != nil {
+		internalError(c, "Failed to read limits")
+		return
+	}
+	override, err := limits.LoadOverride(c, h.DB, tenantId, walletId)
+	if err != nil {
+		internalError(c, "Failed to read limits")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"walletId": walletId, "override": override, "effective": override.Apply(defaults)})
+}
+
+// HandleSetLimits replaces the wallet's override as a whole, only wallets of
+// the caller's tenant that exist can have one
+func (h *Handler) HandleSetLimits(c *gin.Context) {
+	walletId, err := uuid.Parse(c.Param("walletId"))
+	if err != nil {
+		badRequest(c, "Invalid walletId format")
+		return
+	}
+	var override models.LimitsOverride
+	if err := c.ShouldBindJSON(&override); err != nil {
+		badRequest(c, err.Error())
+		return
+	}
+	for _, amount := range []*decimal.Decimal{override.MaxOperationAmount, override.DailyWithdrawal, override.MonthlyWithdrawal} {
+		if amount != nil && amount.IsNegative() {
+			badRequest(c, "Limits must not be negative")
+			return
+		}
+	}
+	if _, _, ok := h.balances(c, walletId); !ok {
+		return
+	}
+	tenantId := auth.TenantId(c.Request.Context())
+	defaults, err := limits.TenantDefaults(c, h.DB, tenantId)
+	if err != nil {
+		internalError(c, "Failed to read limits")
+		return
+	}
+	if err := limits.SaveOverride(c, h.DB, tenantId, walletId, override); err != nil {
+		internalError(c, "Failed to save limits")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"walletId": walletId, "override": override, "effective": override.Apply(defaults)})
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"wallet-api-server/internal/auth"
+	"wallet-api-server/internal/idempotency"
+	"wallet-api-server/internal/ledger"
+	"wallet-api-server/internal/limits"
+	"wallet-api-server/internal/problem"
+	"wallet-api-server/internal/queue"
+	"wallet-api-server/internal/scheduler"
+	"wallet-api-server/internal/tenant"
+	"wallet-api-server/internal/webhook"
+)
+
+// Codes of problems that are not caused by a domain error
+const (
+	codeWalletNotFound  = "WALLET_NOT_FOUND"
+	codeBalanceNotFound = "BALANCE_NOT_FOUND"
+)
+
+// errorProblems gives every domain error its HTTP status and its stable code,
+// errors not listed here are internal errors
+var errorProblems = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{queue.ErrWalletNotFound, http.StatusNotFound, codeWalletNotFound},
+	{queue.ErrWalletExists, http.StatusConflict, "WALLET_EXISTS"},
+	{queue.ErrWalletFrozen, http.StatusLocked, "WALLET_FROZEN"},
+	{queue.ErrWalletClosed, http.StatusGone, "WALLET_CLOSED"},
+	{queue.ErrInvalidStatusChange, http.StatusConflict, "INVALID_STATUS_CHANGE"},
+	{queue.ErrWalletNotEmpty, http.StatusConflict, "WALLET_NOT_EMPTY"},
+	{queue.ErrInsufficientFunds, http.StatusBadRequest, "INSUFFICIENT_FUNDS"},
+	{queue.ErrCurrencyMismatch, http.StatusBadRequest, "CURRENCY_MISMATCH"},
+	{queue.ErrInvalidAmount, http.StatusBadRequest, "INVALID_AMOUNT"},
+	{queue.ErrSameWallet, http.StatusBadRequest, "SAME_WALLET"},
+	{queue.ErrDuplicateReference, http.StatusConflict, "DUPLICATE_REFERENCE"},
+	{queue.ErrInvalidCreditLimit, http.StatusBadRequest, "INVALID_CREDIT_LIMIT"},
+	{queue.ErrHoldNotFound, http.StatusNotFound, "HOLD_NOT_FOUND"},
+	{queue.ErrHoldNotActive, http.StatusConflict, "HOLD_NOT_ACTIVE"},
+	{queue.ErrHoldExpired, http.StatusConflict, "HOLD_EXPIRED"},
+	{queue.ErrCaptureExceedsHold, http.StatusBadRequest, "CAPTURE_EXCEEDS_HOLD"},
+	{queue.ErrOperationNotFound, http.StatusNotFound, "OPERATION_NOT_FOUND"},
+	{queue.ErrNotReversible, http.StatusBadRequest, "NOT_REVERSIBLE"},
+	{queue.ErrAlreadyReversed, http.StatusConflict, "ALREADY_REVERSED"},
+	{queue.ErrReversalExceedsAmount, http.StatusBadRequest, "REVERSAL_EXCEEDS_AMOUNT"},
+	{idempotency.ErrKeyConflict, http.StatusUnprocessableEntity, "IDEMPOTENCY_KEY_CONFLICT"},
+	{ledger.ErrInvalidCursor, http.StatusBadRequest, "INVALID_CURSOR"},
+	{scheduler.ErrNotFound, http.StatusNotFound, "SCHEDULED_OPERATION_NOT_FOUND"},
+	{scheduler.ErrNotPending, http.StatusConflict, "SCHEDULED_OPERATION_NOT_PENDING"},
+	{scheduler.ErrStandingOrderNotFound, http.StatusNotFound, "STANDING_ORDER_NOT_FOUND"},
+	{scheduler.ErrStandingOrderStatus, http.StatusConflict, "STANDING_ORDER_STATUS"},
+	{webhook.ErrEndpointNotFound, http.StatusNotFound, "WEBHOOK_NOT_FOUND"},
+	{webhook.ErrDeliveryNotFound, http.StatusNotFound, "WEBHOOK_DELIVERY_NOT_FOUND"},
+	{webhook.ErrForbiddenUrl, http.StatusBadRequest, "INVALID_WEBHOOK_URL"},
+	{auth.ErrKeyNotFound, http.StatusNotFound, "API_KEY_NOT_FOUND"},
+	{tenant.ErrTenantNotFound, http.StatusNotFound, "TENANT_NOT_FOUND"},
+	{tenant.ErrTenantExists, http.StatusConflict, "TENANT_EXISTS"},
+	{tenant.ErrOperationNotAllowed, http.StatusForbidden, "OPERATION_NOT_ALLOWED"},
+}
+
+// errorProblem maps an error to the HTTP status and the code of its problem
+func errorProblem(err error) (int, string) {
+	var limitErr *limits.Error
+	if errors.As(err, &limitErr) {
+		if limitErr.Code == limits.CodeOperationCount {
+			return http.StatusTooManyRequests, limitErr.Code
+		}
+		return http.StatusForbidden, limitErr.Code
+	}
+	for _, p := range errorProblems {
+		if errors.Is(err, p.err) {
+			return p.status, p.code
+		}
+	}
+	return http.StatusInternalServerError, problem.CodeInternal
+}
+
+// writeError responds with the problem of err, detail is the message for people
+func writeError(c *gin.Context, err error, detail string, ext gin.H) {
+	status, code := errorProblem(err)
+	problem.Write(c, status, code, detail, ext)
+}
+
+// writeOpError responds with the problem of a failed queue operation
+func writeOpError(c *gin.Context, res queue.OpResult) {
+	var ext gin.H
+	if errors.Is(res.Err, queue.ErrCurrencyMismatch) {
+		ext = gin.H{"currency": res.Currency}
+	}
+	writeError(c, res.Err, res.Msg, ext)
+}
+
+func badRequest(c *gin.Context, detail string) {
+	problem.Write(c, http.StatusBadRequest, problem.CodeInvalidRequest, detail, nil)
+}
+
+func internalError(c *gin.Context, detail string) {
+	problem.Write(c, http.StatusInternalServerError, problem.CodeInternal, detail, nil)
+}
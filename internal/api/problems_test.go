@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"wallet-api-server/internal/cache"
+	"wallet-api-server/internal/idempotency"
+	"wallet-api-server/internal/limits"
+	"wallet-api-server/internal/problem"
+	"wallet-api-server/internal/queue"
+	"wallet-api-server/internal/scheduler"
+	"wallet-api-server/internal/tenant"
+)
+
+func TestErrorProblem(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{queue.ErrInsufficientFunds, http.StatusBadRequest, "INSUFFICIENT_FUNDS"},
+		{fmt.Errorf("withdraw: %w", queue.ErrInsufficientFunds), http.StatusBadRequest, "INSUFFICIENT_FUNDS"},
+		{queue.ErrWalletNotFound, http.StatusNotFound, "WALLET_NOT_FOUND"},
+		{queue.ErrWalletFrozen, http.StatusLocked, "WALLET_FROZEN"},
+		{queue.ErrWalletClosed, http.StatusGone, "WALLET_CLOSED"},
+		{queue.ErrCurrencyMismatch, http.StatusBadRequest, "CURRENCY_MISMATCH"},
+		{queue.ErrHoldExpired, http.StatusConflict, "HOLD_EXPIRED"},
+		{queue.ErrAlreadyReversed, http.StatusConflict, "ALREADY_REVERSED"},
+		{idempotency.ErrKeyConflict, http.StatusUnprocessableEntity, "IDEMPOTENCY_KEY_CONFLICT"},
+		{scheduler.ErrStandingOrderNotFound, http.StatusNotFound, "STANDING_ORDER_NOT_FOUND"},
+		{tenant.ErrOperationNotAllowed, http.StatusForbidden, "OPERATION_NOT_ALLOWED"},
+		{tenant.ErrTenantExists, http.StatusConflict, "TENANT_EXISTS"},
+		{&limits.Error{Code: limits.CodeDailyWithdrawal}, http.StatusForbidden, limits.CodeDailyWithdrawal},
+		{&limits.Error{Code: limits.CodeOperationCount}, http.StatusTooManyRequests, limits.CodeOperationCount},
+		{fmt.Errorf("connection reset"), http.StatusInternalServerError, problem.CodeInternal},
+	}
+	for _, tt := range tests {
+		status, code := errorProblem(tt.err)
+		assert.Equal(t, tt.status, status, tt.err.Error())
+		assert.Equal(t, tt.code, code, tt.err.Error())
+	}
+}
+
+func TestErrorProblem_CodesAreUnique(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, p := range errorProblems {
+		assert.False(t, seen[p.code], p.code)
+		seen[p.code] = true
+	}
+}
+
+func TestHandleGetBalance_ProblemDetails(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c := &cache.BalanceCache{}
+	h := NewHandler(c, &queue.QueueManager{Cache: c}, new(mockDBProvider))
+	r := gin.Default()
+	r.GET("/api/v1/wallets/:walletId", h.HandleGetBalance)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/wallets/"+uuid.New().String()+"?currency=usd1", nil)
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, problem.ContentType, w.Header().Get("Content-Type"))
+	var resp map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	assert.Equal(t, problem.CodeInvalidRequest, resp["code"])
+	assert.Equal(t, "urn:wallet-api:problem:invalid-request", resp["type"])
+	assert.Equal(t, float64(http.StatusBadRequest), resp["status"])
+	assert.Equal(t, "Invalid currency", resp["detail"])
+	assert.Equal(t, resp["detail"], resp["error"])
+}
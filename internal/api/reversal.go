@@ -0,0 +1,53 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"wallet-api-server/internal/auth"
+	"wallet-api-server/internal/models"
+)
+
+func (h *Handler) HandleReverseOperation(c *gin.Context) {
+	operationId, err := uuid.Parse(c.Param("operationId"))
+	if err != nil {
+		badRequest(c, "Invalid operationId format")
+		return
+	}
+	// An empty body reverses everything that was not reversed yet
+	var req models.ReversalRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		badRequest(c, err.Error())
+		return
+	}
+	if req.Amount != nil && !req.Amount.IsPositive() {
+		badRequest(c, "Amount must be positive")
+		return
+	}
+	if !bindIdempotencyKey(c, &req.IdempotencyKey) {
+		return
+	}
+	req.ApiKeyId = auth.KeyId(c.Request.Context())
+	req.TenantId = auth.TenantId(c.Request.Context())
+	res := h.Queue.Reverse(operationId, req)
+	if res.Replayed {
+		c.Header("Idempotent-Replayed", "true")
+	}
+	if res.Err != nil {
+		writeOpError(c, res)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"operationId":   res.OperationId,
+		"reversalOf":    res.Entry.ReversalOf,
+		"walletId":      res.Entry.WalletId,
+		"operationType": res.Entry.OperationType,
+		"amount":        res.Entry.Amount,
+		"balance":       res.Balance,
+		"currency":      res.Currency,
+	})
+}
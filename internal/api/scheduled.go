@@ -0,0 +1,106 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"wallet-api-server/internal/auth"
+	"wallet-api-server/internal/models"
+	"wallet-api-server/internal/scheduler"
+)
+
+const maxScheduledPageSize = 500
+
+func (h *Handler) HandleScheduleOperation(c *gin.Context) {
+	var req models.ScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err.Error())
+		return
+	}
+	if !req.Amount.IsPositive() {
+		badRequest(c, "Amount must be positive")
+		return
+	}
+	if !allowsOperation(c, models.TenantOperationOf(req.OperationType)) {
+		return
+	}
+	req.ApiKeyId = auth.KeyId(c.Request.Context())
+	req.TenantId = auth.TenantId(c.Request.Context())
+	s, err := scheduler.Create(c, h.DB, req)
+	if err != nil {
+		internalError(c, "Failed to schedule operation")
+		return
+	}
+	c.JSON(http.StatusCreated, s)
+}
+
+func (h *Handler) HandleListScheduled(c *gin.Context) {
+	walletId, err := uuid.Parse(c.Query("walletId"))
+	if err != nil {
+		badRequest(c, "Invalid walletId format")
+		return
+	}
+	status := models.ScheduleStatus(c.Query("status"))
+	switch status {
+	case "", models.SCHEDULE_PENDING, models.SCHEDULE_RUNNING, models.SCHEDULE_EXECUTED, models.SCHEDULE_FAILED, models.SCHEDULE_CANCELLED:
+	default:
+		badRequest(c, "Invalid status")
+		return
+	}
+	limit := maxScheduledPageSize
+	if v := c.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxScheduledPageSize {
+			badRequest(c, "Invalid limit")
+			return
+		}
+	}
+	ops, err := scheduler.List(c, h.DB, auth.TenantId(c.Request.Context()), walletId, status, limit)
+	if err != nil {
+		internalError(c, "Failed to read scheduled operations")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"walletId": walletId, "operations": ops})
+}
+
+func (h *Handler) HandleGetScheduled(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		badRequest(c, "Invalid id format")
+		return
+	}
+	s, err := scheduler.Get(c, h.DB, auth.TenantId(c.Request.Context()), id)
+	if err != nil {
+		writeScheduledError(c, s, err)
+		return
+	}
+	c.JSON(http.StatusOK, s)
+}
+
+func (h *Handler) HandleCancelScheduled(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		badRequest(c, "Invalid id format")
+		return
+	}
+	s, err := scheduler.Cancel(c, h.DB, auth.TenantId(c.Request.Context()), id)
+	if err != nil {
+		writeScheduledError(c, s, err)
+		return
+	}
+	c.JSON(http.StatusOK, s)
+}
+
+func writeScheduledError(c *gin.Context, s models.ScheduledOperation, err error) {
+	switch {
+	case errors.Is(err, scheduler.ErrNotFound):
+		writeError(c, err, "Scheduled operation not found", nil)
+	case errors.Is(err, scheduler.ErrNotPending):
+		writeError(c, err, "Scheduled operation is "+string(s.Status), gin.H{"operation": s})
+	default:
+		internalError(c, "Failed to read scheduled operation")
+	}
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"wallet-api-server/internal/auth"
+	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/models"
+	"wallet-api-server/internal/scheduler"
+)
+
+const maxExecutionsPageSize = 500
+
+func (h *Handler) HandleCreateStandingOrder(c *gin.Context) {
+	var req models.StandingOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err.Error())
+		return
+	}
+	if !req.Amount.IsPositive() {
+		badRequest(c, "Amount must be positive")
+		return
+	}
+	if req.EndAt != nil && !req.EndAt.After(req.StartAt) {
+		badRequest(c, "endAt must be after startAt")
+		return
+	}
+	if !allowsOperation(c, models.TENANT_TRANSFER) {
+		return
+	}
+	req.ApiKeyId = auth.KeyId(c.Request.Context())
+	req.TenantId = auth.TenantId(c.Request.Context())
+	o, err := scheduler.CreateStandingOrder(c, h.DB, req)
+	if err != nil {
+		internalError(c, "Failed to create standing order")
+		return
+	}
+	c.JSON(http.StatusCreated, o)
+}
+
+func (h *Handler) HandleListStandingOrders(c *gin.Context) {
+	walletId, err := uuid.Parse(c.Query("walletId"))
+	if err != nil {
+		badRequest(c, "Invalid walletId format")
+		return
+	}
+	orders, err := scheduler.ListStandingOrders(c, h.DB, auth.TenantId(c.Request.Context()), walletId)
+	if err != nil {
+		internalError(c, "Failed to read standing orders")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"walletId": walletId, "standingOrders": orders})
+}
+
+func (h *Handler) HandleGetStandingOrder(c *gin.Context) {
+	h.standingOrderAction(c, func(ctx context.Context, q db.DBProvider, tenantId string, id uuid.UUID) (models.StandingOrder, error) {
+		return scheduler.GetStandingOrder(ctx, q, tenantId, id)
+	})
+}
+
+func (h *Handler) HandlePauseStandingOrder(c *gin.Context) {
+	h.standingOrderAction(c, scheduler.PauseStandingOrder)
+}
+
+func (h *Handler) HandleResumeStandingOrder(c *gin.Context) {
+	h.standingOrderAction(c, scheduler.ResumeStandingOrder)
+}
+
+func (h *Handler) HandleDeleteStandingOrder(c *gin.Context) {
+	h.standingOrderAction(c, scheduler.DeleteStandingOrder)
+}
+
+func (h *Handler) HandleListStandingOrderExecutions(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		badRequest(c, "Invalid id format")
+		return
+	}
+	limit := maxExecutionsPageSize
+	if v := c.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxExecutionsPageSize {
+			badRequest(c, "Invalid limit")
+			return
+		}
+	}
+	tenantId := auth.TenantId(c.Request.Context())
+	if _, err = scheduler.GetStandingOrder(c, h.DB, tenantId, id); err != nil {
+		writeStandingOrderError(c, models.StandingOrder{}, err)
+		return
+	}
+	executions, err := scheduler.ListExecutions(c, h.DB, tenantId, id, limit)
+	if err != nil {
+		internalError(c, "Failed to read standing order executions")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"orderId": id, "executions": executions})
+}
+
+func (h *Handler) standingOrderAction(c *gin.Context, action func(context.Context, db.DBProvider, string, uuid.UUID) (models.StandingOrder, error)) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		badRequest(c, "Invalid id format")
+		return
+	}
+	o, err := action(c, h.DB, auth.TenantId(c.Request.Context()), id)
+	if err != nil {
+		writeStandingOrderError(c, o, err)
+		return
+	}
+	c.JSON(http.StatusOK, o)
+}
+
+func writeStandingOrderError(c *gin.Context, o models.StandingOrder, err error) {
+	switch {
+	case errors.Is(err, scheduler.ErrStandingOrderNotFound):
+		writeError(c, err, "Standing order not found", nil)
+	case errors.Is(err, scheduler.ErrStandingOrderStatus):
+		writeError(c, err, "Standing order is "+string(o.Status), gin.H{"standingOrder": o})
+	default:
+		internalError(c, "Failed to update standing order")
+	}
+}
@@ -0,0 +1,259 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	"wallet-api-server/internal/auth"
+	"wallet-api-server/internal/ledger"
+	"wallet-api-server/internal/models"
+	"wallet-api-server/internal/problem"
+	"wallet-api-server/internal/queue"
+)
+
+// statementFlushEvery is how many entries are written between flushes
+const statementFlushEvery = 100
+
+// statementLine is the NDJSON record of the opening or the closing balance
+type statementLine struct {
+	Type         string           `json:"type"`
+	WalletId     *uuid.UUID       `json:"walletId,omitempty"`
+	Currency     string           `json:"currency,omitempty"`
+	From         *time.Time       `json:"from,omitempty"`
+	To           *time.Time       `json:"to,omitempty"`
+	Balance      *decimal.Decimal `json:"balance,omitempty"`
+	TotalCredits *decimal.Decimal `json:"totalCredits,omitempty"`
+	TotalDebits  *decimal.Decimal `json:"totalDebits,omitempty"`
+	Entries      *int             `json:"entries,omitempty"`
+	// Open-ended statements are checked against the current wallet balance
+	WalletBalance *decimal.Decimal `json:"walletBalance,omitempty"`
+	Reconciled    *bool            `json:"reconciled,omitempty"`
+}
+
+// statementWriter renders a statement in one output format
+type statementWriter interface {
+	opening(walletId uuid.UUID, currency string, from *time.Time, balance decimal.Decimal) error
+	entry(t models.Transaction) error
+	closing(line statementLine) error
+	flush() error
+}
+
+type ndjsonStatement struct {
+	enc *json.Encoder
+	w   http.Flusher
+}
+
+func (s *ndjsonStatement) opening(walletId uuid.UUID, currency string, from *time.Time, balance decimal.Decimal) error {
+	return s.enc.Encode(statementLine{Type: "opening", WalletId: &walletId, Currency: currency, From: from, Balance: &balance})
+}
+
+func (s *ndjsonStatement) entry(t models.Transaction) error {
+	return s.enc.Encode(struct {
+		Type string `json:"type"`
+		models.Transaction
+	}{"entry", t})
+}
+
+func (s *ndjsonStatement) closing(line statementLine) error {
+	line.Type = "closing"
+	return s.enc.Encode(line)
+}
+
+func (s *ndjsonStatement) flush() error {
+	s.w.Flush()
+	return nil
+}
+
+var statementCSVHeader = []string{"record", "createdAt", "operationId", "operationType", "currency", "amount", "balance", "transferId", "holdId", "reversalOf"}
+
+type csvStatement struct {
+	w *csv.Writer
+}
+
+func (s *csvStatement) opening(walletId uuid.UUID, currency string, from *time.Time, balance decimal.Decimal) error {
+	if err := s.w.Write(statementCSVHeader); err != nil {
+		return err
+	}
+	return s.w.Write([]string{"OPENING", formatOptionalTime(from), "", "", currency, "", balance.String(), "", "", ""})
+}
+
+func (s *csvStatement) entry(t models.Transaction) error {
+	return s.w.Write([]string{"ENTRY", t.CreatedAt.UTC().Format(time.RFC3339Nano), t.OperationId.String(), string(t.OperationType), t.Currency,
+		t.Amount.String(), t.BalanceAfter.String(), formatOptionalId(t.TransferId), formatOptionalId(t.HoldId), formatOptionalId(t.ReversalOf)})
+}
+
+// closing reports the net change of the period as its amount
+func (s *csvStatement) closing(line statementLine) error {
+	return s.w.Write([]string{"CLOSING", formatOptionalTime(line.To), "", "", line.Currency,
+		line.TotalCredits.Sub(*line.TotalDebits).String(), line.Balance.String(), "", "", ""})
+}
+
+func (s *csvStatement) flush() error {
+	s.w.Flush()
+	return s.w.Error()
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func formatOptionalId(id *uuid.UUID) string {
+	if id == nil {
+		return ""
+	}
+	return id.String()
+}
+
+// HandleStatement streams the opening balance, every entry and the closing balance
+// of a period. All of it is read from one database snapshot, so the totals add up
+// and, for open-ended periods, the closing balance matches the wallet balance
+func (h *Handler) HandleStatement(c *gin.Context) {
+	walletId, err := uuid.Parse(c.Param("walletId"))
+	if err != nil {
+		badRequest(c, "Invalid walletId format")
+		return
+	}
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "ndjson" {
+		badRequest(c, "Invalid format, expected csv or ndjson")
+		return
+	}
+	currency := strings.ToUpper(c.Query("currency"))
+	if currency != "" && !models.IsCurrencyCode(currency) {
+		badRequest(c, "Invalid currency")
+		return
+	}
+	from, err := parseTimeQuery(c, "from")
+	if err != nil {
+		badRequest(c, "Invalid from, RFC 3339 timestamp expected")
+		return
+	}
+	to, err := parseTimeQuery(c, "to")
+	if err != nil {
+		badRequest(c, "Invalid to, RFC 3339 timestamp expected")
+		return
+	}
+	if from != nil && to != nil && !to.After(*from) {
+		badRequest(c, "to must be after from")
+		return
+	}
+
+	tx, err := h.DB.Begin(c)
+	if err != nil {
+		internalError(c, "Transaction error")
+		return
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(c); rollbackErr != nil {
+			log.Printf("Failed to rollback transaction: %v", rollbackErr)
+		}
+	}()
+	if _, err = tx.Exec(c, "SET TRANSACTION ISOLATION LEVEL REPEATABLE READ, READ ONLY"); err != nil {
+		internalError(c, "Transaction error")
+		return
+	}
+
+	tenantId := auth.TenantId(c.Request.Context())
+	balances, err := queue.LoadBalances(c, tx, tenantId, walletId)
+	if err != nil {
+		internalError(c, "Failed to read balance")
+		return
+	}
+	if len(balances) == 0 {
+		writeError(c, queue.ErrWalletNotFound, "Wallet not found", nil)
+		return
+	}
+	current := balances[0]
+	if currency != "" {
+		found := false
+		for _, b := range balances {
+			if b.Currency == currency {
+				current, found = b, true
+				break
+			}
+		}
+		if !found {
+			problem.Write(c, http.StatusNotFound, codeBalanceNotFound, "Wallet has no balance in "+currency, nil)
+			return
+		}
+	}
+
+	opening := decimal.Zero
+	if from != nil {
+		if opening, err = ledger.BalanceBefore(c, tx, tenantId, walletId, current.Currency, *from); err != nil {
+			internalError(c, "Failed to read balance")
+			return
+		}
+	}
+
+	var out statementWriter
+	if format == "ndjson" {
+		c.Header("Content-Type", "application/x-ndjson")
+		out = &ndjsonStatement{enc: json.NewEncoder(c.Writer), w: c.Writer}
+	} else {
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", `attachment; filename="statement-`+walletId.String()+`.csv"`)
+		out = &csvStatement{w: csv.NewWriter(c.Writer)}
+	}
+	c.Status(http.StatusOK)
+
+	// Once the body has started the status can no longer change, a failed
+	// statement is cut off before its closing line
+	if err = writeStatement(out, walletId, current, from, to, opening, func(fn func(models.Transaction) error) error {
+		return ledger.Stream(c, tx, tenantId, walletId, current.Currency, from, to, fn)
+	}); err != nil {
+		log.Printf("Failed to write statement of wallet %s: %v", walletId, err)
+	}
+}
+
+func writeStatement(out statementWriter, walletId uuid.UUID, current models.Balance, from, to *time.Time, opening decimal.Decimal,
+	stream func(func(models.Transaction) error) error) error {
+	if err := out.opening(walletId, current.Currency, from, opening); err != nil {
+		return err
+	}
+	credits, debits := decimal.Zero, decimal.Zero
+	count := 0
+	err := stream(func(t models.Transaction) error {
+		if t.OperationType.IsCredit() {
+			credits = credits.Add(t.Amount)
+		} else {
+			debits = debits.Add(t.Amount)
+		}
+		count++
+		if err := out.entry(t); err != nil {
+			return err
+		}
+		if count%statementFlushEvery == 0 {
+			return out.flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	closing := opening.Add(credits).Sub(debits)
+	line := statementLine{Currency: current.Currency, To: to, Balance: &closing, TotalCredits: &credits, TotalDebits: &debits, Entries: &count}
+	if to == nil || to.After(time.Now()) {
+		reconciled := closing.Equal(current.Balance)
+		line.WalletBalance, line.Reconciled = &current.Balance, &reconciled
+		if !reconciled {
+			log.Printf("Statement of wallet %s does not reconcile: ledger %s, wallet balance %s %s", walletId, closing, current.Balance, current.Currency)
+		}
+	}
+	if err := out.closing(line); err != nil {
+		return err
+	}
+	return out.flush()
+}
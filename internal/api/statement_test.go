@@ -0,0 +1,88 @@
+package api
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"wallet-api-server/internal/models"
+)
+
+func statementEntries(walletId uuid.UUID) []models.Transaction {
+	now := time.Now().UTC()
+	return []models.Transaction{
+		{OperationId: uuid.New(), WalletId: walletId, OperationType: models.DEPOSIT, Currency: "USD", Amount: decimal.NewFromInt(100), BalanceAfter: decimal.NewFromInt(110), CreatedAt: now},
+		{OperationId: uuid.New(), WalletId: walletId, OperationType: models.TRANSFER_OUT, Currency: "USD", Amount: decimal.NewFromInt(30), BalanceAfter: decimal.NewFromInt(80), CreatedAt: now},
+	}
+}
+
+func streamOf(entries []models.Transaction) func(func(models.Transaction) error) error {
+	return func(fn func(models.Transaction) error) error {
+		for _, t := range entries {
+			if err := fn(t); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func TestWriteStatement_NDJSONReconciles(t *testing.T) {
+	walletId := uuid.New()
+	w := httptest.NewRecorder()
+	out := &ndjsonStatement{enc: json.NewEncoder(w), w: w}
+	current := models.NewBalance("USD", decimal.NewFromInt(80), decimal.Zero, decimal.Zero)
+	from := time.Now().Add(-time.Hour)
+
+	err := writeStatement(out, walletId, current, &from, nil, decimal.NewFromInt(10), streamOf(statementEntries(walletId)))
+	assert.NoError(t, err)
+
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+	if assert.Len(t, lines, 4) {
+		assert.Equal(t, "opening", lines[0]["type"])
+		assert.Equal(t, "10", lines[0]["balance"])
+		assert.Equal(t, "entry", lines[1]["type"])
+		assert.Equal(t, walletId.String(), lines[1]["walletId"])
+		assert.Equal(t, "DEPOSIT", lines[1]["operationType"])
+		assert.Equal(t, "closing", lines[3]["type"])
+		assert.Equal(t, "80", lines[3]["balance"])
+		assert.Equal(t, "100", lines[3]["totalCredits"])
+		assert.Equal(t, "30", lines[3]["totalDebits"])
+		assert.Equal(t, true, lines[3]["reconciled"])
+	}
+}
+
+func TestWriteStatement_CSVClosedPeriod(t *testing.T) {
+	walletId := uuid.New()
+	w := httptest.NewRecorder()
+	out := &csvStatement{w: csv.NewWriter(w)}
+	// The wallet moved on after the period, a closed period is not checked against it
+	current := models.NewBalance("USD", decimal.NewFromInt(500), decimal.Zero, decimal.Zero)
+	to := time.Now().Add(-time.Minute)
+
+	err := writeStatement(out, walletId, current, nil, &to, decimal.Zero, streamOf(statementEntries(walletId)))
+	assert.NoError(t, err)
+
+	records, err := csv.NewReader(w.Body).ReadAll()
+	assert.NoError(t, err)
+	if assert.Len(t, records, 5) {
+		assert.Equal(t, statementCSVHeader, records[0])
+		assert.Equal(t, []string{"OPENING", "", "", "", "USD", "", "0", "", "", ""}, records[1])
+		assert.Equal(t, "ENTRY", records[2][0])
+		assert.Equal(t, "TRANSFER_OUT", records[3][3])
+		assert.Equal(t, []string{"CLOSING", to.UTC().Format(time.RFC3339Nano), "", "", "USD", "70", "70", "", "", ""}, records[4])
+	}
+}
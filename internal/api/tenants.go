@@ -0,0 +1,126 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"wallet-api-server/internal/auth"
+	"wallet-api-server/internal/models"
+	"wallet-api-server/internal/queue"
+	"wallet-api-server/internal/tenant"
+)
+
+// RequireTenantWallet answers 404 for a :walletId of another tenant, as if the wallet did
+// not exist. Unknown wallets pass, the handlers decide what a missing wallet means
+func (h *Handler) RequireTenantWallet(c *gin.Context) {
+	walletId, err := uuid.Parse(c.Param("walletId"))
+	if err != nil {
+		c.Next()
+		return
+	}
+	if err := queue.CheckTenant(c, h.DB, auth.TenantId(c.Request.Context()), walletId); err != nil {
+		if errors.Is(err, queue.ErrWalletNotFound) {
+			writeError(c, err, "Wallet not found", nil)
+		} else {
+			internalError(c, "Failed to read wallet")
+		}
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// allowsOperation writes a 403 response and returns false when the tenant's configuration does not allow op
+func allowsOperation(c *gin.Context, op models.TenantOperation) bool {
+	if auth.AllowsOperation(c.Request.Context(), op) {
+		return true
+	}
+	writeError(c, tenant.ErrOperationNotAllowed, "The tenant is not allowed to use "+string(op)+" operations", nil)
+	return false
+}
+
+func (h *Handler) HandleCreateTenant(c *gin.Context) {
+	var req models.CreateTenantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err.Error())
+		return
+	}
+	if !tenant.ValidId(req.Id) {
+		badRequest(c, "Tenant ids consist of lower case letters, digits and dashes")
+		return
+	}
+	t, err := tenant.Create(c, h.DB, req)
+	if err != nil {
+		if errors.Is(err, tenant.ErrTenantExists) {
+			writeError(c, err, "Tenant already exists", nil)
+		} else {
+			internalError(c, "Failed to create tenant")
+		}
+		return
+	}
+	c.JSON(http.StatusCreated, t)
+}
+
+func (h *Handler) HandleListTenants(c *gin.Context) {
+	tenants, err := tenant.List(c, h.DB)
+	if err != nil {
+		internalError(c, "Failed to read tenants")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tenants": tenants})
+}
+
+func (h *Handler) HandleGetTenant(c *gin.Context) {
+	t, err := tenant.Load(c, h.DB, c.Param("tenantId"))
+	if err != nil {
+		writeTenantError(c, err, "Failed to read tenant")
+		return
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+// HandleUpdateTenant replaces the configuration of a tenant as a whole
+func (h *Handler) HandleUpdateTenant(c *gin.Context) {
+	var req models.TenantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err.Error())
+		return
+	}
+	t, err := tenant.Update(c, h.DB, c.Param("tenantId"), req)
+	if err != nil {
+		writeTenantError(c, err, "Failed to update tenant")
+		return
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+// HandleCreateTenantAPIKey issues a key of another tenant, how a new tenant gets its first admin key
+func (h *Handler) HandleCreateTenantAPIKey(c *gin.Context) {
+	var req models.APIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err.Error())
+		return
+	}
+	t, err := tenant.Load(c, h.DB, c.Param("tenantId"))
+	if err != nil {
+		writeTenantError(c, err, "Failed to read tenant")
+		return
+	}
+	k, err := auth.CreateKey(c, h.DB, t.Id, req)
+	if err != nil {
+		internalError(c, "Failed to create API key")
+		return
+	}
+	c.JSON(http.StatusCreated, k)
+}
+
+func writeTenantError(c *gin.Context, err error, detail string) {
+	if errors.Is(err, tenant.ErrTenantNotFound) {
+		writeError(c, err, "Tenant not found", nil)
+	} else {
+		internalError(c, detail)
+	}
+}
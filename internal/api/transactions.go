@@ -0,0 +1,94 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"wallet-api-server/internal/auth"
+	"wallet-api-server/internal/ledger"
+	"wallet-api-server/internal/models"
+)
+
+func (h *Handler) HandleListTransactions(c *gin.Context) {
+	walletId, transactions, nextCursor, ok := h.listTransactions(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"walletId": walletId, "transactions": transactions, "nextCursor": nextCursor})
+}
+
+// listTransactions reads the page of the wallet's history the query asks for,
+// it writes the error response and returns false when that fails
+func (h *Handler) listTransactions(c *gin.Context) (uuid.UUID, []models.Transaction, string, bool) {
+	walletId, err := uuid.Parse(c.Param("walletId"))
+	if err != nil {
+		badRequest(c, "Invalid walletId format")
+		return walletId, nil, "", false
+	}
+
+	filter := ledger.Filter{TenantId: auth.TenantId(c.Request.Context()), WalletId: walletId, Cursor: c.Query("cursor")}
+	if v := c.Query("limit"); v != "" {
+		filter.Limit, err = strconv.Atoi(v)
+		if err != nil || filter.Limit <= 0 {
+			badRequest(c, "Invalid limit")
+			return walletId, nil, "", false
+		}
+	}
+	if v := c.Query("type"); v != "" {
+		filter.OperationType = models.OperationType(v)
+		switch filter.OperationType {
+		case models.DEPOSIT, models.WITHDRAW, models.TRANSFER_IN, models.TRANSFER_OUT, models.CAPTURE, models.REVERSAL_IN, models.REVERSAL_OUT:
+		default:
+			badRequest(c, "Invalid type")
+			return walletId, nil, "", false
+		}
+	}
+	if v := c.Query("currency"); v != "" {
+		filter.Currency = strings.ToUpper(v)
+		if !models.IsCurrencyCode(filter.Currency) {
+			badRequest(c, "Invalid currency")
+			return walletId, nil, "", false
+		}
+	}
+	if filter.Reference = c.Query("reference"); len(filter.Reference) > 128 {
+		badRequest(c, "Invalid reference")
+		return walletId, nil, "", false
+	}
+	if filter.From, err = parseTimeQuery(c, "from"); err != nil {
+		badRequest(c, "Invalid from, RFC 3339 timestamp expected")
+		return walletId, nil, "", false
+	}
+	if filter.To, err = parseTimeQuery(c, "to"); err != nil {
+		badRequest(c, "Invalid to, RFC 3339 timestamp expected")
+		return walletId, nil, "", false
+	}
+
+	transactions, nextCursor, err := ledger.List(c, h.DB, filter)
+	if err != nil {
+		if errors.Is(err, ledger.ErrInvalidCursor) {
+			badRequest(c, "Invalid cursor")
+		} else {
+			internalError(c, "Failed to read transactions")
+		}
+		return walletId, nil, "", false
+	}
+	return walletId, transactions, nextCursor, true
+}
+
+func parseTimeQuery(c *gin.Context, name string) (*time.Time, error) {
+	v := c.Query(name)
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"wallet-api-server/internal/auth"
+	"wallet-api-server/internal/models"
+	"wallet-api-server/internal/queue"
+)
+
+func (h *Handler) HandleTransfer(c *gin.Context) {
+	var req models.TransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err.Error())
+		return
+	}
+	if !req.Amount.IsPositive() {
+		badRequest(c, "Amount must be positive")
+		return
+	}
+	// Only the debited wallet has to be allowed, any wallet may be credited
+	if !auth.Allows(c, models.SCOPE_WITHDRAW, uuid.MustParse(req.FromWalletId)) {
+		auth.Forbid(c)
+		return
+	}
+	if !allowsOperation(c, models.TENANT_TRANSFER) {
+		return
+	}
+	req.ApiKeyId = auth.KeyId(c.Request.Context())
+	req.TenantId = auth.TenantId(c.Request.Context())
+	if !bindIdempotencyKey(c, &req.IdempotencyKey) {
+		return
+	}
+	res := h.Queue.Transfer(req)
+	if res.Replayed {
+		c.Header("Idempotent-Replayed", "true")
+	}
+	if res.Err != nil {
+		var ext gin.H
+		if errors.Is(res.Err, queue.ErrCurrencyMismatch) {
+			ext = gin.H{"currency": res.Currency}
+		}
+		writeError(c, res.Err, res.Msg, ext)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"transferId":   res.TransferId,
+		"fromWalletId": req.FromWalletId,
+		"fromBalance":  res.FromBalance,
+		"toWalletId":   req.ToWalletId,
+		"toBalance":    res.ToBalance,
+		"currency":     res.Currency,
+	})
+}
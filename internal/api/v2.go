@@ -0,0 +1,235 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"wallet-api-server/internal/auth"
+	"wallet-api-server/internal/models"
+	"wallet-api-server/internal/problem"
+	"wallet-api-server/internal/queue"
+)
+
+// API v2 runs the same queue operations and reads as v1, only the representation
+// differs: amounts are models.Money and every response names its currency
+
+type transactionV2 struct {
+	OperationId   uuid.UUID            `json:"operationId"`
+	WalletId      uuid.UUID            `json:"walletId"`
+	OperationType models.OperationType `json:"operationType"`
+	Currency      string               `json:"currency"`
+	Amount        models.Money         `json:"amount"`
+	BalanceAfter  models.Money         `json:"balanceAfter"`
+	TransferId    *uuid.UUID           `json:"transferId,omitempty"`
+	HoldId        *uuid.UUID           `json:"holdId,omitempty"`
+	ReversalOf    *uuid.UUID           `json:"reversalOf,omitempty"`
+	models.OperationDetails
+	ApiKeyId  *uuid.UUID `json:"apiKeyId,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+func newTransactionV2(t models.Transaction) transactionV2 {
+	return transactionV2{
+		OperationId:      t.OperationId,
+		WalletId:         t.WalletId,
+		OperationType:    t.OperationType,
+		Currency:         t.Currency,
+		Amount:           models.NewMoney(t.Amount, t.Currency),
+		BalanceAfter:     models.NewMoney(t.BalanceAfter, t.Currency),
+		TransferId:       t.TransferId,
+		HoldId:           t.HoldId,
+		ReversalOf:       t.ReversalOf,
+		OperationDetails: t.OperationDetails,
+		ApiKeyId:         t.ApiKeyId,
+		CreatedAt:        t.CreatedAt,
+	}
+}
+
+// operationV2 is the ledger entry an operation wrote with the state of the balance
+// right after it. Available is omitted for transfer legs
+type operationV2 struct {
+	transactionV2
+	Available *models.Money `json:"available,omitempty"`
+	Version   int64         `json:"version,omitempty"`
+}
+
+type balanceV2 struct {
+	Currency        string       `json:"currency"`
+	Balance         models.Money `json:"balance"`
+	Available       models.Money `json:"available"`
+	CreditLimit     models.Money `json:"creditLimit"`
+	AvailableCredit models.Money `json:"availableCredit"`
+	Version         int64        `json:"version"`
+	UpdatedAt       time.Time    `json:"updatedAt"`
+}
+
+func newBalanceV2(b models.Balance) balanceV2 {
+	return balanceV2{
+		Currency:        b.Currency,
+		Balance:         models.NewMoney(b.Balance, b.Currency),
+		Available:       models.NewMoney(b.Available, b.Currency),
+		CreditLimit:     models.NewMoney(b.CreditLimit, b.Currency),
+		AvailableCredit: models.NewMoney(b.AvailableCredit, b.Currency),
+		Version:         b.Version,
+		UpdatedAt:       b.UpdatedAt,
+	}
+}
+
+type pointInTimeBalanceV2 struct {
+	Currency string       `json:"currency"`
+	Balance  models.Money `json:"balance"`
+}
+
+func (h *Handler) HandleWalletOperationV2(c *gin.Context) {
+	var body models.WalletOperationRequestV2
+	if err := c.ShouldBindJSON(&body); err != nil {
+		badRequest(c, err.Error())
+		return
+	}
+	req, err := body.V1()
+	if err != nil {
+		writeError(c, queue.ErrInvalidAmount, err.Error(), nil)
+		return
+	}
+	walletId, err := uuid.Parse(req.WalletId)
+	if err != nil {
+		badRequest(c, "Invalid walletId format")
+		return
+	}
+	if !auth.Allows(c, models.OperationScope(req.OperationType), walletId) {
+		auth.Forbid(c)
+		return
+	}
+	if !allowsOperation(c, models.TenantOperationOf(req.OperationType)) {
+		return
+	}
+	req.ApiKeyId = auth.KeyId(c.Request.Context())
+	req.TenantId = auth.TenantId(c.Request.Context())
+	if !bindIdempotencyKey(c, &req.IdempotencyKey) {
+		return
+	}
+	res := h.Queue.Enqueue(walletId, req)
+	if res.Replayed {
+		c.Header("Idempotent-Replayed", "true")
+	}
+	if res.Err != nil {
+		writeOpError(c, res)
+		return
+	}
+	available := models.NewMoney(res.Available, res.Currency)
+	op := operationV2{transactionV2: newTransactionV2(*res.Entry), Available: &available, Version: res.Version}
+	c.JSON(http.StatusOK, op)
+}
+
+func (h *Handler) HandleTransferV2(c *gin.Context) {
+	var body models.TransferRequestV2
+	if err := c.ShouldBindJSON(&body); err != nil {
+		badRequest(c, err.Error())
+		return
+	}
+	req, err := body.V1()
+	if err != nil {
+		writeError(c, queue.ErrInvalidAmount, err.Error(), nil)
+		return
+	}
+	if !auth.Allows(c, models.SCOPE_WITHDRAW, uuid.MustParse(req.FromWalletId)) {
+		auth.Forbid(c)
+		return
+	}
+	if !allowsOperation(c, models.TENANT_TRANSFER) {
+		return
+	}
+	req.ApiKeyId = auth.KeyId(c.Request.Context())
+	req.TenantId = auth.TenantId(c.Request.Context())
+	if !bindIdempotencyKey(c, &req.IdempotencyKey) {
+		return
+	}
+	res := h.Queue.Transfer(req)
+	if res.Replayed {
+		c.Header("Idempotent-Replayed", "true")
+	}
+	if res.Err != nil {
+		var ext gin.H
+		if errors.Is(res.Err, queue.ErrCurrencyMismatch) {
+			ext = gin.H{"currency": res.Currency}
+		}
+		writeError(c, res.Err, res.Msg, ext)
+		return
+	}
+	from, to := res.Legs[0], res.Legs[1]
+	c.JSON(http.StatusOK, gin.H{
+		"transferId": res.TransferId,
+		"currency":   res.Currency,
+		"amount":     models.NewMoney(from.Amount, res.Currency),
+		"createdAt":  from.CreatedAt,
+		"from":       operationV2{transactionV2: newTransactionV2(from), Version: res.FromVersion},
+		"to":         operationV2{transactionV2: newTransactionV2(to), Version: res.ToVersion},
+	})
+}
+
+func (h *Handler) HandleGetBalanceV2(c *gin.Context) {
+	walletId, currency, asOf, ok := bindBalanceQuery(c)
+	if !ok {
+		return
+	}
+
+	if asOf != nil {
+		balances, ok := h.balancesAt(c, walletId, *asOf)
+		if !ok {
+			return
+		}
+		selected, found := pickBalance(balances, currency, func(b models.PointInTimeBalance) string { return b.Currency })
+		if !found {
+			problem.Write(c, http.StatusNotFound, codeBalanceNotFound, "Wallet had no balance in "+currency+" at asOf", nil)
+			return
+		}
+		all := make([]pointInTimeBalanceV2, len(balances))
+		for i, b := range balances {
+			all[i] = pointInTimeBalanceV2{Currency: b.Currency, Balance: models.NewMoney(b.Balance, b.Currency)}
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"walletId": walletId,
+			"asOf":     asOf.UTC(),
+			"currency": selected.Currency,
+			"balance":  models.NewMoney(selected.Balance, selected.Currency),
+			"balances": all,
+		})
+		return
+	}
+
+	balances, cached, ok := h.balances(c, walletId)
+	if !ok {
+		return
+	}
+	selected, found := pickBalance(balances, currency, func(b models.Balance) string { return b.Currency })
+	if !found {
+		problem.Write(c, http.StatusNotFound, codeBalanceNotFound, "Wallet has no balance in "+currency, nil)
+		return
+	}
+	all := make([]balanceV2, len(balances))
+	for i, b := range balances {
+		all[i] = newBalanceV2(b)
+	}
+	c.JSON(http.StatusOK, struct {
+		WalletId uuid.UUID `json:"walletId"`
+		balanceV2
+		Balances []balanceV2 `json:"balances"`
+		Cached   bool        `json:"cached"`
+	}{walletId, newBalanceV2(selected), all, cached})
+}
+
+func (h *Handler) HandleListTransactionsV2(c *gin.Context) {
+	walletId, transactions, nextCursor, ok := h.listTransactions(c)
+	if !ok {
+		return
+	}
+	all := make([]transactionV2, len(transactions))
+	for i, t := range transactions {
+		all[i] = newTransactionV2(t)
+	}
+	c.JSON(http.StatusOK, gin.H{"walletId": walletId, "transactions": all, "nextCursor": nextCursor})
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"wallet-api-server/internal/cache"
+	"wallet-api-server/internal/queue"
+)
+
+func TestHandleWalletOperationV2_InvalidAmount(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mdb := new(mockDBProvider)
+	h := NewHandler(&cache.BalanceCache{}, &queue.QueueManager{}, mdb)
+	r := gin.New()
+	r.POST("/api/v2/wallet", h.HandleWalletOperationV2)
+
+	walletId := uuid.NewString()
+	tests := []struct {
+		amount string
+		code   string
+	}{
+		{`"amount":10.5`, "INVALID_REQUEST"},
+		{``, "INVALID_AMOUNT"},
+		{`"amount":"10.50","amountMinor":1050,"currency":"USD"`, "INVALID_AMOUNT"},
+		{`"amountMinor":1050`, "INVALID_AMOUNT"},
+		{`"amountMinor":0,"currency":"USD"`, "INVALID_AMOUNT"},
+		{`"amount":"1e3"`, "INVALID_AMOUNT"},
+		{`"amount":"0"`, "INVALID_AMOUNT"},
+		{`"amount":"1.005","currency":"USD"`, "INVALID_AMOUNT"},
+		{`"amount":"1.5","currency":"JPY"`, "INVALID_AMOUNT"},
+		{`"amount":"1.00001"`, "INVALID_AMOUNT"},
+	}
+	for _, tt := range tests {
+		body := `{"walletId":"` + walletId + `","operationType":"DEPOSIT"`
+		if tt.amount != "" {
+			body += "," + tt.amount
+		}
+		body += "}"
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v2/wallet", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		var resp map[string]interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, tt.code, resp["code"], body)
+	}
+	mdb.AssertNotCalled(t, "Begin", mock.Anything)
+}
+
+func TestHandleGetBalanceV2(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c := &cache.BalanceCache{}
+	mdb := new(mockDBProvider)
+	id := uuid.New()
+	updatedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	rows := &mockRows{rows: [][]interface{}{
+		{"USD", decimal.RequireFromString("123.4"), decimal.NewFromInt(23), decimal.Zero, int64(3), updatedAt},
+		{"JPY", decimal.RequireFromString("0.5"), decimal.Zero, decimal.Zero, int64(1), updatedAt},
+	}}
+	mdb.On("Query", mock.Anything, mock.Anything, mock.Anything).Return(rows, nil)
+	h := NewHandler(c, &queue.QueueManager{Cache: c}, mdb)
+	r := gin.New()
+	r.GET("/api/v1/wallets/:walletId", h.HandleGetBalance)
+	r.GET("/api/v2/wallets/:walletId", h.HandleGetBalanceV2)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v2/wallets/"+id.String(), nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		WalletId  string                 `json:"walletId"`
+		Currency  string                 `json:"currency"`
+		Balance   map[string]interface{} `json:"balance"`
+		Available map[string]interface{} `json:"available"`
+		Version   int64                  `json:"version"`
+		UpdatedAt time.Time              `json:"updatedAt"`
+		Balances  []struct {
+			Currency string                 `json:"currency"`
+			Balance  map[string]interface{} `json:"balance"`
+		} `json:"balances"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, id.String(), resp.WalletId)
+	assert.Equal(t, "USD", resp.Currency)
+	assert.Equal(t, map[string]interface{}{"value": "123.40", "minor": float64(12340)}, resp.Balance)
+	assert.Equal(t, map[string]interface{}{"value": "100.40", "minor": float64(10040)}, resp.Available)
+	assert.Equal(t, int64(3), resp.Version)
+	assert.True(t, updatedAt.Equal(resp.UpdatedAt))
+	// Half a yen is not a whole number of minor units
+	assert.Equal(t, map[string]interface{}{"value": "0.5"}, resp.Balances[1].Balance)
+
+	// v1 keeps its representation, served from the cache v2 filled
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/wallets/"+id.String(), nil)
+	r.ServeHTTP(w, req)
+	var v1 map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &v1))
+	assert.Equal(t, "123.4", v1["balance"])
+	assert.Equal(t, true, v1["cached"])
+	assert.NotContains(t, v1, "version")
+	mdb.AssertNumberOfCalls(t, "Query", 1)
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"wallet-api-server/internal/auth"
+	"wallet-api-server/internal/models"
+	"wallet-api-server/internal/queue"
+)
+
+func (h *Handler) HandleCreateWallet(c *gin.Context) {
+	var req models.CreateWalletRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		badRequest(c, err.Error())
+		return
+	}
+	req.TenantId = auth.TenantId(c.Request.Context())
+	res := h.Queue.CreateWallet(req)
+	if res.Err != nil {
+		writeWalletError(c, res)
+		return
+	}
+	c.JSON(http.StatusCreated, res.Wallet)
+}
+
+func (h *Handler) HandleFreezeWallet(c *gin.Context) {
+	h.handleWalletStatus(c, h.Queue.FreezeWallet)
+}
+
+func (h *Handler) HandleUnfreezeWallet(c *gin.Context) {
+	h.handleWalletStatus(c, h.Queue.UnfreezeWallet)
+}
+
+func (h *Handler) handleWalletStatus(c *gin.Context, change func(string, uuid.UUID) queue.WalletResult) {
+	walletId, err := uuid.Parse(c.Param("walletId"))
+	if err != nil {
+		badRequest(c, "Invalid walletId format")
+		return
+	}
+	res := change(auth.TenantId(c.Request.Context()), walletId)
+	if res.Err != nil {
+		writeWalletError(c, res)
+		return
+	}
+	c.JSON(http.StatusOK, res.Wallet)
+}
+
+func (h *Handler) HandleCloseWallet(c *gin.Context) {
+	walletId, err := uuid.Parse(c.Param("walletId"))
+	if err != nil {
+		badRequest(c, "Invalid walletId format")
+		return
+	}
+	var req models.CloseWalletRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		badRequest(c, err.Error())
+		return
+	}
+	var sweepTo *uuid.UUID
+	if req.SweepToWalletId != "" {
+		id := uuid.MustParse(req.SweepToWalletId)
+		sweepTo = &id
+	}
+	res := h.Queue.CloseWallet(auth.TenantId(c.Request.Context()), walletId, sweepTo)
+	if res.Err != nil {
+		writeWalletError(c, res)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"walletId": res.Wallet.WalletId, "status": res.Wallet.Status, "balances": res.Wallet.Balances, "sweeps": res.Sweeps})
+}
+
+// HandleListWallets lists the wallets of an owner. A token's subject always lists its own wallets
+func (h *Handler) HandleListWallets(c *gin.Context) {
+	owner := c.Query("owner")
+	if p := auth.FromContext(c.Request.Context()); p != nil {
+		switch {
+		case p.Owner != "" && (owner == "" || owner == p.Owner):
+			owner = p.Owner
+		case p.Restricted():
+			auth.Forbid(c)
+			return
+		}
+	}
+	if owner == "" || len(owner) > 255 {
+		badRequest(c, "Invalid owner")
+		return
+	}
+	wallets, err := queue.ListWallets(c, h.DB, auth.TenantId(c.Request.Context()), owner)
+	if err != nil {
+		internalError(c, "Failed to read wallets")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"owner": owner, "wallets": wallets})
+}
+
+func (h *Handler) HandleSetOwner(c *gin.Context) {
+	walletId, err := uuid.Parse(c.Param("walletId"))
+	if err != nil {
+		badRequest(c, "Invalid walletId format")
+		return
+	}
+	var req models.OwnerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err.Error())
+		return
+	}
+	res := h.Queue.SetOwner(auth.TenantId(c.Request.Context()), walletId, req.Owner)
+	if res.Err != nil {
+		writeWalletError(c, res)
+		return
+	}
+	c.JSON(http.StatusOK, res.Wallet)
+}
+
+func writeWalletError(c *gin.Context, res queue.WalletResult) {
+	var ext gin.H
+	if errors.Is(res.Err, queue.ErrWalletExists) || errors.Is(res.Err, queue.ErrInvalidStatusChange) || errors.Is(res.Err, queue.ErrWalletNotEmpty) {
+		ext = gin.H{"status": res.Wallet.Status}
+	}
+	writeError(c, res.Err, res.Msg, ext)
+}
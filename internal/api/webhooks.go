@@ -0,0 +1,112 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"wallet-api-server/internal/auth"
+	"wallet-api-server/internal/models"
+	"wallet-api-server/internal/webhook"
+)
+
+const maxDeliveriesPageSize = 500
+
+func (h *Handler) HandleCreateWebhook(c *gin.Context) {
+	var req models.WebhookEndpointRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, err.Error())
+		return
+	}
+	req.TenantId = auth.TenantId(c.Request.Context())
+	e, err := webhook.CreateEndpoint(c, h.DB, req)
+	if err != nil {
+		if errors.Is(err, webhook.ErrForbiddenUrl) {
+			writeError(c, err, "Webhook url must resolve to a public address", nil)
+		} else {
+			internalError(c, "Failed to register webhook")
+		}
+		return
+	}
+	c.JSON(http.StatusCreated, e)
+}
+
+func (h *Handler) HandleListWebhooks(c *gin.Context) {
+	endpoints, err := webhook.ListEndpoints(c, h.DB, auth.TenantId(c.Request.Context()))
+	if err != nil {
+		internalError(c, "Failed to read webhooks")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": endpoints})
+}
+
+func (h *Handler) HandleDeleteWebhook(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		badRequest(c, "Invalid id format")
+		return
+	}
+	if err = webhook.DeleteEndpoint(c, h.DB, auth.TenantId(c.Request.Context()), id); err != nil {
+		if errors.Is(err, webhook.ErrEndpointNotFound) {
+			writeError(c, err, "Webhook not found", nil)
+		} else {
+			internalError(c, "Failed to delete webhook")
+		}
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) HandleListWebhookDeliveries(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		badRequest(c, "Invalid id format")
+		return
+	}
+	status := models.DeliveryStatus(c.Query("status"))
+	switch status {
+	case "", models.DELIVERY_PENDING, models.DELIVERY_DELIVERED, models.DELIVERY_DEAD:
+	default:
+		badRequest(c, "Invalid status")
+		return
+	}
+	limit := maxDeliveriesPageSize
+	if v := c.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxDeliveriesPageSize {
+			badRequest(c, "Invalid limit")
+			return
+		}
+	}
+	deliveries, err := webhook.ListDeliveries(c, h.DB, auth.TenantId(c.Request.Context()), id, status, limit)
+	if err != nil {
+		internalError(c, "Failed to read webhook deliveries")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"webhookId": id, "deliveries": deliveries})
+}
+
+func (h *Handler) HandleRedeliverWebhook(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		badRequest(c, "Invalid id format")
+		return
+	}
+	deliveryId, err := uuid.Parse(c.Param("deliveryId"))
+	if err != nil {
+		badRequest(c, "Invalid deliveryId format")
+		return
+	}
+	d, err := webhook.Redeliver(c, h.DB, auth.TenantId(c.Request.Context()), id, deliveryId)
+	if err != nil {
+		if errors.Is(err, webhook.ErrDeliveryNotFound) {
+			writeError(c, err, "Webhook delivery not found", nil)
+		} else {
+			internalError(c, "Failed to schedule redelivery")
+		}
+		return
+	}
+	c.JSON(http.StatusAccepted, d)
+}
@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+
+	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/models"
+	"wallet-api-server/internal/tenant"
+)
+
+var (
+	ErrUnauthenticated = errors.New("missing or invalid credentials")
+	ErrKeyNotFound     = errors.New("API key not found")
+)
+
+// APIKeysEnabled reports whether requests may authenticate with API keys
+func APIKeysEnabled() bool {
+	viper.AutomaticEnv()
+	viper.SetDefault("AUTH_API_KEYS", false)
+	return viper.GetBool("AUTH_API_KEYS")
+}
+
+// adminKey is a static key with the admin scope to create the first keys with
+func adminKey() string {
+	viper.AutomaticEnv()
+	viper.SetDefault("AUTH_ADMIN_KEY", "")
+	return viper.GetString("AUTH_ADMIN_KEY")
+}
+
+// Principal is who made a request and what it may do
+type Principal struct {
+	// KeyId is nil for the static admin key and for JWTs
+	KeyId *uuid.UUID
+	// Owner is the subject of a JWT, the principal is restricted to the wallets it owns
+	Owner  string
+	Scopes []models.Scope
+	// WalletIds restricts the principal to these wallets, nil allows all wallets
+	WalletIds []uuid.UUID
+	// Tenant is the tenant of the credentials, the principal cannot reach other tenants
+	Tenant models.Tenant
+	// signingSecret is set for keys whose requests have to be signed
+	signingSecret string
+}
+
+// RequiresSignature reports whether the requests of the principal have to be signed
+func (p *Principal) RequiresSignature() bool {
+	return p.signingSecret != ""
+}
+
+// HasScope reports whether the principal was granted scope, admin grants every scope
+func (p *Principal) HasScope(scope models.Scope) bool {
+	return slices.Contains(p.Scopes, scope) || slices.Contains(p.Scopes, models.SCOPE_ADMIN)
+}
+
+// Restricted reports whether the principal may only use some wallets
+func (p *Principal) Restricted() bool {
+	return p.WalletIds != nil
+}
+
+func (p *Principal) AllowsWallet(walletId uuid.UUID) bool {
+	return !p.Restricted() || slices.Contains(p.WalletIds, walletId)
+}
+
+// Allows reports whether the principal may use scope on the wallet
+func (p *Principal) Allows(scope models.Scope, walletId uuid.UUID) bool {
+	return p.HasScope(scope) && p.AllowsWallet(walletId)
+}
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the authenticated principal, nil when authentication is disabled
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(contextKey{}).(*Principal)
+	return p
+}
+
+// KeyId is the API key a request was made with, it is recorded with the operations of the request
+func KeyId(ctx context.Context) *uuid.UUID {
+	if p := FromContext(ctx); p != nil {
+		return p.KeyId
+	}
+	return nil
+}
+
+// TenantId is the tenant a request acts for, the default tenant without authentication
+func TenantId(ctx context.Context) string {
+	if p := FromContext(ctx); p != nil {
+		return p.Tenant.Id
+	}
+	return models.DefaultTenant
+}
+
+// AllowsOperation reports whether the tenant of the request may use op, always true without authentication
+func AllowsOperation(ctx context.Context, op models.TenantOperation) bool {
+	p := FromContext(ctx)
+	return p == nil || p.Tenant.Allows(op)
+}
+
+// CacheTTLSeconds is the balance cache TTL of the tenant of the request, nil for the global one
+func CacheTTLSeconds(ctx context.Context) *int {
+	if p := FromContext(ctx); p != nil {
+		return p.Tenant.CacheTTLSeconds
+	}
+	return nil
+}
+
+// Authenticator resolves the credentials of a request to its principal
+type Authenticator struct {
+	DB db.DBProvider
+	// JWT verifies the tokens of end users, nil when no JWT key is configured
+	JWT *JWTVerifier
+}
+
+func NewAuthenticator(dbProvider db.DBProvider) (*Authenticator, error) {
+	verifier, err := LoadJWTVerifier()
+	if err != nil {
+		return nil, err
+	}
+	return &Authenticator{DB: dbProvider, JWT: verifier}, nil
+}
+
+// Enabled reports whether every request has to authenticate, with an API key or a JWT
+func (a *Authenticator) Enabled() bool {
+	return APIKeysEnabled() || a.JWT != nil
+}
+
+// Authenticate resolves a token to its principal. Unknown, revoked and invalid credentials are ErrUnauthenticated
+func (a *Authenticator) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	if admin := adminKey(); admin != "" && subtle.ConstantTimeCompare([]byte(token), []byte(admin)) == 1 {
+		return a.withTenant(ctx, &Principal{Scopes: []models.Scope{models.SCOPE_ADMIN}}, models.DefaultTenant)
+	}
+	if a.JWT != nil && isJWT(token) {
+		return a.authenticateJWT(ctx, token)
+	}
+	if !APIKeysEnabled() || !strings.HasPrefix(token, tokenPrefix) {
+		return nil, ErrUnauthenticated
+	}
+	var id uuid.UUID
+	var scopes []string
+	var walletIds []uuid.UUID
+	var signingSecret *string
+	var tenantId string
+	err := a.DB.QueryRow(ctx, "SELECT id, scopes, wallet_ids, signing_secret, tenant_id FROM api_keys WHERE key_hash=$1 AND revoked_at IS NULL", hashToken(token)).
+		Scan(&id, &scopes, &walletIds, &signingSecret, &tenantId)
+	if err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return nil, ErrUnauthenticated
+		}
+		return nil, err
+	}
+	p := &Principal{KeyId: &id, Scopes: toScopes(scopes), WalletIds: walletIds}
+	if signingSecret != nil {
+		p.signingSecret = *signingSecret
+	}
+	return a.withTenant(ctx, p, tenantId)
+}
+
+// withTenant loads the configuration of the principal's tenant, credentials of an unknown tenant are ErrUnauthenticated
+func (a *Authenticator) withTenant(ctx context.Context, p *Principal, tenantId string) (*Principal, error) {
+	t, err := tenant.Load(ctx, a.DB, tenantId)
+	if err != nil {
+		if errors.Is(err, tenant.ErrTenantNotFound) {
+			return nil, ErrUnauthenticated
+		}
+		return nil, err
+	}
+	p.Tenant = t
+	return p, nil
+}
+
+// authenticateJWT restricts the subject of a token to the wallets it owns right now in the tenant of the token
+func (a *Authenticator) authenticateJWT(ctx context.Context, token string) (*Principal, error) {
+	claims, err := a.JWT.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := a.DB.Query(ctx, "SELECT DISTINCT wallet_id FROM wallets WHERE owner=$1 AND tenant_id=$2", claims.Subject, claims.Tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	walletIds := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		walletIds = append(walletIds, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return a.withTenant(ctx, &Principal{Owner: claims.Subject, Scopes: claims.Scopes(), WalletIds: walletIds}, claims.Tenant)
+}
@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/db/dbtest"
+	"wallet-api-server/internal/models"
+	"wallet-api-server/internal/tenant"
+)
+
+// tenantRow is the row of a tenant without any configuration
+func tenantRow(id string, allowed []string) *dbtest.Row {
+	values := map[string]interface{}{"id": id, "name": id}
+	if allowed != nil {
+		values["allowed_operations"] = allowed
+	}
+	return dbtest.NewRow(dbtest.RowOf(tenant.Columns, values)...)
+}
+
+func TestPrincipal_Allows(t *testing.T) {
+	walletId, other := uuid.New(), uuid.New()
+	admin := &Principal{Scopes: []models.Scope{models.SCOPE_ADMIN}}
+	assert.True(t, admin.Allows(models.SCOPE_WITHDRAW, walletId))
+
+	reader := &Principal{Scopes: []models.Scope{models.SCOPE_BALANCE_READ}, WalletIds: []uuid.UUID{walletId}}
+	assert.True(t, reader.Allows(models.SCOPE_BALANCE_READ, walletId))
+	assert.False(t, reader.Allows(models.SCOPE_BALANCE_READ, other))
+	assert.False(t, reader.Allows(models.SCOPE_DEPOSIT, walletId))
+}
+
+func TestBearerToken(t *testing.T) {
+	assert.Equal(t, "wk_abc", BearerToken("Bearer wk_abc"))
+	assert.Equal(t, "wk_abc", BearerToken("bearer  wk_abc "))
+	assert.Equal(t, "", BearerToken("Basic dXNlcjpwYXNz"))
+	assert.Equal(t, "", BearerToken("Bearer"))
+}
+
+func TestAuthenticate(t *testing.T) {
+	viper.Set("AUTH_API_KEYS", true)
+	viper.Set("AUTH_ADMIN_KEY", "bootstrap-secret")
+	defer viper.Set("AUTH_API_KEYS", false)
+	defer viper.Set("AUTH_ADMIN_KEY", "")
+	mdb := new(dbtest.DB)
+	a := &Authenticator{DB: mdb}
+
+	// Tokens that cannot be keys are refused without a lookup
+	_, err := a.Authenticate(context.Background(), "")
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+	_, err = a.Authenticate(context.Background(), "bootstrap")
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+	mdb.AssertNotCalled(t, "QueryRow", mock.Anything, mock.Anything, mock.Anything)
+
+	id, walletId := uuid.New(), uuid.New()
+	mdb.On("QueryRow", mock.Anything, dbtest.IsQuery("SELECT id, scopes, wallet_ids, signing_secret, tenant_id FROM api_keys"), []interface{}{hashToken("wk_known")}).
+		Return(dbtest.NewRow(id, []string{"balance:read"}, []uuid.UUID{walletId}, nil, "brand-a"))
+	mdb.On("QueryRow", mock.Anything, mock.Anything, []interface{}{models.DefaultTenant}).Return(tenantRow(models.DefaultTenant, nil))
+	mdb.On("QueryRow", mock.Anything, mock.Anything, []interface{}{"brand-a"}).Return(tenantRow("brand-a", []string{"DEPOSIT"}))
+	mdb.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(dbtest.ErrRow(db.ErrNoRows))
+
+	// The static admin key acts for the default tenant
+	p, err := a.Authenticate(context.Background(), "bootstrap-secret")
+	assert.NoError(t, err)
+	assert.Nil(t, p.KeyId)
+	assert.True(t, p.HasScope(models.SCOPE_DEPOSIT))
+	assert.Equal(t, models.DefaultTenant, p.Tenant.Id)
+
+	p, err = a.Authenticate(context.Background(), "wk_known")
+	assert.NoError(t, err)
+	assert.Equal(t, &id, p.KeyId)
+	assert.Equal(t, []models.Scope{models.SCOPE_BALANCE_READ}, p.Scopes)
+	assert.Equal(t, []uuid.UUID{walletId}, p.WalletIds)
+	assert.Equal(t, "brand-a", TenantId(NewContext(context.Background(), p)))
+	assert.True(t, p.Tenant.Allows(models.TENANT_DEPOSIT))
+	assert.False(t, p.Tenant.Allows(models.TENANT_WITHDRAW))
+
+	_, err = a.Authenticate(context.Background(), "wk_revoked")
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+
+	// Keys stop working when API keys are disabled
+	viper.Set("AUTH_API_KEYS", false)
+	_, err = a.Authenticate(context.Background(), "wk_known")
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+}
+
+func TestCreateKey_StoresHash(t *testing.T) {
+	mdb := new(dbtest.DB)
+	mdb.On("Exec", mock.Anything, dbtest.IsQuery("INSERT INTO api_keys"), mock.Anything).Return(nil, nil)
+	walletId := uuid.New()
+
+	k, err := CreateKey(context.Background(), mdb, models.DefaultTenant, models.APIKeyRequest{
+		Name: "checkout", Scopes: []models.Scope{models.SCOPE_DEPOSIT}, WalletIds: []string{walletId.String()},
+	})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(k.Token, tokenPrefix))
+	assert.Equal(t, k.Token[:prefixLength], k.Prefix)
+	assert.Equal(t, []uuid.UUID{walletId}, k.WalletIds)
+
+	args := mdb.Calls[0].Arguments.Get(2).([]interface{})
+	assert.Equal(t, hashToken(k.Token), args[2])
+	assert.NotContains(t, args, k.Token)
+	assert.Equal(t, []string{"wallet:deposit"}, args[4])
+}
+
+func TestMiddleware_Unauthenticated(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mdb := new(dbtest.DB)
+	mdb.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(dbtest.ErrRow(db.ErrNoRows))
+	r := gin.New()
+	r.Use(Middleware(&Authenticator{DB: mdb}))
+	r.GET("/api/v1/wallets/:walletId", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for _, header := range []string{"", "Bearer wk_unknown"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/wallets/"+uuid.NewString(), nil)
+		req.Header.Set("Authorization", header)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+		var resp map[string]interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, "UNAUTHORIZED", resp["code"])
+	}
+}
+
+func TestRequire(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	walletId := uuid.New()
+	tests := []struct {
+		name      string
+		principal *Principal
+		path      string
+		want      int
+	}{
+		{"authentication disabled", nil, "/wallets/" + uuid.NewString(), http.StatusOK},
+		{"scope", &Principal{Scopes: []models.Scope{models.SCOPE_BALANCE_READ}}, "/wallets/" + uuid.NewString(), http.StatusOK},
+		{"missing scope", &Principal{Scopes: []models.Scope{models.SCOPE_DEPOSIT}}, "/wallets/" + walletId.String(), http.StatusForbidden},
+		{"admin", &Principal{Scopes: []models.Scope{models.SCOPE_ADMIN}}, "/holds/" + uuid.NewString(), http.StatusOK},
+		{"allowed wallet", &Principal{Scopes: []models.Scope{models.SCOPE_BALANCE_READ}, WalletIds: []uuid.UUID{walletId}}, "/wallets/" + walletId.String(), http.StatusOK},
+		{"other wallet", &Principal{Scopes: []models.Scope{models.SCOPE_BALANCE_READ}, WalletIds: []uuid.UUID{walletId}}, "/wallets/" + uuid.NewString(), http.StatusForbidden},
+		{"route without a wallet", &Principal{Scopes: []models.Scope{models.SCOPE_BALANCE_READ}, WalletIds: []uuid.UUID{walletId}}, "/holds/" + uuid.NewString(), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := gin.New()
+		r.Use(func(c *gin.Context) {
+			if tt.principal != nil {
+				c.Request = c.Request.WithContext(NewContext(c.Request.Context(), tt.principal))
+			}
+		})
+		ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+		r.GET("/wallets/:walletId", Require(models.SCOPE_BALANCE_READ), ok)
+		r.GET("/holds/:holdId", Require(models.SCOPE_BALANCE_READ), ok)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", tt.path, nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, tt.want, w.Code, tt.name)
+	}
+}
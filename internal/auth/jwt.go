@@ -0,0 +1,292 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"wallet-api-server/internal/models"
+)
+
+// defaultUserScopes are granted to tokens without a scope claim
+var defaultUserScopes = []models.Scope{models.SCOPE_BALANCE_READ, models.SCOPE_DEPOSIT, models.SCOPE_WITHDRAW}
+
+// jwtKey verifies the signatures of one algorithm, HS256 with a secret or RS256 with a public key.
+// The tokens it verifies act in its tenant
+type jwtKey struct {
+	alg    string
+	secret []byte
+	public *rsa.PublicKey
+	tenant string
+}
+
+func (k jwtKey) verify(signed, signature []byte) bool {
+	switch k.alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case "RS256":
+		sum := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(k.public, crypto.SHA256, sum[:], signature) == nil
+	}
+	return false
+}
+
+// JWTVerifier checks the bearer JWTs of end users. Keys without a key id are tried for every token
+type JWTVerifier struct {
+	keys     map[string][]jwtKey
+	issuer   string
+	audience string
+	leeway   time.Duration
+	now      func() time.Time
+}
+
+// Claims are the registered claims the server reads, Scope holds space separated scopes.
+// Tenant is the tenant of the key that verified the token, a tenant claim has to name the same one
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	ExpiresAt *float64 `json:"exp"`
+	NotBefore *float64 `json:"nbf"`
+	Scope     string   `json:"scope"`
+	Tenant    string   `json:"tenant"`
+}
+
+// audience is a single string or an array of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// LoadJWTVerifier reads the keys of AUTH_JWT_HS256_SECRET, AUTH_JWT_RS256_PUBLIC_KEY (PEM) and
+// AUTH_JWT_JWKS_FILE. The first two belong to the default tenant, the keys of the set to the tenant
+// they name. It returns nil when none is configured, JWTs are not accepted then
+func LoadJWTVerifier() (*JWTVerifier, error) {
+	viper.AutomaticEnv()
+	viper.SetDefault("AUTH_JWT_HS256_SECRET", "")
+	viper.SetDefault("AUTH_JWT_RS256_PUBLIC_KEY", "")
+	viper.SetDefault("AUTH_JWT_JWKS_FILE", "")
+	viper.SetDefault("AUTH_JWT_ISSUER", "")
+	viper.SetDefault("AUTH_JWT_AUDIENCE", "")
+	viper.SetDefault("AUTH_JWT_LEEWAY", 60)
+
+	keys := map[string][]jwtKey{}
+	if secret := viper.GetString("AUTH_JWT_HS256_SECRET"); secret != "" {
+		keys[""] = append(keys[""], jwtKey{alg: "HS256", secret: []byte(secret), tenant: models.DefaultTenant})
+	}
+	if key := viper.GetString("AUTH_JWT_RS256_PUBLIC_KEY"); key != "" {
+		public, err := parsePublicKey([]byte(key))
+		if err != nil {
+			return nil, fmt.Errorf("AUTH_JWT_RS256_PUBLIC_KEY: %w", err)
+		}
+		keys[""] = append(keys[""], jwtKey{alg: "RS256", public: public, tenant: models.DefaultTenant})
+	}
+	if file := viper.GetString("AUTH_JWT_JWKS_FILE"); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := parseJWKS(data, keys); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	return &JWTVerifier{
+		keys:     keys,
+		issuer:   viper.GetString("AUTH_JWT_ISSUER"),
+		audience: viper.GetString("AUTH_JWT_AUDIENCE"),
+		leeway:   time.Duration(viper.GetInt("AUTH_JWT_LEEWAY")) * time.Second,
+		now:      time.Now,
+	}, nil
+}
+
+func parsePublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	public, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not an RSA public key")
+	}
+	return public, nil
+}
+
+// parseJWKS adds the RSA and symmetric keys of a JSON Web Key Set by their key ids. A key
+// belongs to the tenant of its "tenant" member, the default tenant when it has none
+func parseJWKS(data []byte, keys map[string][]jwtKey) error {
+	var set struct {
+		Keys []struct {
+			Kty    string `json:"kty"`
+			Kid    string `json:"kid"`
+			Alg    string `json:"alg"`
+			Use    string `json:"use"`
+			N      string `json:"n"`
+			E      string `json:"e"`
+			K      string `json:"k"`
+			Tenant string `json:"tenant"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return err
+	}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		tenant := k.Tenant
+		if tenant == "" {
+			tenant = models.DefaultTenant
+		}
+		switch {
+		case k.Kty == "RSA" && (k.Alg == "" || k.Alg == "RS256"):
+			n, err := base64.RawURLEncoding.DecodeString(k.N)
+			if err != nil {
+				return fmt.Errorf("key %q: %w", k.Kid, err)
+			}
+			e, err := base64.RawURLEncoding.DecodeString(k.E)
+			if err != nil {
+				return fmt.Errorf("key %q: %w", k.Kid, err)
+			}
+			public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+			keys[k.Kid] = append(keys[k.Kid], jwtKey{alg: "RS256", public: public, tenant: tenant})
+		case k.Kty == "oct" && (k.Alg == "" || k.Alg == "HS256"):
+			secret, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil {
+				return fmt.Errorf("key %q: %w", k.Kid, err)
+			}
+			keys[k.Kid] = append(keys[k.Kid], jwtKey{alg: "HS256", secret: secret, tenant: tenant})
+		}
+	}
+	return nil
+}
+
+// isJWT tells a compact JWT apart from an API key, which never contains a dot
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+func invalidToken(reason string) error {
+	return fmt.Errorf("%w: %s", ErrUnauthenticated, reason)
+}
+
+// Verify checks the signature and the time, issuer, audience and tenant claims of a token.
+// Tokens have to expire and name their subject
+func (v *JWTVerifier) Verify(token string) (Claims, error) {
+	var claims Claims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, invalidToken("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return claims, invalidToken("malformed header")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, invalidToken("malformed signature")
+	}
+	candidates := v.keys[""]
+	if header.Kid != "" {
+		candidates = append(slices.Clone(candidates), v.keys[header.Kid]...)
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	var key *jwtKey
+	for i, k := range candidates {
+		// The algorithm of the key decides, a token cannot pick a weaker one
+		if k.alg == header.Alg && k.verify(signed, signature) {
+			key = &candidates[i]
+			break
+		}
+	}
+	if key == nil {
+		return claims, invalidToken("invalid signature")
+	}
+
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return claims, invalidToken("malformed claims")
+	}
+	now := v.now()
+	switch {
+	case claims.Subject == "":
+		return claims, invalidToken("no subject")
+	case claims.ExpiresAt == nil:
+		return claims, invalidToken("no expiry")
+	case now.After(numericDate(*claims.ExpiresAt).Add(v.leeway)):
+		return claims, invalidToken("token expired")
+	case claims.NotBefore != nil && now.Add(v.leeway).Before(numericDate(*claims.NotBefore)):
+		return claims, invalidToken("token not valid yet")
+	case v.issuer != "" && claims.Issuer != v.issuer:
+		return claims, invalidToken("unexpected issuer")
+	case v.audience != "" && !slices.Contains(claims.Audience, v.audience):
+		return claims, invalidToken("unexpected audience")
+	// A token acts in the tenant of its key, it cannot name another one
+	case claims.Tenant != "" && claims.Tenant != key.tenant:
+		return claims, invalidToken("tenant of another key")
+	}
+	claims.Tenant = key.tenant
+	return claims, nil
+}
+
+// Scopes are the known scopes of the scope claim, the end user scopes when the claim is absent
+func (c Claims) Scopes() []models.Scope {
+	if c.Scope == "" {
+		return defaultUserScopes
+	}
+	scopes := []models.Scope{}
+	for _, s := range strings.Fields(c.Scope) {
+		switch scope := models.Scope(s); scope {
+		case models.SCOPE_BALANCE_READ, models.SCOPE_DEPOSIT, models.SCOPE_WITHDRAW, models.SCOPE_ADMIN:
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func numericDate(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/db/dbtest"
+	"wallet-api-server/internal/models"
+)
+
+var testNow = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func encodeSegment(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// sign builds a compact JWT, signature signs the header and claims segments
+func sign(header, claims map[string]interface{}, signature func([]byte) []byte) string {
+	signed := encodeSegment(header) + "." + encodeSegment(claims)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature([]byte(signed)))
+}
+
+func hs256(secret string) func([]byte) []byte {
+	return func(b []byte) []byte {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(b)
+		return mac.Sum(nil)
+	}
+}
+
+func rs256(key *rsa.PrivateKey) func([]byte) []byte {
+	return func(b []byte) []byte {
+		sum := sha256.Sum256(b)
+		sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+		return sig
+	}
+}
+
+func claimsFor(subject string) map[string]interface{} {
+	return map[string]interface{}{"sub": subject, "exp": testNow.Add(time.Hour).Unix(), "iss": "https://id.example.com", "aud": []string{"wallet"}}
+}
+
+func TestVerify_HS256(t *testing.T) {
+	v := &JWTVerifier{keys: map[string][]jwtKey{"": {{alg: "HS256", secret: []byte("s3cret")}}}, issuer: "https://id.example.com", audience: "wallet", leeway: time.Minute, now: func() time.Time { return testNow }}
+	header := map[string]interface{}{"alg": "HS256", "typ": "JWT"}
+
+	claims, err := v.Verify(sign(header, claimsFor("user-1"), hs256("s3cret")))
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", claims.Subject)
+	assert.Equal(t, defaultUserScopes, claims.Scopes())
+
+	expired := claimsFor("user-1")
+	expired["exp"] = testNow.Add(-2 * time.Minute).Unix()
+	withScope := claimsFor("user-1")
+	withScope["scope"] = "balance:read unknown"
+	noExpiry := claimsFor("user-1")
+	delete(noExpiry, "exp")
+	otherAudience := claimsFor("user-1")
+	otherAudience["aud"] = "billing"
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"wrong secret", sign(header, claimsFor("user-1"), hs256("other"))},
+		{"alg none", sign(map[string]interface{}{"alg": "none"}, claimsFor("user-1"), func([]byte) []byte { return nil })},
+		{"expired", sign(header, expired, hs256("s3cret"))},
+		{"no expiry", sign(header, noExpiry, hs256("s3cret"))},
+		{"no subject", sign(header, claimsFor(""), hs256("s3cret"))},
+		{"other audience", sign(header, otherAudience, hs256("s3cret"))},
+		{"malformed", "a.b"},
+	}
+	for _, tt := range tests {
+		_, err := v.Verify(tt.token)
+		assert.ErrorIs(t, err, ErrUnauthenticated, tt.name)
+	}
+
+	claims, err = v.Verify(sign(header, withScope, hs256("s3cret")))
+	assert.NoError(t, err)
+	assert.Equal(t, []models.Scope{models.SCOPE_BALANCE_READ}, claims.Scopes())
+}
+
+func TestLoadJWTVerifier_JWKS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	jwks := map[string]interface{}{"keys": []map[string]string{{
+		"kty": "RSA", "kid": "k1", "alg": "RS256", "use": "sig", "tenant": "brand-a",
+		"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}}
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	b, _ := json.Marshal(jwks)
+	assert.NoError(t, os.WriteFile(file, b, 0o600))
+	viper.Set("AUTH_JWT_JWKS_FILE", file)
+	defer viper.Set("AUTH_JWT_JWKS_FILE", "")
+
+	v, err := LoadJWTVerifier()
+	assert.NoError(t, err)
+	v.now = func() time.Time { return testNow }
+
+	claims, err := v.Verify(sign(map[string]interface{}{"alg": "RS256", "kid": "k1"}, claimsFor("user-1"), rs256(key)))
+	assert.NoError(t, err)
+	assert.Equal(t, "brand-a", claims.Tenant)
+
+	// The public key must not double as an HMAC secret
+	public, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public})
+	_, err = v.Verify(sign(map[string]interface{}{"alg": "HS256", "kid": "k1"}, claimsFor("user-1"), hs256(string(pemKey))))
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+}
+
+func TestLoadJWTVerifier_NotConfigured(t *testing.T) {
+	v, err := LoadJWTVerifier()
+	assert.NoError(t, err)
+	assert.Nil(t, v)
+	assert.False(t, (&Authenticator{}).Enabled())
+}
+
+func TestAuthenticate_JWTOwner(t *testing.T) {
+	mdb := new(dbtest.DB)
+	owned := uuid.New()
+	mdb.On("Query", mock.Anything, dbtest.IsQuery("SELECT DISTINCT wallet_id FROM wallets WHERE owner=$1 AND tenant_id=$2"),
+		[]interface{}{"user-1", models.DefaultTenant}).Return(dbtest.NewRows([]interface{}{owned}), nil)
+	mdb.On("Query", mock.Anything, mock.Anything, mock.Anything).Return(dbtest.NewRows(), nil)
+	mdb.On("QueryRow", mock.Anything, mock.Anything, []interface{}{models.DefaultTenant}).Return(tenantRow(models.DefaultTenant, nil))
+	mdb.On("QueryRow", mock.Anything, mock.Anything, []interface{}{"brand-a"}).Return(tenantRow("brand-a", nil))
+	mdb.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(dbtest.ErrRow(db.ErrNoRows))
+	a := &Authenticator{DB: mdb, JWT: &JWTVerifier{keys: map[string][]jwtKey{
+		"":        {{alg: "HS256", secret: []byte("s3cret"), tenant: models.DefaultTenant}},
+		"brand-a": {{alg: "HS256", secret: []byte("brand-a-secret"), tenant: "brand-a"}},
+		"brand-z": {{alg: "HS256", secret: []byte("brand-z-secret"), tenant: "brand-z"}},
+	}, now: func() time.Time { return testNow }}}
+	header := map[string]interface{}{"alg": "HS256"}
+
+	p, err := a.Authenticate(context.Background(), sign(header, claimsFor("user-1"), hs256("s3cret")))
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", p.Owner)
+	assert.Nil(t, p.KeyId)
+	assert.True(t, p.Allows(models.SCOPE_WITHDRAW, owned))
+	assert.False(t, p.Allows(models.SCOPE_BALANCE_READ, uuid.New()))
+
+	// A subject without wallets may not use any
+	p, err = a.Authenticate(context.Background(), sign(header, claimsFor("user-2"), hs256("s3cret")))
+	assert.NoError(t, err)
+	assert.True(t, p.Restricted())
+	assert.False(t, p.Allows(models.SCOPE_BALANCE_READ, owned))
+
+	// The same subject in another tenant owns other wallets, the tenant is the one of the key
+	brandA := map[string]interface{}{"alg": "HS256", "kid": "brand-a"}
+	inTenant := claimsFor("user-1")
+	inTenant["tenant"] = "brand-a"
+	p, err = a.Authenticate(context.Background(), sign(brandA, inTenant, hs256("brand-a-secret")))
+	assert.NoError(t, err)
+	assert.Equal(t, "brand-a", p.Tenant.Id)
+	assert.False(t, p.Allows(models.SCOPE_BALANCE_READ, owned))
+
+	_, err = a.Authenticate(context.Background(), sign(map[string]interface{}{"alg": "HS256", "kid": "brand-z"}, claimsFor("user-1"), hs256("brand-z-secret")))
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+
+	_, err = a.Authenticate(context.Background(), sign(header, claimsFor("user-1"), hs256("other")))
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+	mdb.AssertNumberOfCalls(t, "Query", 4)
+}
+
+func TestVerify_KeyTenant(t *testing.T) {
+	v := &JWTVerifier{keys: map[string][]jwtKey{
+		"":        {{alg: "HS256", secret: []byte("s3cret"), tenant: models.DefaultTenant}},
+		"brand-a": {{alg: "HS256", secret: []byte("brand-a-secret"), tenant: "brand-a"}},
+	}, now: func() time.Time { return testNow }}
+	header := map[string]interface{}{"alg": "HS256"}
+	brandA := map[string]interface{}{"alg": "HS256", "kid": "brand-a"}
+
+	claims, err := v.Verify(sign(header, claimsFor("user-1"), hs256("s3cret")))
+	assert.NoError(t, err)
+	assert.Equal(t, models.DefaultTenant, claims.Tenant)
+	claims, err = v.Verify(sign(brandA, claimsFor("user-1"), hs256("brand-a-secret")))
+	assert.NoError(t, err)
+	assert.Equal(t, "brand-a", claims.Tenant)
+
+	// A key cannot sign tokens for a tenant other than its own
+	foreign := claimsFor("user-1")
+	foreign["tenant"] = "brand-a"
+	_, err = v.Verify(sign(header, foreign, hs256("s3cret")))
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+	foreign["tenant"] = models.DefaultTenant
+	_, err = v.Verify(sign(brandA, foreign, hs256("brand-a-secret")))
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+}
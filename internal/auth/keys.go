@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+
+	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/models"
+)
+
+const (
+	tokenPrefix = "wk_"
+	// prefixLength is how much of a token is kept to tell keys apart
+	prefixLength = 11
+)
+
+func newToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// newSigningSecret is the HMAC secret of a signing key. Unlike tokens it has to be stored as it is
+func newSigningSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken is what is stored of a token. Tokens are random, so an unsalted hash is enough
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func toScopes(s []string) []models.Scope {
+	scopes := make([]models.Scope, len(s))
+	for i, scope := range s {
+		scopes[i] = models.Scope(scope)
+	}
+	return scopes
+}
+
+func fromScopes(scopes []models.Scope) []string {
+	s := make([]string, len(scopes))
+	for i, scope := range scopes {
+		s[i] = string(scope)
+	}
+	return s
+}
+
+const keyColumns = "id, name, prefix, scopes, wallet_ids, signing_secret IS NOT NULL, created_at, rotated_at, revoked_at"
+
+func scanKey(row db.RowScanner, k *models.APIKey) error {
+	var scopes []string
+	if err := row.Scan(&k.Id, &k.Name, &k.Prefix, &scopes, &k.WalletIds, &k.RequireSignature, &k.CreatedAt, &k.RotatedAt, &k.RevokedAt); err != nil {
+		return err
+	}
+	k.Scopes = toScopes(scopes)
+	return nil
+}
+
+// CreateKey issues a new key of the tenant, the only time its token is returned besides a rotation
+func CreateKey(ctx context.Context, dbProvider db.DBProvider, tenantId string, req models.APIKeyRequest) (models.APIKey, error) {
+	token, err := newToken()
+	if err != nil {
+		return models.APIKey{}, err
+	}
+	k := models.APIKey{Id: uuid.New(), Name: req.Name, Prefix: token[:prefixLength], Token: token, Scopes: req.Scopes,
+		RequireSignature: req.RequireSignature, CreatedAt: time.Now().UTC()}
+	var signingSecret *string
+	if req.RequireSignature {
+		if k.SigningSecret, err = newSigningSecret(); err != nil {
+			return models.APIKey{}, err
+		}
+		signingSecret = &k.SigningSecret
+	}
+	if len(req.WalletIds) > 0 {
+		k.WalletIds = make([]uuid.UUID, len(req.WalletIds))
+		for i, id := range req.WalletIds {
+			k.WalletIds[i] = uuid.MustParse(id)
+		}
+	}
+	_, err = dbProvider.Exec(ctx, "INSERT INTO api_keys (id, name, key_hash, prefix, scopes, wallet_ids, created_at, signing_secret, tenant_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		k.Id, k.Name, hashToken(token), k.Prefix, fromScopes(k.Scopes), k.WalletIds, k.CreatedAt, signingSecret, tenantId)
+	return k, err
+}
+
+// ListKeys returns all keys of the tenant, revoked ones included
+func ListKeys(ctx context.Context, q db.Querier, tenantId string) ([]models.APIKey, error) {
+	rows, err := q.Query(ctx, "SELECT "+keyColumns+" FROM api_keys WHERE tenant_id=$1 ORDER BY created_at, id", tenantId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := []models.APIKey{}
+	for rows.Next() {
+		var k models.APIKey
+		if err := scanKey(rows, &k); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// RotateKey replaces the token and the signing secret of a key that is not revoked,
+// the old ones stop working at once
+func RotateKey(ctx context.Context, dbProvider db.DBProvider, tenantId string, id uuid.UUID) (models.APIKey, error) {
+	token, err := newToken()
+	if err != nil {
+		return models.APIKey{}, err
+	}
+	signingSecret, err := newSigningSecret()
+	if err != nil {
+		return models.APIKey{}, err
+	}
+	var k models.APIKey
+	err = scanKey(dbProvider.QueryRow(ctx,
+		"UPDATE api_keys SET key_hash=$1, prefix=$2, signing_secret=CASE WHEN signing_secret IS NULL THEN NULL ELSE $3 END, rotated_at=now() "+
+			"WHERE id=$4 AND tenant_id=$5 AND revoked_at IS NULL RETURNING "+keyColumns,
+		hashToken(token), token[:prefixLength], signingSecret, id, tenantId), &k)
+	if err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return k, ErrKeyNotFound
+		}
+		return k, err
+	}
+	k.Token = token
+	if k.RequireSignature {
+		k.SigningSecret = signingSecret
+	}
+	return k, nil
+}
+
+// RevokeKey disables a key for good
+func RevokeKey(ctx context.Context, dbProvider db.DBProvider, tenantId string, id uuid.UUID) error {
+	var revoked uuid.UUID
+	err := dbProvider.QueryRow(ctx, "UPDATE api_keys SET revoked_at=now() WHERE id=$1 AND tenant_id=$2 AND revoked_at IS NULL RETURNING id", id, tenantId).Scan(&revoked)
+	if errors.Is(err, db.ErrNoRows) {
+		return ErrKeyNotFound
+	}
+	return err
+}
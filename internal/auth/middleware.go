@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"wallet-api-server/internal/models"
+	"wallet-api-server/internal/problem"
+)
+
+const APIKeyHeader = "X-API-Key"
+
+// BearerToken is the token of an Authorization header value, empty for other schemes
+func BearerToken(authorization string) string {
+	if len(authorization) > 7 && strings.EqualFold(authorization[:7], "Bearer ") {
+		return strings.TrimSpace(authorization[7:])
+	}
+	return ""
+}
+
+// token reads the credentials from the Authorization bearer token or the X-API-Key header
+func token(c *gin.Context) string {
+	if t := BearerToken(c.GetHeader("Authorization")); t != "" {
+		return t
+	}
+	return c.GetHeader(APIKeyHeader)
+}
+
+// Middleware authenticates every request and makes its principal available to FromContext
+func Middleware(a *Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, err := a.Authenticate(c, token(c))
+		if err != nil {
+			if errors.Is(err, ErrUnauthenticated) {
+				c.Header("WWW-Authenticate", `Bearer realm="wallet-api"`)
+				problem.Abort(c, http.StatusUnauthorized, problem.CodeUnauthorized, "A valid API key or token is required", nil)
+			} else {
+				problem.Abort(c, http.StatusInternalServerError, problem.CodeInternal, "Failed to authenticate", nil)
+			}
+			return
+		}
+		c.Request = c.Request.WithContext(NewContext(c.Request.Context(), p))
+		c.Next()
+	}
+}
+
+// Require lets a request through when its principal has any of the scopes. On routes
+// with a :walletId the principal has to be allowed that wallet, keys restricted to
+// some wallets are refused on routes that do not name one
+func Require(scopes ...models.Scope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p := FromContext(c.Request.Context())
+		if p == nil {
+			c.Next()
+			return
+		}
+		if !hasAny(p, scopes) {
+			Forbid(c)
+			c.Abort()
+			return
+		}
+		if p.Restricted() {
+			walletId, err := uuid.Parse(c.Param("walletId"))
+			if err != nil || !p.AllowsWallet(walletId) {
+				Forbid(c)
+				c.Abort()
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// RequireScope only checks the scopes, for handlers that check the wallets
+// named in the request body themselves with Allows
+func RequireScope(scopes ...models.Scope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if p := FromContext(c.Request.Context()); p != nil && !hasAny(p, scopes) {
+			Forbid(c)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireOperator lets only the default tenant through, its admins run the
+// deployment and manage the other tenants
+func RequireOperator() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if p := FromContext(c.Request.Context()); p != nil && p.Tenant.Id != models.DefaultTenant {
+			Forbid(c)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// Allows reports whether the request may use scope on the wallet, always true without authentication
+func Allows(c *gin.Context, scope models.Scope, walletId uuid.UUID) bool {
+	p := FromContext(c.Request.Context())
+	return p == nil || p.Allows(scope, walletId)
+}
+
+// Forbid responds that the credentials do not allow the request
+func Forbid(c *gin.Context) {
+	problem.Write(c, http.StatusForbidden, problem.CodeForbidden, "The API key does not allow this request", nil)
+}
+
+func hasAny(p *Principal, scopes []models.Scope) bool {
+	for _, scope := range scopes {
+		if p.HasScope(scope) {
+			return true
+		}
+	}
+	return false
+}
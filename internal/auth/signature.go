@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+
+	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/problem"
+)
+
+const (
+	SignatureHeader = "X-Signature"
+	TimestampHeader = "X-Signature-Timestamp"
+	NonceHeader     = "X-Signature-Nonce"
+	maxNonceLength  = 128
+)
+
+// ErrNonceReused is returned when a nonce was already used with the key
+var ErrNonceReused = errors.New("nonce already used")
+
+// SignatureTolerance is how far the timestamp of a signed request may be from the server's clock
+func SignatureTolerance() time.Duration {
+	viper.AutomaticEnv()
+	viper.SetDefault("AUTH_SIGNATURE_TOLERANCE", 300)
+	return time.Duration(viper.GetInt("AUTH_SIGNATURE_TOLERANCE")) * time.Second
+}
+
+// SignatureMaxBody is the largest body in bytes a signed request may have, it is read
+// into memory to be verified
+func SignatureMaxBody() int64 {
+	viper.AutomaticEnv()
+	viper.SetDefault("AUTH_SIGNATURE_MAX_BODY", 1<<20)
+	return viper.GetInt64("AUTH_SIGNATURE_MAX_BODY")
+}
+
+// nonceRetention covers every request whose timestamp is still accepted, a nonce
+// can be reused once a request with it would be stale anyway
+func nonceRetention() time.Duration {
+	return 2 * SignatureTolerance()
+}
+
+// StringToSign is what a request signature covers: the method, the path with its query,
+// the timestamp, the nonce and the hex SHA-256 digest of the body, one per line
+func StringToSign(method, path, timestamp, nonce string, body []byte) string {
+	sum := sha256.Sum256(body)
+	return strings.Join([]string{method, path, timestamp, nonce, hex.EncodeToString(sum[:])}, "\n")
+}
+
+// Sign is the hex HMAC-SHA256 of the string to sign with the signing secret of a key
+func Sign(secret, method, path, timestamp, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(StringToSign(method, path, timestamp, nonce, body)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// saveNonce records the nonce of a key of the tenant, a nonce that is still retained is ErrNonceReused
+func saveNonce(ctx context.Context, dbProvider db.DBProvider, tenantId string, keyId uuid.UUID, nonce string) error {
+	now := time.Now()
+	var stored string
+	err := dbProvider.QueryRow(ctx, `
+		INSERT INTO request_nonces (tenant_id, api_key_id, nonce, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (tenant_id, api_key_id, nonce) DO UPDATE
+		SET created_at = EXCLUDED.created_at
+		WHERE request_nonces.created_at <= $5
+		RETURNING nonce`,
+		tenantId, keyId, nonce, now, now.Add(-nonceRetention())).Scan(&stored)
+	if errors.Is(err, db.ErrNoRows) {
+		return ErrNonceReused
+	}
+	return err
+}
+
+func PurgeNonces(ctx context.Context, dbProvider db.DBProvider) error {
+	_, err := dbProvider.Exec(ctx, "DELETE FROM request_nonces WHERE created_at <= $1", time.Now().Add(-nonceRetention()))
+	return err
+}
+
+// StartNonceJanitor periodically deletes the nonces that no longer need to be kept until ctx is cancelled
+func StartNonceJanitor(ctx context.Context, dbProvider db.DBProvider, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := PurgeNonces(ctx, dbProvider); err != nil {
+					log.Printf("Failed to purge request nonces: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// VerifySignature checks the signature of requests made with a key that requires one. Stale
+// timestamps and reused nonces are rejected, requests with other credentials pass unchanged
+func VerifySignature(dbProvider db.DBProvider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p := FromContext(c.Request.Context())
+		if p == nil || !p.RequiresSignature() {
+			c.Next()
+			return
+		}
+		signature, timestamp, nonce := c.GetHeader(SignatureHeader), c.GetHeader(TimestampHeader), c.GetHeader(NonceHeader)
+		if signature == "" || timestamp == "" || nonce == "" {
+			invalidSignature(c, "The request has to be signed with the signing secret of the API key")
+			return
+		}
+		if len(nonce) > maxNonceLength {
+			invalidSignature(c, "The nonce is too long")
+			return
+		}
+		seconds, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			invalidSignature(c, "The timestamp has to be in Unix seconds")
+			return
+		}
+		if skew := time.Since(time.Unix(seconds, 0)); skew > SignatureTolerance() || skew < -SignatureTolerance() {
+			invalidSignature(c, "The timestamp is too old or in the future")
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, SignatureMaxBody()))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				problem.Abort(c, http.StatusRequestEntityTooLarge, problem.CodePayloadTooLarge,
+					"The body of a signed request may be at most "+strconv.FormatInt(tooLarge.Limit, 10)+" bytes", nil)
+			} else {
+				invalidSignature(c, "The body could not be read")
+			}
+			return
+		}
+		// The handler reads the body again
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		want := Sign(p.signingSecret, c.Request.Method, c.Request.URL.RequestURI(), timestamp, nonce, body)
+		if !hmac.Equal([]byte(want), []byte(strings.ToLower(signature))) {
+			invalidSignature(c, "The signature does not match the request")
+			return
+		}
+		// Only a valid signature uses up the nonce
+		if err := saveNonce(c, dbProvider, p.Tenant.Id, *p.KeyId, nonce); err != nil {
+			if errors.Is(err, ErrNonceReused) {
+				invalidSignature(c, "The nonce was already used")
+			} else {
+				problem.Abort(c, http.StatusInternalServerError, problem.CodeInternal, "Failed to verify the signature", nil)
+			}
+			return
+		}
+		c.Next()
+	}
+}
+
+func invalidSignature(c *gin.Context, detail string) {
+	problem.Abort(c, http.StatusUnauthorized, problem.CodeInvalidSignature, detail, nil)
+}
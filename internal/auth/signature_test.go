@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/db/dbtest"
+	"wallet-api-server/internal/models"
+)
+
+func signedRouter(mdb *dbtest.DB, p *Principal) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Request = c.Request.WithContext(NewContext(c.Request.Context(), p))
+	})
+	r.Use(VerifySignature(mdb))
+	r.POST("/api/v1/wallet", func(c *gin.Context) {
+		var req map[string]interface{}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.Status(http.StatusBadRequest)
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+	return r
+}
+
+func signedRequest(secret, body, timestamp, nonce string) *http.Request {
+	req, _ := http.NewRequest("POST", "/api/v1/wallet?dryRun=false", strings.NewReader(body))
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(NonceHeader, nonce)
+	req.Header.Set(SignatureHeader, Sign(secret, "POST", "/api/v1/wallet?dryRun=false", timestamp, nonce, []byte(body)))
+	return req
+}
+
+func TestVerifySignature(t *testing.T) {
+	keyId := uuid.New()
+	mdb := new(dbtest.DB)
+	mdb.On("QueryRow", mock.Anything, dbtest.IsQuery("INSERT INTO request_nonces"), mock.MatchedBy(func(args []interface{}) bool {
+		return args[0] == "brand-a" && args[1] == keyId && args[2] == "n-1"
+	})).Return(dbtest.NewRow("n-1")).Once()
+	mdb.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(dbtest.ErrRow(db.ErrNoRows))
+	r := signedRouter(mdb, &Principal{KeyId: &keyId, Scopes: []models.Scope{models.SCOPE_DEPOSIT}, Tenant: models.Tenant{Id: "brand-a"}, signingSecret: "secret"})
+	body := `{"walletId":"` + uuid.NewString() + `","operationType":"DEPOSIT","amount":"10"}`
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	// The handler still reads the body
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, signedRequest("secret", body, now, "n-1"))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	tampered := signedRequest("secret", body, now, "n-2")
+	tampered.Body = http.NoBody
+	unsigned, _ := http.NewRequest("POST", "/api/v1/wallet", strings.NewReader(body))
+	tests := []struct {
+		name string
+		req  *http.Request
+	}{
+		{"replayed", signedRequest("secret", body, now, "n-1")},
+		{"wrong secret", signedRequest("other", body, now, "n-3")},
+		{"tampered body", tampered},
+		{"stale", signedRequest("secret", body, strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10), "n-4")},
+		{"future", signedRequest("secret", body, strconv.FormatInt(time.Now().Add(10*time.Minute).Unix(), 10), "n-5")},
+		{"unsigned", unsigned},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, tt.req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, tt.name)
+		var resp map[string]interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, "INVALID_SIGNATURE", resp["code"], tt.name)
+	}
+	// Only the valid signature and the replay reached the nonce store
+	mdb.AssertNumberOfCalls(t, "QueryRow", 2)
+}
+
+func TestVerifySignature_BodyTooLarge(t *testing.T) {
+	viper.Set("AUTH_SIGNATURE_MAX_BODY", 64)
+	defer viper.Set("AUTH_SIGNATURE_MAX_BODY", 1<<20)
+	keyId := uuid.New()
+	mdb := new(dbtest.DB)
+	r := signedRouter(mdb, &Principal{KeyId: &keyId, Scopes: []models.Scope{models.SCOPE_DEPOSIT}, signingSecret: "secret"})
+	body := `{"walletId":"` + uuid.NewString() + `","operationType":"DEPOSIT","amount":"10"}`
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, signedRequest("secret", body, strconv.FormatInt(time.Now().Unix(), 10), "n-1"))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	var resp map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "PAYLOAD_TOO_LARGE", resp["code"])
+	mdb.AssertNotCalled(t, "QueryRow", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestVerifySignature_KeyWithoutSecret(t *testing.T) {
+	keyId := uuid.New()
+	mdb := new(dbtest.DB)
+	r := signedRouter(mdb, &Principal{KeyId: &keyId, Scopes: []models.Scope{models.SCOPE_DEPOSIT}})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/wallet", strings.NewReader(`{}`))
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	mdb.AssertNotCalled(t, "QueryRow", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestCreateKey_SigningSecret(t *testing.T) {
+	mdb := new(dbtest.DB)
+	mdb.On("Exec", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+
+	k, err := CreateKey(context.Background(), mdb, models.DefaultTenant, models.APIKeyRequest{
+		Name: "payouts", Scopes: []models.Scope{models.SCOPE_WITHDRAW}, RequireSignature: true,
+	})
+	assert.NoError(t, err)
+	assert.True(t, k.RequireSignature)
+	assert.Len(t, k.SigningSecret, 64)
+	args := mdb.Calls[0].Arguments.Get(2).([]interface{})
+	assert.Equal(t, &k.SigningSecret, args[7])
+}
@@ -5,12 +5,15 @@ import (
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/shopspring/decimal"
 	"github.com/spf13/viper"
+
+	"wallet-api-server/internal/models"
 )
 
 type CacheEntry struct {
-	Balance    decimal.Decimal
+	// TenantId is the tenant the balances were read for, other tenants never get them
+	TenantId   string
+	Balances   []models.Balance
 	Expiration time.Time
 }
 
@@ -27,26 +30,42 @@ func getCacheTTL() time.Duration {
 
 var cacheTTL = getCacheTTL()
 
+// TTL is the cache TTL of a tenant configured with seconds, the global TTL when it is nil
+func TTL(seconds *int) time.Duration {
+	if seconds == nil {
+		return cacheTTL
+	}
+	return time.Duration(*seconds) * time.Second
+}
+
 func (c *BalanceCache) Invalidate(walletId uuid.UUID) {
 	c.m.Delete(walletId)
 }
 
-func (c *BalanceCache) Set(walletId uuid.UUID, balance decimal.Decimal) {
+// Set caches the balances for ttl, a TTL of zero disables caching
+func (c *BalanceCache) Set(tenantId string, walletId uuid.UUID, balances []models.Balance, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
 	c.m.Store(walletId, &CacheEntry{
-		Balance:    balance,
-		Expiration: time.Now().Add(cacheTTL),
+		TenantId:   tenantId,
+		Balances:   balances,
+		Expiration: time.Now().Add(ttl),
 	})
 }
 
-func (c *BalanceCache) Get(walletId uuid.UUID) (decimal.Decimal, bool) {
+func (c *BalanceCache) Get(tenantId string, walletId uuid.UUID) ([]models.Balance, bool) {
 	v, found := c.m.Load(walletId)
 	if !found {
-		return decimal.Zero, false
+		return nil, false
 	}
 	entry := v.(*CacheEntry)
+	if entry.TenantId != tenantId {
+		return nil, false
+	}
 	if time.Now().After(entry.Expiration) {
 		c.m.Delete(walletId)
-		return decimal.Zero, false
+		return nil, false
 	}
-	return entry.Balance, true
+	return entry.Balances, true
 }
@@ -7,34 +7,55 @@ import (
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
+
+	"wallet-api-server/internal/models"
 )
 
 func TestBalanceCache_SetAndGet(t *testing.T) {
 	c := &BalanceCache{}
 	id := uuid.New()
-	c.Set(id, decimal.NewFromInt(123))
-	balance, ok := c.Get(id)
+	c.Set(models.DefaultTenant, id, []models.Balance{{Currency: "USD", Balance: decimal.NewFromInt(123)}}, TTL(nil))
+	balances, ok := c.Get(models.DefaultTenant, id)
 	assert.True(t, ok)
-	assert.Equal(t, decimal.NewFromInt(123), balance)
+	assert.Equal(t, []models.Balance{{Currency: "USD", Balance: decimal.NewFromInt(123)}}, balances)
 }
 
 func TestBalanceCache_Invalidate(t *testing.T) {
 	c := &BalanceCache{}
 	id := uuid.New()
-	c.Set(id, decimal.NewFromInt(50))
+	c.Set(models.DefaultTenant, id, []models.Balance{{Currency: "USD", Balance: decimal.NewFromInt(50)}}, TTL(nil))
 	c.Invalidate(id)
-	_, ok := c.Get(id)
+	_, ok := c.Get(models.DefaultTenant, id)
 	assert.False(t, ok)
 }
 
 func TestBalanceCache_Expiration(t *testing.T) {
 	c := &BalanceCache{}
 	id := uuid.New()
-	c.Set(id, decimal.NewFromInt(77))
+	c.Set(models.DefaultTenant, id, []models.Balance{{Currency: "USD", Balance: decimal.NewFromInt(77)}}, TTL(nil))
 	// Force expiration
 	entry, _ := c.m.Load(id)
 	ce := entry.(*CacheEntry)
 	ce.Expiration = time.Now().Add(-1 * time.Second)
-	_, ok := c.Get(id)
+	_, ok := c.Get(models.DefaultTenant, id)
+	assert.False(t, ok)
+}
+
+func TestBalanceCache_OtherTenant(t *testing.T) {
+	c := &BalanceCache{}
+	id := uuid.New()
+	c.Set("brand-a", id, []models.Balance{{Currency: "USD", Balance: decimal.NewFromInt(10)}}, time.Minute)
+	_, ok := c.Get("brand-b", id)
+	assert.False(t, ok)
+	_, ok = c.Get("brand-a", id)
+	assert.True(t, ok)
+}
+
+func TestBalanceCache_ZeroTTL(t *testing.T) {
+	c := &BalanceCache{}
+	id := uuid.New()
+	seconds := 0
+	c.Set(models.DefaultTenant, id, []models.Balance{{Currency: "USD"}}, TTL(&seconds))
+	_, ok := c.Get(models.DefaultTenant, id)
 	assert.False(t, ok)
 }
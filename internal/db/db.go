@@ -8,6 +8,8 @@ import (
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/spf13/viper"
+
+	"wallet-api-server/internal/models"
 )
 
 var DB *pgxpool.Pool
@@ -35,33 +37,373 @@ func CloseDB() {
 }
 
 func CreateTablesIfNotExist() error {
+	// The default currency is inlined into DDL, so it must be a plain currency code
+	currency := models.DefaultCurrency()
+	if !models.IsCurrencyCode(currency) {
+		return fmt.Errorf("invalid WALLET_DEFAULT_CURRENCY %q", currency)
+	}
+
 	query := `
 	CREATE TABLE IF NOT EXISTS wallets (
+		wallet_id UUID NOT NULL,
+		currency CHAR(3) NOT NULL,
+		balance NUMERIC(19,4) NOT NULL DEFAULT 0,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (wallet_id, currency)
+	);
+	-- Wallets created before multi-currency support hold the default currency
+	ALTER TABLE wallets ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT '%[1]s';
+	ALTER TABLE wallets ALTER COLUMN currency DROP DEFAULT;
+	ALTER TABLE wallets ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+	-- Sum of active holds, available balance is balance - held
+	ALTER TABLE wallets ADD COLUMN IF NOT EXISTS held NUMERIC(19,4) NOT NULL DEFAULT 0;
+	-- Agreed credit line, the balance may go down to -credit_limit
+	ALTER TABLE wallets ADD COLUMN IF NOT EXISTS credit_limit NUMERIC(19,4) NOT NULL DEFAULT 0;
+	-- ACTIVE, FROZEN or CLOSED, kept equal on all currency rows of a wallet
+	ALTER TABLE wallets ADD COLUMN IF NOT EXISTS status VARCHAR(16) NOT NULL DEFAULT 'ACTIVE';
+	DO $$
+	BEGIN
+		IF NOT EXISTS (
+			SELECT 1 FROM information_schema.key_column_usage
+			WHERE table_name = 'wallets' AND constraint_name = 'wallets_pkey' AND column_name = 'currency'
+		) THEN
+			ALTER TABLE wallets DROP CONSTRAINT wallets_pkey, ADD PRIMARY KEY (wallet_id, currency);
+		END IF;
+	END $$;
+	-- Bumped by the trigger below on every change of the row, whichever statement makes it
+	ALTER TABLE wallets ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 0;
+	ALTER TABLE wallets ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+	-- Subject of the tokens that may use the wallet, the same on every currency row of a wallet
+	ALTER TABLE wallets ADD COLUMN IF NOT EXISTS owner VARCHAR(255);
+	CREATE INDEX IF NOT EXISTS wallets_owner_idx ON wallets (owner, wallet_id) WHERE owner IS NOT NULL;
+	CREATE OR REPLACE FUNCTION wallets_bump_version() RETURNS trigger AS $$
+	BEGIN
+		NEW.version := OLD.version + 1;
+		NEW.updated_at := now();
+		RETURN NEW;
+	END $$ LANGUAGE plpgsql;
+	CREATE OR REPLACE TRIGGER wallets_bump_version BEFORE UPDATE ON wallets
+		FOR EACH ROW EXECUTE FUNCTION wallets_bump_version();
+
+	CREATE TABLE IF NOT EXISTS wallet_transactions (
+		id BIGSERIAL PRIMARY KEY,
+		operation_id UUID NOT NULL UNIQUE,
+		wallet_id UUID NOT NULL,
+		operation_type VARCHAR(32) NOT NULL,
+		amount NUMERIC(19,4) NOT NULL,
+		balance_after NUMERIC(19,4) NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	CREATE INDEX IF NOT EXISTS wallet_transactions_wallet_id_idx ON wallet_transactions (wallet_id, id);
+	ALTER TABLE wallet_transactions ADD COLUMN IF NOT EXISTS transfer_id UUID;
+	CREATE INDEX IF NOT EXISTS wallet_transactions_transfer_id_idx ON wallet_transactions (transfer_id) WHERE transfer_id IS NOT NULL;
+	ALTER TABLE wallet_transactions ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT '%[1]s';
+	ALTER TABLE wallet_transactions ALTER COLUMN currency DROP DEFAULT;
+	ALTER TABLE wallet_transactions ADD COLUMN IF NOT EXISTS hold_id UUID;
+	ALTER TABLE wallet_transactions ADD COLUMN IF NOT EXISTS reversal_of UUID;
+	CREATE INDEX IF NOT EXISTS wallet_transactions_reversal_of_idx ON wallet_transactions (reversal_of) WHERE reversal_of IS NOT NULL;
+	-- Entries written before events were published count as published
+	ALTER TABLE wallet_transactions ADD COLUMN IF NOT EXISTS published_at TIMESTAMPTZ DEFAULT now();
+	ALTER TABLE wallet_transactions ALTER COLUMN published_at DROP DEFAULT;
+	CREATE INDEX IF NOT EXISTS wallet_transactions_unpublished_idx ON wallet_transactions (id) WHERE published_at IS NULL;
+	-- The caller's own reference, description and key/values of the operation
+	ALTER TABLE wallet_transactions ADD COLUMN IF NOT EXISTS reference VARCHAR(128) NOT NULL DEFAULT '';
+	ALTER TABLE wallet_transactions ADD COLUMN IF NOT EXISTS description VARCHAR(255) NOT NULL DEFAULT '';
+	ALTER TABLE wallet_transactions ADD COLUMN IF NOT EXISTS metadata JSONB;
+	CREATE INDEX IF NOT EXISTS wallet_transactions_reference_idx ON wallet_transactions (wallet_id, reference) WHERE reference <> '';
+	-- The API key the operation was made with, NULL without authentication
+	ALTER TABLE wallet_transactions ADD COLUMN IF NOT EXISTS api_key_id UUID;
+
+	-- Balance of each wallet currency at the end of a day, ledger_id is its last entry of that day
+	CREATE TABLE IF NOT EXISTS balance_snapshots (
+		wallet_id UUID NOT NULL,
+		currency CHAR(3) NOT NULL,
+		as_of TIMESTAMPTZ NOT NULL,
+		ledger_id BIGINT NOT NULL,
+		balance NUMERIC(19,4) NOT NULL,
+		PRIMARY KEY (wallet_id, currency, as_of)
+	);
+
+	CREATE TABLE IF NOT EXISTS credit_limit_changes (
+		id BIGSERIAL PRIMARY KEY,
+		wallet_id UUID NOT NULL,
+		currency CHAR(3) NOT NULL,
+		old_limit NUMERIC(19,4) NOT NULL,
+		new_limit NUMERIC(19,4) NOT NULL,
+		reason TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	CREATE INDEX IF NOT EXISTS credit_limit_changes_wallet_id_idx ON credit_limit_changes (wallet_id, id);
+
+	-- Per-wallet overrides of the global limits, NULL keeps the default
+	CREATE TABLE IF NOT EXISTS wallet_limits (
 		wallet_id UUID PRIMARY KEY,
-		balance NUMERIC(19,4) NOT NULL DEFAULT 0
+		max_operation_amount NUMERIC(19,4),
+		daily_withdrawal NUMERIC(19,4),
+		monthly_withdrawal NUMERIC(19,4),
+		max_operations INTEGER,
+		operations_window INTEGER,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
 	);
+
+	CREATE TABLE IF NOT EXISTS scheduled_operations (
+		id UUID PRIMARY KEY,
+		wallet_id UUID NOT NULL,
+		operation_type VARCHAR(32) NOT NULL,
+		amount NUMERIC(19,4) NOT NULL,
+		-- Empty selects the wallet's primary currency when the operation runs
+		currency VARCHAR(3) NOT NULL DEFAULT '',
+		execute_at TIMESTAMPTZ NOT NULL,
+		status VARCHAR(16) NOT NULL,
+		operation_id UUID,
+		balance_after NUMERIC(19,4),
+		error TEXT,
+		claimed_at TIMESTAMPTZ,
+		executed_at TIMESTAMPTZ,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	CREATE INDEX IF NOT EXISTS scheduled_operations_due_idx ON scheduled_operations (execute_at) WHERE status IN ('PENDING', 'RUNNING');
+	CREATE INDEX IF NOT EXISTS scheduled_operations_wallet_id_idx ON scheduled_operations (wallet_id, execute_at);
+
+	CREATE TABLE IF NOT EXISTS standing_orders (
+		id UUID PRIMARY KEY,
+		from_wallet_id UUID NOT NULL,
+		to_wallet_id UUID NOT NULL,
+		amount NUMERIC(19,4) NOT NULL,
+		currency VARCHAR(3) NOT NULL DEFAULT '',
+		day_of_month INTEGER NOT NULL,
+		start_at TIMESTAMPTZ NOT NULL,
+		end_at TIMESTAMPTZ,
+		max_retries INTEGER NOT NULL,
+		retry_interval INTEGER NOT NULL,
+		status VARCHAR(16) NOT NULL,
+		due_at TIMESTAMPTZ NOT NULL,
+		next_run_at TIMESTAMPTZ NOT NULL,
+		attempt INTEGER NOT NULL DEFAULT 0,
+		-- Set while a server instance runs the order
+		claimed_until TIMESTAMPTZ,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	CREATE INDEX IF NOT EXISTS standing_orders_next_run_at_idx ON standing_orders (next_run_at) WHERE status = 'ACTIVE';
+	CREATE INDEX IF NOT EXISTS standing_orders_from_wallet_id_idx ON standing_orders (from_wallet_id);
+	CREATE INDEX IF NOT EXISTS standing_orders_to_wallet_id_idx ON standing_orders (to_wallet_id);
+	-- The API key that scheduled the operation or created the order, the ledger entries are recorded with it
+	ALTER TABLE scheduled_operations ADD COLUMN IF NOT EXISTS api_key_id UUID;
+	ALTER TABLE standing_orders ADD COLUMN IF NOT EXISTS api_key_id UUID;
+
+	CREATE TABLE IF NOT EXISTS standing_order_executions (
+		id BIGSERIAL PRIMARY KEY,
+		order_id UUID NOT NULL,
+		due_at TIMESTAMPTZ NOT NULL,
+		attempt INTEGER NOT NULL,
+		status VARCHAR(16) NOT NULL,
+		transfer_id UUID,
+		error TEXT,
+		executed_at TIMESTAMPTZ NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS standing_order_executions_order_id_idx ON standing_order_executions (order_id, id);
+
+	CREATE TABLE IF NOT EXISTS webhook_endpoints (
+		id UUID PRIMARY KEY,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		-- NULL subscribes to the events of all wallets
+		wallet_id UUID,
+		active BOOLEAN NOT NULL DEFAULT true,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id UUID PRIMARY KEY,
+		endpoint_id UUID NOT NULL,
+		event_id UUID NOT NULL,
+		event_type VARCHAR(64) NOT NULL,
+		payload TEXT NOT NULL,
+		status VARCHAR(16) NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMPTZ NOT NULL,
+		last_error TEXT,
+		last_status_code INTEGER,
+		claimed_until TIMESTAMPTZ,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		delivered_at TIMESTAMPTZ
+	);
+	CREATE INDEX IF NOT EXISTS webhook_deliveries_next_attempt_at_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'PENDING';
+	CREATE INDEX IF NOT EXISTS webhook_deliveries_endpoint_id_idx ON webhook_deliveries (endpoint_id, created_at);
+
+	CREATE TABLE IF NOT EXISTS holds (
+		hold_id UUID PRIMARY KEY,
+		wallet_id UUID NOT NULL,
+		currency CHAR(3) NOT NULL,
+		amount NUMERIC(19,4) NOT NULL,
+		remaining NUMERIC(19,4) NOT NULL,
+		status VARCHAR(16) NOT NULL,
+		expires_at TIMESTAMPTZ NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	CREATE INDEX IF NOT EXISTS holds_active_expires_at_idx ON holds (expires_at) WHERE status = 'ACTIVE';
+
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id UUID PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		-- SHA-256 of the token, the token itself is never stored
+		key_hash CHAR(64) NOT NULL UNIQUE,
+		prefix VARCHAR(16) NOT NULL,
+		scopes TEXT[] NOT NULL,
+		-- NULL allows the key to use all wallets
+		wallet_ids UUID[],
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		rotated_at TIMESTAMPTZ,
+		revoked_at TIMESTAMPTZ
+	);
+	-- The secret requests with the key are signed with, NULL when the key does not sign
+	ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS signing_secret VARCHAR(64);
+
+	CREATE TABLE IF NOT EXISTS request_nonces (
+		api_key_id UUID NOT NULL,
+		nonce VARCHAR(128) NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (api_key_id, nonce)
+	);
+
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		idempotency_key VARCHAR(255) PRIMARY KEY,
+		request_hash CHAR(64) NOT NULL,
+		operation_id UUID NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+
+	-- Partner brands sharing the deployment, each wallet, operation and credential belongs to one
+	CREATE TABLE IF NOT EXISTS tenants (
+		id VARCHAR(64) PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		-- NULL allows every operation
+		allowed_operations TEXT[],
+		-- Overrides of the global limits for the tenant's wallets, NULL keeps the default
+		max_operation_amount NUMERIC(19,4),
+		daily_withdrawal NUMERIC(19,4),
+		monthly_withdrawal NUMERIC(19,4),
+		max_operations INTEGER,
+		operations_window INTEGER,
+		-- NULL keeps the global balance cache TTL
+		cache_ttl INTEGER,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	INSERT INTO tenants (id, name) VALUES ('default', 'Default') ON CONFLICT (id) DO NOTHING;
+	-- Rows from before tenants existed belong to the default tenant, new rows have to name theirs
+	ALTER TABLE wallets ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+	ALTER TABLE wallets ALTER COLUMN tenant_id DROP DEFAULT;
+	CREATE INDEX IF NOT EXISTS wallets_tenant_id_idx ON wallets (tenant_id, wallet_id);
+	ALTER TABLE wallet_transactions ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+	ALTER TABLE wallet_transactions ALTER COLUMN tenant_id DROP DEFAULT;
+	ALTER TABLE holds ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+	ALTER TABLE holds ALTER COLUMN tenant_id DROP DEFAULT;
+	ALTER TABLE scheduled_operations ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+	ALTER TABLE scheduled_operations ALTER COLUMN tenant_id DROP DEFAULT;
+	ALTER TABLE standing_orders ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+	ALTER TABLE standing_orders ALTER COLUMN tenant_id DROP DEFAULT;
+	ALTER TABLE webhook_endpoints ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+	ALTER TABLE webhook_endpoints ALTER COLUMN tenant_id DROP DEFAULT;
+	ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+	ALTER TABLE api_keys ALTER COLUMN tenant_id DROP DEFAULT;
+	-- Idempotency keys are unique within a tenant
+	ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+	ALTER TABLE idempotency_keys ALTER COLUMN tenant_id DROP DEFAULT;
+	DO $$
+	BEGIN
+		IF NOT EXISTS (
+			SELECT 1 FROM information_schema.key_column_usage
+			WHERE table_name = 'idempotency_keys' AND constraint_name = 'idempotency_keys_pkey' AND column_name = 'tenant_id'
+		) THEN
+			ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey, ADD PRIMARY KEY (tenant_id, idempotency_key);
+		END IF;
+	END $$;
+	ALTER TABLE credit_limit_changes ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+	ALTER TABLE credit_limit_changes ALTER COLUMN tenant_id DROP DEFAULT;
+	ALTER TABLE standing_order_executions ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+	ALTER TABLE standing_order_executions ALTER COLUMN tenant_id DROP DEFAULT;
+	ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+	ALTER TABLE webhook_deliveries ALTER COLUMN tenant_id DROP DEFAULT;
+	ALTER TABLE balance_snapshots ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+	ALTER TABLE balance_snapshots ALTER COLUMN tenant_id DROP DEFAULT;
+	DO $$
+	BEGIN
+		IF NOT EXISTS (
+			SELECT 1 FROM information_schema.key_column_usage
+			WHERE table_name = 'balance_snapshots' AND constraint_name = 'balance_snapshots_pkey' AND column_name = 'tenant_id'
+		) THEN
+			ALTER TABLE balance_snapshots DROP CONSTRAINT balance_snapshots_pkey, ADD PRIMARY KEY (tenant_id, wallet_id, currency, as_of);
+		END IF;
+	END $$;
+	ALTER TABLE request_nonces ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+	ALTER TABLE request_nonces ALTER COLUMN tenant_id DROP DEFAULT;
+	DO $$
+	BEGIN
+		IF NOT EXISTS (
+			SELECT 1 FROM information_schema.key_column_usage
+			WHERE table_name = 'request_nonces' AND constraint_name = 'request_nonces_pkey' AND column_name = 'tenant_id'
+		) THEN
+			ALTER TABLE request_nonces DROP CONSTRAINT request_nonces_pkey, ADD PRIMARY KEY (tenant_id, api_key_id, nonce);
+		END IF;
+	END $$;
+	-- Overrides are kept per tenant, an override of one tenant never applies to another tenant's wallet
+	ALTER TABLE wallet_limits ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+	ALTER TABLE wallet_limits ALTER COLUMN tenant_id DROP DEFAULT;
+	DO $$
+	BEGIN
+		IF NOT EXISTS (
+			SELECT 1 FROM information_schema.key_column_usage
+			WHERE table_name = 'wallet_limits' AND constraint_name = 'wallet_limits_pkey' AND column_name = 'tenant_id'
+		) THEN
+			ALTER TABLE wallet_limits DROP CONSTRAINT wallet_limits_pkey, ADD PRIMARY KEY (tenant_id, wallet_id);
+		END IF;
+	END $$;
+	-- What a replay answers with besides the ledger entries, such as the available balance and version
+	ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS outcome JSONB;
 	`
-	_, err := DB.Exec(context.Background(), query)
+	_, err := DB.Exec(context.Background(), fmt.Sprintf(query, currency))
 	return err
 }
 
+// ErrNoRows is returned by RowScanner.Scan when the query selected no rows
+var ErrNoRows = pgx.ErrNoRows
+
 // DBProvider describes the interface for working with the database
 // (or use testify/mock manually)
 //
 //go:generate mockery --name=DBProvider --output=./mocks --case=underscore
 type DBProvider interface {
 	QueryRow(ctx context.Context, query string, args ...interface{}) RowScanner
+	Query(ctx context.Context, query string, args ...interface{}) (Rows, error)
 	Exec(ctx context.Context, query string, args ...interface{}) (interface{}, error)
 	Begin(ctx context.Context) (TxProvider, error)
 	Close()
 }
 
+// Querier is the read side shared by DBProvider and TxProvider
+type Querier interface {
+	QueryRow(ctx context.Context, query string, args ...interface{}) RowScanner
+	Query(ctx context.Context, query string, args ...interface{}) (Rows, error)
+}
+
 type RowScanner interface {
 	Scan(dest ...interface{}) error
 }
 
+// Rows is a forward-only cursor over a multi-row result set
+type Rows interface {
+	Next() bool
+	Scan(dest ...interface{}) error
+	Err() error
+	Close()
+}
+
 type TxProvider interface {
 	QueryRow(ctx context.Context, query string, args ...interface{}) RowScanner
+	Query(ctx context.Context, query string, args ...interface{}) (Rows, error)
 	Exec(ctx context.Context, query string, args ...interface{}) (interface{}, error)
 	Commit(ctx context.Context) error
 	Rollback(ctx context.Context) error
@@ -95,6 +437,10 @@ func (p *PgxDBProvider) QueryRow(ctx context.Context, query string, args ...inte
 	return &PgxRowScanner{r: p.pool.QueryRow(ctx, query, args...)}
 }
 
+func (p *PgxDBProvider) Query(ctx context.Context, query string, args ...interface{}) (Rows, error) {
+	return p.pool.Query(ctx, query, args...)
+}
+
 func (p *PgxDBProvider) Exec(ctx context.Context, query string, args ...interface{}) (interface{}, error) {
 	return p.pool.Exec(ctx, query, args...)
 }
@@ -115,6 +461,10 @@ func (t *PgxTxProvider) QueryRow(ctx context.Context, query string, args ...inte
 	return &PgxRowScanner{r: t.tx.QueryRow(ctx, query, args...)}
 }
 
+func (t *PgxTxProvider) Query(ctx context.Context, query string, args ...interface{}) (Rows, error) {
+	return t.tx.Query(ctx, query, args...)
+}
+
 func (t *PgxTxProvider) Exec(ctx context.Context, query string, args ...interface{}) (interface{}, error) {
 	return t.tx.Exec(ctx, query, args...)
 }
@@ -0,0 +1,171 @@
+// Package dbtest mocks the db interfaces for tests. Rows are scanned
+// strictly: a scan fails unless it is given one destination per value of
+// the row and each value fits its destination
+package dbtest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/stretchr/testify/mock"
+
+	"wallet-api-server/internal/db"
+)
+
+var (
+	_ db.DBProvider = (*DB)(nil)
+	_ db.TxProvider = (*Tx)(nil)
+	_ db.RowScanner = (*Row)(nil)
+	_ db.Rows       = (*Rows)(nil)
+)
+
+// DB mocks db.DBProvider, expectations receive the arguments of a query as one slice
+type DB struct{ mock.Mock }
+
+func (m *DB) QueryRow(ctx context.Context, query string, args ...interface{}) db.RowScanner {
+	argsM := m.Called(ctx, query, args)
+	return argsM.Get(0).(db.RowScanner)
+}
+func (m *DB) Query(ctx context.Context, query string, args ...interface{}) (db.Rows, error) {
+	argsM := m.Called(ctx, query, args)
+	rows, _ := argsM.Get(0).(db.Rows)
+	return rows, argsM.Error(1)
+}
+func (m *DB) Exec(ctx context.Context, query string, args ...interface{}) (interface{}, error) {
+	argsM := m.Called(ctx, query, args)
+	return argsM.Get(0), argsM.Error(1)
+}
+func (m *DB) Begin(ctx context.Context) (db.TxProvider, error) {
+	argsM := m.Called(ctx)
+	tx, _ := argsM.Get(0).(db.TxProvider)
+	return tx, argsM.Error(1)
+}
+func (m *DB) Close() {}
+
+// Tx mocks db.TxProvider like DB
+type Tx struct{ mock.Mock }
+
+func (m *Tx) QueryRow(ctx context.Context, query string, args ...interface{}) db.RowScanner {
+	argsM := m.Called(ctx, query, args)
+	return argsM.Get(0).(db.RowScanner)
+}
+func (m *Tx) Query(ctx context.Context, query string, args ...interface{}) (db.Rows, error) {
+	argsM := m.Called(ctx, query, args)
+	rows, _ := argsM.Get(0).(db.Rows)
+	return rows, argsM.Error(1)
+}
+func (m *Tx) Exec(ctx context.Context, query string, args ...interface{}) (interface{}, error) {
+	argsM := m.Called(ctx, query, args)
+	return argsM.Get(0), argsM.Error(1)
+}
+func (m *Tx) Commit(ctx context.Context) error {
+	return m.Called(ctx).Error(0)
+}
+func (m *Tx) Rollback(ctx context.Context) error {
+	return m.Called(ctx).Error(0)
+}
+
+// Row is the result of QueryRow, a non-nil Err is returned by Scan instead of the values
+type Row struct {
+	Values []interface{}
+	Err    error
+}
+
+func NewRow(values ...interface{}) *Row {
+	return &Row{Values: values}
+}
+
+// ErrRow is a row whose Scan fails with err, db.ErrNoRows for an empty result
+func ErrRow(err error) *Row {
+	return &Row{Err: err}
+}
+
+func (r *Row) Scan(dest ...interface{}) error {
+	if r.Err != nil {
+		return r.Err
+	}
+	return assign(r.Values, dest)
+}
+
+// Rows is the result of Query, each element is the values of one row
+type Rows struct {
+	rows [][]interface{}
+	pos  int
+	// Closed is set once the caller closed the rows
+	Closed bool
+}
+
+func NewRows(rows ...[]interface{}) *Rows {
+	return &Rows{rows: rows}
+}
+
+func (r *Rows) Next() bool {
+	r.pos++
+	return r.pos <= len(r.rows)
+}
+func (r *Rows) Scan(dest ...interface{}) error {
+	if r.pos < 1 || r.pos > len(r.rows) {
+		return fmt.Errorf("dbtest: scan without a current row")
+	}
+	return assign(r.rows[r.pos-1], dest)
+}
+func (r *Rows) Err() error { return nil }
+func (r *Rows) Close()     { r.Closed = true }
+
+// IsQuery matches a query starting with prefix, leading whitespace aside
+func IsQuery(prefix string) interface{} {
+	return mock.MatchedBy(func(q string) bool { return strings.HasPrefix(strings.TrimSpace(q), prefix) })
+}
+
+// RowOf lays the named values out in the order of columns, the comma separated
+// select list of the query. Columns left out scan into zero values
+func RowOf(columns string, values map[string]interface{}) []interface{} {
+	names := strings.Split(columns, ",")
+	row := make([]interface{}, len(names))
+	index := make(map[string]int, len(names))
+	for i, name := range names {
+		index[strings.TrimSpace(name)] = i
+	}
+	for name, v := range values {
+		i, ok := index[name]
+		if !ok {
+			panic(fmt.Sprintf("dbtest: %q is not one of the columns %s", name, columns))
+		}
+		row[i] = v
+	}
+	return row
+}
+
+// assign sets each destination to its value the way a driver would: nil sets
+// the zero value, a value of the pointed-to type is stored as is and, for a
+// nullable destination, behind a new pointer
+func assign(values, dest []interface{}) error {
+	if len(dest) != len(values) {
+		return fmt.Errorf("dbtest: scan into %d destinations, the row has %d values", len(dest), len(values))
+	}
+	for i, v := range values {
+		d := reflect.ValueOf(dest[i])
+		if d.Kind() != reflect.Pointer || d.IsNil() {
+			return fmt.Errorf("dbtest: destination %d is %T, not a pointer", i, dest[i])
+		}
+		target := d.Elem()
+		if v == nil {
+			target.Set(reflect.Zero(target.Type()))
+			continue
+		}
+		value := reflect.ValueOf(v)
+		switch {
+		case value.Type().AssignableTo(target.Type()):
+			target.Set(value)
+		case target.Kind() == reflect.Pointer && value.Type().AssignableTo(target.Type().Elem()):
+			p := reflect.New(target.Type().Elem())
+			p.Elem().Set(value)
+			target.Set(p)
+		default:
+			return fmt.Errorf("dbtest: cannot scan %T into destination %d of type %s", v, i, target.Type())
+		}
+	}
+	return nil
+}
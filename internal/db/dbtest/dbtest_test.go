@@ -0,0 +1,48 @@
+package dbtest
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRow_Scan(t *testing.T) {
+	id := uuid.New()
+	var gotId uuid.UUID
+	var name string
+	var parent *uuid.UUID
+	assert.NoError(t, NewRow(id, nil, id).Scan(&gotId, &name, &parent))
+	assert.Equal(t, id, gotId)
+	assert.Equal(t, "", name)
+	assert.Equal(t, &id, parent)
+}
+
+func TestRow_ScanArityMismatch(t *testing.T) {
+	var a, b string
+	assert.Error(t, NewRow("a", "b", "c").Scan(&a, &b))
+	assert.Error(t, NewRow("a").Scan(&a, &b))
+}
+
+func TestRow_ScanWrongType(t *testing.T) {
+	var n int64
+	assert.Error(t, NewRow("1").Scan(&n))
+}
+
+func TestRows(t *testing.T) {
+	rows := NewRows([]interface{}{"a"}, []interface{}{"b"})
+	var got []string
+	for rows.Next() {
+		var s string
+		assert.NoError(t, rows.Scan(&s))
+		got = append(got, s)
+	}
+	rows.Close()
+	assert.Equal(t, []string{"a", "b"}, got)
+	assert.True(t, rows.Closed)
+}
+
+func TestRowOf(t *testing.T) {
+	assert.Equal(t, []interface{}{nil, "b", nil}, RowOf("a, b, c", map[string]interface{}{"b": "b"}))
+	assert.Panics(t, func() { RowOf("a, b", map[string]interface{}{"d": 1}) })
+}
@@ -0,0 +1,189 @@
+package events
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/ledger"
+	"wallet-api-server/internal/models"
+)
+
+const (
+	// subscriberBuffer is how many entries a subscriber may fall behind before it is dropped
+	subscriberBuffer = 64
+	// fetchLimit bounds how many ledger entries one round reads
+	fetchLimit = 500
+)
+
+// Subscription receives the ledger entries of one wallet made after Cursor.
+// C is closed when the subscriber fell too far behind, it should resume from
+// the last entry it got
+type Subscription struct {
+	WalletId uuid.UUID
+	Cursor   int64
+	C        chan models.Transaction
+}
+
+type walletSubscribers struct {
+	// cursor is the id of the newest entry handed to the subscribers
+	cursor int64
+	subs   map[*Subscription]struct{}
+}
+
+// Hub fans the ledger entries of subscribed wallets out to their subscribers. A
+// single goroutine reads the ledger, for the wallets it was notified about and
+// periodically for all subscribed ones, so idle subscribers cost no queries
+type Hub struct {
+	db      db.DBProvider
+	mu      sync.Mutex
+	wallets map[uuid.UUID]*walletSubscribers
+	dirty   map[uuid.UUID]struct{}
+	wake    chan struct{}
+}
+
+func NewHub(dbProvider db.DBProvider) *Hub {
+	return &Hub{
+		db:      dbProvider,
+		wallets: make(map[uuid.UUID]*walletSubscribers),
+		dirty:   make(map[uuid.UUID]struct{}),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Notify tells the hub that the wallet has new ledger entries, it never blocks
+func (h *Hub) Notify(walletId uuid.UUID) {
+	h.mu.Lock()
+	_, subscribed := h.wallets[walletId]
+	if subscribed {
+		h.dirty[walletId] = struct{}{}
+	}
+	h.mu.Unlock()
+	if subscribed {
+		select {
+		case h.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Subscribe starts delivering the new entries of the tenant's wallet. Entries up to the
+// returned Cursor are not delivered, callers replay them from the ledger
+func (h *Hub) Subscribe(ctx context.Context, tenantId string, walletId uuid.UUID) (*Subscription, error) {
+	latest, err := ledger.LatestId(ctx, h.db, tenantId, walletId)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// An already subscribed wallet keeps its cursor, newer entries are on their way
+	w, ok := h.wallets[walletId]
+	if !ok {
+		w = &walletSubscribers{cursor: latest, subs: make(map[*Subscription]struct{})}
+		h.wallets[walletId] = w
+	}
+	s := &Subscription{WalletId: walletId, Cursor: w.cursor, C: make(chan models.Transaction, subscriberBuffer)}
+	w.subs[s] = struct{}{}
+	return s, nil
+}
+
+func (h *Hub) Unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	w, ok := h.wallets[s.WalletId]
+	if !ok {
+		return
+	}
+	if _, ok := w.subs[s]; ok {
+		delete(w.subs, s)
+		close(s.C)
+	}
+	if len(w.subs) == 0 {
+		delete(h.wallets, s.WalletId)
+		delete(h.dirty, s.WalletId)
+	}
+}
+
+// Run reads new entries whenever notified, and every interval for all subscribed
+// wallets to pick up operations committed by other server instances, until ctx is cancelled
+func (h *Hub) Run(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				h.mu.Lock()
+				for walletId := range h.wallets {
+					h.dirty[walletId] = struct{}{}
+				}
+				h.mu.Unlock()
+			case <-h.wake:
+			}
+			for h.fetch(ctx) && ctx.Err() == nil {
+			}
+		}
+	}()
+}
+
+// fetch reads one round of entries for the dirty wallets and
+// reports whether some wallets still have more to read
+func (h *Hub) fetch(ctx context.Context) bool {
+	h.mu.Lock()
+	walletIds := make([]uuid.UUID, 0, len(h.dirty))
+	cursors := make([]int64, 0, len(h.dirty))
+	for walletId := range h.dirty {
+		if w, ok := h.wallets[walletId]; ok {
+			walletIds = append(walletIds, walletId)
+			cursors = append(cursors, w.cursor)
+		}
+	}
+	h.dirty = make(map[uuid.UUID]struct{})
+	h.mu.Unlock()
+	if len(walletIds) == 0 {
+		return false
+	}
+
+	entries, err := ledger.Since(ctx, h.db, walletIds, cursors, fetchLimit)
+	if err != nil {
+		log.Printf("Failed to read wallet events: %v", err)
+		// Try again with the next tick
+		return false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, t := range entries {
+		w, ok := h.wallets[t.WalletId]
+		if !ok || t.Id <= w.cursor {
+			continue
+		}
+		w.cursor = t.Id
+		for s := range w.subs {
+			select {
+			case s.C <- t:
+			default:
+				// Too slow, the subscriber resumes from the ledger once it reconnects
+				delete(w.subs, s)
+				close(s.C)
+			}
+		}
+	}
+	if len(entries) < fetchLimit {
+		return false
+	}
+	// A full round may have cut some wallets short
+	for _, walletId := range walletIds {
+		if _, ok := h.wallets[walletId]; ok {
+			h.dirty[walletId] = struct{}{}
+		}
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+package events
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"wallet-api-server/internal/db/dbtest"
+	"wallet-api-server/internal/ledger"
+	"wallet-api-server/internal/models"
+)
+
+func latestId(mdb *dbtest.DB, id int64) {
+	mdb.On("QueryRow", mock.Anything, dbtest.IsQuery("SELECT COALESCE(max(id), 0) FROM wallet_transactions"), mock.Anything).Return(dbtest.NewRow(id))
+}
+
+func entry(id int64, walletId uuid.UUID) []interface{} {
+	return dbtest.RowOf(ledger.Columns, map[string]interface{}{
+		"id": id, "operation_id": uuid.New(), "wallet_id": walletId, "operation_type": models.DEPOSIT, "currency": "USD",
+		"amount": decimal.NewFromInt(1), "balance_after": decimal.NewFromInt(id), "created_at": time.Now(),
+	})
+}
+
+func TestHub_DeliversNewEntriesOnce(t *testing.T) {
+	walletId := uuid.New()
+	mdb := new(dbtest.DB)
+	latestId(mdb, 10)
+	// The entry at the cursor is left out even if the ledger returns it again
+	mdb.On("Query", mock.Anything, mock.Anything, []interface{}{[]uuid.UUID{walletId}, []int64{10}, fetchLimit}).
+		Return(dbtest.NewRows(entry(10, walletId), entry(11, walletId), entry(12, walletId)), nil).Once()
+	hub := NewHub(mdb)
+
+	sub, err := hub.Subscribe(context.Background(), models.DefaultTenant, walletId)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), sub.Cursor)
+
+	hub.Notify(walletId)
+	assert.False(t, hub.fetch(context.Background()))
+	assert.Equal(t, int64(11), (<-sub.C).Id)
+	assert.Equal(t, int64(12), (<-sub.C).Id)
+	assert.Empty(t, sub.C)
+
+	// Nothing is dirty any more, so nothing is read
+	assert.False(t, hub.fetch(context.Background()))
+	mdb.AssertNumberOfCalls(t, "Query", 1)
+}
+
+func TestHub_NotifyIgnoresUnsubscribedWallets(t *testing.T) {
+	hub := NewHub(new(dbtest.DB))
+	hub.Notify(uuid.New())
+	assert.Empty(t, hub.dirty)
+	assert.Empty(t, hub.wake)
+}
+
+func TestHub_DropsSlowSubscriber(t *testing.T) {
+	walletId := uuid.New()
+	mdb := new(dbtest.DB)
+	latestId(mdb, 0)
+	rows := make([][]interface{}, subscriberBuffer+1)
+	for i := range rows {
+		rows[i] = entry(int64(i+1), walletId)
+	}
+	mdb.On("Query", mock.Anything, mock.Anything, mock.Anything).Return(dbtest.NewRows(rows...), nil)
+	hub := NewHub(mdb)
+	sub, err := hub.Subscribe(context.Background(), models.DefaultTenant, walletId)
+	assert.NoError(t, err)
+
+	hub.Notify(walletId)
+	hub.fetch(context.Background())
+	received := 0
+	for range sub.C {
+		received++
+	}
+	assert.Equal(t, subscriberBuffer, received)
+
+	// Unsubscribing a dropped subscriber only forgets the wallet
+	hub.Unsubscribe(sub)
+	assert.Empty(t, hub.wallets)
+}
@@ -0,0 +1,91 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"wallet-api-server/internal/auth"
+	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/models"
+	"wallet-api-server/internal/queue"
+)
+
+// authenticate reads the API key or JWT from the "authorization" bearer token or the
+// "x-api-key" metadata, the same way the HTTP API reads its headers
+func authenticate(ctx context.Context, a *auth.Authenticator) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var token string
+	if v := md.Get("authorization"); len(v) > 0 {
+		token = auth.BearerToken(v[0])
+	}
+	if v := md.Get("x-api-key"); token == "" && len(v) > 0 {
+		token = v[0]
+	}
+	p, err := a.Authenticate(ctx, token)
+	if err != nil {
+		if errors.Is(err, auth.ErrUnauthenticated) {
+			return nil, status.Error(codes.Unauthenticated, "A valid API key or token is required")
+		}
+		return nil, status.Error(codes.Internal, "Failed to authenticate")
+	}
+	// Calls carry no signature, a key that has to sign is limited to the HTTP API
+	if p.RequiresSignature() {
+		return nil, status.Error(codes.Unauthenticated, "The API key requires signed HTTP requests")
+	}
+	return auth.NewContext(ctx, p), nil
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// AuthInterceptors authenticate every call, the methods check the scopes on the wallet of their request
+func AuthInterceptors(a *auth.Authenticator) []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			ctx, err := authenticate(ctx, a)
+			if err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			ctx, err := authenticate(ss.Context(), a)
+			if err != nil {
+				return err
+			}
+			return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+		}),
+	}
+}
+
+// authorize fails with PermissionDenied unless the caller may use scope on the wallet
+func authorize(ctx context.Context, scope models.Scope, walletId uuid.UUID) error {
+	if p := auth.FromContext(ctx); p != nil && !p.Allows(scope, walletId) {
+		return status.Error(codes.PermissionDenied, "The API key does not allow this request")
+	}
+	return nil
+}
+
+// requireTenantWallet fails with NotFound for a wallet of another tenant, for the
+// methods that read by wallet id alone
+func requireTenantWallet(ctx context.Context, q db.Querier, walletId uuid.UUID) error {
+	if err := queue.CheckTenant(ctx, q, auth.TenantId(ctx), walletId); err != nil {
+		if errors.Is(err, queue.ErrWalletNotFound) {
+			return status.Error(codes.NotFound, "Wallet not found")
+		}
+		return status.Error(codes.Internal, "Failed to read wallet")
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package grpcapi
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"wallet-api-server/internal/grpcapi/walletv1"
+	"wallet-api-server/internal/models"
+)
+
+// parseAmount reads a decimal string of a request
+func parseAmount(amount string) (decimal.Decimal, error) {
+	d, err := decimal.NewFromString(amount)
+	if err != nil {
+		return d, status.Error(codes.InvalidArgument, "Invalid amount")
+	}
+	return d, nil
+}
+
+// timeOf is nil for an unset timestamp
+func timeOf(ts *timestamppb.Timestamp) *time.Time {
+	if ts == nil {
+		return nil
+	}
+	t := ts.AsTime()
+	return &t
+}
+
+// idOf is empty for a missing id
+func idOf(id *uuid.UUID) string {
+	if id == nil {
+		return ""
+	}
+	return id.String()
+}
+
+func toBalance(b models.Balance) *walletv1.Balance {
+	return &walletv1.Balance{
+		Currency:        b.Currency,
+		Balance:         b.Balance.String(),
+		Available:       b.Available.String(),
+		CreditLimit:     b.CreditLimit.String(),
+		AvailableCredit: b.AvailableCredit.String(),
+	}
+}
+
+func toTransaction(t models.Transaction) *walletv1.Transaction {
+	return &walletv1.Transaction{
+		OperationId:   t.OperationId.String(),
+		WalletId:      t.WalletId.String(),
+		OperationType: string(t.OperationType),
+		Currency:      t.Currency,
+		Amount:        t.Amount.String(),
+		BalanceAfter:  t.BalanceAfter.String(),
+		TransferId:    idOf(t.TransferId),
+		HoldId:        idOf(t.HoldId),
+		ReversalOf:    idOf(t.ReversalOf),
+		Reference:     t.Reference,
+		Description:   t.Description,
+		Metadata:      t.Metadata,
+		ApiKeyId:      idOf(t.ApiKeyId),
+		CreatedAt:     timestamppb.New(t.CreatedAt),
+	}
+}
+
+func toEvent(e models.Event) *walletv1.Event {
+	return &walletv1.Event{
+		Id:        e.Id.String(),
+		Type:      e.Type,
+		CreatedAt: timestamppb.New(e.CreatedAt),
+		Data:      toTransaction(e.Data),
+	}
+}
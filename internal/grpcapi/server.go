@@ -0,0 +1,265 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+
+	"wallet-api-server/internal/auth"
+	"wallet-api-server/internal/cache"
+	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/events"
+	"wallet-api-server/internal/grpcapi/walletv1"
+	"wallet-api-server/internal/idempotency"
+	"wallet-api-server/internal/ledger"
+	"wallet-api-server/internal/limits"
+	"wallet-api-server/internal/models"
+	"wallet-api-server/internal/queue"
+)
+
+// Server implements walletv1.WalletServiceServer, it shares the queue, the cache and the event hub with the HTTP handlers
+type Server struct {
+	walletv1.UnimplementedWalletServiceServer
+	Cache  *cache.BalanceCache
+	Queue  *queue.QueueManager
+	DB     db.DBProvider
+	Events *events.Hub
+}
+
+func NewServer(c *cache.BalanceCache, q *queue.QueueManager, dbProvider db.DBProvider, hub *events.Hub) *Server {
+	return &Server{Cache: c, Queue: q, DB: dbProvider, Events: hub}
+}
+
+// Register adds the wallet service to s together with server reflection, so that
+// tools like grpcurl can list and call it without the proto file
+func Register(s *grpc.Server, srv walletv1.WalletServiceServer) {
+	walletv1.RegisterWalletServiceServer(s, srv)
+	reflection.Register(s)
+}
+
+// opError maps a failed queue operation to a status, the same way the HTTP API picks its status codes
+func opError(res queue.OpResult) error {
+	var limitErr *limits.Error
+	code := codes.Internal
+	switch {
+	case errors.Is(res.Err, queue.ErrWalletNotFound):
+		code = codes.NotFound
+	case errors.Is(res.Err, queue.ErrWalletFrozen), errors.Is(res.Err, queue.ErrWalletClosed):
+		code = codes.FailedPrecondition
+	case errors.As(res.Err, &limitErr) && limitErr.Code == limits.CodeOperationCount:
+		code = codes.ResourceExhausted
+	case errors.As(res.Err, &limitErr):
+		code = codes.PermissionDenied
+	case errors.Is(res.Err, idempotency.ErrKeyConflict), errors.Is(res.Err, queue.ErrDuplicateReference):
+		code = codes.AlreadyExists
+	case errors.Is(res.Err, queue.ErrCurrencyMismatch), errors.Is(res.Err, queue.ErrInvalidAmount):
+		code = codes.InvalidArgument
+	case errors.Is(res.Err, queue.ErrInsufficientFunds):
+		code = codes.FailedPrecondition
+	}
+	return status.Error(code, res.Msg)
+}
+
+func parseWalletId(walletId string) (uuid.UUID, error) {
+	id, err := uuid.Parse(walletId)
+	if err != nil {
+		return id, status.Error(codes.InvalidArgument, "Invalid walletId format")
+	}
+	return id, nil
+}
+
+func (s *Server) Operate(ctx context.Context, in *walletv1.OperateRequest) (*walletv1.OperateResponse, error) {
+	walletId, err := parseWalletId(in.WalletId)
+	if err != nil {
+		return nil, err
+	}
+	req := models.WalletOperationRequest{
+		WalletId:         in.WalletId,
+		OperationType:    models.OperationType(in.OperationType),
+		Currency:         in.Currency,
+		IdempotencyKey:   in.IdempotencyKey,
+		OperationDetails: models.OperationDetails{Reference: in.Reference, Description: in.Description, Metadata: in.Metadata},
+	}
+	if req.OperationType != models.DEPOSIT && req.OperationType != models.WITHDRAW {
+		return nil, status.Error(codes.InvalidArgument, "operationType must be DEPOSIT or WITHDRAW")
+	}
+	if req.Amount, err = parseAmount(in.Amount); err != nil {
+		return nil, err
+	}
+	if !req.Amount.IsPositive() {
+		return nil, status.Error(codes.InvalidArgument, "Amount must be positive")
+	}
+	if req.Currency != "" && !models.IsCurrencyCode(req.Currency) {
+		return nil, status.Error(codes.InvalidArgument, "Invalid currency")
+	}
+	if len(req.IdempotencyKey) > 255 {
+		return nil, status.Error(codes.InvalidArgument, "idempotencyKey is too long")
+	}
+	// The details are bounded by the same rules as over HTTP
+	if err := binding.Validator.ValidateStruct(req.OperationDetails); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := authorize(ctx, models.OperationScope(req.OperationType), walletId); err != nil {
+		return nil, err
+	}
+	if !auth.AllowsOperation(ctx, models.TenantOperationOf(req.OperationType)) {
+		return nil, status.Error(codes.PermissionDenied, "The tenant is not allowed to use "+string(req.OperationType)+" operations")
+	}
+	req.ApiKeyId = auth.KeyId(ctx)
+	req.TenantId = auth.TenantId(ctx)
+
+	res := s.Queue.Enqueue(walletId, req)
+	if res.Err != nil {
+		return nil, opError(res)
+	}
+	return &walletv1.OperateResponse{
+		OperationId: res.OperationId.String(),
+		WalletId:    walletId.String(),
+		Balance:     res.Balance.String(),
+		Available:   res.Available.String(),
+		Currency:    res.Currency,
+		Replayed:    res.Replayed,
+		Version:     res.Version,
+	}, nil
+}
+
+func (s *Server) GetBalance(ctx context.Context, req *walletv1.GetBalanceRequest) (*walletv1.BalanceResponse, error) {
+	walletId, err := parseWalletId(req.WalletId)
+	if err != nil {
+		return nil, err
+	}
+	currency := strings.ToUpper(req.Currency)
+	if currency != "" && !models.IsCurrencyCode(currency) {
+		return nil, status.Error(codes.InvalidArgument, "Invalid currency")
+	}
+	if err := authorize(ctx, models.SCOPE_BALANCE_READ, walletId); err != nil {
+		return nil, err
+	}
+
+	tenantId := auth.TenantId(ctx)
+	balances, cached := s.Cache.Get(tenantId, walletId)
+	if !cached {
+		if balances, err = queue.LoadBalances(ctx, s.DB, tenantId, walletId); err != nil {
+			return nil, status.Error(codes.Internal, "Failed to read balance")
+		}
+		if len(balances) == 0 {
+			return nil, status.Error(codes.NotFound, "Wallet not found")
+		}
+		s.Cache.Set(tenantId, walletId, balances, cache.TTL(auth.CacheTTLSeconds(ctx)))
+	}
+
+	selected, found := balances[0], currency == ""
+	for _, b := range balances {
+		if !found && b.Currency == currency {
+			selected, found = b, true
+		}
+	}
+	if !found {
+		return nil, status.Error(codes.NotFound, "Wallet has no balance in "+currency)
+	}
+	resp := &walletv1.BalanceResponse{
+		WalletId:        walletId.String(),
+		Balance:         selected.Balance.String(),
+		Available:       selected.Available.String(),
+		CreditLimit:     selected.CreditLimit.String(),
+		AvailableCredit: selected.AvailableCredit.String(),
+		Currency:        selected.Currency,
+		Cached:          cached,
+	}
+	for _, b := range balances {
+		resp.Balances = append(resp.Balances, toBalance(b))
+	}
+	return resp, nil
+}
+
+func (s *Server) ListTransactions(ctx context.Context, req *walletv1.ListTransactionsRequest) (*walletv1.ListTransactionsResponse, error) {
+	walletId, err := parseWalletId(req.WalletId)
+	if err != nil {
+		return nil, err
+	}
+	if req.Limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Invalid limit")
+	}
+	if err := authorize(ctx, models.SCOPE_BALANCE_READ, walletId); err != nil {
+		return nil, err
+	}
+	if err := requireTenantWallet(ctx, s.DB, walletId); err != nil {
+		return nil, err
+	}
+	transactions, nextCursor, err := ledger.List(ctx, s.DB, ledger.Filter{
+		TenantId:      auth.TenantId(ctx),
+		WalletId:      walletId,
+		OperationType: models.OperationType(req.OperationType),
+		Currency:      strings.ToUpper(req.Currency),
+		Reference:     req.Reference,
+		From:          timeOf(req.From),
+		To:            timeOf(req.To),
+		Cursor:        req.Cursor,
+		Limit:         int(req.Limit),
+	})
+	if err != nil {
+		if errors.Is(err, ledger.ErrInvalidCursor) {
+			return nil, status.Error(codes.InvalidArgument, "Invalid cursor")
+		}
+		return nil, status.Error(codes.Internal, "Failed to read transactions")
+	}
+	resp := &walletv1.ListTransactionsResponse{NextCursor: nextCursor}
+	for _, t := range transactions {
+		resp.Transactions = append(resp.Transactions, toTransaction(t))
+	}
+	return resp, nil
+}
+
+// WatchBalance streams every committed ledger entry of the wallet, the entries
+// after LastEventId first. The stream ends with Unavailable when the client
+// falls too far behind, it resumes from the last event it got
+func (s *Server) WatchBalance(req *walletv1.WatchBalanceRequest, stream walletv1.WalletService_WatchBalanceServer) error {
+	walletId, err := parseWalletId(req.WalletId)
+	if err != nil {
+		return err
+	}
+	if req.LastEventId < 0 {
+		return status.Error(codes.InvalidArgument, "Invalid lastEventId")
+	}
+	ctx := stream.Context()
+	if err := authorize(ctx, models.SCOPE_BALANCE_READ, walletId); err != nil {
+		return err
+	}
+	if err := requireTenantWallet(ctx, s.DB, walletId); err != nil {
+		return err
+	}
+	sub, err := s.Events.Subscribe(ctx, auth.TenantId(ctx), walletId)
+	if err != nil {
+		return status.Error(codes.Internal, "Failed to subscribe to wallet events")
+	}
+	defer s.Events.Unsubscribe(sub)
+
+	send := func(t models.Transaction) error {
+		return stream.Send(&walletv1.BalanceEvent{EventId: t.Id, Event: toEvent(models.NewBalanceChangedEvent(t))})
+	}
+	if req.LastEventId > 0 && req.LastEventId < sub.Cursor {
+		if err := ledger.StreamRange(ctx, s.DB, auth.TenantId(ctx), walletId, req.LastEventId, sub.Cursor, send); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case t, ok := <-sub.C:
+			if !ok {
+				return status.Error(codes.Unavailable, "Subscriber fell behind, resume from the last event")
+			}
+			if err := send(t); err != nil {
+				return err
+			}
+		}
+	}
+}
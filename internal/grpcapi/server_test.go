@@ -0,0 +1,158 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"wallet-api-server/internal/cache"
+	"wallet-api-server/internal/grpcapi/walletv1"
+	"wallet-api-server/internal/idempotency"
+	"wallet-api-server/internal/limits"
+	"wallet-api-server/internal/models"
+	"wallet-api-server/internal/queue"
+)
+
+// dial serves srv over an in-memory listener and returns a connection to it
+func dial(t *testing.T, srv *Server) *grpc.ClientConn {
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	Register(s, srv)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGetBalance_CacheHit(t *testing.T) {
+	c := &cache.BalanceCache{}
+	id := uuid.New()
+	c.Set(models.DefaultTenant, id, []models.Balance{
+		{Currency: "USD", Balance: decimal.NewFromInt(100), Available: decimal.NewFromInt(100)},
+		{Currency: "EUR", Balance: decimal.NewFromInt(7), Available: decimal.NewFromInt(5)},
+	}, time.Minute)
+	client := walletv1.NewWalletServiceClient(dial(t, NewServer(c, &queue.QueueManager{Cache: c}, nil, nil)))
+
+	resp, err := client.GetBalance(context.Background(), &walletv1.GetBalanceRequest{WalletId: id.String(), Currency: "eur"})
+	assert.NoError(t, err)
+	assert.Equal(t, id.String(), resp.WalletId)
+	assert.Equal(t, "EUR", resp.Currency)
+	assert.Equal(t, "7", resp.Balance)
+	assert.Equal(t, "5", resp.Available)
+	if assert.Len(t, resp.Balances, 2) {
+		assert.Equal(t, "USD", resp.Balances[0].Currency)
+	}
+	assert.True(t, resp.Cached)
+
+	_, err = client.GetBalance(context.Background(), &walletv1.GetBalanceRequest{WalletId: id.String(), Currency: "GBP"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestInvalidArguments(t *testing.T) {
+	c := &cache.BalanceCache{}
+	client := walletv1.NewWalletServiceClient(dial(t, NewServer(c, &queue.QueueManager{Cache: c}, nil, nil)))
+	ctx := context.Background()
+
+	_, err := client.GetBalance(ctx, &walletv1.GetBalanceRequest{WalletId: "not-a-uuid"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.Operate(ctx, &walletv1.OperateRequest{WalletId: uuid.NewString(), OperationType: "DEPOSIT", Amount: "-1"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.Operate(ctx, &walletv1.OperateRequest{WalletId: uuid.NewString(), OperationType: "DEPOSIT", Amount: "ten"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.Operate(ctx, &walletv1.OperateRequest{WalletId: uuid.NewString(), OperationType: string(models.TRANSFER_IN), Amount: "1"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.ListTransactions(ctx, &walletv1.ListTransactionsRequest{WalletId: uuid.NewString(), Limit: -1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestReflection(t *testing.T) {
+	c := &cache.BalanceCache{}
+	client := grpc_reflection_v1.NewServerReflectionClient(dial(t, NewServer(c, &queue.QueueManager{Cache: c}, nil, nil)))
+	stream, err := client.ServerReflectionInfo(context.Background())
+	if !assert.NoError(t, err) {
+		return
+	}
+	err = stream.Send(&grpc_reflection_v1.ServerReflectionRequest{
+		MessageRequest: &grpc_reflection_v1.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: "wallet.v1.WalletService"},
+	})
+	assert.NoError(t, err)
+	resp, err := stream.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	// The descriptor is the one of the proto file, so grpcurl can build the messages
+	files := resp.GetFileDescriptorResponse().GetFileDescriptorProto()
+	if assert.NotEmpty(t, files) {
+		file := new(descriptorpb.FileDescriptorProto)
+		assert.NoError(t, proto.Unmarshal(files[0], file))
+		assert.Equal(t, "wallet/v1/wallet.proto", file.GetName())
+		assert.Equal(t, "WalletService", file.GetService()[0].GetName())
+	}
+}
+
+func TestTransactionMessage(t *testing.T) {
+	transferId := uuid.New()
+	created := time.Date(2024, 3, 10, 9, 0, 0, 0, time.UTC)
+	msg := toTransaction(models.Transaction{
+		OperationId:   uuid.New(),
+		WalletId:      uuid.New(),
+		OperationType: models.TRANSFER_OUT,
+		Currency:      "USD",
+		Amount:        decimal.RequireFromString("-10.50"),
+		BalanceAfter:  decimal.RequireFromString("89.50"),
+		TransferId:    &transferId,
+		CreatedAt:     created,
+	})
+	assert.Equal(t, "-10.5", msg.Amount)
+	assert.Equal(t, "89.5", msg.BalanceAfter)
+	assert.Equal(t, transferId.String(), msg.TransferId)
+	assert.Empty(t, msg.HoldId)
+	assert.Equal(t, created, msg.CreatedAt.AsTime())
+}
+
+func TestOpError(t *testing.T) {
+	tests := []struct {
+		res  queue.OpResult
+		code codes.Code
+	}{
+		{queue.OpResult{Err: queue.ErrWalletNotFound, Msg: "Wallet not found"}, codes.NotFound},
+		{queue.OpResult{Err: queue.ErrWalletFrozen, Msg: "Wallet is frozen"}, codes.FailedPrecondition},
+		{queue.OpResult{Err: queue.ErrWalletClosed, Msg: "Wallet is closed"}, codes.FailedPrecondition},
+		{queue.OpResult{Err: &limits.Error{Code: limits.CodeOperationCount}}, codes.ResourceExhausted},
+		{queue.OpResult{Err: &limits.Error{Code: limits.CodeDailyWithdrawal}}, codes.PermissionDenied},
+		{queue.OpResult{Err: idempotency.ErrKeyConflict}, codes.AlreadyExists},
+		{queue.OpResult{Err: queue.ErrCurrencyMismatch}, codes.InvalidArgument},
+		{queue.OpResult{Err: queue.ErrInsufficientFunds, Msg: "Insufficient funds"}, codes.FailedPrecondition},
+		{queue.OpResult{Err: errors.New("boom"), Msg: "Database error"}, codes.Internal},
+	}
+	for _, tt := range tests {
+		err := opError(tt.res)
+		assert.Equal(t, tt.code, status.Code(err), tt.res.Msg)
+		assert.Equal(t, tt.res.Msg, status.Convert(err).Message())
+	}
+}
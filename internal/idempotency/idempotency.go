@@ -0,0 +1,116 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+
+	"wallet-api-server/internal/db"
+)
+
+// ErrKeyConflict is returned when a key is reused with a different payload
+var ErrKeyConflict = errors.New("idempotency key reused with a different payload")
+
+func Retention() time.Duration {
+	viper.AutomaticEnv()
+	viper.SetDefault("IDEMPOTENCY_KEY_TTL", 24*60*60)
+	ttl := viper.GetInt("IDEMPOTENCY_KEY_TTL")
+	return time.Duration(ttl) * time.Second
+}
+
+// Hash fingerprints a request payload so replays can be told apart from
+// conflicting requests sent under the same key
+func Hash(payload interface{}) (string, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Lookup returns the id of the operation already committed under the tenant's key,
+// expired keys are treated as absent. Each tenant has its own keys. The outcome
+// saved with the key is decoded into outcome, which is left untouched when none was saved
+func Lookup(ctx context.Context, tx db.TxProvider, tenantId, key, requestHash string, outcome interface{}) (uuid.UUID, bool, error) {
+	var storedHash string
+	var operationId uuid.UUID
+	var storedOutcome []byte
+	err := tx.QueryRow(ctx,
+		"SELECT request_hash, operation_id, outcome FROM idempotency_keys WHERE tenant_id=$1 AND idempotency_key=$2 AND created_at > $3",
+		tenantId, key, time.Now().Add(-Retention())).Scan(&storedHash, &operationId, &storedOutcome)
+	if err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return uuid.Nil, false, nil
+		}
+		return uuid.Nil, false, err
+	}
+	if storedHash != requestHash {
+		return uuid.Nil, false, ErrKeyConflict
+	}
+	if outcome != nil && storedOutcome != nil {
+		if err = json.Unmarshal(storedOutcome, outcome); err != nil {
+			return uuid.Nil, false, err
+		}
+	}
+	return operationId, true, nil
+}
+
+// Save stores the key in the caller's transaction so it is committed
+// together with the operation. An expired key is taken over, a live one
+// that appeared concurrently results in ErrKeyConflict. outcome holds the
+// parts of the response that cannot be read back from the ledger on a replay
+func Save(ctx context.Context, tx db.TxProvider, tenantId, key, requestHash string, operationId uuid.UUID, outcome interface{}) error {
+	storedOutcome, err := json.Marshal(outcome)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var stored uuid.UUID
+	err = tx.QueryRow(ctx, `
+		INSERT INTO idempotency_keys (tenant_id, idempotency_key, request_hash, operation_id, outcome, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (tenant_id, idempotency_key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash, operation_id = EXCLUDED.operation_id,
+			outcome = EXCLUDED.outcome, created_at = EXCLUDED.created_at
+		WHERE idempotency_keys.created_at <= $7
+		RETURNING operation_id`,
+		tenantId, key, requestHash, operationId, storedOutcome, now, now.Add(-Retention())).Scan(&stored)
+	if err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return ErrKeyConflict
+		}
+		return err
+	}
+	return nil
+}
+
+func Purge(ctx context.Context, dbProvider db.DBProvider) error {
+	_, err := dbProvider.Exec(ctx, "DELETE FROM idempotency_keys WHERE created_at <= $1", time.Now().Add(-Retention()))
+	return err
+}
+
+// StartJanitor periodically deletes expired keys until ctx is cancelled
+func StartJanitor(ctx context.Context, dbProvider db.DBProvider, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := Purge(ctx, dbProvider); err != nil {
+					log.Printf("Failed to purge expired idempotency keys: %v", err)
+				}
+			}
+		}
+	}()
+}
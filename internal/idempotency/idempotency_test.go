@@ -0,0 +1,83 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/db/dbtest"
+	"wallet-api-server/internal/models"
+)
+
+func TestHash_NormalizesAmount(t *testing.T) {
+	type payload struct {
+		Amount decimal.Decimal `json:"amount"`
+	}
+	a, err := Hash(payload{Amount: decimal.RequireFromString("100.00")})
+	assert.NoError(t, err)
+	b, err := Hash(payload{Amount: decimal.NewFromInt(100)})
+	assert.NoError(t, err)
+	c, err := Hash(payload{Amount: decimal.NewFromInt(101)})
+	assert.NoError(t, err)
+	assert.Equal(t, a, b)
+	assert.NotEqual(t, a, c)
+}
+
+func TestLookup(t *testing.T) {
+	opId := uuid.New()
+	mtx := new(dbtest.Tx)
+	mtx.On("QueryRow", mock.Anything, dbtest.IsQuery("SELECT request_hash, operation_id, outcome FROM idempotency_keys WHERE tenant_id=$1"),
+		mock.MatchedBy(func(args []interface{}) bool { return args[0] == "brand-a" && args[1] == "key" })).
+		Return(dbtest.NewRow("hash-a", opId, []byte(`{"available":"40","version":3}`)))
+
+	var outcome struct {
+		Available decimal.Decimal `json:"available"`
+		Version   int64           `json:"version"`
+	}
+	id, found, err := Lookup(context.Background(), mtx, "brand-a", "key", "hash-a", &outcome)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, opId, id)
+	assert.True(t, outcome.Available.Equal(decimal.NewFromInt(40)))
+	assert.Equal(t, int64(3), outcome.Version)
+
+	_, found, err = Lookup(context.Background(), mtx, "brand-a", "key", "hash-b", nil)
+	assert.True(t, errors.Is(err, ErrKeyConflict))
+	assert.False(t, found)
+}
+
+func TestLookup_NotFound(t *testing.T) {
+	mtx := new(dbtest.Tx)
+	mtx.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(dbtest.ErrRow(db.ErrNoRows))
+
+	_, found, err := Lookup(context.Background(), mtx, models.DefaultTenant, "key", "hash", nil)
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestSave(t *testing.T) {
+	opId := uuid.New()
+	mtx := new(dbtest.Tx)
+	mtx.On("QueryRow", mock.Anything, dbtest.IsQuery("INSERT INTO idempotency_keys (tenant_id, idempotency_key, request_hash, operation_id, outcome"),
+		mock.MatchedBy(func(args []interface{}) bool {
+			return len(args) == 7 && args[0] == "brand-a" && args[3] == opId && string(args[4].([]byte)) == `{"version":2}`
+		})).Return(dbtest.NewRow(opId))
+
+	err := Save(context.Background(), mtx, "brand-a", "key", "hash", opId, map[string]int{"version": 2})
+	assert.NoError(t, err)
+	mtx.AssertExpectations(t)
+}
+
+func TestSave_LiveKeyConflict(t *testing.T) {
+	mtx := new(dbtest.Tx)
+	mtx.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(dbtest.ErrRow(db.ErrNoRows))
+
+	err := Save(context.Background(), mtx, models.DefaultTenant, "key", "hash", uuid.New(), nil)
+	assert.True(t, errors.Is(err, ErrKeyConflict))
+}
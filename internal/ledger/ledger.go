@@ -0,0 +1,264 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/models"
+)
+
+// Columns is the select list of an entry, in the order scan reads it
+const Columns = "id, operation_id, wallet_id, operation_type, currency, amount, balance_after, transfer_id, hold_id, reversal_of, created_at, reference, description, metadata, api_key_id"
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Filter narrows down a wallet history listing,
+// Cursor is the opaque nextCursor value returned with the previous page
+type Filter struct {
+	TenantId      string
+	WalletId      uuid.UUID
+	OperationType models.OperationType
+	Currency      string
+	Reference     string
+	From          *time.Time
+	To            *time.Time
+	Cursor        string
+	Limit         int
+}
+
+// Record appends an entry to the ledger inside the caller's transaction,
+// so the entry is committed or rolled back together with the balance update.
+// The entry belongs to the tenant of its wallet
+func Record(ctx context.Context, tx db.TxProvider, t models.Transaction) error {
+	// Entries without metadata store NULL rather than a JSON null
+	var metadata interface{}
+	if len(t.Metadata) > 0 {
+		metadata = t.Metadata
+	}
+	_, err := tx.Exec(ctx,
+		"INSERT INTO wallet_transactions (operation_id, wallet_id, operation_type, currency, amount, balance_after, transfer_id, hold_id, reversal_of, created_at, reference, description, metadata, api_key_id, tenant_id) "+
+			"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, (SELECT tenant_id FROM wallets WHERE wallet_id=$2 LIMIT 1))",
+		t.OperationId, t.WalletId, t.OperationType, t.Currency, t.Amount, t.BalanceAfter, t.TransferId, t.HoldId, t.ReversalOf, t.CreatedAt, t.Reference, t.Description, metadata, t.ApiKeyId)
+	return err
+}
+
+// ReferenceUsed reports whether the tenant's wallet already has an entry with the reference
+func ReferenceUsed(ctx context.Context, q db.Querier, tenantId string, walletId uuid.UUID, reference string) (bool, error) {
+	var used bool
+	err := q.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM wallet_transactions WHERE wallet_id=$1 AND tenant_id=$2 AND reference=$3)",
+		walletId, tenantId, reference).Scan(&used)
+	return used, err
+}
+
+// Get reads a single ledger entry of the tenant by its operation id
+func Get(ctx context.Context, q db.Querier, tenantId string, operationId uuid.UUID) (models.Transaction, error) {
+	var t models.Transaction
+	err := scan(q.QueryRow(ctx, "SELECT "+Columns+" FROM wallet_transactions WHERE operation_id=$1 AND tenant_id=$2", operationId, tenantId), &t)
+	return t, err
+}
+
+// GetTransfer reads both legs of a transfer of the tenant, the debited wallet first
+func GetTransfer(ctx context.Context, q db.Querier, tenantId string, transferId uuid.UUID) ([]models.Transaction, error) {
+	rows, err := q.Query(ctx,
+		"SELECT "+Columns+" FROM wallet_transactions WHERE transfer_id=$1 AND tenant_id=$2 ORDER BY operation_type DESC", transferId, tenantId)
+	if err != nil {
+		return nil, err
+	}
+	return collect(rows)
+}
+
+// ReversedAmount sums up all reversals the tenant already recorded for an operation
+func ReversedAmount(ctx context.Context, q db.Querier, tenantId string, operationId uuid.UUID) (decimal.Decimal, error) {
+	var reversed decimal.Decimal
+	err := q.QueryRow(ctx, "SELECT COALESCE(SUM(amount), 0) FROM wallet_transactions WHERE reversal_of=$1 AND tenant_id=$2", operationId, tenantId).Scan(&reversed)
+	return reversed, err
+}
+
+// Stream passes the entries of the tenant's wallet in one currency within [from, to) to fn
+// in the order they were made, without loading them all at once. A nil bound is open
+func Stream(ctx context.Context, q db.Querier, tenantId string, walletId uuid.UUID, currency string, from, to *time.Time, fn func(models.Transaction) error) error {
+	query := "SELECT " + Columns + " FROM wallet_transactions WHERE wallet_id=$1 AND tenant_id=$2 AND currency=$3"
+	args := []interface{}{walletId, tenantId, currency}
+	if from != nil {
+		args = append(args, *from)
+		query += fmt.Sprintf(" AND created_at >= $%d", len(args))
+	}
+	if to != nil {
+		args = append(args, *to)
+		query += fmt.Sprintf(" AND created_at < $%d", len(args))
+	}
+	rows, err := q.Query(ctx, query+" ORDER BY id", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t models.Transaction
+		if err := scan(rows, &t); err != nil {
+			return err
+		}
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// List returns one page of the history of the filter's wallet of its tenant, newest
+// first, and the cursor of the next page (empty when there are no more entries)
+func List(ctx context.Context, dbProvider db.DBProvider, f Filter) ([]models.Transaction, string, error) {
+	limit := f.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	query := "SELECT " + Columns + " FROM wallet_transactions WHERE wallet_id=$1 AND tenant_id=$2"
+	args := []interface{}{f.WalletId, f.TenantId}
+	if f.Cursor != "" {
+		cursor, err := strconv.ParseInt(f.Cursor, 10, 64)
+		if err != nil || cursor <= 0 {
+			return nil, "", ErrInvalidCursor
+		}
+		args = append(args, cursor)
+		query += fmt.Sprintf(" AND id < $%d", len(args))
+	}
+	if f.OperationType != "" {
+		args = append(args, f.OperationType)
+		query += fmt.Sprintf(" AND operation_type = $%d", len(args))
+	}
+	if f.Currency != "" {
+		args = append(args, f.Currency)
+		query += fmt.Sprintf(" AND currency = $%d", len(args))
+	}
+	if f.Reference != "" {
+		args = append(args, f.Reference)
+		query += fmt.Sprintf(" AND reference = $%d", len(args))
+	}
+	if f.From != nil {
+		args = append(args, *f.From)
+		query += fmt.Sprintf(" AND created_at >= $%d", len(args))
+	}
+	if f.To != nil {
+		args = append(args, *f.To)
+		query += fmt.Sprintf(" AND created_at < $%d", len(args))
+	}
+	// Fetch one extra row to find out whether there is a next page
+	args = append(args, limit+1)
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d", len(args))
+
+	rows, err := dbProvider.Query(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	transactions, err := collect(rows)
+	if err != nil {
+		return nil, "", err
+	}
+
+	nextCursor := ""
+	if len(transactions) > limit {
+		transactions = transactions[:limit]
+		nextCursor = strconv.FormatInt(transactions[limit-1].Id, 10)
+	}
+	return transactions, nextCursor, nil
+}
+
+// LatestId returns the id of the newest entry of the tenant's wallet, 0 when it has none
+func LatestId(ctx context.Context, q db.Querier, tenantId string, walletId uuid.UUID) (int64, error) {
+	var id int64
+	err := q.QueryRow(ctx, "SELECT COALESCE(max(id), 0) FROM wallet_transactions WHERE wallet_id=$1 AND tenant_id=$2", walletId, tenantId).Scan(&id)
+	return id, err
+}
+
+// StreamRange passes the entries of the tenant's wallet with after < id <= upTo to fn, oldest first
+func StreamRange(ctx context.Context, q db.Querier, tenantId string, walletId uuid.UUID, after, upTo int64, fn func(models.Transaction) error) error {
+	rows, err := q.Query(ctx, "SELECT "+Columns+" FROM wallet_transactions WHERE wallet_id=$1 AND tenant_id=$2 AND id > $3 AND id <= $4 ORDER BY id",
+		walletId, tenantId, after, upTo)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t models.Transaction
+		if err := scan(rows, &t); err != nil {
+			return err
+		}
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Since returns up to limit entries, oldest first, of each wallet walletIds[i] with an id above after[i]
+func Since(ctx context.Context, q db.Querier, walletIds []uuid.UUID, after []int64, limit int) ([]models.Transaction, error) {
+	rows, err := q.Query(ctx, `
+		SELECT `+qualified("t")+`
+		FROM unnest($1::uuid[], $2::bigint[]) AS c(wallet_id, after)
+		JOIN wallet_transactions t ON t.wallet_id=c.wallet_id AND t.id > c.after
+		ORDER BY t.id
+		LIMIT $3`, walletIds, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	return collect(rows)
+}
+
+// ClaimUnpublished locks up to limit entries that were not handed to event
+// consumers yet, oldest first. Entries locked by another instance are skipped
+func ClaimUnpublished(ctx context.Context, tx db.TxProvider, limit int) ([]models.Transaction, error) {
+	rows, err := tx.Query(ctx, "SELECT "+Columns+" FROM wallet_transactions WHERE published_at IS NULL ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED", limit)
+	if err != nil {
+		return nil, err
+	}
+	return collect(rows)
+}
+
+// MarkPublished records that the entries were handed to event consumers
+func MarkPublished(ctx context.Context, tx db.TxProvider, ids []int64) error {
+	_, err := tx.Exec(ctx, "UPDATE wallet_transactions SET published_at=$1 WHERE id = ANY($2)", time.Now().UTC(), ids)
+	return err
+}
+
+// qualified returns Columns with each column prefixed by the table alias
+func qualified(alias string) string {
+	names := strings.Split(Columns, ", ")
+	for i, name := range names {
+		names[i] = alias + "." + name
+	}
+	return strings.Join(names, ", ")
+}
+
+func scan(row db.RowScanner, t *models.Transaction) error {
+	return row.Scan(&t.Id, &t.OperationId, &t.WalletId, &t.OperationType, &t.Currency, &t.Amount, &t.BalanceAfter, &t.TransferId, &t.HoldId, &t.ReversalOf, &t.CreatedAt,
+		&t.Reference, &t.Description, &t.Metadata, &t.ApiKeyId)
+}
+
+func collect(rows db.Rows) ([]models.Transaction, error) {
+	defer rows.Close()
+	transactions := []models.Transaction{}
+	for rows.Next() {
+		var t models.Transaction
+		if err := scan(rows, &t); err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, t)
+	}
+	return transactions, rows.Err()
+}
@@ -0,0 +1,97 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"wallet-api-server/internal/db/dbtest"
+	"wallet-api-server/internal/models"
+)
+
+func entry(id int64, walletId uuid.UUID, values map[string]interface{}) []interface{} {
+	row := map[string]interface{}{
+		"id": id, "operation_id": uuid.New(), "wallet_id": walletId, "operation_type": models.DEPOSIT, "currency": "USD",
+		"amount": decimal.NewFromInt(1), "balance_after": decimal.NewFromInt(1), "created_at": time.Now(),
+	}
+	for k, v := range values {
+		row[k] = v
+	}
+	return dbtest.RowOf(Columns, row)
+}
+
+func TestList_FiltersAndCursor(t *testing.T) {
+	mdb := new(dbtest.DB)
+	id := uuid.New()
+	from := time.Now().Add(-time.Hour)
+	rows := dbtest.NewRows(entry(7, id, nil))
+	mdb.On("Query", mock.Anything, mock.MatchedBy(func(q string) bool {
+		return strings.HasPrefix(q, "SELECT "+Columns+" FROM wallet_transactions") && strings.Contains(q, "WHERE wallet_id=$1 AND tenant_id=$2") && strings.Contains(q, "id < $3") &&
+			strings.Contains(q, "operation_type = $4") && strings.Contains(q, "created_at >= $5") && strings.HasSuffix(q, "LIMIT $6")
+	}), []interface{}{id, "brand-a", int64(10), models.DEPOSIT, from, 3}).Return(rows, nil)
+
+	transactions, next, err := List(context.Background(), mdb, Filter{
+		TenantId:      "brand-a",
+		WalletId:      id,
+		OperationType: models.DEPOSIT,
+		From:          &from,
+		Cursor:        "10",
+		Limit:         2,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, transactions, 1)
+	assert.Equal(t, "", next)
+	mdb.AssertExpectations(t)
+}
+
+func TestList_InvalidCursor(t *testing.T) {
+	mdb := new(dbtest.DB)
+	_, _, err := List(context.Background(), mdb, Filter{WalletId: uuid.New(), Cursor: "abc"})
+	assert.True(t, errors.Is(err, ErrInvalidCursor))
+}
+
+func TestList_Reference(t *testing.T) {
+	mdb := new(dbtest.DB)
+	id := uuid.New()
+	rows := dbtest.NewRows(entry(7, id, map[string]interface{}{
+		"reference": "ORDER-123", "description": "First order", "metadata": map[string]string{"channel": "web"},
+	}))
+	mdb.On("Query", mock.Anything, mock.MatchedBy(func(q string) bool {
+		return strings.Contains(q, "reference = $3")
+	}), []interface{}{id, models.DefaultTenant, "ORDER-123", DefaultPageSize + 1}).Return(rows, nil)
+
+	transactions, _, err := List(context.Background(), mdb, Filter{TenantId: models.DefaultTenant, WalletId: id, Reference: "ORDER-123"})
+	assert.NoError(t, err)
+	assert.Equal(t, models.OperationDetails{Reference: "ORDER-123", Description: "First order", Metadata: map[string]string{"channel": "web"}},
+		transactions[0].OperationDetails)
+}
+
+func TestSince(t *testing.T) {
+	mdb := new(dbtest.DB)
+	a, b := uuid.New(), uuid.New()
+	mdb.On("Query", mock.Anything, mock.MatchedBy(func(q string) bool {
+		return strings.Contains(q, "SELECT "+qualified("t")+"\n")
+	}), []interface{}{[]uuid.UUID{a, b}, []int64{3, 5}, 10}).Return(dbtest.NewRows(
+		entry(4, a, nil), entry(6, b, map[string]interface{}{"reference": "ORDER-1"}),
+	), nil)
+
+	transactions, err := Since(context.Background(), mdb, []uuid.UUID{a, b}, []int64{3, 5}, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, transactions, 2) {
+		assert.Equal(t, a, transactions[0].WalletId)
+		assert.Equal(t, "ORDER-1", transactions[1].Reference)
+	}
+}
+
+func TestQualified(t *testing.T) {
+	assert.Equal(t, len(strings.Split(Columns, ",")), strings.Count(qualified("t"), "t."))
+	assert.True(t, strings.HasPrefix(qualified("t"), "t.id, t.operation_id, "))
+	assert.True(t, strings.HasSuffix(qualified("t"), ", t.api_key_id"))
+}
@@ -0,0 +1,139 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/models"
+	"wallet-api-server/internal/tenant"
+)
+
+// snapshotDelay leaves in-flight transactions of the previous day time to
+// commit before that day is summarized
+const snapshotDelay = 5 * time.Minute
+
+// BalancesAt reconstructs the balances of a wallet of the tenant at asOf from the ledger,
+// one per currency the wallet had by then, primary currency first. The daily snapshots
+// around asOf narrow the search down to at most one day of entries
+func BalancesAt(ctx context.Context, q db.Querier, tenantId string, walletId uuid.UUID, asOf time.Time) ([]models.PointInTimeBalance, error) {
+	rows, err := q.Query(ctx, "SELECT currency FROM wallets WHERE wallet_id=$1 AND tenant_id=$2 AND created_at <= $3 ORDER BY created_at, currency",
+		walletId, tenantId, asOf)
+	if err != nil {
+		return nil, err
+	}
+	var currencies []string
+	for rows.Next() {
+		var currency string
+		if err = rows.Scan(&currency); err != nil {
+			break
+		}
+		currencies = append(currencies, currency)
+	}
+	rows.Close()
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	balances := make([]models.PointInTimeBalance, 0, len(currencies))
+	for _, currency := range currencies {
+		balance, err := balanceAt(ctx, q, tenantId, walletId, currency, asOf, "<=")
+		if err != nil {
+			return nil, err
+		}
+		balances = append(balances, models.PointInTimeBalance{Currency: currency, Balance: balance})
+	}
+	return balances, nil
+}
+
+// BalanceBefore is the balance of one currency of the tenant's wallet right before t, so entries made at t are not included
+func BalanceBefore(ctx context.Context, q db.Querier, tenantId string, walletId uuid.UUID, currency string, t time.Time) (decimal.Decimal, error) {
+	return balanceAt(ctx, q, tenantId, walletId, currency, t, "<")
+}
+
+// balanceAt takes the entries whose created_at compares to t with op into account.
+// The last such entry wins over the snapshot it follows, neither of them means nothing happened yet
+func balanceAt(ctx context.Context, q db.Querier, tenantId string, walletId uuid.UUID, currency string, t time.Time, op string) (decimal.Decimal, error) {
+	var balance decimal.Decimal
+	var ledgerId int64
+	err := q.QueryRow(ctx, `
+		(SELECT balance_after, id FROM wallet_transactions
+		WHERE wallet_id=$1 AND tenant_id=$4 AND currency=$2 AND created_at `+op+` $3
+			AND id > COALESCE((SELECT ledger_id FROM balance_snapshots WHERE wallet_id=$1 AND tenant_id=$4 AND currency=$2 AND as_of <= $3 ORDER BY as_of DESC LIMIT 1), 0)
+			AND id <= COALESCE((SELECT ledger_id FROM balance_snapshots WHERE wallet_id=$1 AND tenant_id=$4 AND currency=$2 AND as_of > $3 ORDER BY as_of LIMIT 1), 9223372036854775807)
+		ORDER BY id DESC LIMIT 1)
+		UNION ALL
+		(SELECT balance, ledger_id FROM balance_snapshots WHERE wallet_id=$1 AND tenant_id=$4 AND currency=$2 AND as_of <= $3 ORDER BY as_of DESC LIMIT 1)
+		ORDER BY 2 DESC LIMIT 1`,
+		walletId, currency, t, tenantId).Scan(&balance, &ledgerId)
+	if err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return decimal.Zero, nil
+		}
+		return decimal.Zero, err
+	}
+	return balance, nil
+}
+
+// TakeSnapshots records the balance at asOf of every currency of the tenant's wallets
+// whose ledger moved since its previous snapshot. Taking the same snapshot twice is a no-op
+func TakeSnapshots(ctx context.Context, dbProvider db.DBProvider, tenantId string, asOf time.Time) error {
+	_, err := dbProvider.Exec(ctx, `
+		INSERT INTO balance_snapshots (tenant_id, wallet_id, currency, as_of, ledger_id, balance)
+		SELECT DISTINCT ON (t.wallet_id, t.currency) t.tenant_id, t.wallet_id, t.currency, $2, t.id, t.balance_after
+		FROM wallet_transactions t
+		WHERE t.tenant_id=$1 AND t.created_at < $2
+			AND t.id > COALESCE((SELECT max(s.ledger_id) FROM balance_snapshots s
+				WHERE s.tenant_id=t.tenant_id AND s.wallet_id=t.wallet_id AND s.currency=t.currency), 0)
+		ORDER BY t.wallet_id, t.currency, t.id DESC
+		ON CONFLICT DO NOTHING`, tenantId, asOf)
+	return err
+}
+
+// takeAllSnapshots takes the snapshots of each tenant in turn, a failing tenant does not hold up the others
+func takeAllSnapshots(ctx context.Context, dbProvider db.DBProvider, asOf time.Time) error {
+	tenants, err := tenant.List(ctx, dbProvider)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, t := range tenants {
+		if err := TakeSnapshots(ctx, dbProvider, t.Id, asOf); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", t.Id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// lastSnapshotTime is the most recent midnight (UTC) that can be summarized
+func lastSnapshotTime(now time.Time) time.Time {
+	return now.UTC().Add(-snapshotDelay).Truncate(24 * time.Hour)
+}
+
+// StartSnapshots takes the daily balance snapshots until ctx is cancelled,
+// every server instance may run it
+func StartSnapshots(ctx context.Context, dbProvider db.DBProvider, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := takeAllSnapshots(ctx, dbProvider, lastSnapshotTime(time.Now())); err != nil {
+					log.Printf("Failed to take balance snapshots: %v", err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,59 @@
+package ledger
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/db/dbtest"
+	"wallet-api-server/internal/models"
+)
+
+func TestBalancesAt(t *testing.T) {
+	mdb := new(dbtest.DB)
+	id := uuid.New()
+	asOf := time.Now().Add(-48 * time.Hour)
+	mdb.On("Query", mock.Anything, mock.Anything, []interface{}{id, models.DefaultTenant, asOf}).
+		Return(dbtest.NewRows([]interface{}{"USD"}, []interface{}{"EUR"}), nil)
+	found := dbtest.NewRow(decimal.NewFromInt(42), int64(7))
+	missing := dbtest.ErrRow(db.ErrNoRows)
+	isBalanceQuery := func(q string) bool { return strings.Contains(q, "balance_snapshots") }
+	mdb.On("QueryRow", mock.Anything, mock.MatchedBy(isBalanceQuery), []interface{}{id, "USD", asOf, models.DefaultTenant}).Return(found)
+	mdb.On("QueryRow", mock.Anything, mock.MatchedBy(isBalanceQuery), []interface{}{id, "EUR", asOf, models.DefaultTenant}).Return(missing)
+
+	balances, err := BalancesAt(context.Background(), mdb, models.DefaultTenant, id, asOf)
+	assert.NoError(t, err)
+	if assert.Len(t, balances, 2) {
+		assert.Equal(t, "USD", balances[0].Currency)
+		assert.True(t, balances[0].Balance.Equal(decimal.NewFromInt(42)))
+		// No entry before asOf means the currency was still empty
+		assert.Equal(t, "EUR", balances[1].Currency)
+		assert.True(t, balances[1].Balance.IsZero())
+	}
+}
+
+func TestTakeSnapshots_PerTenant(t *testing.T) {
+	mdb := new(dbtest.DB)
+	asOf := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	mdb.On("Exec", mock.Anything, dbtest.IsQuery("INSERT INTO balance_snapshots (tenant_id, wallet_id"), []interface{}{"brand-a", asOf}).Return(nil, nil)
+
+	assert.NoError(t, TakeSnapshots(context.Background(), mdb, "brand-a", asOf))
+	mdb.AssertExpectations(t)
+	query := mdb.Calls[0].Arguments.String(1)
+	assert.Contains(t, query, "WHERE t.tenant_id=$1")
+	assert.Contains(t, query, "s.tenant_id=t.tenant_id")
+}
+
+func TestLastSnapshotTime(t *testing.T) {
+	midnight := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, midnight, lastSnapshotTime(midnight.Add(time.Hour)))
+	// Right after midnight the previous day may still be committing
+	assert.Equal(t, midnight.AddDate(0, 0, -1), lastSnapshotTime(midnight.Add(time.Minute)))
+}
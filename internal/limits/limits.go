@@ -0,0 +1,185 @@
+package limits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/spf13/viper"
+
+	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/models"
+)
+
+// Error codes of rejected operations
+const (
+	CodeOperationAmount   = "LIMIT_OPERATION_AMOUNT"
+	CodeDailyWithdrawal   = "LIMIT_DAILY_WITHDRAWAL"
+	CodeMonthlyWithdrawal = "LIMIT_MONTHLY_WITHDRAWAL"
+	CodeOperationCount    = "LIMIT_OPERATION_COUNT"
+)
+
+// Error is returned for an operation that would exceed one of the wallet's limits
+type Error struct {
+	Code  string
+	Limit decimal.Decimal
+}
+
+func (e *Error) Error() string {
+	switch e.Code {
+	case CodeOperationAmount:
+		return fmt.Sprintf("Operation amount exceeds the limit of %s", e.Limit)
+	case CodeDailyWithdrawal:
+		return fmt.Sprintf("Daily withdrawal limit of %s exceeded", e.Limit)
+	case CodeMonthlyWithdrawal:
+		return fmt.Sprintf("Monthly withdrawal limit of %s exceeded", e.Limit)
+	default:
+		return fmt.Sprintf("Limit of %s operations per time window exceeded", e.Limit)
+	}
+}
+
+// withdrawals are the ledger entry types counted against the withdrawal limits
+var withdrawals = []string{string(models.WITHDRAW), string(models.TRANSFER_OUT), string(models.CAPTURE)}
+
+// initiated are the ledger entry types counted against the operation count limit,
+// incoming transfers and corrections are not operations of the wallet itself
+var initiated = []string{string(models.DEPOSIT), string(models.WITHDRAW), string(models.TRANSFER_OUT), string(models.CAPTURE)}
+
+func configAmount(key string) decimal.Decimal {
+	viper.SetDefault(key, "0")
+	amount, err := decimal.NewFromString(viper.GetString(key))
+	if err != nil {
+		log.Printf("Invalid %s, the limit is disabled: %v", key, err)
+		return decimal.Zero
+	}
+	return amount
+}
+
+// Defaults are the global limits of wallets without an override
+func Defaults() models.Limits {
+	viper.AutomaticEnv()
+	viper.SetDefault("LIMIT_MAX_OPERATIONS", 0)
+	viper.SetDefault("LIMIT_OPERATIONS_WINDOW", 60*60)
+	return models.Limits{
+		MaxOperationAmount:      configAmount("LIMIT_MAX_OPERATION_AMOUNT"),
+		DailyWithdrawal:         configAmount("LIMIT_DAILY_WITHDRAWAL"),
+		MonthlyWithdrawal:       configAmount("LIMIT_MONTHLY_WITHDRAWAL"),
+		MaxOperations:           viper.GetInt("LIMIT_MAX_OPERATIONS"),
+		OperationsWindowSeconds: viper.GetInt("LIMIT_OPERATIONS_WINDOW"),
+	}
+}
+
+func scanOverride(row db.RowScanner) (models.LimitsOverride, error) {
+	var o models.LimitsOverride
+	err := row.Scan(&o.MaxOperationAmount, &o.DailyWithdrawal, &o.MonthlyWithdrawal, &o.MaxOperations, &o.OperationsWindowSeconds)
+	if err != nil && errors.Is(err, db.ErrNoRows) {
+		return models.LimitsOverride{}, nil
+	}
+	return o, err
+}
+
+// LoadOverride returns the override of the tenant's wallet, an empty one when there is none
+func LoadOverride(ctx context.Context, q db.Querier, tenantId string, walletId uuid.UUID) (models.LimitsOverride, error) {
+	return scanOverride(q.QueryRow(ctx,
+		"SELECT max_operation_amount, daily_withdrawal, monthly_withdrawal, max_operations, operations_window FROM wallet_limits WHERE tenant_id=$1 AND wallet_id=$2",
+		tenantId, walletId))
+}
+
+// TenantDefaults are the global limits with the tenant's configuration applied, the
+// limits of the tenant's wallets without an override
+func TenantDefaults(ctx context.Context, q db.Querier, tenantId string) (models.Limits, error) {
+	o, err := scanOverride(q.QueryRow(ctx,
+		"SELECT max_operation_amount, daily_withdrawal, monthly_withdrawal, max_operations, operations_window FROM tenants WHERE id=$1",
+		tenantId))
+	if err != nil {
+		return models.Limits{}, err
+	}
+	return o.Apply(Defaults()), nil
+}
+
+// SaveOverride replaces the override of the tenant's wallet
+func SaveOverride(ctx context.Context, dbProvider db.DBProvider, tenantId string, walletId uuid.UUID, o models.LimitsOverride) error {
+	_, err := dbProvider.Exec(ctx, `
+		INSERT INTO wallet_limits (tenant_id, wallet_id, max_operation_amount, daily_withdrawal, monthly_withdrawal, max_operations, operations_window, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, now())
+		ON CONFLICT (tenant_id, wallet_id) DO UPDATE SET
+			max_operation_amount = EXCLUDED.max_operation_amount,
+			daily_withdrawal = EXCLUDED.daily_withdrawal,
+			monthly_withdrawal = EXCLUDED.monthly_withdrawal,
+			max_operations = EXCLUDED.max_operations,
+			operations_window = EXCLUDED.operations_window,
+			updated_at = EXCLUDED.updated_at`,
+		tenantId, walletId, o.MaxOperationAmount, o.DailyWithdrawal, o.MonthlyWithdrawal, o.MaxOperations, o.OperationsWindowSeconds)
+	return err
+}
+
+// Effective returns the limits in effect for the wallet: the global defaults,
+// replaced by the tenant's limits and then by the wallet's override
+func Effective(ctx context.Context, q db.Querier, tenantId string, walletId uuid.UUID) (models.Limits, error) {
+	defaults, err := TenantDefaults(ctx, q, tenantId)
+	if err != nil {
+		return models.Limits{}, err
+	}
+	o, err := LoadOverride(ctx, q, tenantId, walletId)
+	if err != nil {
+		return models.Limits{}, err
+	}
+	return o.Apply(defaults), nil
+}
+
+// Check evaluates the wallet's limits for an operation about to be recorded. It must run
+// in the operation's transaction while the wallet is locked, so the totals it reads
+// cannot change before the operation is committed
+func Check(ctx context.Context, tx db.TxProvider, tenantId string, walletId uuid.UUID, currency string, opType models.OperationType, amount decimal.Decimal) error {
+	l, err := Effective(ctx, tx, tenantId, walletId)
+	if err != nil {
+		return err
+	}
+	if l.MaxOperationAmount.IsPositive() && amount.GreaterThan(l.MaxOperationAmount) {
+		return &Error{Code: CodeOperationAmount, Limit: l.MaxOperationAmount}
+	}
+
+	withdrawal := opType == models.WITHDRAW || opType == models.TRANSFER_OUT || opType == models.CAPTURE
+	checkWithdrawals := withdrawal && (l.DailyWithdrawal.IsPositive() || l.MonthlyWithdrawal.IsPositive())
+	checkCount := l.MaxOperations > 0 && l.OperationsWindowSeconds > 0
+	if !checkWithdrawals && !checkCount {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	window := now.Add(-time.Duration(l.OperationsWindowSeconds) * time.Second)
+	since := month
+	if checkCount && window.Before(since) {
+		since = window
+	}
+
+	var count int
+	var daily, monthly decimal.Decimal
+	err = tx.QueryRow(ctx, `
+		SELECT
+			COUNT(*) FILTER (WHERE created_at >= $3 AND operation_type = ANY($4)),
+			COALESCE(SUM(amount) FILTER (WHERE created_at >= $5 AND currency = $6 AND operation_type = ANY($7)), 0),
+			COALESCE(SUM(amount) FILTER (WHERE created_at >= $8 AND currency = $6 AND operation_type = ANY($7)), 0)
+		FROM wallet_transactions WHERE wallet_id=$1 AND created_at >= $2`,
+		walletId, since, window, initiated, day, currency, withdrawals, month).Scan(&count, &daily, &monthly)
+	if err != nil {
+		return err
+	}
+
+	if checkCount && count >= l.MaxOperations {
+		return &Error{Code: CodeOperationCount, Limit: decimal.NewFromInt(int64(l.MaxOperations))}
+	}
+	if withdrawal && l.DailyWithdrawal.IsPositive() && daily.Add(amount).GreaterThan(l.DailyWithdrawal) {
+		return &Error{Code: CodeDailyWithdrawal, Limit: l.DailyWithdrawal}
+	}
+	if withdrawal && l.MonthlyWithdrawal.IsPositive() && monthly.Add(amount).GreaterThan(l.MonthlyWithdrawal) {
+		return &Error{Code: CodeMonthlyWithdrawal, Limit: l.MonthlyWithdrawal}
+	}
+	return nil
+}
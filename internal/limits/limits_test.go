@@ -0,0 +1,151 @@
+package limits
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/db/dbtest"
+	"wallet-api-server/internal/models"
+)
+
+// overrideRow mocks the wallet_limits lookup, a nil override finds no row
+func overrideRow(o *models.LimitsOverride) *dbtest.Row {
+	if o == nil {
+		return dbtest.ErrRow(db.ErrNoRows)
+	}
+	return dbtest.NewRow(o.MaxOperationAmount, o.DailyWithdrawal, o.MonthlyWithdrawal, o.MaxOperations, o.OperationsWindowSeconds)
+}
+
+func totalsRow(count int, daily, monthly int64) *dbtest.Row {
+	return dbtest.NewRow(count, decimal.NewFromInt(daily), decimal.NewFromInt(monthly))
+}
+
+func amount(v int64) *decimal.Decimal {
+	d := decimal.NewFromInt(v)
+	return &d
+}
+
+func TestCheck_NoLimits(t *testing.T) {
+	mtx := new(dbtest.Tx)
+	mtx.On("QueryRow", mock.Anything, dbtest.IsQuery("SELECT max_operation_amount"), mock.Anything).Return(overrideRow(nil))
+
+	err := Check(context.Background(), mtx, models.DefaultTenant, uuid.New(), "USD", models.WITHDRAW, decimal.NewFromInt(1000000))
+	assert.NoError(t, err)
+	// without limits the totals are never read, only the tenant and the wallet overrides
+	mtx.AssertNumberOfCalls(t, "QueryRow", 2)
+}
+
+func TestCheck_DefaultOperationAmount(t *testing.T) {
+	t.Setenv("LIMIT_MAX_OPERATION_AMOUNT", "100")
+	mtx := new(dbtest.Tx)
+	mtx.On("QueryRow", mock.Anything, dbtest.IsQuery("SELECT max_operation_amount"), mock.Anything).Return(overrideRow(nil))
+
+	err := Check(context.Background(), mtx, models.DefaultTenant, uuid.New(), "USD", models.DEPOSIT, decimal.NewFromInt(101))
+	var limitErr *Error
+	assert.True(t, errors.As(err, &limitErr))
+	assert.Equal(t, CodeOperationAmount, limitErr.Code)
+
+	assert.NoError(t, Check(context.Background(), mtx, models.DefaultTenant, uuid.New(), "USD", models.DEPOSIT, decimal.NewFromInt(100)))
+}
+
+func TestCheck_OverrideRemovesDefault(t *testing.T) {
+	t.Setenv("LIMIT_MAX_OPERATION_AMOUNT", "100")
+	mtx := new(dbtest.Tx)
+	mtx.On("QueryRow", mock.Anything, dbtest.IsQuery("SELECT max_operation_amount"), mock.Anything).
+		Return(overrideRow(&models.LimitsOverride{MaxOperationAmount: amount(0)}))
+
+	assert.NoError(t, Check(context.Background(), mtx, models.DefaultTenant, uuid.New(), "USD", models.DEPOSIT, decimal.NewFromInt(500)))
+}
+
+func TestCheck_TenantLimits(t *testing.T) {
+	t.Setenv("LIMIT_MAX_OPERATION_AMOUNT", "100")
+	mtx := new(dbtest.Tx)
+	mtx.On("QueryRow", mock.Anything, dbtest.IsQuery("SELECT max_operation_amount"), []interface{}{"brand-a"}).
+		Return(overrideRow(&models.LimitsOverride{MaxOperationAmount: amount(1000)}))
+	mtx.On("QueryRow", mock.Anything, dbtest.IsQuery("SELECT max_operation_amount"), []interface{}{"brand-b"}).
+		Return(overrideRow(nil))
+	mtx.On("QueryRow", mock.Anything, dbtest.IsQuery("SELECT max_operation_amount"), mock.Anything).Return(overrideRow(nil))
+
+	// The tenant replaces the global default, other tenants keep it
+	assert.NoError(t, Check(context.Background(), mtx, "brand-a", uuid.New(), "USD", models.DEPOSIT, decimal.NewFromInt(500)))
+	err := Check(context.Background(), mtx, "brand-b", uuid.New(), "USD", models.DEPOSIT, decimal.NewFromInt(500))
+	var limitErr *Error
+	assert.True(t, errors.As(err, &limitErr))
+	assert.Equal(t, CodeOperationAmount, limitErr.Code)
+}
+
+func TestCheck_WithdrawalTotals(t *testing.T) {
+	cases := []struct {
+		opType        models.OperationType
+		daily, amount int64
+		code          string
+	}{
+		{models.WITHDRAW, 40, 10, ""},
+		{models.WITHDRAW, 40, 11, CodeDailyWithdrawal},
+		{models.TRANSFER_OUT, 0, 51, CodeDailyWithdrawal},
+		{models.CAPTURE, 0, 30, CodeMonthlyWithdrawal},
+		{models.DEPOSIT, 40, 100, ""},
+	}
+	for _, tc := range cases {
+		mtx := new(dbtest.Tx)
+		mtx.On("QueryRow", mock.Anything, dbtest.IsQuery("SELECT max_operation_amount"), mock.Anything).
+			Return(overrideRow(&models.LimitsOverride{DailyWithdrawal: amount(50), MonthlyWithdrawal: amount(200)}))
+		mtx.On("QueryRow", mock.Anything, dbtest.IsQuery("SELECT\n\t\t\tCOUNT(*)"), mock.Anything).Return(totalsRow(0, tc.daily, 180))
+
+		err := Check(context.Background(), mtx, models.DefaultTenant, uuid.New(), "USD", tc.opType, decimal.NewFromInt(tc.amount))
+		if tc.code == "" {
+			assert.NoError(t, err)
+			continue
+		}
+		var limitErr *Error
+		if assert.True(t, errors.As(err, &limitErr), "%v", err) {
+			assert.Equal(t, tc.code, limitErr.Code)
+		}
+	}
+}
+
+func TestCheck_OperationCount(t *testing.T) {
+	mtx := new(dbtest.Tx)
+	mtx.On("QueryRow", mock.Anything, dbtest.IsQuery("SELECT max_operation_amount"), mock.Anything).
+		Return(overrideRow(&models.LimitsOverride{MaxOperations: new(int)}))
+	assert.NoError(t, Check(context.Background(), mtx, models.DefaultTenant, uuid.New(), "USD", models.DEPOSIT, decimal.NewFromInt(1)))
+
+	three := 3
+	mtx = new(dbtest.Tx)
+	mtx.On("QueryRow", mock.Anything, dbtest.IsQuery("SELECT max_operation_amount"), mock.Anything).
+		Return(overrideRow(&models.LimitsOverride{MaxOperations: &three}))
+	mtx.On("QueryRow", mock.Anything, dbtest.IsQuery("SELECT\n\t\t\tCOUNT(*)"), mock.Anything).Return(totalsRow(3, 0, 0))
+
+	err := Check(context.Background(), mtx, models.DefaultTenant, uuid.New(), "USD", models.DEPOSIT, decimal.NewFromInt(1))
+	var limitErr *Error
+	assert.True(t, errors.As(err, &limitErr))
+	assert.Equal(t, CodeOperationCount, limitErr.Code)
+}
+
+func TestOverride_KeyedByTenant(t *testing.T) {
+	walletId := uuid.New()
+	mdb := new(dbtest.DB)
+	mdb.On("QueryRow", mock.Anything, dbtest.IsQuery("SELECT max_operation_amount, daily_withdrawal, monthly_withdrawal, max_operations, operations_window FROM wallet_limits"),
+		[]interface{}{"brand-a", walletId}).Return(overrideRow(&models.LimitsOverride{MaxOperationAmount: amount(10)}))
+	mdb.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(overrideRow(nil))
+	mdb.On("Exec", mock.Anything, dbtest.IsQuery("INSERT INTO wallet_limits (tenant_id, wallet_id"), mock.Anything).Return(nil, nil)
+
+	// An override saved by one tenant is not seen by another one
+	o, err := LoadOverride(context.Background(), mdb, "brand-a", walletId)
+	assert.NoError(t, err)
+	assert.True(t, o.MaxOperationAmount.Equal(decimal.NewFromInt(10)))
+	o, err = LoadOverride(context.Background(), mdb, "brand-b", walletId)
+	assert.NoError(t, err)
+	assert.Nil(t, o.MaxOperationAmount)
+
+	assert.NoError(t, SaveOverride(context.Background(), mdb, "brand-b", walletId, models.LimitsOverride{MaxOperationAmount: amount(5)}))
+	args := mdb.Calls[len(mdb.Calls)-1].Arguments.Get(2).([]interface{})
+	assert.Equal(t, []interface{}{"brand-b", walletId}, args[:2])
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Scope string
+
+const (
+	SCOPE_BALANCE_READ Scope = "balance:read"
+	SCOPE_DEPOSIT      Scope = "wallet:deposit"
+	SCOPE_WITHDRAW     Scope = "wallet:withdraw"
+	// SCOPE_ADMIN grants every other scope and the endpoints that manage wallets, keys and webhooks
+	SCOPE_ADMIN Scope = "admin"
+)
+
+// OperationScope is the scope a wallet operation of this type needs
+func OperationScope(t OperationType) Scope {
+	if t == DEPOSIT {
+		return SCOPE_DEPOSIT
+	}
+	return SCOPE_WITHDRAW
+}
+
+type APIKeyRequest struct {
+	Name   string  `json:"name" binding:"required,max=255"`
+	Scopes []Scope `json:"scopes" binding:"required,min=1,dive,oneof=balance:read wallet:deposit wallet:withdraw admin"`
+	// WalletIds restricts the key to these wallets, it may use all wallets when omitted
+	WalletIds []string `json:"walletIds,omitempty" binding:"omitempty,max=100,dive,uuid"`
+	// RequireSignature issues a signing secret, every request with the key has to be signed with it
+	RequireSignature bool `json:"requireSignature"`
+}
+
+// APIKey is stored as a hash of its token, Prefix is the start of the token
+// to tell keys apart. Token and SigningSecret are only returned when the key is created or rotated
+type APIKey struct {
+	Id               uuid.UUID   `json:"id"`
+	Name             string      `json:"name"`
+	Prefix           string      `json:"prefix"`
+	Token            string      `json:"token,omitempty"`
+	Scopes           []Scope     `json:"scopes"`
+	WalletIds        []uuid.UUID `json:"walletIds,omitempty"`
+	RequireSignature bool        `json:"requireSignature"`
+	SigningSecret    string      `json:"signingSecret,omitempty"`
+	CreatedAt        time.Time   `json:"createdAt"`
+	RotatedAt        *time.Time  `json:"rotatedAt,omitempty"`
+	RevokedAt        *time.Time  `json:"revokedAt,omitempty"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// DefaultCurrency is the ISO 4217 code of wallets created without an explicit currency
+func DefaultCurrency() string {
+	viper.AutomaticEnv()
+	viper.SetDefault("WALLET_DEFAULT_CURRENCY", "USD")
+	return strings.ToUpper(viper.GetString("WALLET_DEFAULT_CURRENCY"))
+}
+
+// MultiCurrencyEnabled reports whether a wallet may hold balances in several currencies,
+// otherwise operations in any currency other than the wallet's own are rejected
+func MultiCurrencyEnabled() bool {
+	viper.AutomaticEnv()
+	viper.SetDefault("WALLET_MULTI_CURRENCY", false)
+	return viper.GetBool("WALLET_MULTI_CURRENCY")
+}
+
+// IsCurrencyCode reports whether code looks like an ISO 4217 alphabetic code
+func IsCurrencyCode(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+type HoldStatus string
+
+const (
+	HOLD_ACTIVE   HoldStatus = "ACTIVE"
+	HOLD_CAPTURED HoldStatus = "CAPTURED"
+	HOLD_RELEASED HoldStatus = "RELEASED"
+	HOLD_EXPIRED  HoldStatus = "EXPIRED"
+)
+
+// Hold reserves funds of a wallet without withdrawing them,
+// Remaining is the part that is still reserved and can be captured
+type Hold struct {
+	HoldId    uuid.UUID       `json:"holdId"`
+	WalletId  uuid.UUID       `json:"walletId"`
+	Currency  string          `json:"currency"`
+	Amount    decimal.Decimal `json:"amount"`
+	Remaining decimal.Decimal `json:"remaining"`
+	Status    HoldStatus      `json:"status"`
+	ExpiresAt time.Time       `json:"expiresAt"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+type HoldRequest struct {
+	WalletId string          `json:"walletId" binding:"required,uuid"`
+	Amount   decimal.Decimal `json:"amount" binding:"required"`
+	Currency string          `json:"currency,omitempty" binding:"omitempty,iso4217"`
+	// TTLSeconds defaults to HOLD_DEFAULT_TTL
+	TTLSeconds int    `json:"ttlSeconds,omitempty" binding:"omitempty,gt=0"`
+	TenantId   string `json:"-"`
+}
+
+// CaptureRequest captures the whole remaining amount when Amount is omitted
+type CaptureRequest struct {
+	Amount   *decimal.Decimal `json:"amount,omitempty"`
+	ApiKeyId *uuid.UUID       `json:"-"`
+	TenantId string           `json:"-"`
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+// Limits are the limits in effect for a wallet, a zero value means unlimited.
+// Amounts apply in the currency of the operation
+type Limits struct {
+	MaxOperationAmount decimal.Decimal `json:"maxOperationAmount"`
+	DailyWithdrawal    decimal.Decimal `json:"dailyWithdrawal"`
+	MonthlyWithdrawal  decimal.Decimal `json:"monthlyWithdrawal"`
+	// MaxOperations bounds the operations a wallet initiates within OperationsWindowSeconds
+	MaxOperations           int `json:"maxOperations"`
+	OperationsWindowSeconds int `json:"operationsWindowSeconds"`
+}
+
+// LimitsOverride replaces the global defaults for a single wallet,
+// omitted fields keep the default and zero removes the limit
+type LimitsOverride struct {
+	MaxOperationAmount      *decimal.Decimal `json:"maxOperationAmount,omitempty"`
+	DailyWithdrawal         *decimal.Decimal `json:"dailyWithdrawal,omitempty"`
+	MonthlyWithdrawal       *decimal.Decimal `json:"monthlyWithdrawal,omitempty"`
+	MaxOperations           *int             `json:"maxOperations,omitempty" binding:"omitempty,min=0"`
+	OperationsWindowSeconds *int             `json:"operationsWindowSeconds,omitempty" binding:"omitempty,min=1"`
+}
+
+// Apply returns the limits with the overridden fields replaced
+func (o LimitsOverride) Apply(l Limits) Limits {
+	if o.MaxOperationAmount != nil {
+		l.MaxOperationAmount = *o.MaxOperationAmount
+	}
+	if o.DailyWithdrawal != nil {
+		l.DailyWithdrawal = *o.DailyWithdrawal
+	}
+	if o.MonthlyWithdrawal != nil {
+		l.MonthlyWithdrawal = *o.MonthlyWithdrawal
+	}
+	if o.MaxOperations != nil {
+		l.MaxOperations = *o.MaxOperations
+	}
+	if o.OperationsWindowSeconds != nil {
+		l.OperationsWindowSeconds = *o.OperationsWindowSeconds
+	}
+	return l
+}
@@ -1,8 +1,11 @@
 package models
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
+	"github.com/spf13/viper"
 )
 
 type OperationType string
@@ -10,15 +13,135 @@ type OperationType string
 const (
 	DEPOSIT  OperationType = "DEPOSIT"
 	WITHDRAW OperationType = "WITHDRAW"
+	// Ledger entry types of the two legs of a transfer
+	TRANSFER_OUT OperationType = "TRANSFER_OUT"
+	TRANSFER_IN  OperationType = "TRANSFER_IN"
+	// Ledger entry type of a captured hold
+	CAPTURE OperationType = "CAPTURE"
+	// Ledger entry types of compensating entries crediting or debiting the wallet
+	REVERSAL_IN  OperationType = "REVERSAL_IN"
+	REVERSAL_OUT OperationType = "REVERSAL_OUT"
 )
 
+// IsCredit reports whether a ledger entry of this type increases the balance
+func (t OperationType) IsCredit() bool {
+	switch t {
+	case DEPOSIT, TRANSFER_IN, REVERSAL_IN:
+		return true
+	}
+	return false
+}
+
+// OperationDetails are the caller's own data about an operation, stored with its ledger entry.
+// Metadata holds at most 20 keys of up to 64 characters with values of up to 512 characters
+type OperationDetails struct {
+	// Reference identifies the operation in the caller's system, e.g. an order id
+	Reference   string            `json:"reference,omitempty" binding:"omitempty,max=128"`
+	Description string            `json:"description,omitempty" binding:"omitempty,max=255"`
+	Metadata    map[string]string `json:"metadata,omitempty" binding:"omitempty,max=20,dive,keys,min=1,max=64,endkeys,max=512"`
+}
+
+// UniqueReferencesEnabled reports whether a reference may be used only once per wallet
+func UniqueReferencesEnabled() bool {
+	viper.AutomaticEnv()
+	viper.SetDefault("WALLET_UNIQUE_REFERENCES", false)
+	return viper.GetBool("WALLET_UNIQUE_REFERENCES")
+}
+
 type WalletOperationRequest struct {
 	WalletId      string          `json:"walletId" binding:"required,uuid"`
 	OperationType OperationType   `json:"operationType" binding:"required,oneof=DEPOSIT WITHDRAW"`
 	Amount        decimal.Decimal `json:"amount" binding:"required,gt=0"`
+	// Currency defaults to the wallet's primary currency
+	Currency string `json:"currency,omitempty" binding:"omitempty,iso4217"`
+	// IdempotencyKey may also be sent in the Idempotency-Key header
+	IdempotencyKey string `json:"idempotencyKey,omitempty" binding:"omitempty,max=255"`
+	OperationDetails
+	// ApiKeyId is the key the request was authenticated with, it is not part of the payload
+	ApiKeyId *uuid.UUID `json:"-"`
+	// TenantId is the tenant of the credentials, only its wallets can be used
+	TenantId string `json:"-"`
+}
+
+type BatchMode string
+
+const (
+	BATCH_ALL_OR_NOTHING BatchMode = "all-or-nothing"
+	BATCH_BEST_EFFORT    BatchMode = "best-effort"
+)
+
+type BatchRequest struct {
+	Mode       BatchMode                `json:"mode" binding:"required,oneof=all-or-nothing best-effort"`
+	Operations []WalletOperationRequest `json:"operations" binding:"required,min=1,dive"`
+}
+
+type TransferRequest struct {
+	FromWalletId   string          `json:"fromWalletId" binding:"required,uuid"`
+	ToWalletId     string          `json:"toWalletId" binding:"required,uuid,nefield=FromWalletId"`
+	Amount         decimal.Decimal `json:"amount" binding:"required"`
+	Currency       string          `json:"currency,omitempty" binding:"omitempty,iso4217"`
+	IdempotencyKey string          `json:"idempotencyKey,omitempty" binding:"omitempty,max=255"`
+	ApiKeyId       *uuid.UUID      `json:"-"`
+	TenantId       string          `json:"-"`
+}
+
+// ReversalRequest reverses the whole not yet reversed amount when Amount is omitted
+type ReversalRequest struct {
+	Amount         *decimal.Decimal `json:"amount,omitempty"`
+	IdempotencyKey string           `json:"idempotencyKey,omitempty" binding:"omitempty,max=255"`
+	ApiKeyId       *uuid.UUID       `json:"-"`
+	TenantId       string           `json:"-"`
 }
 
 type Wallet struct {
 	WalletId uuid.UUID       `json:"walletId"`
+	Currency string          `json:"currency"`
+	Balance  decimal.Decimal `json:"balance"`
+}
+
+// Balance is one currency balance of a wallet. Available is what can be spent:
+// the balance without the amounts reserved by active holds, plus the credit limit
+type Balance struct {
+	Currency        string          `json:"currency"`
+	Balance         decimal.Decimal `json:"balance"`
+	Available       decimal.Decimal `json:"available"`
+	CreditLimit     decimal.Decimal `json:"creditLimit"`
+	AvailableCredit decimal.Decimal `json:"availableCredit"`
+	// Version grows with every change of the balance row, API v1 does not report it
+	Version   int64     `json:"-"`
+	UpdatedAt time.Time `json:"-"`
+}
+
+func NewBalance(currency string, balance, held, creditLimit decimal.Decimal) Balance {
+	available := balance.Sub(held).Add(creditLimit)
+	return Balance{
+		Currency:        currency,
+		Balance:         balance,
+		Available:       available,
+		CreditLimit:     creditLimit,
+		AvailableCredit: decimal.Min(creditLimit, decimal.Max(available, decimal.Zero)),
+	}
+}
+
+type Transaction struct {
+	Id            int64           `json:"-"`
+	OperationId   uuid.UUID       `json:"operationId"`
+	WalletId      uuid.UUID       `json:"walletId"`
+	OperationType OperationType   `json:"operationType"`
+	Currency      string          `json:"currency"`
+	Amount        decimal.Decimal `json:"amount"`
+	BalanceAfter  decimal.Decimal `json:"balanceAfter"`
+	TransferId    *uuid.UUID      `json:"transferId,omitempty"`
+	HoldId        *uuid.UUID      `json:"holdId,omitempty"`
+	ReversalOf    *uuid.UUID      `json:"reversalOf,omitempty"`
+	OperationDetails
+	// ApiKeyId is the key the operation was made with
+	ApiKeyId  *uuid.UUID `json:"apiKeyId,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// PointInTimeBalance is the balance of one currency as it was at a past moment
+type PointInTimeBalance struct {
+	Currency string          `json:"currency"`
 	Balance  decimal.Decimal `json:"balance"`
 }
@@ -32,3 +32,73 @@ func TestWallet_Struct(t *testing.T) {
 	assert.Equal(t, id, w.WalletId)
 	assert.True(t, w.Balance.Equal(decimal.NewFromInt(100)))
 }
+
+func TestIsCurrencyCode(t *testing.T) {
+	assert.True(t, IsCurrencyCode("USD"))
+	assert.False(t, IsCurrencyCode("usd"))
+	assert.False(t, IsCurrencyCode("US"))
+	assert.False(t, IsCurrencyCode("US'"))
+}
+
+func TestParseAmount(t *testing.T) {
+	minor := func(v int64) *int64 { return &v }
+	tests := []struct {
+		value    string
+		minor    *int64
+		currency string
+		want     string
+		err      bool
+	}{
+		{"10.50", nil, "USD", "10.5", false},
+		{"10.5", nil, "", "10.5", false},
+		{"0.0001", nil, "", "0.0001", false},
+		{"", minor(1050), "USD", "10.5", false},
+		{"", minor(1050), "JPY", "1050", false},
+		{"", minor(1050), "KWD", "1.05", false},
+		{"", nil, "USD", "", true},
+		{"1", minor(100), "USD", "", true},
+		{"", minor(100), "", "", true},
+		{"", minor(-1), "USD", "", true},
+		{"-1", nil, "USD", "", true},
+		{"1e2", nil, "USD", "", true},
+		{"0.00", nil, "USD", "", true},
+		{"1.001", nil, "USD", "", true},
+		{"0.00001", nil, "", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseAmount(tt.value, tt.minor, tt.currency)
+		if tt.err {
+			assert.Error(t, err, tt.value)
+			continue
+		}
+		assert.NoError(t, err, tt.value)
+		assert.True(t, decimal.RequireFromString(tt.want).Equal(got), "%s: %s", tt.value, got)
+	}
+}
+
+func TestNewMoney(t *testing.T) {
+	m := NewMoney(decimal.RequireFromString("10.5"), "USD")
+	assert.Equal(t, "10.50", m.Value)
+	assert.Equal(t, int64(1050), *m.Minor)
+
+	m = NewMoney(decimal.RequireFromString("-3"), "JPY")
+	assert.Equal(t, "-3", m.Value)
+	assert.Equal(t, int64(-3), *m.Minor)
+
+	m = NewMoney(decimal.RequireFromString("0.0005"), "USD")
+	assert.Equal(t, "0.0005", m.Value)
+	assert.Nil(t, m.Minor)
+}
+
+func TestTenantAllows(t *testing.T) {
+	all := Tenant{Id: "brand-a"}
+	assert.True(t, all.Allows(TENANT_HOLD))
+
+	depositsOnly := Tenant{Id: "brand-b", AllowedOperations: []TenantOperation{TENANT_DEPOSIT}}
+	assert.True(t, depositsOnly.Allows(TenantOperationOf(DEPOSIT)))
+	assert.False(t, depositsOnly.Allows(TenantOperationOf(WITHDRAW)))
+	assert.False(t, depositsOnly.Allows(TENANT_TRANSFER))
+
+	none := Tenant{Id: "brand-c", AllowedOperations: []TenantOperation{}}
+	assert.False(t, none.Allows(TENANT_DEPOSIT))
+}
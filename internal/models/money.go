@@ -0,0 +1,103 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+
+	"github.com/shopspring/decimal"
+)
+
+// minorUnits lists the ISO 4217 currencies whose minor unit is not a hundredth
+var minorUnits = map[string]int32{
+	"BHD": 3, "BIF": 0, "CLF": 4, "CLP": 0, "DJF": 0, "GNF": 0, "IQD": 3, "ISK": 0,
+	"JOD": 3, "JPY": 0, "KMF": 0, "KRW": 0, "KWD": 3, "LYD": 3, "OMR": 3, "PYG": 0,
+	"RWF": 0, "TND": 3, "UGX": 0, "UYI": 0, "UYW": 4, "VND": 0, "VUV": 0, "XAF": 0,
+	"XOF": 0, "XPF": 0,
+}
+
+// amountScale is the scale of the amount columns, no amount may be more precise
+const amountScale = 4
+
+var amountPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?$`)
+
+// MinorUnits returns the number of decimal places of the currency's minor unit
+func MinorUnits(currency string) int32 {
+	if exp, ok := minorUnits[currency]; ok {
+		return exp
+	}
+	return 2
+}
+
+// FromMinor converts an amount in minor units of the currency to a decimal
+func FromMinor(minor int64, currency string) decimal.Decimal {
+	return decimal.New(minor, -MinorUnits(currency))
+}
+
+// ToMinor converts an amount to minor units of the currency,
+// ok is false when it is not a whole number of them
+func ToMinor(amount decimal.Decimal, currency string) (minor int64, ok bool) {
+	shifted := amount.Shift(MinorUnits(currency))
+	if !shifted.IsInteger() || shifted.Abs().GreaterThan(decimal.NewFromInt(math.MaxInt64)) {
+		return 0, false
+	}
+	return shifted.IntPart(), true
+}
+
+// Money is an amount as API v2 writes it: the exact decimal as a string and, when
+// the amount is a whole number of the currency's minor units, that number
+type Money struct {
+	Value string `json:"value"`
+	Minor *int64 `json:"minor,omitempty"`
+}
+
+func NewMoney(amount decimal.Decimal, currency string) Money {
+	minor, ok := ToMinor(amount, currency)
+	if !ok {
+		return Money{Value: amount.String()}
+	}
+	return Money{Value: amount.StringFixed(MinorUnits(currency)), Minor: &minor}
+}
+
+// ParseAmount reads an API v2 amount, given either as a decimal string or as minor
+// units of the currency. Exactly one of them must be set and the amount must be positive.
+// Without a currency the decimal string may have as many places as the ledger keeps
+func ParseAmount(value string, minor *int64, currency string) (decimal.Decimal, error) {
+	switch {
+	case value == "" && minor == nil:
+		return decimal.Zero, errors.New("amount or amountMinor is required")
+	case value != "" && minor != nil:
+		return decimal.Zero, errors.New("amount and amountMinor are mutually exclusive")
+	case minor != nil:
+		if currency == "" {
+			return decimal.Zero, errors.New("amountMinor requires currency")
+		}
+		if *minor <= 0 {
+			return decimal.Zero, errors.New("amountMinor must be positive")
+		}
+		return FromMinor(*minor, currency), nil
+	}
+
+	if !amountPattern.MatchString(value) {
+		return decimal.Zero, errors.New("amount must be a decimal string such as \"10.50\"")
+	}
+	amount, err := decimal.NewFromString(value)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("invalid amount: %w", err)
+	}
+	if !amount.IsPositive() {
+		return decimal.Zero, errors.New("amount must be positive")
+	}
+	places := int32(amountScale)
+	if currency != "" {
+		places = MinorUnits(currency)
+	}
+	if !amount.Equal(amount.Truncate(places)) {
+		if currency == "" {
+			return decimal.Zero, fmt.Errorf("amount has more than %d decimal places", places)
+		}
+		return decimal.Zero, fmt.Errorf("amount has more decimal places than %s allows", currency)
+	}
+	return amount, nil
+}
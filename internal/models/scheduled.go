@@ -0,0 +1,62 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+type ScheduleStatus string
+
+const (
+	SCHEDULE_PENDING ScheduleStatus = "PENDING"
+	// Claimed by a server instance that is executing it
+	SCHEDULE_RUNNING   ScheduleStatus = "RUNNING"
+	SCHEDULE_EXECUTED  ScheduleStatus = "EXECUTED"
+	SCHEDULE_FAILED    ScheduleStatus = "FAILED"
+	SCHEDULE_CANCELLED ScheduleStatus = "CANCELLED"
+)
+
+type ScheduleRequest struct {
+	WalletId      string          `json:"walletId" binding:"required,uuid"`
+	OperationType OperationType   `json:"operationType" binding:"required,oneof=DEPOSIT WITHDRAW"`
+	Amount        decimal.Decimal `json:"amount" binding:"required"`
+	Currency      string          `json:"currency,omitempty" binding:"omitempty,iso4217"`
+	ExecuteAt     time.Time       `json:"executeAt" binding:"required"`
+	ApiKeyId      *uuid.UUID      `json:"-"`
+	TenantId      string          `json:"-"`
+}
+
+// ScheduledOperation is a wallet operation that runs at ExecuteAt. Once it ran,
+// OperationId and BalanceAfter or Error hold the result
+type ScheduledOperation struct {
+	Id            uuid.UUID        `json:"id"`
+	WalletId      uuid.UUID        `json:"walletId"`
+	OperationType OperationType    `json:"operationType"`
+	Amount        decimal.Decimal  `json:"amount"`
+	Currency      string           `json:"currency,omitempty"`
+	ExecuteAt     time.Time        `json:"executeAt"`
+	Status        ScheduleStatus   `json:"status"`
+	OperationId   *uuid.UUID       `json:"operationId,omitempty"`
+	BalanceAfter  *decimal.Decimal `json:"balanceAfter,omitempty"`
+	Error         *string          `json:"error,omitempty"`
+	CreatedAt     time.Time        `json:"createdAt"`
+	ExecutedAt    *time.Time       `json:"executedAt,omitempty"`
+	// ApiKeyId is the key the operation was scheduled with, its ledger entry is recorded with it
+	ApiKeyId *uuid.UUID `json:"apiKeyId,omitempty"`
+	TenantId string     `json:"-"`
+}
+
+// Request is the wallet operation to run, keyed so that it is applied at most once
+func (s ScheduledOperation) Request() WalletOperationRequest {
+	return WalletOperationRequest{
+		WalletId:       s.WalletId.String(),
+		OperationType:  s.OperationType,
+		Amount:         s.Amount,
+		Currency:       s.Currency,
+		IdempotencyKey: "scheduled:" + s.Id.String(),
+		ApiKeyId:       s.ApiKeyId,
+		TenantId:       s.TenantId,
+	}
+}
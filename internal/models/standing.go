@@ -0,0 +1,90 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+type StandingOrderStatus string
+
+const (
+	STANDING_ACTIVE StandingOrderStatus = "ACTIVE"
+	STANDING_PAUSED StandingOrderStatus = "PAUSED"
+	// The end date has passed, no more transfers are made
+	STANDING_COMPLETED StandingOrderStatus = "COMPLETED"
+	STANDING_DELETED   StandingOrderStatus = "DELETED"
+)
+
+type StandingOrderRequest struct {
+	FromWalletId string          `json:"fromWalletId" binding:"required,uuid"`
+	ToWalletId   string          `json:"toWalletId" binding:"required,uuid,nefield=FromWalletId"`
+	Amount       decimal.Decimal `json:"amount" binding:"required"`
+	Currency     string          `json:"currency,omitempty" binding:"omitempty,iso4217"`
+	// DayOfMonth beyond the length of a month runs on its last day
+	DayOfMonth int `json:"dayOfMonth" binding:"required,min=1,max=31"`
+	// StartAt also sets the time of day of every run
+	StartAt time.Time  `json:"startAt" binding:"required"`
+	EndAt   *time.Time `json:"endAt,omitempty"`
+	// MaxRetries is how often a run that failed for insufficient funds is retried
+	MaxRetries           int        `json:"maxRetries" binding:"min=0,max=100"`
+	RetryIntervalSeconds int        `json:"retryIntervalSeconds,omitempty" binding:"omitempty,min=60"`
+	ApiKeyId             *uuid.UUID `json:"-"`
+	TenantId             string     `json:"-"`
+}
+
+// StandingOrder transfers Amount every month. DueAt is the run of the current
+// period and NextRunAt the next attempt of it, later than DueAt while retrying
+type StandingOrder struct {
+	Id                   uuid.UUID           `json:"id"`
+	FromWalletId         uuid.UUID           `json:"fromWalletId"`
+	ToWalletId           uuid.UUID           `json:"toWalletId"`
+	Amount               decimal.Decimal     `json:"amount"`
+	Currency             string              `json:"currency,omitempty"`
+	DayOfMonth           int                 `json:"dayOfMonth"`
+	StartAt              time.Time           `json:"startAt"`
+	EndAt                *time.Time          `json:"endAt,omitempty"`
+	MaxRetries           int                 `json:"maxRetries"`
+	RetryIntervalSeconds int                 `json:"retryIntervalSeconds"`
+	Status               StandingOrderStatus `json:"status"`
+	DueAt                time.Time           `json:"dueAt"`
+	NextRunAt            time.Time           `json:"nextRunAt"`
+	Attempt              int                 `json:"attempt"`
+	CreatedAt            time.Time           `json:"createdAt"`
+	// ApiKeyId is the key the order was created with, its transfers are recorded with it
+	ApiKeyId *uuid.UUID `json:"apiKeyId,omitempty"`
+	TenantId string     `json:"-"`
+}
+
+// Request is the transfer of one attempt, keyed so that it is applied at most once
+func (o StandingOrder) Request() TransferRequest {
+	return TransferRequest{
+		FromWalletId:   o.FromWalletId.String(),
+		ToWalletId:     o.ToWalletId.String(),
+		Amount:         o.Amount,
+		Currency:       o.Currency,
+		IdempotencyKey: fmt.Sprintf("standing:%s:%d:%d", o.Id, o.DueAt.Unix(), o.Attempt),
+		ApiKeyId:       o.ApiKeyId,
+		TenantId:       o.TenantId,
+	}
+}
+
+type ExecutionStatus string
+
+const (
+	EXECUTION_SUCCEEDED ExecutionStatus = "SUCCEEDED"
+	EXECUTION_FAILED    ExecutionStatus = "FAILED"
+)
+
+// StandingOrderExecution records one attempt to run a standing order
+type StandingOrderExecution struct {
+	OrderId    uuid.UUID       `json:"orderId"`
+	DueAt      time.Time       `json:"dueAt"`
+	Attempt    int             `json:"attempt"`
+	Status     ExecutionStatus `json:"status"`
+	TransferId *uuid.UUID      `json:"transferId,omitempty"`
+	Error      *string         `json:"error,omitempty"`
+	ExecutedAt time.Time       `json:"executedAt"`
+}
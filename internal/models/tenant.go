@@ -0,0 +1,64 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// DefaultTenant owns the wallets created without authentication and before tenants
+// existed. Its admins manage the other tenants
+const DefaultTenant = "default"
+
+// TenantOperation is a kind of request a tenant can be limited to
+type TenantOperation string
+
+const (
+	TENANT_DEPOSIT  TenantOperation = "DEPOSIT"
+	TENANT_WITHDRAW TenantOperation = "WITHDRAW"
+	TENANT_TRANSFER TenantOperation = "TRANSFER"
+	// Creating and capturing holds, releasing one is always allowed
+	TENANT_HOLD TenantOperation = "HOLD"
+)
+
+// TenantOperationOf is the tenant operation a wallet operation of this type counts as
+func TenantOperationOf(t OperationType) TenantOperation {
+	if t == DEPOSIT {
+		return TENANT_DEPOSIT
+	}
+	return TENANT_WITHDRAW
+}
+
+// TenantRequest is the configuration of a tenant, it replaces the whole configuration on an update
+type TenantRequest struct {
+	Name string `json:"name" binding:"required,max=255"`
+	// AllowedOperations limits the tenant to these operations, it may use all of them when omitted
+	AllowedOperations []TenantOperation `json:"allowedOperations,omitempty" binding:"omitempty,dive,oneof=DEPOSIT WITHDRAW TRANSFER HOLD"`
+	// Limits replace the global limits for the tenant's wallets, wallet overrides still apply on top
+	Limits LimitsOverride `json:"limits"`
+	// CacheTTLSeconds replaces HTTP_GET_WALLET_BALANCE_CACHE_TTL for the tenant's wallets
+	CacheTTLSeconds *int `json:"cacheTtlSeconds,omitempty" binding:"omitempty,min=0"`
+}
+
+// CreateTenantRequest names the new tenant with Id, lower case letters, digits and dashes
+type CreateTenantRequest struct {
+	Id string `json:"id" binding:"required,max=64"`
+	TenantRequest
+}
+
+// Tenant is a partner brand hosted by the deployment. Its wallets, operations and
+// credentials cannot be reached with the credentials of any other tenant
+type Tenant struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	// AllowedOperations is null when the tenant may use every operation
+	AllowedOperations []TenantOperation `json:"allowedOperations"`
+	Limits            LimitsOverride    `json:"limits"`
+	CacheTTLSeconds   *int              `json:"cacheTtlSeconds,omitempty"`
+	CreatedAt         time.Time         `json:"createdAt"`
+	UpdatedAt         time.Time         `json:"updatedAt"`
+}
+
+// Allows reports whether the tenant may make requests of the operation
+func (t Tenant) Allows(op TenantOperation) bool {
+	return t.AllowedOperations == nil || slices.Contains(t.AllowedOperations, op)
+}
@@ -0,0 +1,53 @@
+package models
+
+// API v2 requests take amounts as decimal strings or as integer minor units,
+// JSON numbers are rejected. They convert to the v1 requests the queue runs
+
+type WalletOperationRequestV2 struct {
+	WalletId      string        `json:"walletId" binding:"required,uuid"`
+	OperationType OperationType `json:"operationType" binding:"required,oneof=DEPOSIT WITHDRAW"`
+	Amount        string        `json:"amount,omitempty"`
+	AmountMinor   *int64        `json:"amountMinor,omitempty"`
+	// Currency is required with AmountMinor, otherwise it defaults to the wallet's primary currency
+	Currency       string `json:"currency,omitempty" binding:"omitempty,iso4217"`
+	IdempotencyKey string `json:"idempotencyKey,omitempty" binding:"omitempty,max=255"`
+	OperationDetails
+}
+
+func (r WalletOperationRequestV2) V1() (WalletOperationRequest, error) {
+	amount, err := ParseAmount(r.Amount, r.AmountMinor, r.Currency)
+	if err != nil {
+		return WalletOperationRequest{}, err
+	}
+	return WalletOperationRequest{
+		WalletId:         r.WalletId,
+		OperationType:    r.OperationType,
+		Amount:           amount,
+		Currency:         r.Currency,
+		IdempotencyKey:   r.IdempotencyKey,
+		OperationDetails: r.OperationDetails,
+	}, nil
+}
+
+type TransferRequestV2 struct {
+	FromWalletId   string `json:"fromWalletId" binding:"required,uuid"`
+	ToWalletId     string `json:"toWalletId" binding:"required,uuid,nefield=FromWalletId"`
+	Amount         string `json:"amount,omitempty"`
+	AmountMinor    *int64 `json:"amountMinor,omitempty"`
+	Currency       string `json:"currency,omitempty" binding:"omitempty,iso4217"`
+	IdempotencyKey string `json:"idempotencyKey,omitempty" binding:"omitempty,max=255"`
+}
+
+func (r TransferRequestV2) V1() (TransferRequest, error) {
+	amount, err := ParseAmount(r.Amount, r.AmountMinor, r.Currency)
+	if err != nil {
+		return TransferRequest{}, err
+	}
+	return TransferRequest{
+		FromWalletId:   r.FromWalletId,
+		ToWalletId:     r.ToWalletId,
+		Amount:         amount,
+		Currency:       r.Currency,
+		IdempotencyKey: r.IdempotencyKey,
+	}, nil
+}
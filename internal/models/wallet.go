@@ -0,0 +1,74 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/spf13/viper"
+)
+
+type WalletStatus string
+
+const (
+	WALLET_ACTIVE WalletStatus = "ACTIVE"
+	// A frozen wallet rejects every operation that moves funds until it is unfrozen
+	WALLET_FROZEN WalletStatus = "FROZEN"
+	// A closed wallet is kept for its history only
+	WALLET_CLOSED WalletStatus = "CLOSED"
+)
+
+// ImplicitCreationEnabled reports whether the first operation on an unknown
+// wallet id creates the wallet, otherwise wallets must be created explicitly
+func ImplicitCreationEnabled() bool {
+	viper.AutomaticEnv()
+	viper.SetDefault("WALLET_IMPLICIT_CREATION", true)
+	return viper.GetBool("WALLET_IMPLICIT_CREATION")
+}
+
+// CreateWalletRequest creates a wallet with a generated id when WalletId is omitted
+type CreateWalletRequest struct {
+	WalletId string `json:"walletId,omitempty" binding:"omitempty,uuid"`
+	Currency string `json:"currency,omitempty" binding:"omitempty,iso4217"`
+	// Owner is the subject of the end user's tokens that may use the wallet
+	Owner    string `json:"owner,omitempty" binding:"omitempty,max=255"`
+	TenantId string `json:"-"`
+}
+
+// OwnerRequest hands a wallet to another owner, an empty owner leaves it without one
+type OwnerRequest struct {
+	Owner string `json:"owner" binding:"max=255"`
+}
+
+// CloseWalletRequest moves any remaining funds to SweepToWalletId before closing,
+// without it only a wallet with zero balances can be closed
+type CloseWalletRequest struct {
+	SweepToWalletId string `json:"sweepToWalletId,omitempty" binding:"omitempty,uuid"`
+}
+
+// WalletState is a wallet's status together with its currency balances
+type WalletState struct {
+	WalletId uuid.UUID    `json:"walletId"`
+	Owner    string       `json:"owner,omitempty"`
+	Status   WalletStatus `json:"status"`
+	Balances []Balance    `json:"balances"`
+}
+
+// CreditLimitRequest sets the credit limit of the wallet's balance in Currency,
+// the primary currency when it is omitted
+type CreditLimitRequest struct {
+	CreditLimit *decimal.Decimal `json:"creditLimit"`
+	Currency    string           `json:"currency,omitempty" binding:"omitempty,iso4217"`
+	Reason      string           `json:"reason,omitempty" binding:"max=255"`
+	TenantId    string           `json:"-"`
+}
+
+// CreditLimitChange is the audit record of an adjusted credit limit
+type CreditLimitChange struct {
+	WalletId  uuid.UUID       `json:"walletId"`
+	Currency  string          `json:"currency"`
+	OldLimit  decimal.Decimal `json:"oldLimit"`
+	NewLimit  decimal.Decimal `json:"newLimit"`
+	Reason    string          `json:"reason,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
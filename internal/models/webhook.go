@@ -0,0 +1,64 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event type of a committed ledger entry
+const EVENT_BALANCE_CHANGED = "balance.changed"
+
+// Event is published for every committed ledger entry. Its id is the operation id of the entry
+type Event struct {
+	Id        uuid.UUID   `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Data      Transaction `json:"data"`
+}
+
+func NewBalanceChangedEvent(t Transaction) Event {
+	return Event{Id: t.OperationId, Type: EVENT_BALANCE_CHANGED, CreatedAt: t.CreatedAt, Data: t}
+}
+
+type WebhookEndpointRequest struct {
+	Url string `json:"url" binding:"required,url,startswith=http"`
+	// WalletId limits the endpoint to the events of one wallet
+	WalletId string `json:"walletId,omitempty" binding:"omitempty,uuid"`
+	TenantId string `json:"-"`
+}
+
+type WebhookEndpoint struct {
+	Id       uuid.UUID  `json:"id"`
+	Url      string     `json:"url"`
+	WalletId *uuid.UUID `json:"walletId,omitempty"`
+	// Secret is only returned when the endpoint is registered
+	Secret    string    `json:"secret,omitempty"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"createdAt"`
+	// TenantId is the tenant whose wallet events the endpoint receives
+	TenantId string `json:"-"`
+}
+
+type DeliveryStatus string
+
+const (
+	DELIVERY_PENDING   DeliveryStatus = "PENDING"
+	DELIVERY_DELIVERED DeliveryStatus = "DELIVERED"
+	// Delivery gave up after the last retry, it can be redelivered manually
+	DELIVERY_DEAD DeliveryStatus = "DEAD"
+)
+
+type WebhookDelivery struct {
+	Id             uuid.UUID      `json:"id"`
+	EndpointId     uuid.UUID      `json:"endpointId"`
+	EventId        uuid.UUID      `json:"eventId"`
+	EventType      string         `json:"eventType"`
+	Status         DeliveryStatus `json:"status"`
+	Attempts       int            `json:"attempts"`
+	NextAttemptAt  time.Time      `json:"nextAttemptAt"`
+	LastError      *string        `json:"lastError,omitempty"`
+	LastStatusCode *int           `json:"lastStatusCode,omitempty"`
+	CreatedAt      time.Time      `json:"createdAt"`
+	DeliveredAt    *time.Time     `json:"deliveredAt,omitempty"`
+}
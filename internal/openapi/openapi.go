@@ -0,0 +1,122 @@
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"wallet-api-server/internal/problem"
+)
+
+//go:embed openapi.json
+var spec []byte
+
+// Document is the part of an OpenAPI 3 document needed to validate requests
+type Document struct {
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components struct {
+		Schemas   map[string]*Schema   `json:"schemas"`
+		Responses map[string]*Response `json:"responses"`
+	} `json:"components"`
+
+	// operations is keyed by method and gin route, e.g. "GET /api/v1/wallets/:walletId"
+	operations map[string]*Operation
+}
+
+type Operation struct {
+	OperationId string               `json:"operationId"`
+	Parameters  []Parameter          `json:"parameters"`
+	RequestBody *RequestBody         `json:"requestBody"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Ref     string               `json:"$ref"`
+	Content map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+var pathParam = regexp.MustCompile(`\{([^}]+)\}`)
+
+// Load parses the embedded document
+func Load() (*Document, error) {
+	var d Document
+	if err := json.Unmarshal(spec, &d); err != nil {
+		return nil, fmt.Errorf("parse openapi.json: %w", err)
+	}
+	d.operations = make(map[string]*Operation)
+	for path, item := range d.Paths {
+		route := pathParam.ReplaceAllString(path, ":$1")
+		for method, op := range item {
+			d.operations[strings.ToUpper(method)+" "+route] = op
+		}
+	}
+	return &d, nil
+}
+
+// Serve writes the document as it is embedded
+func (d *Document) Serve(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json", spec)
+}
+
+// operation returns the operation of a gin route, nil when the document does not describe it
+func (d *Document) operation(method, route string) *Operation {
+	return d.operations[method+" "+route]
+}
+
+// response resolves the schema of a JSON response, nil when the status is not documented
+func (d *Document) response(op *Operation, status int) *Schema {
+	r, ok := op.Responses[fmt.Sprint(status)]
+	if !ok {
+		return nil
+	}
+	if r.Ref != "" {
+		if r = d.Components.Responses[strings.TrimPrefix(r.Ref, "#/components/responses/")]; r == nil {
+			return nil
+		}
+	}
+	if media, ok := r.Content[problem.ContentType]; ok {
+		return media.Schema
+	}
+	return r.Content["application/json"].Schema
+}
+
+// Validate rejects the requests to documented operations that do not conform to
+// their parameters and request body with 400 and the list of violations.
+// Routes the document does not describe pass through unchecked
+func (d *Document) Validate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		op := d.operation(c.Request.Method, c.FullPath())
+		if op == nil {
+			c.Next()
+			return
+		}
+		violations := d.validateRequest(c, op)
+		if len(violations) > 0 {
+			problem.Abort(c, http.StatusBadRequest, problem.CodeInvalidRequest, "Request does not match the API specification",
+				gin.H{"violations": violations})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,280 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"wallet-api-server/internal/api"
+	"wallet-api-server/internal/cache"
+	"wallet-api-server/internal/models"
+	"wallet-api-server/internal/problem"
+	"wallet-api-server/internal/queue"
+)
+
+func load(t *testing.T) *Document {
+	d, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load the document: %v", err)
+	}
+	return d
+}
+
+// jsonFields returns the JSON names of a struct's fields and the ones bound as required
+func jsonFields(typ reflect.Type) (names, required []string) {
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.Anonymous && f.Tag.Get("json") == "" {
+			embedded, embeddedRequired := jsonFields(f.Type)
+			names = append(names, embedded...)
+			required = append(required, embeddedRequired...)
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		names = append(names, name)
+		for _, rule := range strings.Split(f.Tag.Get("binding"), ",") {
+			if rule == "required" {
+				required = append(required, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	sort.Strings(required)
+	return names, required
+}
+
+func schemaFields(s *Schema) (names []string) {
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestSchemasMatchModels(t *testing.T) {
+	d := load(t)
+	tests := []struct {
+		schema string
+		model  interface{}
+		// Request schemas also require the fields the model binds as required
+		request bool
+	}{
+		{"WalletOperationRequest", models.WalletOperationRequest{}, true},
+		{"WalletOperationRequestV2", models.WalletOperationRequestV2{}, true},
+		{"Balance", models.Balance{}, false},
+		{"PointInTimeBalance", models.PointInTimeBalance{}, false},
+	}
+	for _, tt := range tests {
+		s := d.Components.Schemas[tt.schema]
+		if !assert.NotNil(t, s, tt.schema) {
+			continue
+		}
+		names, required := jsonFields(reflect.TypeOf(tt.model))
+		assert.Equal(t, names, schemaFields(s), tt.schema)
+		if tt.request {
+			documented := append([]string(nil), s.Required...)
+			sort.Strings(documented)
+			assert.Equal(t, required, documented, tt.schema)
+		}
+	}
+}
+
+func TestReferencesResolve(t *testing.T) {
+	d := load(t)
+	var refs []string
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if ref, ok := v["$ref"].(string); ok {
+				refs = append(refs, ref)
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	var raw interface{}
+	assert.NoError(t, json.Unmarshal(spec, &raw))
+	walk(raw)
+	assert.NotEmpty(t, refs)
+	for _, ref := range refs {
+		switch {
+		case strings.HasPrefix(ref, "#/components/schemas/"):
+			assert.NotNil(t, d.Components.Schemas[strings.TrimPrefix(ref, "#/components/schemas/")], ref)
+		case strings.HasPrefix(ref, "#/components/responses/"):
+			assert.NotNil(t, d.Components.Responses[strings.TrimPrefix(ref, "#/components/responses/")], ref)
+		default:
+			t.Errorf("Unsupported reference %s", ref)
+		}
+	}
+}
+
+func router(d *Document) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(d.Validate())
+	ok := func(c *gin.Context) {
+		// The handler still sees the whole body
+		var req map[string]interface{}
+		_ = c.ShouldBindJSON(&req)
+		c.JSON(http.StatusOK, req)
+	}
+	r.POST("/api/v1/wallet", ok)
+	r.GET("/api/v1/wallets/:walletId", ok)
+	r.POST("/api/v1/undocumented", ok)
+	return r
+}
+
+func do(r http.Handler, method, url, body string, header map[string]string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func violations(t *testing.T, w *httptest.ResponseRecorder) []Violation {
+	var resp struct {
+		Code       string      `json:"code"`
+		Violations []Violation `json:"violations"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	assert.Equal(t, "INVALID_REQUEST", resp.Code)
+	return resp.Violations
+}
+
+func TestValidate_Body(t *testing.T) {
+	r := router(load(t))
+	walletId := uuid.NewString()
+
+	w := do(r, "POST", "/api/v1/wallet", `{"walletId":"`+walletId+`","operationType":"DEPOSIT","amount":"10.50","currency":"USD"}`, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), walletId)
+
+	w = do(r, "POST", "/api/v1/wallet", `{"walletId":"`+walletId+`","operationType":"DEPOSIT","amount":10.5}`, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = do(r, "POST", "/api/v1/wallet", `{"walletId":"nope","operationType":"TRANSFER_IN","amount":"-1","currency":"usd"}`, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, []Violation{
+		{In: "body", Field: "amount", Message: "does not match exactly one of the allowed forms"},
+		{In: "body", Field: "currency", Message: "must match ^[A-Z]{3}$"},
+		{In: "body", Field: "operationType", Message: "must be one of [DEPOSIT WITHDRAW]"},
+		{In: "body", Field: "walletId", Message: "must be a UUID"},
+	}, violations(t, w))
+
+	w = do(r, "POST", "/api/v1/wallet", `{"operationType":"DEPOSIT"}`, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, []Violation{
+		{In: "body", Field: "walletId", Message: "is required"},
+		{In: "body", Field: "amount", Message: "is required"},
+	}, violations(t, w))
+
+	w = do(r, "POST", "/api/v1/wallet", `{"walletId":`, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, []Violation{{In: "body", Message: "is not valid JSON"}}, violations(t, w))
+
+	w = do(r, "POST", "/api/v1/wallet", ``, nil)
+	assert.Equal(t, []Violation{{In: "body", Message: "is required"}}, violations(t, w))
+}
+
+func TestValidate_Parameters(t *testing.T) {
+	r := router(load(t))
+
+	w := do(r, "GET", "/api/v1/wallets/"+uuid.NewString()+"?currency=eur&asOf=2024-01-01T00:00:00Z", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = do(r, "GET", "/api/v1/wallets/123?asOf=yesterday", "", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, []Violation{
+		{In: "path", Field: "walletId", Message: "must be a UUID"},
+		{In: "query", Field: "asOf", Message: "must be an RFC 3339 timestamp"},
+	}, violations(t, w))
+
+	w = do(r, "POST", "/api/v1/wallet", `{"walletId":"`+uuid.NewString()+`","operationType":"DEPOSIT","amount":"1"}`,
+		map[string]string{"Idempotency-Key": strings.Repeat("k", 256)})
+	assert.Equal(t, []Violation{{In: "header", Field: "Idempotency-Key", Message: "must be at most 255 characters long"}}, violations(t, w))
+}
+
+func TestValidate_UndocumentedPassesThrough(t *testing.T) {
+	r := router(load(t))
+	w := do(r, "POST", "/api/v1/undocumented", `{"anything":1}`, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestServe(t *testing.T) {
+	d := load(t)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/openapi.json", d.Serve)
+	w := do(r, "GET", "/openapi.json", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, spec, w.Body.Bytes())
+}
+
+// The handlers' responses are checked against the document, a field added to or
+// removed from a response without updating openapi.json fails here
+func TestHandlerResponsesMatchDocument(t *testing.T) {
+	d := load(t)
+	gin.SetMode(gin.TestMode)
+	c := &cache.BalanceCache{}
+	walletId := uuid.New()
+	c.Set(models.DefaultTenant, walletId, []models.Balance{
+		models.NewBalance("USD", decimal.NewFromInt(100), decimal.NewFromInt(10), decimal.Zero),
+		models.NewBalance("EUR", decimal.NewFromInt(5), decimal.Zero, decimal.NewFromInt(50)),
+	}, time.Minute)
+	h := api.NewHandler(c, &queue.QueueManager{Cache: c}, nil)
+	r := gin.New()
+	r.POST("/api/v1/wallet", h.HandleWalletOperation)
+	r.GET("/api/v1/wallets/:walletId", h.HandleGetBalance)
+	r.POST("/api/v2/wallet", h.HandleWalletOperationV2)
+	r.GET("/api/v2/wallets/:walletId", h.HandleGetBalanceV2)
+
+	tests := []struct {
+		method, route, url, body string
+		status                   int
+	}{
+		{"GET", "/api/v1/wallets/:walletId", "/api/v1/wallets/" + walletId.String(), "", http.StatusOK},
+		{"GET", "/api/v1/wallets/:walletId", "/api/v1/wallets/" + walletId.String() + "?currency=EUR", "", http.StatusOK},
+		{"GET", "/api/v1/wallets/:walletId", "/api/v1/wallets/" + walletId.String() + "?currency=GBP", "", http.StatusNotFound},
+		{"POST", "/api/v1/wallet", "/api/v1/wallet", `{"walletId":"` + walletId.String() + `","operationType":"DEPOSIT","amount":"0"}`, http.StatusBadRequest},
+		{"GET", "/api/v2/wallets/:walletId", "/api/v2/wallets/" + walletId.String() + "?currency=EUR", "", http.StatusOK},
+		{"GET", "/api/v2/wallets/:walletId", "/api/v2/wallets/" + walletId.String() + "?currency=GBP", "", http.StatusNotFound},
+		{"POST", "/api/v2/wallet", "/api/v2/wallet", `{"walletId":"` + walletId.String() + `","operationType":"DEPOSIT","amountMinor":100}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := do(r, tt.method, tt.url, tt.body, nil)
+		assert.Equal(t, tt.status, w.Code, tt.url)
+		assert.Empty(t, d.validateResponse(tt.method, tt.route, w.Code, w.Body.Bytes()), tt.url)
+	}
+
+	assert.NotEmpty(t, d.validateResponse("GET", "/api/v1/wallets/:walletId", http.StatusOK,
+		[]byte(`{"walletId":"`+walletId.String()+`","balance":"1","currency":"USD","unexpected":true}`)))
+	w := do(router(d), "POST", "/api/v1/wallet", `{}`, nil)
+	assert.Equal(t, problem.ContentType, w.Header().Get("Content-Type"))
+	assert.Empty(t, d.validateResponse("POST", "/api/v1/wallet", w.Code, w.Body.Bytes()))
+}
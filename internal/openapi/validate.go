@@ -0,0 +1,271 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Schema is the subset of the OpenAPI schema object the document uses
+type Schema struct {
+	Ref              string             `json:"$ref"`
+	Type             string             `json:"type"`
+	Format           string             `json:"format"`
+	Pattern          string             `json:"pattern"`
+	Enum             []interface{}      `json:"enum"`
+	MinLength        *int               `json:"minLength"`
+	MaxLength        *int               `json:"maxLength"`
+	Minimum          *float64           `json:"minimum"`
+	ExclusiveMinimum bool               `json:"exclusiveMinimum"`
+	Properties       map[string]*Schema `json:"properties"`
+	Required         []string           `json:"required"`
+	// Only the boolean form of additionalProperties is supported
+	AdditionalProperties *bool     `json:"additionalProperties"`
+	Items                *Schema   `json:"items"`
+	OneOf                []*Schema `json:"oneOf"`
+}
+
+// Violation is one way a request does not match the document
+type Violation struct {
+	In      string `json:"in"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+var patterns sync.Map
+
+func compiled(pattern string) *regexp.Regexp {
+	if re, ok := patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+	re := regexp.MustCompile(pattern)
+	patterns.Store(pattern, re)
+	return re
+}
+
+func (d *Document) validateRequest(c *gin.Context, op *Operation) []Violation {
+	var violations []Violation
+	for _, p := range op.Parameters {
+		var value string
+		var present bool
+		switch p.In {
+		case "path":
+			value = c.Param(p.Name)
+			present = value != ""
+		case "query":
+			value, present = c.GetQuery(p.Name)
+		case "header":
+			value = c.GetHeader(p.Name)
+			present = value != ""
+		}
+		if !present {
+			if p.Required {
+				violations = append(violations, Violation{In: p.In, Field: p.Name, Message: "is required"})
+			}
+			continue
+		}
+		violations = append(violations, d.validate(p.Schema, parameterValue(p.Schema, value), p.In, p.Name)...)
+	}
+
+	if op.RequestBody == nil {
+		return violations
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return append(violations, Violation{In: "body", Message: "could not be read"})
+	}
+	// The handler reads the body again
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	if len(bytes.TrimSpace(body)) == 0 {
+		if op.RequestBody.Required {
+			violations = append(violations, Violation{In: "body", Message: "is required"})
+		}
+		return violations
+	}
+	media, ok := op.RequestBody.Content["application/json"]
+	if !ok {
+		return violations
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return append(violations, Violation{In: "body", Message: "is not valid JSON"})
+	}
+	return append(violations, d.validate(media.Schema, value, "body", "")...)
+}
+
+// parameterValue converts a parameter to the JSON value its schema describes
+func parameterValue(s *Schema, value string) interface{} {
+	if s != nil && (s.Type == "integer" || s.Type == "number") {
+		return json.Number(value)
+	}
+	return value
+}
+
+func (d *Document) resolve(s *Schema) *Schema {
+	for s != nil && s.Ref != "" {
+		s = d.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	return s
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// validate checks a value decoded with json.Decoder.UseNumber against s
+func (d *Document) validate(s *Schema, value interface{}, in, field string) []Violation {
+	s = d.resolve(s)
+	if s == nil {
+		return nil
+	}
+	fail := func(format string, args ...interface{}) []Violation {
+		return []Violation{{In: in, Field: field, Message: fmt.Sprintf(format, args...)}}
+	}
+
+	if len(s.OneOf) > 0 {
+		matched := 0
+		for _, alt := range s.OneOf {
+			if len(d.validate(alt, value, in, field)) == 0 {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return fail("does not match exactly one of the allowed forms")
+		}
+		return nil
+	}
+
+	switch s.Type {
+	case "string":
+		v, ok := value.(string)
+		if !ok {
+			return fail("must be a string")
+		}
+		if s.MinLength != nil && len(v) < *s.MinLength {
+			return fail("must be at least %d characters long", *s.MinLength)
+		}
+		if s.MaxLength != nil && len(v) > *s.MaxLength {
+			return fail("must be at most %d characters long", *s.MaxLength)
+		}
+		if s.Pattern != "" && !compiled(s.Pattern).MatchString(v) {
+			return fail("must match %s", s.Pattern)
+		}
+		switch s.Format {
+		case "uuid":
+			if !uuidPattern.MatchString(v) {
+				return fail("must be a UUID")
+			}
+		case "date-time":
+			if _, err := time.Parse(time.RFC3339Nano, v); err != nil {
+				return fail("must be an RFC 3339 timestamp")
+			}
+		}
+	case "number", "integer":
+		v, ok := value.(json.Number)
+		if !ok {
+			return fail("must be a number")
+		}
+		if s.Type == "integer" {
+			if _, err := v.Int64(); err != nil {
+				return fail("must be an integer")
+			}
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return fail("must be a number")
+		}
+		if s.Minimum != nil && (f < *s.Minimum || s.ExclusiveMinimum && f == *s.Minimum) {
+			if s.ExclusiveMinimum {
+				return fail("must be greater than %v", *s.Minimum)
+			}
+			return fail("must be at least %v", *s.Minimum)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fail("must be a boolean")
+		}
+	case "array":
+		v, ok := value.([]interface{})
+		if !ok {
+			return fail("must be an array")
+		}
+		var violations []Violation
+		for i, item := range v {
+			violations = append(violations, d.validate(s.Items, item, in, fmt.Sprintf("%s[%d]", field, i))...)
+		}
+		return violations
+	case "object":
+		v, ok := value.(map[string]interface{})
+		if !ok {
+			return fail("must be an object")
+		}
+		var violations []Violation
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				violations = append(violations, Violation{In: in, Field: join(field, name), Message: "is required"})
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		// Sorted to report the violations in a stable order
+		sort.Strings(names)
+		for _, name := range names {
+			prop := v[name]
+			propSchema, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					violations = append(violations, Violation{In: in, Field: join(field, name), Message: "is not allowed"})
+				}
+				continue
+			}
+			violations = append(violations, d.validate(propSchema, prop, in, join(field, name))...)
+		}
+		return violations
+	}
+
+	if len(s.Enum) > 0 {
+		for _, allowed := range s.Enum {
+			if fmt.Sprint(allowed) == fmt.Sprint(value) {
+				return nil
+			}
+		}
+		return fail("must be one of %v", s.Enum)
+	}
+	return nil
+}
+
+// validateResponse checks a JSON response body against the documented response of its status
+func (d *Document) validateResponse(method, route string, status int, body []byte) []Violation {
+	op := d.operation(method, route)
+	if op == nil {
+		return []Violation{{In: "body", Message: "operation is not documented"}}
+	}
+	schema := d.response(op, status)
+	if schema == nil {
+		return []Violation{{In: "body", Message: fmt.Sprintf("status %d is not documented", status)}}
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return []Violation{{In: "body", Message: "is not valid JSON"}}
+	}
+	return d.validate(schema, value, "body", "")
+}
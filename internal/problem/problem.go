@@ -0,0 +1,58 @@
+package problem
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContentType is the media type of RFC 7807 problem details
+const ContentType = "application/problem+json"
+
+// Codes that are not tied to a domain error
+const (
+	CodeInvalidRequest   = "INVALID_REQUEST"
+	CodeInternal         = "INTERNAL_ERROR"
+	CodeUnavailable      = "UNAVAILABLE"
+	CodeUnauthorized     = "UNAUTHORIZED"
+	CodeForbidden        = "FORBIDDEN"
+	CodeInvalidSignature = "INVALID_SIGNATURE"
+	CodePayloadTooLarge  = "PAYLOAD_TOO_LARGE"
+)
+
+// Type is the problem type URI of a code
+func Type(code string) string {
+	return "urn:wallet-api:problem:" + strings.ToLower(strings.ReplaceAll(code, "_", "-"))
+}
+
+// Title is the summary of a code, the same for every occurrence
+func Title(code string) string {
+	title := strings.ToLower(strings.ReplaceAll(code, "_", " "))
+	return strings.ToUpper(title[:1]) + title[1:]
+}
+
+// Write responds with problem details. code is the stable machine-readable
+// identifier of the problem and detail explains this occurrence. error repeats
+// detail for clients that predate problem details. ext adds extension members
+func Write(c *gin.Context, status int, code, detail string, ext gin.H) {
+	body := gin.H{
+		"type":     Type(code),
+		"title":    Title(code),
+		"status":   status,
+		"detail":   detail,
+		"instance": c.Request.URL.Path,
+		"code":     code,
+		"error":    detail,
+	}
+	for k, v := range ext {
+		body[k] = v
+	}
+	c.Header("Content-Type", ContentType)
+	c.JSON(status, body)
+}
+
+// Abort writes the problem and stops the remaining handlers
+func Abort(c *gin.Context, status int, code, detail string, ext gin.H) {
+	Write(c, status, code, detail, ext)
+	c.Abort()
+}
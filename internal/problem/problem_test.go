@@ -0,0 +1,37 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrite(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/api/v1/wallets/:walletId", func(c *gin.Context) {
+		Write(c, http.StatusNotFound, "WALLET_NOT_FOUND", "Wallet not found", gin.H{"walletId": c.Param("walletId")})
+	})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/wallets/abc", nil)
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, ContentType, w.Header().Get("Content-Type"))
+	var body map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, map[string]interface{}{
+		"type":     "urn:wallet-api:problem:wallet-not-found",
+		"title":    "Wallet not found",
+		"status":   float64(http.StatusNotFound),
+		"detail":   "Wallet not found",
+		"instance": "/api/v1/wallets/abc",
+		"code":     "WALLET_NOT_FOUND",
+		"error":    "Wallet not found",
+		"walletId": "abc",
+	}, body)
+}
@@ -0,0 +1,114 @@
+package queue
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/models"
+)
+
+// batchConcurrency bounds how many wallets a best-effort batch works on at once
+const batchConcurrency = 32
+
+// BatchBestEffort runs every operation through its wallet queue on its own and
+// returns one result per operation. Operations on the same wallet keep their order
+func (qm *QueueManager) BatchBestEffort(reqs []models.WalletOperationRequest) []OpResult {
+	results := make([]OpResult, len(reqs))
+	byWallet := make(map[uuid.UUID][]int)
+	for i, req := range reqs {
+		walletId, err := uuid.Parse(req.WalletId)
+		if err != nil {
+			results[i] = OpResult{Err: err, Msg: "Invalid walletId format"}
+			continue
+		}
+		byWallet[walletId] = append(byWallet[walletId], i)
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, batchConcurrency)
+	for walletId, indexes := range byWallet {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(walletId uuid.UUID, indexes []int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			for _, i := range indexes {
+				results[i] = qm.Enqueue(walletId, reqs[i])
+			}
+		}(walletId, indexes)
+	}
+	wg.Wait()
+	return results
+}
+
+// BatchResult is the outcome of an atomic batch. When Err is set nothing was
+// applied and FailedIndex points at the operation that failed (-1 when the
+// failure was not caused by a single operation)
+type BatchResult struct {
+	Results     []OpResult
+	FailedIndex int
+	Err         error
+	Msg         string
+}
+
+// BatchAtomic applies all operations in a single DB transaction while the
+// queues of all involved wallets are held
+func (qm *QueueManager) BatchAtomic(reqs []models.WalletOperationRequest) BatchResult {
+	walletIds := make([]uuid.UUID, len(reqs))
+	for i, req := range reqs {
+		walletId, err := uuid.Parse(req.WalletId)
+		if err != nil {
+			return BatchResult{FailedIndex: i, Err: err, Msg: "Invalid walletId format"}
+		}
+		walletIds[i] = walletId
+	}
+
+	var res BatchResult
+	qm.runExclusive(walletIds, func() {
+		res = processBatch(qm.DB, walletIds, reqs)
+		if res.Err == nil {
+			for _, walletId := range walletIds {
+				qm.changed(walletId)
+			}
+		}
+	})
+	return res
+}
+
+// processBatch relies on the caller holding all wallet queues, so the row locks
+// taken in request order cannot conflict with any other wallet transaction
+func processBatch(dbProvider db.DBProvider, walletIds []uuid.UUID, reqs []models.WalletOperationRequest) BatchResult {
+	tx, err := dbProvider.Begin(context.Background())
+	if err != nil {
+		return BatchResult{FailedIndex: -1, Err: err, Msg: "Transaction error"}
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			if rollbackErr := tx.Rollback(context.Background()); rollbackErr != nil {
+				log.Printf("Failed to rollback transaction: %v", rollbackErr)
+			}
+		}
+	}()
+
+	results := make([]OpResult, len(reqs))
+	for i, req := range reqs {
+		results[i] = applyWalletOperation(tx, walletIds[i], req)
+		if results[i].Err != nil {
+			return BatchResult{Results: results[:i+1], FailedIndex: i, Err: results[i].Err, Msg: results[i].Msg}
+		}
+	}
+
+	err = tx.Commit(context.Background())
+	if err != nil {
+		return BatchResult{FailedIndex: -1, Err: err, Msg: "Transaction commit error"}
+	}
+
+	committed = true
+	return BatchResult{Results: results, FailedIndex: -1}
+}
@@ -0,0 +1,89 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"wallet-api-server/internal/cache"
+	"wallet-api-server/internal/models"
+)
+
+func TestProcessBatch_RollsBackOnFailure(t *testing.T) {
+	mdb := new(mockDBProvider)
+	mtx := new(mockTxProvider)
+	mdb.On("Begin", mock.Anything).Return(mtx, nil)
+	noLimits(mtx)
+	mtx.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(walletRow(10, 0))
+	mtx.On("Exec", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+	mtx.On("Rollback", mock.Anything).Return(nil)
+
+	a, b := uuid.New(), uuid.New()
+	reqs := []models.WalletOperationRequest{
+		{WalletId: a.String(), OperationType: models.DEPOSIT, Amount: decimal.NewFromInt(5)},
+		{WalletId: b.String(), OperationType: models.WITHDRAW, Amount: decimal.NewFromInt(50)},
+		{WalletId: a.String(), OperationType: models.DEPOSIT, Amount: decimal.NewFromInt(5)},
+	}
+	res := processBatch(mdb, []uuid.UUID{a, b, a}, reqs)
+	assert.Error(t, res.Err)
+	assert.Equal(t, 1, res.FailedIndex)
+	assert.ErrorIs(t, res.Err, ErrInsufficientFunds)
+	assert.Equal(t, "Insufficient funds", res.Msg)
+	assert.Len(t, res.Results, 2)
+	mtx.AssertNotCalled(t, "Commit", mock.Anything)
+	mtx.AssertCalled(t, "Rollback", mock.Anything)
+}
+
+func TestQueueManager_BatchAtomic(t *testing.T) {
+	mdb := new(mockDBProvider)
+	mtx := new(mockTxProvider)
+	mdb.On("Begin", mock.Anything).Return(mtx, nil)
+	noLimits(mtx)
+	mtx.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(walletRow(10, 0))
+	mtx.On("Exec", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+	mtx.On("Commit", mock.Anything).Return(nil)
+
+	c := &cache.BalanceCache{}
+	a, b := uuid.New(), uuid.New()
+	c.Set(models.DefaultTenant, a, []models.Balance{{Currency: "USD", Balance: decimal.NewFromInt(10)}}, time.Minute)
+	qm := NewQueueManager(c, mdb)
+	res := qm.BatchAtomic([]models.WalletOperationRequest{
+		{WalletId: a.String(), OperationType: models.WITHDRAW, Amount: decimal.NewFromInt(5)},
+		{WalletId: b.String(), OperationType: models.DEPOSIT, Amount: decimal.NewFromInt(5)},
+	})
+	assert.NoError(t, res.Err)
+	assert.Equal(t, -1, res.FailedIndex)
+	assert.Len(t, res.Results, 2)
+	mtx.AssertNumberOfCalls(t, "Commit", 1)
+	_, cached := c.Get(models.DefaultTenant, a)
+	assert.False(t, cached)
+}
+
+func TestQueueManager_BatchBestEffort(t *testing.T) {
+	mdb := new(mockDBProvider)
+	mtx := new(mockTxProvider)
+	mdb.On("Begin", mock.Anything).Return(mtx, nil)
+	noLimits(mtx)
+	mtx.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(walletRow(10, 0))
+	mtx.On("Exec", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+	mtx.On("Commit", mock.Anything).Return(nil)
+	mtx.On("Rollback", mock.Anything).Return(nil)
+
+	qm := NewQueueManager(&cache.BalanceCache{}, mdb)
+	a, b := uuid.New(), uuid.New()
+	results := qm.BatchBestEffort([]models.WalletOperationRequest{
+		{WalletId: a.String(), OperationType: models.WITHDRAW, Amount: decimal.NewFromInt(50)},
+		{WalletId: b.String(), OperationType: models.DEPOSIT, Amount: decimal.NewFromInt(5)},
+		{WalletId: "not-a-uuid", OperationType: models.DEPOSIT, Amount: decimal.NewFromInt(5)},
+	})
+	assert.Len(t, results, 3)
+	assert.ErrorIs(t, results[0].Err, ErrInsufficientFunds)
+	assert.Equal(t, "Insufficient funds", results[0].Msg)
+	assert.NoError(t, results[1].Err)
+	assert.True(t, decimal.NewFromInt(15).Equal(results[1].Balance))
+	assert.Error(t, results[2].Err)
+}
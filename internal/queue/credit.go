@@ -0,0 +1,129 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/models"
+)
+
+var ErrInvalidCreditLimit = errors.New("invalid credit limit")
+
+type CreditLimitResult struct {
+	Balance models.Balance
+	Change  models.CreditLimitChange
+	Err     error
+	Msg     string
+}
+
+// SetCreditLimit adjusts the credit limit and records the change. Lowering it
+// below what is already drawn is allowed and only blocks further spending
+func (qm *QueueManager) SetCreditLimit(walletId uuid.UUID, req models.CreditLimitRequest) CreditLimitResult {
+	var res CreditLimitResult
+	qm.runExclusive([]uuid.UUID{walletId}, func() {
+		res = processCreditLimit(qm.DB, walletId, req)
+		if res.Err == nil {
+			qm.changed(walletId)
+		}
+	})
+	return res
+}
+
+// ListCreditLimitChanges returns the credit limit changes of a wallet of the tenant, newest first
+func ListCreditLimitChanges(ctx context.Context, q db.Querier, tenantId string, walletId uuid.UUID) ([]models.CreditLimitChange, error) {
+	rows, err := q.Query(ctx, `
+		SELECT wallet_id, currency, old_limit, new_limit, reason, created_at FROM credit_limit_changes
+		WHERE wallet_id=$1 AND tenant_id=$2
+		ORDER BY id DESC`, walletId, tenantId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	changes := []models.CreditLimitChange{}
+	for rows.Next() {
+		var ch models.CreditLimitChange
+		if err := rows.Scan(&ch.WalletId, &ch.Currency, &ch.OldLimit, &ch.NewLimit, &ch.Reason, &ch.CreatedAt); err != nil {
+			return nil, err
+		}
+		changes = append(changes, ch)
+	}
+	return changes, rows.Err()
+}
+
+func processCreditLimit(dbProvider db.DBProvider, walletId uuid.UUID, req models.CreditLimitRequest) CreditLimitResult {
+	if req.CreditLimit == nil {
+		return CreditLimitResult{Err: ErrInvalidCreditLimit, Msg: "Credit limit is required"}
+	}
+	if req.CreditLimit.IsNegative() {
+		return CreditLimitResult{Err: ErrInvalidCreditLimit, Msg: "Credit limit must not be negative"}
+	}
+
+	tx, err := dbProvider.Begin(context.Background())
+	if err != nil {
+		return CreditLimitResult{Err: err, Msg: "Transaction error"}
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			if rollbackErr := tx.Rollback(context.Background()); rollbackErr != nil {
+				log.Printf("Failed to rollback transaction: %v", rollbackErr)
+			}
+		}
+	}()
+
+	query := "SELECT currency, balance, held, credit_limit, status FROM wallets WHERE wallet_id=$1 AND tenant_id=$2"
+	args := []interface{}{walletId, req.TenantId}
+	if req.Currency != "" {
+		query += " AND currency=$3"
+		args = append(args, req.Currency)
+	}
+	query += " ORDER BY created_at, currency LIMIT 1 FOR UPDATE"
+
+	var currency string
+	var balance, held, oldLimit decimal.Decimal
+	var status models.WalletStatus
+	err = tx.QueryRow(context.Background(), query, args...).Scan(&currency, &balance, &held, &oldLimit, &status)
+	if err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return CreditLimitResult{Err: ErrWalletNotFound, Msg: "Wallet not found"}
+		}
+		return CreditLimitResult{Err: err, Msg: "Failed to read wallet"}
+	}
+	if status == models.WALLET_CLOSED {
+		return CreditLimitResult{Err: ErrWalletClosed, Msg: "Wallet is closed"}
+	}
+
+	change := models.CreditLimitChange{
+		WalletId:  walletId,
+		Currency:  currency,
+		OldLimit:  oldLimit,
+		NewLimit:  *req.CreditLimit,
+		Reason:    req.Reason,
+		CreatedAt: time.Now().UTC(),
+	}
+	_, err = tx.Exec(context.Background(), "UPDATE wallets SET credit_limit=$1 WHERE wallet_id=$2 AND currency=$3", change.NewLimit, walletId, currency)
+	if err != nil {
+		return CreditLimitResult{Err: err, Msg: "Failed to update credit limit"}
+	}
+	_, err = tx.Exec(context.Background(),
+		"INSERT INTO credit_limit_changes (wallet_id, currency, old_limit, new_limit, reason, created_at, tenant_id) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		change.WalletId, change.Currency, change.OldLimit, change.NewLimit, change.Reason, change.CreatedAt, req.TenantId)
+	if err != nil {
+		return CreditLimitResult{Err: err, Msg: "Failed to record credit limit change"}
+	}
+
+	err = tx.Commit(context.Background())
+	if err != nil {
+		return CreditLimitResult{Err: err, Msg: "Transaction commit error"}
+	}
+
+	committed = true
+	return CreditLimitResult{Balance: models.NewBalance(currency, balance, held, change.NewLimit), Change: change}
+}
@@ -0,0 +1,55 @@
+package queue
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/models"
+)
+
+func TestProcessCreditLimit_RecordsChange(t *testing.T) {
+	mdb := new(mockDBProvider)
+	mtx := new(mockTxProvider)
+	mrow := new(mockRowScanner)
+	mrow.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		dest := args.Get(0).([]interface{})
+		*dest[0].(*string) = "USD"
+		*dest[1].(*decimal.Decimal) = decimal.NewFromInt(-10)
+		*dest[2].(*decimal.Decimal) = decimal.Zero
+		*dest[3].(*decimal.Decimal) = decimal.NewFromInt(20)
+		*dest[4].(*models.WalletStatus) = models.WALLET_ACTIVE
+	})
+	mdb.On("Begin", mock.Anything).Return(mtx, nil)
+	mtx.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(mrow)
+	mtx.On("Exec", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+	mtx.On("Commit", mock.Anything).Return(nil)
+
+	limit := decimal.NewFromInt(100)
+	res := processCreditLimit(mdb, uuid.New(), models.CreditLimitRequest{CreditLimit: &limit, Reason: "annual review"})
+	assert.NoError(t, res.Err)
+	assert.True(t, decimal.NewFromInt(20).Equal(res.Change.OldLimit))
+	assert.True(t, decimal.NewFromInt(90).Equal(res.Balance.Available))
+	assert.True(t, decimal.NewFromInt(90).Equal(res.Balance.AvailableCredit))
+	mtx.AssertCalled(t, "Exec", mock.Anything, isQuery("INSERT INTO credit_limit_changes"), mock.Anything)
+}
+
+func TestProcessCreditLimit_UnknownWallet(t *testing.T) {
+	mdb := new(mockDBProvider)
+	mtx := new(mockTxProvider)
+	missing := new(mockRowScanner)
+	missing.On("Scan", mock.Anything).Return(db.ErrNoRows)
+	mdb.On("Begin", mock.Anything).Return(mtx, nil)
+	mtx.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(missing)
+	mtx.On("Rollback", mock.Anything).Return(nil)
+
+	limit := decimal.NewFromInt(100)
+	res := processCreditLimit(mdb, uuid.New(), models.CreditLimitRequest{CreditLimit: &limit})
+	assert.True(t, errors.Is(res.Err, ErrWalletNotFound))
+	mtx.AssertNotCalled(t, "Exec", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -0,0 +1,302 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/spf13/viper"
+
+	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/ledger"
+	"wallet-api-server/internal/models"
+)
+
+var (
+	ErrHoldNotFound       = errors.New("hold not found")
+	ErrHoldNotActive      = errors.New("hold is not active")
+	ErrHoldExpired        = errors.New("hold expired")
+	ErrCaptureExceedsHold = errors.New("capture amount exceeds the held amount")
+)
+
+func holdDefaultTTL() time.Duration {
+	viper.AutomaticEnv()
+	viper.SetDefault("HOLD_DEFAULT_TTL", 7*24*60*60)
+	ttl := viper.GetInt("HOLD_DEFAULT_TTL")
+	return time.Duration(ttl) * time.Second
+}
+
+const holdColumns = "hold_id, wallet_id, currency, amount, remaining, status, expires_at, created_at"
+
+func scanHold(row db.RowScanner, h *models.Hold) error {
+	return row.Scan(&h.HoldId, &h.WalletId, &h.Currency, &h.Amount, &h.Remaining, &h.Status, &h.ExpiresAt, &h.CreatedAt)
+}
+
+// LoadHold reads a hold of the tenant without locking it
+func LoadHold(ctx context.Context, dbProvider db.DBProvider, tenantId string, holdId uuid.UUID) (models.Hold, error) {
+	var h models.Hold
+	err := scanHold(dbProvider.QueryRow(ctx, "SELECT "+holdColumns+" FROM holds WHERE hold_id=$1 AND tenant_id=$2", holdId, tenantId), &h)
+	if err != nil && errors.Is(err, db.ErrNoRows) {
+		return h, ErrHoldNotFound
+	}
+	return h, err
+}
+
+// CreateHold reserves funds, reducing the available balance but not the balance itself
+func (qm *QueueManager) CreateHold(req models.HoldRequest) OpResult {
+	walletId, err := uuid.Parse(req.WalletId)
+	if err != nil {
+		return OpResult{Err: err, Msg: "Invalid walletId format"}
+	}
+	return qm.submit(walletId, func() OpResult {
+		return processCreateHold(qm.DB, walletId, req)
+	})
+}
+
+// CaptureHold withdraws the requested amount (or everything that is still held when it is omitted) from the hold
+func (qm *QueueManager) CaptureHold(holdId uuid.UUID, req models.CaptureRequest) OpResult {
+	return qm.settleHold(holdId, req, models.HOLD_CAPTURED)
+}
+
+// ReleaseHold gives the remaining held amount back to the available balance
+func (qm *QueueManager) ReleaseHold(tenantId string, holdId uuid.UUID) OpResult {
+	return qm.settleHold(holdId, models.CaptureRequest{TenantId: tenantId}, models.HOLD_RELEASED)
+}
+
+func (qm *QueueManager) settleHold(holdId uuid.UUID, req models.CaptureRequest, status models.HoldStatus) OpResult {
+	// The wallet of a hold never changes, so it is safe to route by it before locking
+	h, err := LoadHold(context.Background(), qm.DB, req.TenantId, holdId)
+	if err != nil {
+		if errors.Is(err, ErrHoldNotFound) {
+			return OpResult{Err: err, Msg: "Hold not found"}
+		}
+		return OpResult{Err: err, Msg: "Failed to read hold"}
+	}
+	return qm.submit(h.WalletId, func() OpResult {
+		return processSettleHold(qm.DB, holdId, req, status)
+	})
+}
+
+// StartHoldExpiry periodically expires holds whose TTL has passed until ctx is cancelled
+func (qm *QueueManager) StartHoldExpiry(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				qm.expireHolds(ctx)
+			}
+		}
+	}()
+}
+
+func (qm *QueueManager) expireHolds(ctx context.Context) {
+	rows, err := qm.DB.Query(ctx,
+		"SELECT hold_id, wallet_id, tenant_id FROM holds WHERE status=$1 AND expires_at <= $2 ORDER BY expires_at LIMIT 500",
+		models.HOLD_ACTIVE, time.Now())
+	if err != nil {
+		log.Printf("Failed to read expired holds: %v", err)
+		return
+	}
+	type expiredHold struct {
+		walletId uuid.UUID
+		tenantId string
+	}
+	expired := map[uuid.UUID]expiredHold{}
+	for rows.Next() {
+		var holdId uuid.UUID
+		var h expiredHold
+		if err := rows.Scan(&holdId, &h.walletId, &h.tenantId); err != nil {
+			log.Printf("Failed to read expired hold: %v", err)
+			break
+		}
+		expired[holdId] = h
+	}
+	rows.Close()
+
+	for holdId, h := range expired {
+		holdId, tenantId := holdId, h.tenantId
+		res := qm.submit(h.walletId, func() OpResult {
+			return processSettleHold(qm.DB, holdId, models.CaptureRequest{TenantId: tenantId}, models.HOLD_EXPIRED)
+		})
+		if res.Err != nil && !errors.Is(res.Err, ErrHoldNotActive) {
+			log.Printf("Failed to expire hold %s: %v", holdId, res.Err)
+		}
+	}
+}
+
+func processCreateHold(dbProvider db.DBProvider, walletId uuid.UUID, req models.HoldRequest) OpResult {
+	tx, err := dbProvider.Begin(context.Background())
+	if err != nil {
+		return OpResult{Err: err, Msg: "Transaction error"}
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			if rollbackErr := tx.Rollback(context.Background()); rollbackErr != nil {
+				log.Printf("Failed to rollback transaction: %v", rollbackErr)
+			}
+		}
+	}()
+
+	wallet, msg, err := lockWallet(tx, req.TenantId, walletId, req.Currency)
+	if err != nil {
+		return OpResult{Currency: wallet.Currency, Err: err, Msg: msg}
+	}
+	if wallet.Available.LessThan(req.Amount) {
+		return OpResult{Balance: wallet.Balance, Available: wallet.Available, Currency: wallet.Currency, Err: ErrInsufficientFunds, Msg: "Insufficient funds"}
+	}
+
+	ttl := holdDefaultTTL()
+	if req.TTLSeconds > 0 {
+		ttl = time.Duration(req.TTLSeconds) * time.Second
+	}
+	now := time.Now().UTC()
+	h := models.Hold{
+		HoldId:    uuid.New(),
+		WalletId:  walletId,
+		Currency:  wallet.Currency,
+		Amount:    req.Amount,
+		Remaining: req.Amount,
+		Status:    models.HOLD_ACTIVE,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+	_, err = tx.Exec(context.Background(),
+		"INSERT INTO holds ("+holdColumns+", updated_at, tenant_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8, $9)",
+		h.HoldId, h.WalletId, h.Currency, h.Amount, h.Remaining, h.Status, h.ExpiresAt, h.CreatedAt, req.TenantId)
+	if err != nil {
+		return OpResult{Err: err, Msg: "Failed to create hold"}
+	}
+	_, err = tx.Exec(context.Background(), "UPDATE wallets SET held=held+$1 WHERE wallet_id=$2 AND currency=$3", h.Amount, walletId, h.Currency)
+	if err != nil {
+		return OpResult{Err: err, Msg: "Failed to update balance"}
+	}
+
+	err = tx.Commit(context.Background())
+	if err != nil {
+		return OpResult{Err: err, Msg: "Transaction commit error"}
+	}
+
+	committed = true
+	return OpResult{Balance: wallet.Balance, Available: wallet.Available.Sub(h.Amount), Currency: h.Currency, Hold: &h}
+}
+
+// processSettleHold moves a hold towards the given status: a capture withdraws
+// the captured part, a release or an expiry only frees the remaining amount
+func processSettleHold(dbProvider db.DBProvider, holdId uuid.UUID, req models.CaptureRequest, status models.HoldStatus) OpResult {
+	tx, err := dbProvider.Begin(context.Background())
+	if err != nil {
+		return OpResult{Err: err, Msg: "Transaction error"}
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			if rollbackErr := tx.Rollback(context.Background()); rollbackErr != nil {
+				log.Printf("Failed to rollback transaction: %v", rollbackErr)
+			}
+		}
+	}()
+
+	var h models.Hold
+	err = scanHold(tx.QueryRow(context.Background(), "SELECT "+holdColumns+" FROM holds WHERE hold_id=$1 AND tenant_id=$2 FOR UPDATE", holdId, req.TenantId), &h)
+	if err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return OpResult{Err: ErrHoldNotFound, Msg: "Hold not found"}
+		}
+		return OpResult{Err: err, Msg: "Failed to read hold"}
+	}
+	if h.Status != models.HOLD_ACTIVE {
+		return OpResult{Hold: &h, Err: ErrHoldNotActive, Msg: fmt.Sprintf("Hold is %s", h.Status)}
+	}
+	expired := !time.Now().Before(h.ExpiresAt)
+	if status == models.HOLD_CAPTURED && expired {
+		return OpResult{Hold: &h, Err: ErrHoldExpired, Msg: "Hold expired"}
+	}
+	if status == models.HOLD_EXPIRED && !expired {
+		return OpResult{Hold: &h, Err: ErrHoldNotActive, Msg: "Hold has not expired yet"}
+	}
+
+	// Releasing funds stays possible while the wallet is frozen, capturing them does not
+	wallet, walletStatus, msg, err := lockWalletRow(tx, req.TenantId, h.WalletId, h.Currency)
+	if err == nil && status == models.HOLD_CAPTURED {
+		msg, err = checkWalletStatus(walletStatus)
+	}
+	if err != nil {
+		return OpResult{Hold: &h, Err: err, Msg: msg}
+	}
+
+	release := h.Remaining
+	captured := decimal.Zero
+	balance := wallet.Balance
+	var entry *models.Transaction
+	if status == models.HOLD_CAPTURED {
+		captured = h.Remaining
+		if req.Amount != nil {
+			captured = *req.Amount
+		}
+		if !captured.IsPositive() {
+			return OpResult{Hold: &h, Err: ErrInvalidAmount, Msg: "Amount must be positive"}
+		}
+		if captured.GreaterThan(h.Remaining) {
+			return OpResult{Hold: &h, Err: ErrCaptureExceedsHold, Msg: "Capture amount exceeds the held amount"}
+		}
+		if msg, err := checkLimits(tx, req.TenantId, h.WalletId, h.Currency, models.CAPTURE, captured); err != nil {
+			return OpResult{Hold: &h, Err: err, Msg: msg}
+		}
+		release = captured
+		balance = balance.Sub(captured)
+		h.Remaining = h.Remaining.Sub(captured)
+		// A partial capture keeps the rest reserved for further captures
+		if h.Remaining.IsPositive() {
+			status = models.HOLD_ACTIVE
+		}
+		entry = &models.Transaction{
+			OperationId:   uuid.New(),
+			WalletId:      h.WalletId,
+			OperationType: models.CAPTURE,
+			Currency:      h.Currency,
+			Amount:        captured,
+			BalanceAfter:  balance,
+			HoldId:        &h.HoldId,
+			ApiKeyId:      req.ApiKeyId,
+			CreatedAt:     time.Now().UTC(),
+		}
+	} else {
+		h.Remaining = decimal.Zero
+	}
+	h.Status = status
+
+	_, err = tx.Exec(context.Background(), "UPDATE wallets SET balance=$1, held=held-$2 WHERE wallet_id=$3 AND currency=$4", balance, release, h.WalletId, h.Currency)
+	if err != nil {
+		return OpResult{Err: err, Msg: "Failed to update balance"}
+	}
+	_, err = tx.Exec(context.Background(), "UPDATE holds SET remaining=$1, status=$2, updated_at=now() WHERE hold_id=$3", h.Remaining, h.Status, h.HoldId)
+	if err != nil {
+		return OpResult{Err: err, Msg: "Failed to update hold"}
+	}
+	res := OpResult{Balance: balance, Available: wallet.Available.Add(release).Sub(captured), Currency: h.Currency, Hold: &h}
+	if entry != nil {
+		if err = ledger.Record(context.Background(), tx, *entry); err != nil {
+			return OpResult{Err: err, Msg: "Failed to record transaction"}
+		}
+		res.OperationId = entry.OperationId
+	}
+
+	err = tx.Commit(context.Background())
+	if err != nil {
+		return OpResult{Err: err, Msg: "Transaction commit error"}
+	}
+
+	committed = true
+	return res
+}
@@ -0,0 +1,205 @@
+package queue
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"wallet-api-server/internal/models"
+)
+
+// walletRow mocks the lockWallet query with the given balance and held amount
+func walletRow(balance, held int64) *mockRowScanner {
+	mrow := new(mockRowScanner)
+	mrow.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		dest := args.Get(0).([]interface{})
+		*dest[0].(*decimal.Decimal) = decimal.NewFromInt(balance)
+		*dest[1].(*string) = "USD"
+		*dest[2].(*decimal.Decimal) = decimal.NewFromInt(held)
+		*dest[3].(*decimal.Decimal) = decimal.Zero
+		*dest[4].(*models.WalletStatus) = models.WALLET_ACTIVE
+	})
+	return mrow
+}
+
+func holdRow(h models.Hold) *mockRowScanner {
+	mrow := new(mockRowScanner)
+	mrow.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		dest := args.Get(0).([]interface{})
+		*dest[0].(*uuid.UUID) = h.HoldId
+		*dest[1].(*uuid.UUID) = h.WalletId
+		*dest[2].(*string) = h.Currency
+		*dest[3].(*decimal.Decimal) = h.Amount
+		*dest[4].(*decimal.Decimal) = h.Remaining
+		*dest[5].(*models.HoldStatus) = h.Status
+		*dest[6].(*time.Time) = h.ExpiresAt
+	})
+	return mrow
+}
+
+func isQuery(prefix string) interface{} {
+	return mock.MatchedBy(func(q string) bool { return strings.HasPrefix(q, prefix) })
+}
+
+func TestProcessWalletOperation_WithdrawChecksAvailable(t *testing.T) {
+	mdb := new(mockDBProvider)
+	mtx := new(mockTxProvider)
+	mdb.On("Begin", mock.Anything).Return(mtx, nil)
+	noLimits(mtx)
+	mtx.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(walletRow(10, 8))
+	mtx.On("Rollback", mock.Anything).Return(nil)
+
+	res := processWalletOperation(mdb, models.WalletOperationRequest{
+		WalletId:      uuid.New().String(),
+		OperationType: models.WITHDRAW,
+		Amount:        decimal.NewFromInt(5),
+	})
+	assert.Error(t, res.Err)
+	assert.ErrorIs(t, res.Err, ErrInsufficientFunds)
+	assert.Equal(t, "Insufficient funds", res.Msg)
+	assert.True(t, decimal.NewFromInt(2).Equal(res.Available))
+}
+
+func TestProcessCreateHold(t *testing.T) {
+	mdb := new(mockDBProvider)
+	mtx := new(mockTxProvider)
+	mdb.On("Begin", mock.Anything).Return(mtx, nil)
+	noLimits(mtx)
+	mtx.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(walletRow(10, 3))
+	mtx.On("Exec", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+	mtx.On("Commit", mock.Anything).Return(nil)
+
+	id := uuid.New()
+	res := processCreateHold(mdb, id, models.HoldRequest{WalletId: id.String(), Amount: decimal.NewFromInt(7), TTLSeconds: 60})
+	assert.NoError(t, res.Err)
+	assert.Equal(t, models.HOLD_ACTIVE, res.Hold.Status)
+	assert.True(t, decimal.NewFromInt(10).Equal(res.Balance))
+	assert.True(t, decimal.Zero.Equal(res.Available))
+	assert.WithinDuration(t, time.Now().Add(time.Minute), res.Hold.ExpiresAt, 5*time.Second)
+	mtx.AssertCalled(t, "Exec", mock.Anything, isQuery("UPDATE wallets SET held=held+$1"), mock.Anything)
+}
+
+func TestProcessCreateHold_InsufficientAvailable(t *testing.T) {
+	mdb := new(mockDBProvider)
+	mtx := new(mockTxProvider)
+	mdb.On("Begin", mock.Anything).Return(mtx, nil)
+	noLimits(mtx)
+	mtx.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(walletRow(10, 3))
+	mtx.On("Rollback", mock.Anything).Return(nil)
+
+	id := uuid.New()
+	res := processCreateHold(mdb, id, models.HoldRequest{WalletId: id.String(), Amount: decimal.NewFromInt(8)})
+	assert.Error(t, res.Err)
+	assert.ErrorIs(t, res.Err, ErrInsufficientFunds)
+	assert.Equal(t, "Insufficient funds", res.Msg)
+	mtx.AssertNotCalled(t, "Exec", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestProcessSettleHold_PartialCapture(t *testing.T) {
+	h := models.Hold{
+		HoldId:    uuid.New(),
+		WalletId:  uuid.New(),
+		Currency:  "USD",
+		Amount:    decimal.NewFromInt(6),
+		Remaining: decimal.NewFromInt(6),
+		Status:    models.HOLD_ACTIVE,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	mdb := new(mockDBProvider)
+	mtx := new(mockTxProvider)
+	mdb.On("Begin", mock.Anything).Return(mtx, nil)
+	noLimits(mtx)
+	mtx.On("QueryRow", mock.Anything, isQuery("SELECT hold_id"), mock.Anything).Return(holdRow(h))
+	mtx.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(walletRow(10, 6))
+	mtx.On("Exec", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+	mtx.On("Commit", mock.Anything).Return(nil)
+
+	amount := decimal.NewFromInt(4)
+	res := processSettleHold(mdb, h.HoldId, models.CaptureRequest{Amount: &amount}, models.HOLD_CAPTURED)
+	assert.NoError(t, res.Err)
+	assert.NotEqual(t, uuid.Nil, res.OperationId)
+	assert.Equal(t, models.HOLD_ACTIVE, res.Hold.Status)
+	assert.True(t, decimal.NewFromInt(2).Equal(res.Hold.Remaining))
+	assert.True(t, decimal.NewFromInt(6).Equal(res.Balance))
+	assert.True(t, decimal.NewFromInt(4).Equal(res.Available))
+	mtx.AssertCalled(t, "Exec", mock.Anything, isQuery("INSERT INTO wallet_transactions"), mock.Anything)
+}
+
+func TestProcessSettleHold_Rejections(t *testing.T) {
+	active := models.Hold{
+		HoldId:    uuid.New(),
+		WalletId:  uuid.New(),
+		Currency:  "USD",
+		Amount:    decimal.NewFromInt(6),
+		Remaining: decimal.NewFromInt(6),
+		Status:    models.HOLD_ACTIVE,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	released := active
+	released.Status = models.HOLD_RELEASED
+	expired := active
+	expired.ExpiresAt = time.Now().Add(-time.Second)
+	tooMuch := decimal.NewFromInt(7)
+
+	cases := []struct {
+		hold   models.Hold
+		amount *decimal.Decimal
+		status models.HoldStatus
+		err    error
+	}{
+		{released, nil, models.HOLD_CAPTURED, ErrHoldNotActive},
+		{expired, nil, models.HOLD_CAPTURED, ErrHoldExpired},
+		{active, nil, models.HOLD_EXPIRED, ErrHoldNotActive},
+		{active, &tooMuch, models.HOLD_CAPTURED, ErrCaptureExceedsHold},
+	}
+	for _, tc := range cases {
+		mdb := new(mockDBProvider)
+		mtx := new(mockTxProvider)
+		mdb.On("Begin", mock.Anything).Return(mtx, nil)
+		noLimits(mtx)
+		mtx.On("QueryRow", mock.Anything, isQuery("SELECT hold_id"), mock.Anything).Return(holdRow(tc.hold))
+		mtx.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(walletRow(10, 6))
+		mtx.On("Rollback", mock.Anything).Return(nil)
+
+		res := processSettleHold(mdb, tc.hold.HoldId, models.CaptureRequest{Amount: tc.amount}, tc.status)
+		assert.True(t, errors.Is(res.Err, tc.err), "%v", res.Err)
+		mtx.AssertNotCalled(t, "Exec", mock.Anything, mock.Anything, mock.Anything)
+	}
+}
+
+func TestProcessWalletOperation_WithdrawUsesCreditLimit(t *testing.T) {
+	mdb := new(mockDBProvider)
+	mtx := new(mockTxProvider)
+	mrow := new(mockRowScanner)
+	mrow.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		dest := args.Get(0).([]interface{})
+		*dest[0].(*decimal.Decimal) = decimal.NewFromInt(10)
+		*dest[1].(*string) = "USD"
+		*dest[2].(*decimal.Decimal) = decimal.Zero
+		*dest[3].(*decimal.Decimal) = decimal.NewFromInt(20)
+		*dest[4].(*models.WalletStatus) = models.WALLET_ACTIVE
+	})
+	mdb.On("Begin", mock.Anything).Return(mtx, nil)
+	noLimits(mtx)
+	mtx.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(mrow)
+	mtx.On("Exec", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+	mtx.On("Commit", mock.Anything).Return(nil)
+	mtx.On("Rollback", mock.Anything).Return(nil)
+
+	req := models.WalletOperationRequest{WalletId: uuid.New().String(), OperationType: models.WITHDRAW, Amount: decimal.NewFromInt(25)}
+	res := processWalletOperation(mdb, req)
+	assert.NoError(t, res.Err)
+	assert.True(t, decimal.NewFromInt(-15).Equal(res.Balance))
+	assert.True(t, decimal.NewFromInt(5).Equal(res.Available))
+
+	req.Amount = decimal.NewFromInt(31)
+	res = processWalletOperation(mdb, req)
+	assert.ErrorIs(t, res.Err, ErrInsufficientFunds)
+	assert.Equal(t, "Insufficient funds", res.Msg)
+}
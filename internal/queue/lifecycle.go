@@ -0,0 +1,398 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/ledger"
+	"wallet-api-server/internal/models"
+)
+
+var (
+	ErrWalletNotFound      = errors.New("wallet not found")
+	ErrWalletExists        = errors.New("wallet already exists")
+	ErrWalletFrozen        = errors.New("wallet is frozen")
+	ErrWalletClosed        = errors.New("wallet is closed")
+	ErrInvalidStatusChange = errors.New("invalid wallet status change")
+	ErrWalletNotEmpty      = errors.New("wallet is not empty")
+)
+
+type WalletResult struct {
+	Wallet models.WalletState
+	// Sweeps are the outgoing transfer legs that emptied a closed wallet
+	Sweeps []models.Transaction
+	Err    error
+	Msg    string
+}
+
+func checkWalletStatus(status models.WalletStatus) (string, error) {
+	switch status {
+	case models.WALLET_FROZEN:
+		return "Wallet is frozen", ErrWalletFrozen
+	case models.WALLET_CLOSED:
+		return "Wallet is closed", ErrWalletClosed
+	}
+	return "", nil
+}
+
+// CheckTenant returns ErrWalletNotFound when the wallet belongs to another tenant.
+// A wallet that does not exist passes, callers decide what a missing wallet means
+func CheckTenant(ctx context.Context, q db.Querier, tenantId string, walletId uuid.UUID) error {
+	var owner string
+	err := q.QueryRow(ctx, "SELECT tenant_id FROM wallets WHERE wallet_id=$1 LIMIT 1", walletId).Scan(&owner)
+	switch {
+	case errors.Is(err, db.ErrNoRows):
+		return nil
+	case err != nil:
+		return err
+	case owner != tenantId:
+		return ErrWalletNotFound
+	}
+	return nil
+}
+
+// LoadBalances reads all currency balances of a wallet of the tenant, primary currency first
+func LoadBalances(ctx context.Context, q db.Querier, tenantId string, walletId uuid.UUID) ([]models.Balance, error) {
+	rows, err := q.Query(ctx, "SELECT currency, balance, held, credit_limit, version, updated_at FROM wallets WHERE wallet_id=$1 AND tenant_id=$2 ORDER BY created_at, currency", walletId, tenantId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	balances := []models.Balance{}
+	for rows.Next() {
+		var currency string
+		var balance, held, creditLimit decimal.Decimal
+		var version int64
+		var updatedAt time.Time
+		if err := rows.Scan(&currency, &balance, &held, &creditLimit, &version, &updatedAt); err != nil {
+			return nil, err
+		}
+		b := models.NewBalance(currency, balance, held, creditLimit)
+		b.Version, b.UpdatedAt = version, updatedAt
+		balances = append(balances, b)
+	}
+	return balances, rows.Err()
+}
+
+// CreateWallet creates an empty wallet in the requested (or the default) currency
+func (qm *QueueManager) CreateWallet(req models.CreateWalletRequest) WalletResult {
+	walletId := uuid.New()
+	if req.WalletId != "" {
+		var err error
+		if walletId, err = uuid.Parse(req.WalletId); err != nil {
+			return WalletResult{Err: err, Msg: "Invalid walletId format"}
+		}
+	}
+	currency := req.Currency
+	if currency == "" {
+		currency = models.DefaultCurrency()
+	}
+
+	var res WalletResult
+	qm.runExclusive([]uuid.UUID{walletId}, func() {
+		res = processCreateWallet(qm.DB, req.TenantId, walletId, currency, req.Owner)
+	})
+	return res
+}
+
+// SetOwner hands the wallet to owner, an empty owner leaves the wallet without one
+func (qm *QueueManager) SetOwner(tenantId string, walletId uuid.UUID, owner string) WalletResult {
+	var res WalletResult
+	qm.runExclusive([]uuid.UUID{walletId}, func() {
+		res = processSetOwner(qm.DB, tenantId, walletId, owner)
+	})
+	return res
+}
+
+// ListWallets reads the wallets of an owner in the tenant with all their balances
+func ListWallets(ctx context.Context, q db.Querier, tenantId, owner string) ([]models.WalletState, error) {
+	rows, err := q.Query(ctx,
+		"SELECT wallet_id, status, currency, balance, held, credit_limit, version, updated_at FROM wallets WHERE owner=$1 AND tenant_id=$2 ORDER BY wallet_id, created_at, currency", owner, tenantId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	wallets := []models.WalletState{}
+	for rows.Next() {
+		var walletId uuid.UUID
+		var status models.WalletStatus
+		var currency string
+		var balance, held, creditLimit decimal.Decimal
+		var version int64
+		var updatedAt time.Time
+		if err := rows.Scan(&walletId, &status, &currency, &balance, &held, &creditLimit, &version, &updatedAt); err != nil {
+			return nil, err
+		}
+		if n := len(wallets); n == 0 || wallets[n-1].WalletId != walletId {
+			wallets = append(wallets, models.WalletState{WalletId: walletId, Owner: owner, Status: status, Balances: []models.Balance{}})
+		}
+		b := models.NewBalance(currency, balance, held, creditLimit)
+		b.Version, b.UpdatedAt = version, updatedAt
+		w := &wallets[len(wallets)-1]
+		w.Balances = append(w.Balances, b)
+	}
+	return wallets, rows.Err()
+}
+
+// nullableOwner stores wallets without an owner as NULL
+func nullableOwner(owner string) interface{} {
+	if owner == "" {
+		return nil
+	}
+	return owner
+}
+
+func (qm *QueueManager) FreezeWallet(tenantId string, walletId uuid.UUID) WalletResult {
+	return qm.changeWalletStatus(tenantId, walletId, models.WALLET_ACTIVE, models.WALLET_FROZEN)
+}
+
+func (qm *QueueManager) UnfreezeWallet(tenantId string, walletId uuid.UUID) WalletResult {
+	return qm.changeWalletStatus(tenantId, walletId, models.WALLET_FROZEN, models.WALLET_ACTIVE)
+}
+
+func (qm *QueueManager) changeWalletStatus(tenantId string, walletId uuid.UUID, from, to models.WalletStatus) WalletResult {
+	var res WalletResult
+	qm.runExclusive([]uuid.UUID{walletId}, func() {
+		res = processWalletStatus(qm.DB, tenantId, walletId, from, to)
+	})
+	return res
+}
+
+// CloseWallet closes a wallet for good. Remaining funds are moved to sweepTo,
+// without a sweep destination every balance of the wallet must be zero
+func (qm *QueueManager) CloseWallet(tenantId string, walletId uuid.UUID, sweepTo *uuid.UUID) WalletResult {
+	walletIds := []uuid.UUID{walletId}
+	if sweepTo != nil {
+		if *sweepTo == walletId {
+			return WalletResult{Err: ErrSameWallet, Msg: "Cannot sweep a wallet into itself"}
+		}
+		walletIds = append(walletIds, *sweepTo)
+	}
+
+	var res WalletResult
+	qm.runExclusive(walletIds, func() {
+		res = processCloseWallet(qm.DB, tenantId, walletId, sweepTo)
+		if res.Err == nil {
+			for _, id := range walletIds {
+				qm.changed(id)
+			}
+		}
+	})
+	return res
+}
+
+func processCreateWallet(dbProvider db.DBProvider, tenantId string, walletId uuid.UUID, currency, owner string) WalletResult {
+	tx, err := dbProvider.Begin(context.Background())
+	if err != nil {
+		return WalletResult{Err: err, Msg: "Transaction error"}
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			if rollbackErr := tx.Rollback(context.Background()); rollbackErr != nil {
+				log.Printf("Failed to rollback transaction: %v", rollbackErr)
+			}
+		}
+	}()
+
+	// Wallet ids are unique across tenants, only the status of the tenant's own wallet is reported
+	var status models.WalletStatus
+	var existing string
+	err = tx.QueryRow(context.Background(), "SELECT status, tenant_id FROM wallets WHERE wallet_id=$1 LIMIT 1", walletId).Scan(&status, &existing)
+	if err == nil && existing != tenantId {
+		return WalletResult{Err: ErrWalletExists, Msg: "Wallet already exists"}
+	}
+	if err == nil {
+		return WalletResult{Wallet: models.WalletState{WalletId: walletId, Status: status}, Err: ErrWalletExists, Msg: "Wallet already exists"}
+	}
+	if !errors.Is(err, db.ErrNoRows) {
+		return WalletResult{Err: err, Msg: "Failed to read wallet"}
+	}
+
+	_, err = tx.Exec(context.Background(), "INSERT INTO wallets (wallet_id, currency, balance, status, owner, tenant_id) VALUES ($1, $2, $3, $4, $5, $6)",
+		walletId, currency, decimal.Zero, models.WALLET_ACTIVE, nullableOwner(owner), tenantId)
+	if err != nil {
+		return WalletResult{Err: err, Msg: "Failed to create wallet"}
+	}
+
+	err = tx.Commit(context.Background())
+	if err != nil {
+		return WalletResult{Err: err, Msg: "Transaction commit error"}
+	}
+
+	committed = true
+	return WalletResult{Wallet: models.WalletState{
+		WalletId: walletId,
+		Owner:    owner,
+		Status:   models.WALLET_ACTIVE,
+		Balances: []models.Balance{models.NewBalance(currency, decimal.Zero, decimal.Zero, decimal.Zero)},
+	}}
+}
+
+func processSetOwner(dbProvider db.DBProvider, tenantId string, walletId uuid.UUID, owner string) WalletResult {
+	var status models.WalletStatus
+	err := dbProvider.QueryRow(context.Background(), "UPDATE wallets SET owner=$1 WHERE wallet_id=$2 AND tenant_id=$3 RETURNING status", nullableOwner(owner), walletId, tenantId).Scan(&status)
+	if err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return WalletResult{Err: ErrWalletNotFound, Msg: "Wallet not found"}
+		}
+		return WalletResult{Err: err, Msg: "Failed to update owner"}
+	}
+	balances, err := LoadBalances(context.Background(), dbProvider, tenantId, walletId)
+	if err != nil {
+		return WalletResult{Err: err, Msg: "Failed to read balance"}
+	}
+	return WalletResult{Wallet: models.WalletState{WalletId: walletId, Owner: owner, Status: status, Balances: balances}}
+}
+
+func processWalletStatus(dbProvider db.DBProvider, tenantId string, walletId uuid.UUID, from, to models.WalletStatus) WalletResult {
+	tx, err := dbProvider.Begin(context.Background())
+	if err != nil {
+		return WalletResult{Err: err, Msg: "Transaction error"}
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			if rollbackErr := tx.Rollback(context.Background()); rollbackErr != nil {
+				log.Printf("Failed to rollback transaction: %v", rollbackErr)
+			}
+		}
+	}()
+
+	var status models.WalletStatus
+	err = tx.QueryRow(context.Background(), "SELECT status FROM wallets WHERE wallet_id=$1 AND tenant_id=$2 ORDER BY created_at, currency LIMIT 1 FOR UPDATE", walletId, tenantId).Scan(&status)
+	if err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return WalletResult{Err: ErrWalletNotFound, Msg: "Wallet not found"}
+		}
+		return WalletResult{Err: err, Msg: "Failed to read wallet"}
+	}
+	state := models.WalletState{WalletId: walletId, Status: status}
+	if status == models.WALLET_CLOSED {
+		return WalletResult{Wallet: state, Err: ErrWalletClosed, Msg: "Wallet is closed"}
+	}
+	if status != from {
+		return WalletResult{Wallet: state, Err: ErrInvalidStatusChange, Msg: fmt.Sprintf("Wallet is %s", status)}
+	}
+
+	_, err = tx.Exec(context.Background(), "UPDATE wallets SET status=$1 WHERE wallet_id=$2", to, walletId)
+	if err != nil {
+		return WalletResult{Err: err, Msg: "Failed to update wallet"}
+	}
+	state.Status = to
+	if state.Balances, err = LoadBalances(context.Background(), tx, tenantId, walletId); err != nil {
+		return WalletResult{Err: err, Msg: "Failed to read balance"}
+	}
+
+	err = tx.Commit(context.Background())
+	if err != nil {
+		return WalletResult{Err: err, Msg: "Transaction commit error"}
+	}
+
+	committed = true
+	return WalletResult{Wallet: state}
+}
+
+// processCloseWallet relies on the caller holding the queues of both wallets
+func processCloseWallet(dbProvider db.DBProvider, tenantId string, walletId uuid.UUID, sweepTo *uuid.UUID) WalletResult {
+	tx, err := dbProvider.Begin(context.Background())
+	if err != nil {
+		return WalletResult{Err: err, Msg: "Transaction error"}
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			if rollbackErr := tx.Rollback(context.Background()); rollbackErr != nil {
+				log.Printf("Failed to rollback transaction: %v", rollbackErr)
+			}
+		}
+	}()
+
+	rows, err := tx.Query(context.Background(), "SELECT currency, balance, held, credit_limit, status FROM wallets WHERE wallet_id=$1 AND tenant_id=$2 ORDER BY created_at, currency FOR UPDATE", walletId, tenantId)
+	if err != nil {
+		return WalletResult{Err: err, Msg: "Failed to read wallet"}
+	}
+	state := models.WalletState{WalletId: walletId, Balances: []models.Balance{}}
+	var held decimal.Decimal
+	for rows.Next() {
+		var currency string
+		var balance, h, creditLimit decimal.Decimal
+		if err = rows.Scan(&currency, &balance, &h, &creditLimit, &state.Status); err != nil {
+			break
+		}
+		held = held.Add(h)
+		state.Balances = append(state.Balances, models.NewBalance(currency, balance, h, creditLimit))
+	}
+	rows.Close()
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		return WalletResult{Err: err, Msg: "Failed to read wallet"}
+	}
+	if len(state.Balances) == 0 {
+		return WalletResult{Err: ErrWalletNotFound, Msg: "Wallet not found"}
+	}
+	if state.Status == models.WALLET_CLOSED {
+		return WalletResult{Wallet: state, Err: ErrWalletClosed, Msg: "Wallet is closed"}
+	}
+	if held.IsPositive() {
+		return WalletResult{Wallet: state, Err: ErrWalletNotEmpty, Msg: "Wallet has active holds"}
+	}
+
+	var sweeps []models.Transaction
+	now := time.Now().UTC()
+	for i, b := range state.Balances {
+		if b.Balance.IsZero() {
+			continue
+		}
+		if sweepTo == nil || b.Balance.IsNegative() {
+			return WalletResult{Wallet: state, Err: ErrWalletNotEmpty, Msg: fmt.Sprintf("Wallet still holds %s %s", b.Balance, b.Currency)}
+		}
+		dest, msg, err := lockWallet(tx, tenantId, *sweepTo, b.Currency)
+		if err != nil {
+			return WalletResult{Err: err, Msg: "Sweep destination: " + msg}
+		}
+
+		transferId := uuid.New()
+		legs := []models.Transaction{
+			{OperationId: uuid.New(), WalletId: walletId, OperationType: models.TRANSFER_OUT, Currency: b.Currency, Amount: b.Balance, BalanceAfter: decimal.Zero, TransferId: &transferId, CreatedAt: now},
+			{OperationId: uuid.New(), WalletId: *sweepTo, OperationType: models.TRANSFER_IN, Currency: b.Currency, Amount: b.Balance, BalanceAfter: dest.Balance.Add(b.Balance), TransferId: &transferId, CreatedAt: now},
+		}
+		for _, leg := range legs {
+			_, err = tx.Exec(context.Background(), "UPDATE wallets SET balance=$1 WHERE wallet_id=$2 AND currency=$3", leg.BalanceAfter, leg.WalletId, leg.Currency)
+			if err != nil {
+				return WalletResult{Err: err, Msg: "Failed to update balance"}
+			}
+			if err = ledger.Record(context.Background(), tx, leg); err != nil {
+				return WalletResult{Err: err, Msg: "Failed to record transaction"}
+			}
+		}
+		sweeps = append(sweeps, legs[0])
+		state.Balances[i] = models.NewBalance(b.Currency, decimal.Zero, decimal.Zero, b.CreditLimit)
+	}
+
+	_, err = tx.Exec(context.Background(), "UPDATE wallets SET status=$1 WHERE wallet_id=$2", models.WALLET_CLOSED, walletId)
+	if err != nil {
+		return WalletResult{Err: err, Msg: "Failed to update wallet"}
+	}
+	state.Status = models.WALLET_CLOSED
+
+	err = tx.Commit(context.Background())
+	if err != nil {
+		return WalletResult{Err: err, Msg: "Transaction commit error"}
+	}
+
+	committed = true
+	return WalletResult{Wallet: state, Sweeps: sweeps}
+}
@@ -0,0 +1,170 @@
+package queue
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/models"
+)
+
+type mockRows struct {
+	rows [][]interface{}
+	pos  int
+}
+
+func (m *mockRows) Next() bool {
+	m.pos++
+	return m.pos <= len(m.rows)
+}
+func (m *mockRows) Scan(dest ...interface{}) error {
+	for i, v := range m.rows[m.pos-1] {
+		reflect.ValueOf(dest[i]).Elem().Set(reflect.ValueOf(v))
+	}
+	return nil
+}
+func (m *mockRows) Err() error { return nil }
+func (m *mockRows) Close()     {}
+
+func statusRow(status models.WalletStatus) *mockRowScanner {
+	mrow := new(mockRowScanner)
+	mrow.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		for _, d := range args.Get(0).([]interface{}) {
+			if s, ok := d.(*models.WalletStatus); ok {
+				*s = status
+			}
+		}
+	})
+	return mrow
+}
+
+func TestLockWallet_RejectsFrozenAndClosed(t *testing.T) {
+	for status, want := range map[models.WalletStatus]error{
+		models.WALLET_FROZEN: ErrWalletFrozen,
+		models.WALLET_CLOSED: ErrWalletClosed,
+	} {
+		mtx := new(mockTxProvider)
+		mtx.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(statusRow(status))
+
+		_, _, err := lockWallet(mtx, models.DefaultTenant, uuid.New(), "")
+		assert.True(t, errors.Is(err, want), "%v", err)
+	}
+}
+
+func TestLockWallet_ImplicitCreationDisabled(t *testing.T) {
+	t.Setenv("WALLET_IMPLICIT_CREATION", "false")
+	mtx := new(mockTxProvider)
+	missing := new(mockRowScanner)
+	missing.On("Scan", mock.Anything).Return(db.ErrNoRows)
+	mtx.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(missing)
+
+	_, msg, err := lockWallet(mtx, models.DefaultTenant, uuid.New(), "")
+	assert.True(t, errors.Is(err, ErrWalletNotFound))
+	assert.Equal(t, "Wallet not found", msg)
+	mtx.AssertNotCalled(t, "Exec", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestProcessSettleHold_ReleaseOnFrozenWallet(t *testing.T) {
+	h := models.Hold{HoldId: uuid.New(), WalletId: uuid.New(), Currency: "USD", Amount: decimal.NewFromInt(6), Remaining: decimal.NewFromInt(6), Status: models.HOLD_ACTIVE, ExpiresAt: time.Now().Add(time.Hour)}
+	frozen := new(mockRowScanner)
+	frozen.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		dest := args.Get(0).([]interface{})
+		*dest[0].(*decimal.Decimal) = decimal.NewFromInt(10)
+		*dest[2].(*decimal.Decimal) = decimal.NewFromInt(6)
+		*dest[4].(*models.WalletStatus) = models.WALLET_FROZEN
+	})
+
+	for status, want := range map[models.HoldStatus]error{
+		models.HOLD_RELEASED: nil,
+		models.HOLD_CAPTURED: ErrWalletFrozen,
+	} {
+		mdb := new(mockDBProvider)
+		mtx := new(mockTxProvider)
+		mdb.On("Begin", mock.Anything).Return(mtx, nil)
+		mtx.On("QueryRow", mock.Anything, isQuery("SELECT hold_id"), mock.Anything).Return(holdRow(h))
+		mtx.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(frozen)
+		mtx.On("Exec", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+		mtx.On("Commit", mock.Anything).Return(nil)
+		mtx.On("Rollback", mock.Anything).Return(nil)
+
+		res := processSettleHold(mdb, h.HoldId, models.CaptureRequest{}, status)
+		assert.Equal(t, want, res.Err)
+	}
+}
+
+func TestProcessWalletStatus(t *testing.T) {
+	cases := []struct {
+		current  models.WalletStatus
+		from, to models.WalletStatus
+		err      error
+	}{
+		{models.WALLET_ACTIVE, models.WALLET_ACTIVE, models.WALLET_FROZEN, nil},
+		{models.WALLET_FROZEN, models.WALLET_FROZEN, models.WALLET_ACTIVE, nil},
+		{models.WALLET_FROZEN, models.WALLET_ACTIVE, models.WALLET_FROZEN, ErrInvalidStatusChange},
+		{models.WALLET_CLOSED, models.WALLET_FROZEN, models.WALLET_ACTIVE, ErrWalletClosed},
+	}
+	for _, tc := range cases {
+		mdb := new(mockDBProvider)
+		mtx := new(mockTxProvider)
+		mdb.On("Begin", mock.Anything).Return(mtx, nil)
+		mtx.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(statusRow(tc.current))
+		mtx.On("Exec", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+		mtx.On("Query", mock.Anything, mock.Anything, mock.Anything).Return(&mockRows{}, nil)
+		mtx.On("Commit", mock.Anything).Return(nil)
+		mtx.On("Rollback", mock.Anything).Return(nil)
+
+		res := processWalletStatus(mdb, models.DefaultTenant, uuid.New(), tc.from, tc.to)
+		assert.Equal(t, tc.err, res.Err)
+		if tc.err == nil {
+			assert.Equal(t, tc.to, res.Wallet.Status)
+			mtx.AssertCalled(t, "Exec", mock.Anything, isQuery("UPDATE wallets SET status=$1"), mock.Anything)
+		} else {
+			mtx.AssertNotCalled(t, "Exec", mock.Anything, mock.Anything, mock.Anything)
+		}
+	}
+}
+
+func TestProcessCloseWallet_RequiresZeroBalance(t *testing.T) {
+	mdb := new(mockDBProvider)
+	mtx := new(mockTxProvider)
+	mdb.On("Begin", mock.Anything).Return(mtx, nil)
+	mtx.On("Query", mock.Anything, mock.Anything, mock.Anything).Return(&mockRows{rows: [][]interface{}{
+		{"USD", decimal.NewFromInt(5), decimal.Zero, decimal.Zero, models.WALLET_ACTIVE},
+	}}, nil)
+	mtx.On("Rollback", mock.Anything).Return(nil)
+
+	res := processCloseWallet(mdb, models.DefaultTenant, uuid.New(), nil)
+	assert.True(t, errors.Is(res.Err, ErrWalletNotEmpty))
+	mtx.AssertNotCalled(t, "Exec", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestProcessCloseWallet_Sweep(t *testing.T) {
+	walletId, sweepTo := uuid.New(), uuid.New()
+	mdb := new(mockDBProvider)
+	mtx := new(mockTxProvider)
+	mdb.On("Begin", mock.Anything).Return(mtx, nil)
+	mtx.On("Query", mock.Anything, mock.Anything, mock.Anything).Return(&mockRows{rows: [][]interface{}{
+		{"USD", decimal.NewFromInt(5), decimal.Zero, decimal.Zero, models.WALLET_FROZEN},
+		{"EUR", decimal.Zero, decimal.Zero, decimal.Zero, models.WALLET_FROZEN},
+	}}, nil)
+	mtx.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(walletRow(3, 0))
+	mtx.On("Exec", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+	mtx.On("Commit", mock.Anything).Return(nil)
+
+	res := processCloseWallet(mdb, models.DefaultTenant, walletId, &sweepTo)
+	assert.NoError(t, res.Err)
+	assert.Equal(t, models.WALLET_CLOSED, res.Wallet.Status)
+	assert.Len(t, res.Sweeps, 1)
+	assert.True(t, decimal.NewFromInt(5).Equal(res.Sweeps[0].Amount))
+	assert.True(t, res.Wallet.Balances[0].Balance.IsZero())
+	// two balance updates, two ledger entries and the status change
+	mtx.AssertNumberOfCalls(t, "Exec", 5)
+	mtx.AssertCalled(t, "Exec", mock.Anything, isQuery("UPDATE wallets SET balance=$1"), []interface{}{decimal.NewFromInt(8), sweepTo, "USD"})
+}
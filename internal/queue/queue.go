@@ -1,28 +1,70 @@
 package queue
 
 import (
+	"bytes"
 	"context"
-	"fmt"
+	"errors"
 	"log"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
 
 	"wallet-api-server/internal/cache"
 	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/idempotency"
+	"wallet-api-server/internal/ledger"
+	"wallet-api-server/internal/limits"
 	"wallet-api-server/internal/models"
 )
 
+var (
+	// ErrCurrencyMismatch is returned for an operation in a currency the wallet does not hold
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+	// ErrInsufficientFunds is returned when the available balance does not cover a debit
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrInvalidAmount     = errors.New("invalid amount")
+	ErrSameWallet        = errors.New("same wallet")
+	// ErrDuplicateReference is returned for a reference the wallet already used, when references must be unique
+	ErrDuplicateReference = errors.New("duplicate reference")
+)
+
 type WalletOpTask struct {
 	Req  models.WalletOperationRequest
 	Resp chan OpResult
+	// run replaces the deposit/withdraw processing for other kinds of wallet operations
+	run  func() OpResult
+	lock *walletLock
+}
+
+// walletLock parks a wallet worker so that an operation spanning several
+// wallets can run while none of them processes anything else
+type walletLock struct {
+	acquired chan struct{}
+	release  chan struct{}
 }
 
 type OpResult struct {
-	Balance decimal.Decimal
+	OperationId uuid.UUID
+	Balance     decimal.Decimal
+	Available   decimal.Decimal
+	Currency    string
+	Hold        *models.Hold
+	// Entry is the ledger entry written by the operation, when only one was written
+	Entry *models.Transaction
+	// Version is the balance row's version after a deposit or withdrawal
+	Version int64
 	Err     error
 	Msg     string
+	// Replayed is set when the result was loaded for an already used idempotency key
+	Replayed bool
+}
+
+// Notifier is told about every wallet whose balance changed, Notify must not block
+type Notifier interface {
+	Notify(walletId uuid.UUID)
 }
 
 type QueueManager struct {
@@ -30,12 +72,22 @@ type QueueManager struct {
 	queueMutex sync.Mutex
 	Cache      *cache.BalanceCache
 	DB         db.DBProvider
+	// Events is optional
+	Events Notifier
 }
 
 func NewQueueManager(c *cache.BalanceCache, dbProvider db.DBProvider) *QueueManager {
 	return &QueueManager{Cache: c, DB: dbProvider}
 }
 
+// changed is called after an operation on the wallet was committed
+func (qm *QueueManager) changed(walletId uuid.UUID) {
+	qm.Cache.Invalidate(walletId)
+	if qm.Events != nil {
+		qm.Events.Notify(walletId)
+	}
+}
+
 func (qm *QueueManager) getOrCreateQueue(walletId uuid.UUID) chan *WalletOpTask {
 	ch, ok := qm.queueMap.Load(walletId)
 	if ok {
@@ -55,11 +107,21 @@ func (qm *QueueManager) getOrCreateQueue(walletId uuid.UUID) chan *WalletOpTask
 
 func (qm *QueueManager) walletWorker(walletId uuid.UUID, ch chan *WalletOpTask) {
 	for task := range ch {
-		balance, msg, err := processWalletOperation(qm.DB, task.Req)
-		if err == nil {
-			qm.Cache.Invalidate(walletId)
+		if task.lock != nil {
+			close(task.lock.acquired)
+			<-task.lock.release
+			continue
+		}
+		var res OpResult
+		if task.run != nil {
+			res = task.run()
+		} else {
+			res = processWalletOperation(qm.DB, task.Req)
+		}
+		if res.Err == nil && !res.Replayed {
+			qm.changed(walletId)
 		}
-		task.Resp <- OpResult{Balance: balance, Err: err, Msg: msg}
+		task.Resp <- res
 	}
 }
 
@@ -70,10 +132,150 @@ func (qm *QueueManager) Enqueue(walletId uuid.UUID, req models.WalletOperationRe
 	return <-respCh
 }
 
-func processWalletOperation(dbProvider db.DBProvider, req models.WalletOperationRequest) (decimal.Decimal, string, error) {
+// submit runs fn on the wallet's worker, serialized with all other operations on the wallet
+func (qm *QueueManager) submit(walletId uuid.UUID, fn func() OpResult) OpResult {
+	ch := qm.getOrCreateQueue(walletId)
+	respCh := make(chan OpResult)
+	ch <- &WalletOpTask{run: fn, Resp: respCh}
+	return <-respCh
+}
+
+// runExclusive parks the workers of all given wallets and runs fn while they are held.
+// Wallets are acquired in ascending id order, so two multi-wallet operations
+// over the same wallets always queue up behind each other instead of deadlocking
+func (qm *QueueManager) runExclusive(walletIds []uuid.UUID, fn func()) {
+	ids := sortWalletIds(walletIds)
+	release := make(chan struct{})
+	defer close(release)
+	for _, id := range ids {
+		lock := &walletLock{acquired: make(chan struct{}), release: release}
+		qm.getOrCreateQueue(id) <- &WalletOpTask{lock: lock}
+		<-lock.acquired
+	}
+	fn()
+}
+
+func sortWalletIds(walletIds []uuid.UUID) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(walletIds))
+	seen := make(map[uuid.UUID]bool, len(walletIds))
+	for _, id := range walletIds {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return bytes.Compare(ids[i][:], ids[j][:]) < 0
+	})
+	return ids
+}
+
+// lockWallet reads the balance in the given currency with a row lock, creating the
+// wallet (or, with multi-currency enabled, its balance in a new currency) on first use.
+// An empty currency selects the wallet's primary currency. Frozen and closed wallets are rejected,
+// a wallet of another tenant is ErrWalletNotFound
+func lockWallet(tx db.TxProvider, tenantId string, walletId uuid.UUID, currency string) (models.Balance, string, error) {
+	b, status, msg, err := lockWalletRow(tx, tenantId, walletId, currency)
+	if err != nil {
+		return b, msg, err
+	}
+	if msg, err = checkWalletStatus(status); err != nil {
+		return b, msg, err
+	}
+	return b, "", nil
+}
+
+// lockWalletRow is lockWallet without the status check, for the few operations
+// that are allowed on a frozen wallet
+func lockWalletRow(tx db.TxProvider, tenantId string, walletId uuid.UUID, currency string) (models.Balance, models.WalletStatus, string, error) {
+	query := "SELECT balance, currency, held, credit_limit, status, version FROM wallets WHERE wallet_id=$1 AND tenant_id=$2"
+	args := []interface{}{walletId, tenantId}
+	if currency != "" {
+		query += " AND currency=$3"
+		args = append(args, currency)
+	}
+	query += " ORDER BY created_at, currency LIMIT 1 FOR UPDATE"
+
+	var balance, held, creditLimit decimal.Decimal
+	var status models.WalletStatus
+	var version int64
+	err := tx.QueryRow(context.Background(), query, args...).Scan(&balance, &currency, &held, &creditLimit, &status, &version)
+	if err == nil {
+		b := models.NewBalance(currency, balance, held, creditLimit)
+		b.Version = version
+		return b, status, "", nil
+	}
+	if !errors.Is(err, db.ErrNoRows) {
+		return models.Balance{}, "", "Failed to read balance", err
+	}
+
+	// Either the wallet does not exist, it has no balance in the requested currency yet or it
+	// belongs to another tenant. The id of another tenant's wallet must not be taken over
+	var existing, owner string
+	err = tx.QueryRow(context.Background(), "SELECT currency, status, tenant_id FROM wallets WHERE wallet_id=$1 ORDER BY created_at, currency LIMIT 1", walletId).Scan(&existing, &status, &owner)
+	switch {
+	case err == nil && owner != tenantId:
+		return models.Balance{}, "", "Wallet not found", ErrWalletNotFound
+	case err == nil:
+		if msg, err := checkWalletStatus(status); err != nil {
+			return models.Balance{Currency: existing}, status, msg, err
+		}
+		if !models.MultiCurrencyEnabled() {
+			return models.Balance{Currency: existing}, status, "Currency mismatch", ErrCurrencyMismatch
+		}
+	case !errors.Is(err, db.ErrNoRows):
+		return models.Balance{}, "", "Failed to read wallet", err
+	case !models.ImplicitCreationEnabled():
+		return models.Balance{}, "", "Wallet not found", ErrWalletNotFound
+	case currency == "":
+		currency = models.DefaultCurrency()
+	}
+
+	b := models.NewBalance(currency, decimal.Zero, decimal.Zero, decimal.Zero)
+	// A new currency balance belongs to the owner of the wallet's other balances
+	_, err = tx.Exec(context.Background(), "INSERT INTO wallets (wallet_id, currency, balance, tenant_id, owner) VALUES ($1, $2, $3, $4, (SELECT owner FROM wallets WHERE wallet_id=$1 LIMIT 1))", walletId, b.Currency, b.Balance, tenantId)
+	if err != nil {
+		return models.Balance{}, "", "Failed to create wallet", err
+	}
+	return b, models.WALLET_ACTIVE, "", nil
+}
+
+// checkLimits evaluates the wallet's limits inside the operation's transaction
+func checkLimits(tx db.TxProvider, tenantId string, walletId uuid.UUID, currency string, opType models.OperationType, amount decimal.Decimal) (string, error) {
+	err := limits.Check(context.Background(), tx, tenantId, walletId, currency, opType, amount)
+	var limitErr *limits.Error
+	if errors.As(err, &limitErr) {
+		return limitErr.Error(), err
+	}
+	if err != nil {
+		return "Failed to check limits", err
+	}
+	return "", nil
+}
+
+// primaryCurrency returns the currency of the wallet's oldest balance,
+// or the default currency for a wallet that does not exist yet in the tenant
+func primaryCurrency(tx db.TxProvider, tenantId string, walletId uuid.UUID) (string, error) {
+	var currency string
+	err := tx.QueryRow(context.Background(), "SELECT currency FROM wallets WHERE wallet_id=$1 AND tenant_id=$2 ORDER BY created_at, currency LIMIT 1", walletId, tenantId).Scan(&currency)
+	if err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return models.DefaultCurrency(), nil
+		}
+		return "", err
+	}
+	return currency, nil
+}
+
+func processWalletOperation(dbProvider db.DBProvider, req models.WalletOperationRequest) OpResult {
+	walletId, err := uuid.Parse(req.WalletId)
+	if err != nil {
+		return OpResult{Err: err, Msg: "Invalid walletId format"}
+	}
+
 	tx, err := dbProvider.Begin(context.Background())
 	if err != nil {
-		return decimal.Zero, "Transaction error", err
+		return OpResult{Err: err, Msg: "Transaction error"}
 	}
 
 	committed := false
@@ -85,17 +287,51 @@ func processWalletOperation(dbProvider db.DBProvider, req models.WalletOperation
 		}
 	}()
 
-	var balance decimal.Decimal
-	err = tx.QueryRow(context.Background(), "SELECT balance FROM wallets WHERE wallet_id=$1 FOR UPDATE", req.WalletId).Scan(&balance)
+	res := applyWalletOperation(tx, walletId, req)
+	if res.Err != nil || res.Replayed {
+		return res
+	}
+
+	err = tx.Commit(context.Background())
 	if err != nil {
-		if err.Error() == "no rows in result set" {
-			balance = decimal.Zero
-			_, err = tx.Exec(context.Background(), "INSERT INTO wallets (wallet_id, balance) VALUES ($1, $2)", req.WalletId, balance)
-			if err != nil {
-				return decimal.Zero, "Failed to create wallet", err
-			}
-		} else {
-			return decimal.Zero, "Failed to read balance", err
+		return OpResult{Err: err, Msg: "Transaction commit error"}
+	}
+
+	committed = true
+	return res
+}
+
+// applyWalletOperation runs a deposit or withdrawal inside the caller's transaction
+func applyWalletOperation(tx db.TxProvider, walletId uuid.UUID, req models.WalletOperationRequest) OpResult {
+	var requestHash string
+	var err error
+	if req.IdempotencyKey != "" {
+		payload := req
+		payload.IdempotencyKey = ""
+		requestHash, err = idempotency.Hash(payload)
+		if err != nil {
+			return OpResult{Err: err, Msg: "Failed to hash request"}
+		}
+		if res, found := replayIdempotent(tx, req.TenantId, req.IdempotencyKey, requestHash); found {
+			return res
+		}
+	}
+
+	wallet, msg, err := lockWallet(tx, req.TenantId, walletId, req.Currency)
+	if err != nil {
+		return OpResult{Currency: wallet.Currency, Err: err, Msg: msg}
+	}
+	balance := wallet.Balance
+	if msg, err := checkLimits(tx, req.TenantId, walletId, wallet.Currency, req.OperationType, req.Amount); err != nil {
+		return OpResult{Balance: balance, Available: wallet.Available, Currency: wallet.Currency, Err: err, Msg: msg}
+	}
+	if req.Reference != "" && models.UniqueReferencesEnabled() {
+		used, err := ledger.ReferenceUsed(context.Background(), tx, req.TenantId, walletId, req.Reference)
+		if err != nil {
+			return OpResult{Err: err, Msg: "Failed to check reference"}
+		}
+		if used {
+			return OpResult{Balance: balance, Available: wallet.Available, Currency: wallet.Currency, Err: ErrDuplicateReference, Msg: "Reference already used for this wallet"}
 		}
 	}
 
@@ -103,22 +339,72 @@ func processWalletOperation(dbProvider db.DBProvider, req models.WalletOperation
 	case models.DEPOSIT:
 		balance = balance.Add(req.Amount)
 	case models.WITHDRAW:
-		if balance.LessThan(req.Amount) {
-			return balance, "Insufficient funds", fmt.Errorf("insufficient funds")
+		if wallet.Available.LessThan(req.Amount) {
+			return OpResult{Balance: balance, Available: wallet.Available, Currency: wallet.Currency, Err: ErrInsufficientFunds, Msg: "Insufficient funds"}
 		}
 		balance = balance.Sub(req.Amount)
 	}
 
-	_, err = tx.Exec(context.Background(), "UPDATE wallets SET balance=$1 WHERE wallet_id=$2", balance, req.WalletId)
+	_, err = tx.Exec(context.Background(), "UPDATE wallets SET balance=$1 WHERE wallet_id=$2 AND currency=$3", balance, walletId, wallet.Currency)
 	if err != nil {
-		return decimal.Zero, "Failed to update balance", err
+		return OpResult{Err: err, Msg: "Failed to update balance"}
 	}
 
-	err = tx.Commit(context.Background())
-	if err != nil {
-		return decimal.Zero, "Transaction commit error", err
+	entry := models.Transaction{
+		OperationId:      uuid.New(),
+		WalletId:         walletId,
+		OperationType:    req.OperationType,
+		Currency:         wallet.Currency,
+		Amount:           req.Amount,
+		BalanceAfter:     balance,
+		OperationDetails: req.OperationDetails,
+		ApiKeyId:         req.ApiKeyId,
+		CreatedAt:        time.Now().UTC(),
+	}
+	if err = ledger.Record(context.Background(), tx, entry); err != nil {
+		return OpResult{Err: err, Msg: "Failed to record transaction"}
 	}
 
-	committed = true
-	return balance, "", nil
+	// Deposits and withdrawals never touch the held amount
+	available := wallet.Available.Add(balance.Sub(wallet.Balance))
+	if req.IdempotencyKey != "" {
+		outcome := opOutcome{Available: available, Version: wallet.Version + 1}
+		if err = idempotency.Save(context.Background(), tx, req.TenantId, req.IdempotencyKey, requestHash, entry.OperationId, outcome); err != nil {
+			if errors.Is(err, idempotency.ErrKeyConflict) {
+				return OpResult{Err: err, Msg: "Idempotency key reused with a different payload"}
+			}
+			return OpResult{Err: err, Msg: "Failed to store idempotency key"}
+		}
+	}
+
+	return OpResult{OperationId: entry.OperationId, Balance: balance, Available: available, Currency: wallet.Currency, Entry: &entry, Version: wallet.Version + 1}
+}
+
+// opOutcome is saved with the idempotency key of a wallet operation, the
+// balances it answered with are not kept in the ledger
+type opOutcome struct {
+	Available decimal.Decimal `json:"available"`
+	Version   int64           `json:"version,omitempty"`
+}
+
+// replayIdempotent looks the key up and, when it was already used, returns
+// the result of the original operation (or the lookup error) instead of running it again
+func replayIdempotent(tx db.TxProvider, tenantId, key, requestHash string) (OpResult, bool) {
+	var outcome opOutcome
+	operationId, found, err := idempotency.Lookup(context.Background(), tx, tenantId, key, requestHash, &outcome)
+	if err != nil {
+		if errors.Is(err, idempotency.ErrKeyConflict) {
+			return OpResult{Err: err, Msg: "Idempotency key reused with a different payload"}, true
+		}
+		return OpResult{Err: err, Msg: "Failed to read idempotency key"}, true
+	}
+	if !found {
+		return OpResult{}, false
+	}
+	entry, err := ledger.Get(context.Background(), tx, tenantId, operationId)
+	if err != nil {
+		return OpResult{Err: err, Msg: "Failed to read transaction"}, true
+	}
+	return OpResult{OperationId: entry.OperationId, Balance: entry.BalanceAfter, Available: outcome.Available, Currency: entry.Currency,
+		Entry: &entry, Version: outcome.Version, Replayed: true}, true
 }
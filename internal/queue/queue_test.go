@@ -3,7 +3,9 @@ package queue
 import (
 	"context"
 	"errors"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
@@ -12,6 +14,7 @@ import (
 
 	"wallet-api-server/internal/cache"
 	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/idempotency"
 	"wallet-api-server/internal/models"
 )
 
@@ -28,6 +31,10 @@ func (m *mockDBProvider) QueryRow(ctx context.Context, query string, args ...int
 	argsM := m.Called(ctx, query, args)
 	return argsM.Get(0).(db.RowScanner)
 }
+func (m *mockDBProvider) Query(ctx context.Context, query string, args ...interface{}) (db.Rows, error) {
+	argsM := m.Called(ctx, query, args)
+	return argsM.Get(0).(db.Rows), argsM.Error(1)
+}
 func (m *mockDBProvider) Exec(ctx context.Context, query string, args ...interface{}) (interface{}, error) {
 	argsM := m.Called(ctx, query, args)
 	return argsM.Get(0), argsM.Error(1)
@@ -42,6 +49,10 @@ func (m *mockTxProvider) QueryRow(ctx context.Context, query string, args ...int
 	argsM := m.Called(ctx, query, args)
 	return argsM.Get(0).(db.RowScanner)
 }
+func (m *mockTxProvider) Query(ctx context.Context, query string, args ...interface{}) (db.Rows, error) {
+	argsM := m.Called(ctx, query, args)
+	return argsM.Get(0).(db.Rows), argsM.Error(1)
+}
 func (m *mockTxProvider) Exec(ctx context.Context, query string, args ...interface{}) (interface{}, error) {
 	argsM := m.Called(ctx, query, args)
 	return argsM.Get(0), argsM.Error(1)
@@ -61,6 +72,14 @@ func (m *mockRowScanner) Scan(dest ...interface{}) error {
 	return argsM.Error(0)
 }
 
+// noLimits makes the limits lookup find no per-wallet override, it has
+// to be registered before any catch-all QueryRow expectation
+func noLimits(mtx *mockTxProvider) {
+	mrow := new(mockRowScanner)
+	mrow.On("Scan", mock.Anything).Return(db.ErrNoRows)
+	mtx.On("QueryRow", mock.Anything, isQuery("SELECT max_operation_amount"), mock.Anything).Return(mrow)
+}
+
 func TestQueueManager_Enqueue_NewWallet(t *testing.T) {
 	c := &cache.BalanceCache{}
 	mdb := new(mockDBProvider)
@@ -75,24 +94,33 @@ func TestQueueManager_Enqueue_NewWallet(t *testing.T) {
 	}
 
 	mdb.On("Begin", mock.Anything).Return(mtx, nil)
+	noLimits(mtx)
 	mtx.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(mrow)
-	mrow.On("Scan", mock.Anything).Return(errors.New("no rows in result set"))
+	mrow.On("Scan", mock.Anything).Return(db.ErrNoRows)
 	mtx.On("Exec", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
 	mtx.On("Exec", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
 	mtx.On("Commit", mock.Anything).Return(nil)
 	mtx.On("Rollback", mock.Anything).Return(nil)
 
 	qm := NewQueueManager(c, mdb)
+	notified := &recordingNotifier{}
+	qm.Events = notified
 	res := qm.Enqueue(id, request)
 	assert.True(t, res.Balance.GreaterThanOrEqual(decimal.Zero))
+	assert.Equal(t, []uuid.UUID{id}, notified.walletIds)
 }
 
+type recordingNotifier struct{ walletIds []uuid.UUID }
+
+func (n *recordingNotifier) Notify(walletId uuid.UUID) { n.walletIds = append(n.walletIds, walletId) }
+
 func TestProcessWalletOperation_InsufficientFunds(t *testing.T) {
 	mdb := new(mockDBProvider)
 	mtx := new(mockTxProvider)
 	mrow := new(mockRowScanner)
 
 	mdb.On("Begin", mock.Anything).Return(mtx, nil)
+	noLimits(mtx)
 	mtx.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(mrow)
 	mrow.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
 		if dest, ok := args.Get(0).([]interface{}); ok {
@@ -113,10 +141,141 @@ func TestProcessWalletOperation_InsufficientFunds(t *testing.T) {
 		OperationType: models.WITHDRAW,
 		Amount:        decimal.NewFromInt(1000),
 	}
-	balance, msg, err := processWalletOperation(mdb, request)
-	assert.Error(t, err)
-	assert.Equal(t, "Insufficient funds", msg)
-	assert.True(t, balance.LessThan(decimal.NewFromInt(1000)))
+	res := processWalletOperation(mdb, request)
+	assert.Error(t, res.Err)
+	assert.ErrorIs(t, res.Err, ErrInsufficientFunds)
+	assert.Equal(t, "Insufficient funds", res.Msg)
+	assert.True(t, res.Balance.LessThan(decimal.NewFromInt(1000)))
+}
+
+func TestProcessWalletOperation_RecordsLedgerEntry(t *testing.T) {
+	keyId := uuid.New()
+	mdb := new(mockDBProvider)
+	mtx := new(mockTxProvider)
+	mrow := new(mockRowScanner)
+
+	mdb.On("Begin", mock.Anything).Return(mtx, nil)
+	noLimits(mtx)
+	mtx.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(mrow)
+	mrow.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		if dest, ok := args.Get(0).([]interface{}); ok {
+			if ptr, ok := dest[0].(*decimal.Decimal); ok {
+				*ptr = decimal.NewFromInt(10)
+				*dest[1].(*string) = "USD"
+				*dest[5].(*int64) = 7
+			}
+		}
+	})
+	mtx.On("Exec", mock.Anything, mock.MatchedBy(func(q string) bool {
+		return strings.HasPrefix(q, "INSERT INTO wallet_transactions")
+	}), mock.Anything).Return(nil, nil).Run(func(args mock.Arguments) {
+		values := args.Get(2).([]interface{})
+		assert.Equal(t, models.DEPOSIT, values[2])
+		assert.Equal(t, "USD", values[3])
+		assert.True(t, decimal.NewFromInt(15).Equal(values[5].(decimal.Decimal)))
+		assert.Equal(t, "ORDER-1", values[10])
+		assert.Equal(t, map[string]string{"channel": "web"}, values[12])
+		assert.Equal(t, &keyId, values[13])
+	})
+	mtx.On("Exec", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+	mtx.On("Commit", mock.Anything).Return(nil)
+
+	request := models.WalletOperationRequest{
+		WalletId:      uuid.New().String(),
+		OperationType: models.DEPOSIT,
+		Amount:        decimal.NewFromInt(5),
+		OperationDetails: models.OperationDetails{
+			Reference: "ORDER-1",
+			Metadata:  map[string]string{"channel": "web"},
+		},
+		ApiKeyId: &keyId,
+	}
+	res := processWalletOperation(mdb, request)
+	assert.NoError(t, res.Err)
+	assert.NotEqual(t, uuid.Nil, res.OperationId)
+	assert.True(t, decimal.NewFromInt(15).Equal(res.Balance))
+	assert.Equal(t, res.OperationId, res.Entry.OperationId)
+	assert.Equal(t, int64(8), res.Version)
+	mtx.AssertNumberOfCalls(t, "Exec", 2)
+	mtx.AssertNotCalled(t, "Rollback", mock.Anything)
+}
+
+func TestProcessWalletOperation_DuplicateReference(t *testing.T) {
+	t.Setenv("WALLET_UNIQUE_REFERENCES", "true")
+	mdb := new(mockDBProvider)
+	mtx := new(mockTxProvider)
+	used := new(mockRowScanner)
+
+	mdb.On("Begin", mock.Anything).Return(mtx, nil)
+	noLimits(mtx)
+	mtx.On("QueryRow", mock.Anything, isQuery("SELECT EXISTS"), mock.Anything).Return(used)
+	used.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		*args.Get(0).([]interface{})[0].(*bool) = true
+	})
+	mtx.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(walletRow(10, 0))
+	mtx.On("Rollback", mock.Anything).Return(nil)
+
+	res := processWalletOperation(mdb, models.WalletOperationRequest{
+		WalletId:         uuid.New().String(),
+		OperationType:    models.DEPOSIT,
+		Amount:           decimal.NewFromInt(5),
+		OperationDetails: models.OperationDetails{Reference: "ORDER-123"},
+	})
+	assert.ErrorIs(t, res.Err, ErrDuplicateReference)
+	mtx.AssertNotCalled(t, "Exec", mock.Anything, mock.Anything, mock.Anything)
+	mtx.AssertNotCalled(t, "Commit", mock.Anything)
+}
+
+func TestProcessWalletOperation_IdempotentReplay(t *testing.T) {
+	mdb := new(mockDBProvider)
+	mtx := new(mockTxProvider)
+	keyRow := new(mockRowScanner)
+	ledgerRow := new(mockRowScanner)
+
+	request := models.WalletOperationRequest{
+		WalletId:       uuid.New().String(),
+		OperationType:  models.DEPOSIT,
+		Amount:         decimal.NewFromInt(5),
+		IdempotencyKey: "retry-1",
+	}
+	payload := request
+	payload.IdempotencyKey = ""
+	hash, err := idempotency.Hash(payload)
+	assert.NoError(t, err)
+	opId := uuid.New()
+
+	mdb.On("Begin", mock.Anything).Return(mtx, nil)
+	noLimits(mtx)
+	mtx.On("QueryRow", mock.Anything, mock.MatchedBy(func(q string) bool {
+		return strings.Contains(q, "FROM idempotency_keys")
+	}), mock.Anything).Return(keyRow)
+	noLimits(mtx)
+	mtx.On("QueryRow", mock.Anything, mock.MatchedBy(func(q string) bool {
+		return strings.Contains(q, "FROM wallet_transactions")
+	}), mock.Anything).Return(ledgerRow)
+	keyRow.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		dest := args.Get(0).([]interface{})
+		*dest[0].(*string) = hash
+		*dest[1].(*uuid.UUID) = opId
+		*dest[2].(*[]byte) = []byte(`{"available":"40","version":7}`)
+	})
+	ledgerRow.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		dest := args.Get(0).([]interface{})
+		*dest[1].(*uuid.UUID) = opId
+		*dest[4].(*string) = "USD"
+		*dest[6].(*decimal.Decimal) = decimal.NewFromInt(42)
+	})
+	mtx.On("Rollback", mock.Anything).Return(nil)
+
+	res := processWalletOperation(mdb, request)
+	assert.NoError(t, res.Err)
+	assert.True(t, res.Replayed)
+	assert.Equal(t, opId, res.OperationId)
+	assert.True(t, decimal.NewFromInt(42).Equal(res.Balance))
+	assert.True(t, decimal.NewFromInt(40).Equal(res.Available))
+	assert.Equal(t, int64(7), res.Version)
+	mtx.AssertNotCalled(t, "Exec", mock.Anything, mock.Anything, mock.Anything)
+	mtx.AssertNotCalled(t, "Commit", mock.Anything)
 }
 
 func TestQueueManager_getOrCreateQueue(t *testing.T) {
@@ -129,3 +288,147 @@ func TestQueueManager_getOrCreateQueue(t *testing.T) {
 	ch2 := qm.getOrCreateQueue(id)
 	assert.Equal(t, ch, ch2)
 }
+
+func TestSortWalletIds(t *testing.T) {
+	a := uuid.MustParse("00000000-0000-0000-0000-000000000001")
+	b := uuid.MustParse("00000000-0000-0000-0000-000000000002")
+	assert.Equal(t, []uuid.UUID{a, b}, sortWalletIds([]uuid.UUID{b, a, b}))
+}
+
+func TestQueueManager_runExclusive_BlocksWallet(t *testing.T) {
+	c := &cache.BalanceCache{}
+	mdb := new(mockDBProvider)
+	mtx := new(mockTxProvider)
+	mrow := new(mockRowScanner)
+	mdb.On("Begin", mock.Anything).Return(mtx, nil)
+	noLimits(mtx)
+	mtx.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(mrow)
+	mrow.On("Scan", mock.Anything).Return(nil)
+	mtx.On("Exec", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+	mtx.On("Commit", mock.Anything).Return(nil)
+
+	qm := NewQueueManager(c, mdb)
+	a, b := uuid.New(), uuid.New()
+	request := models.WalletOperationRequest{WalletId: b.String(), OperationType: models.DEPOSIT, Amount: decimal.NewFromInt(1)}
+
+	done := make(chan OpResult)
+	qm.runExclusive([]uuid.UUID{a, b}, func() {
+		go func() { done <- qm.Enqueue(b, request) }()
+		select {
+		case <-done:
+			t.Fatal("operation ran while the wallet was held")
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+	res := <-done
+	assert.NoError(t, res.Err)
+}
+
+func TestProcessTransfer_InsufficientFunds(t *testing.T) {
+	mdb := new(mockDBProvider)
+	mtx := new(mockTxProvider)
+	mrow := new(mockRowScanner)
+
+	mdb.On("Begin", mock.Anything).Return(mtx, nil)
+	noLimits(mtx)
+	mtx.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(mrow)
+	mrow.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		dest := args.Get(0).([]interface{})
+		if ptr, ok := dest[0].(*decimal.Decimal); ok {
+			*ptr = decimal.NewFromInt(10)
+		}
+	})
+	mtx.On("Rollback", mock.Anything).Return(nil)
+
+	from, to := uuid.New(), uuid.New()
+	res := processTransfer(mdb, from, to, models.TransferRequest{
+		FromWalletId: from.String(),
+		ToWalletId:   to.String(),
+		Amount:       decimal.NewFromInt(11),
+	})
+	assert.Error(t, res.Err)
+	assert.ErrorIs(t, res.Err, ErrInsufficientFunds)
+	assert.Equal(t, "Insufficient funds", res.Msg)
+	mtx.AssertNotCalled(t, "Exec", mock.Anything, mock.Anything, mock.Anything)
+	mtx.AssertNotCalled(t, "Commit", mock.Anything)
+}
+
+func TestProcessTransfer_Success(t *testing.T) {
+	mdb := new(mockDBProvider)
+	mtx := new(mockTxProvider)
+	mrow := new(mockRowScanner)
+
+	mdb.On("Begin", mock.Anything).Return(mtx, nil)
+	noLimits(mtx)
+	mtx.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(mrow)
+	mrow.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		dest := args.Get(0).([]interface{})
+		if ptr, ok := dest[0].(*decimal.Decimal); ok {
+			*ptr = decimal.NewFromInt(10)
+		}
+	})
+	mtx.On("Exec", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+	mtx.On("Commit", mock.Anything).Return(nil)
+
+	from, to := uuid.New(), uuid.New()
+	res := processTransfer(mdb, from, to, models.TransferRequest{
+		FromWalletId: from.String(),
+		ToWalletId:   to.String(),
+		Amount:       decimal.NewFromInt(4),
+	})
+	assert.NoError(t, res.Err)
+	assert.NotEqual(t, uuid.Nil, res.TransferId)
+	assert.True(t, decimal.NewFromInt(6).Equal(res.FromBalance))
+	assert.True(t, decimal.NewFromInt(14).Equal(res.ToBalance))
+	assert.Equal(t, []models.OperationType{models.TRANSFER_OUT, models.TRANSFER_IN}, []models.OperationType{res.Legs[0].OperationType, res.Legs[1].OperationType})
+	assert.Equal(t, int64(1), res.FromVersion)
+	// two balance updates and two ledger entries
+	mtx.AssertNumberOfCalls(t, "Exec", 4)
+}
+
+func TestLockWallet_CurrencyMismatch(t *testing.T) {
+	mtx := new(mockTxProvider)
+	balanceRow := new(mockRowScanner)
+	walletRow := new(mockRowScanner)
+	noLimits(mtx)
+	mtx.On("QueryRow", mock.Anything, mock.MatchedBy(func(q string) bool {
+		return strings.HasPrefix(q, "SELECT balance, currency")
+	}), mock.Anything).Return(balanceRow)
+	noLimits(mtx)
+	mtx.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(walletRow)
+	balanceRow.On("Scan", mock.Anything).Return(db.ErrNoRows)
+	walletRow.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		dest := args.Get(0).([]interface{})
+		*dest[0].(*string) = "USD"
+		*dest[2].(*string) = models.DefaultTenant
+	})
+
+	wallet, msg, err := lockWallet(mtx, models.DefaultTenant, uuid.New(), "EUR")
+	assert.True(t, errors.Is(err, ErrCurrencyMismatch))
+	assert.Equal(t, "Currency mismatch", msg)
+	assert.Equal(t, "USD", wallet.Currency)
+	mtx.AssertNotCalled(t, "Exec", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestLockWallet_OtherTenant(t *testing.T) {
+	mtx := new(mockTxProvider)
+	balanceRow := new(mockRowScanner)
+	walletRow := new(mockRowScanner)
+	mtx.On("QueryRow", mock.Anything, mock.MatchedBy(func(q string) bool {
+		return strings.HasPrefix(q, "SELECT balance, currency")
+	}), mock.Anything).Return(balanceRow)
+	mtx.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(walletRow)
+	balanceRow.On("Scan", mock.Anything).Return(db.ErrNoRows)
+	walletRow.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		dest := args.Get(0).([]interface{})
+		*dest[0].(*string) = "USD"
+		*dest[1].(*models.WalletStatus) = models.WALLET_ACTIVE
+		*dest[2].(*string) = "brand-a"
+	})
+
+	// A known wallet id of another tenant is neither used nor taken over with a new balance
+	_, msg, err := lockWallet(mtx, "brand-b", uuid.New(), "USD")
+	assert.True(t, errors.Is(err, ErrWalletNotFound))
+	assert.Equal(t, "Wallet not found", msg)
+	mtx.AssertNotCalled(t, "Exec", mock.Anything, mock.Anything, mock.Anything)
+}
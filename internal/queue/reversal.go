@@ -0,0 +1,184 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/idempotency"
+	"wallet-api-server/internal/ledger"
+	"wallet-api-server/internal/models"
+)
+
+var (
+	ErrOperationNotFound     = errors.New("operation not found")
+	ErrNotReversible         = errors.New("operation cannot be reversed")
+	ErrAlreadyReversed       = errors.New("operation already reversed")
+	ErrReversalExceedsAmount = errors.New("reversal amount exceeds the amount not yet reversed")
+)
+
+// Reverse records compensating entries for a committed operation. A transfer is
+// reversed as a whole, whichever of its two legs is referenced. Operations of other tenants are not found
+func (qm *QueueManager) Reverse(operationId uuid.UUID, req models.ReversalRequest) OpResult {
+	original, err := ledger.Get(context.Background(), qm.DB, req.TenantId, operationId)
+	if err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return OpResult{Err: ErrOperationNotFound, Msg: "Operation not found"}
+		}
+		return OpResult{Err: err, Msg: "Failed to read operation"}
+	}
+
+	legs := []models.Transaction{original}
+	if original.TransferId != nil {
+		legs, err = ledger.GetTransfer(context.Background(), qm.DB, req.TenantId, *original.TransferId)
+		if err != nil {
+			return OpResult{Err: err, Msg: "Failed to read transfer"}
+		}
+	}
+	walletIds := make([]uuid.UUID, len(legs))
+	for i, leg := range legs {
+		walletIds[i] = leg.WalletId
+	}
+
+	var res OpResult
+	qm.runExclusive(walletIds, func() {
+		res = processReversal(qm.DB, original, legs, req)
+		if res.Err == nil && !res.Replayed {
+			for _, walletId := range walletIds {
+				qm.changed(walletId)
+			}
+		}
+	})
+	return res
+}
+
+func processReversal(dbProvider db.DBProvider, original models.Transaction, legs []models.Transaction, req models.ReversalRequest) OpResult {
+	switch original.OperationType {
+	case models.REVERSAL_IN, models.REVERSAL_OUT:
+		return OpResult{Err: ErrNotReversible, Msg: "A reversal cannot be reversed"}
+	}
+
+	tx, err := dbProvider.Begin(context.Background())
+	if err != nil {
+		return OpResult{Err: err, Msg: "Transaction error"}
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			if rollbackErr := tx.Rollback(context.Background()); rollbackErr != nil {
+				log.Printf("Failed to rollback transaction: %v", rollbackErr)
+			}
+		}
+	}()
+
+	var requestHash string
+	if req.IdempotencyKey != "" {
+		requestHash, err = idempotency.Hash(struct {
+			ReversalOf uuid.UUID        `json:"reversalOf"`
+			Amount     *decimal.Decimal `json:"amount,omitempty"`
+		}{original.OperationId, req.Amount})
+		if err != nil {
+			return OpResult{Err: err, Msg: "Failed to hash request"}
+		}
+		if res, found := replayIdempotent(tx, req.TenantId, req.IdempotencyKey, requestHash); found {
+			return res
+		}
+	}
+
+	// The wallet queues are held, so no other reversal of the operation can run concurrently
+	reversed, err := ledger.ReversedAmount(context.Background(), tx, req.TenantId, original.OperationId)
+	if err != nil {
+		return OpResult{Err: err, Msg: "Failed to read reversals"}
+	}
+	remaining := original.Amount.Sub(reversed)
+	if !remaining.IsPositive() {
+		return OpResult{Err: ErrAlreadyReversed, Msg: "Operation already reversed"}
+	}
+	amount := remaining
+	if req.Amount != nil {
+		amount = *req.Amount
+	}
+	if !amount.IsPositive() {
+		return OpResult{Err: ErrInvalidAmount, Msg: "Amount must be positive"}
+	}
+	if amount.GreaterThan(remaining) {
+		return OpResult{Err: ErrReversalExceedsAmount, Msg: fmt.Sprintf("Only %s can still be reversed", remaining)}
+	}
+
+	// Row locks follow the queue lock order
+	byWallet := make(map[uuid.UUID]models.Transaction, len(legs))
+	walletIds := make([]uuid.UUID, len(legs))
+	for i, leg := range legs {
+		byWallet[leg.WalletId] = leg
+		walletIds[i] = leg.WalletId
+	}
+	now := time.Now().UTC()
+	var res OpResult
+	for _, walletId := range sortWalletIds(walletIds) {
+		leg := byWallet[walletId]
+		wallet, msg, err := lockWallet(tx, req.TenantId, leg.WalletId, leg.Currency)
+		if err != nil {
+			return OpResult{Currency: wallet.Currency, Err: err, Msg: msg}
+		}
+
+		entry := models.Transaction{
+			OperationId: uuid.New(),
+			WalletId:    leg.WalletId,
+			Currency:    leg.Currency,
+			Amount:      amount,
+			ReversalOf:  &leg.OperationId,
+			ApiKeyId:    req.ApiKeyId,
+			CreatedAt:   now,
+		}
+		available := wallet.Available
+		if leg.OperationType.IsCredit() {
+			if wallet.Available.LessThan(amount) {
+				return OpResult{Balance: wallet.Balance, Available: wallet.Available, Currency: wallet.Currency, Err: ErrInsufficientFunds, Msg: "Insufficient funds"}
+			}
+			entry.OperationType = models.REVERSAL_OUT
+			entry.BalanceAfter = wallet.Balance.Sub(amount)
+			available = available.Sub(amount)
+		} else {
+			entry.OperationType = models.REVERSAL_IN
+			entry.BalanceAfter = wallet.Balance.Add(amount)
+			available = available.Add(amount)
+		}
+
+		_, err = tx.Exec(context.Background(), "UPDATE wallets SET balance=$1 WHERE wallet_id=$2 AND currency=$3", entry.BalanceAfter, entry.WalletId, entry.Currency)
+		if err != nil {
+			return OpResult{Err: err, Msg: "Failed to update balance"}
+		}
+		if err = ledger.Record(context.Background(), tx, entry); err != nil {
+			return OpResult{Err: err, Msg: "Failed to record transaction"}
+		}
+		if leg.OperationId == original.OperationId {
+			entry := entry
+			res = OpResult{OperationId: entry.OperationId, Balance: entry.BalanceAfter, Available: available, Currency: entry.Currency, Entry: &entry}
+		}
+	}
+
+	if req.IdempotencyKey != "" {
+		if err = idempotency.Save(context.Background(), tx, req.TenantId, req.IdempotencyKey, requestHash, res.OperationId,
+			opOutcome{Available: res.Available}); err != nil {
+			if errors.Is(err, idempotency.ErrKeyConflict) {
+				return OpResult{Err: err, Msg: "Idempotency key reused with a different payload"}
+			}
+			return OpResult{Err: err, Msg: "Failed to store idempotency key"}
+		}
+	}
+
+	err = tx.Commit(context.Background())
+	if err != nil {
+		return OpResult{Err: err, Msg: "Transaction commit error"}
+	}
+
+	committed = true
+	return res
+}
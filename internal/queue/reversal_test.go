@@ -0,0 +1,124 @@
+package queue
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"wallet-api-server/internal/models"
+)
+
+func reversedRow(reversed int64) *mockRowScanner {
+	mrow := new(mockRowScanner)
+	mrow.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		dest := args.Get(0).([]interface{})
+		*dest[0].(*decimal.Decimal) = decimal.NewFromInt(reversed)
+	})
+	return mrow
+}
+
+func deposit(amount int64) models.Transaction {
+	return models.Transaction{
+		OperationId:   uuid.New(),
+		WalletId:      uuid.New(),
+		OperationType: models.DEPOSIT,
+		Currency:      "USD",
+		Amount:        decimal.NewFromInt(amount),
+		BalanceAfter:  decimal.NewFromInt(amount),
+		CreatedAt:     time.Now(),
+	}
+}
+
+func TestProcessReversal_PartialDeposit(t *testing.T) {
+	original := deposit(10)
+	mdb := new(mockDBProvider)
+	mtx := new(mockTxProvider)
+	mdb.On("Begin", mock.Anything).Return(mtx, nil)
+	mtx.On("QueryRow", mock.Anything, isQuery("SELECT COALESCE(SUM(amount)"), mock.Anything).Return(reversedRow(3))
+	mtx.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(walletRow(10, 0))
+	mtx.On("Exec", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+	mtx.On("Commit", mock.Anything).Return(nil)
+
+	amount := decimal.NewFromInt(4)
+	res := processReversal(mdb, original, []models.Transaction{original}, models.ReversalRequest{Amount: &amount})
+	assert.NoError(t, res.Err)
+	assert.Equal(t, models.REVERSAL_OUT, res.Entry.OperationType)
+	assert.Equal(t, original.OperationId, *res.Entry.ReversalOf)
+	assert.True(t, decimal.NewFromInt(6).Equal(res.Balance))
+	mtx.AssertCalled(t, "Exec", mock.Anything, isQuery("INSERT INTO wallet_transactions"), mock.Anything)
+}
+
+func TestProcessReversal_TransferCreditsSender(t *testing.T) {
+	transferId := uuid.New()
+	out := deposit(5)
+	out.OperationType = models.TRANSFER_OUT
+	out.TransferId = &transferId
+	in := deposit(5)
+	in.OperationType = models.TRANSFER_IN
+	in.TransferId = &transferId
+
+	mdb := new(mockDBProvider)
+	mtx := new(mockTxProvider)
+	mdb.On("Begin", mock.Anything).Return(mtx, nil)
+	mtx.On("QueryRow", mock.Anything, isQuery("SELECT COALESCE(SUM(amount)"), mock.Anything).Return(reversedRow(0))
+	mtx.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(walletRow(5, 0))
+	mtx.On("Exec", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+	mtx.On("Commit", mock.Anything).Return(nil)
+
+	res := processReversal(mdb, out, []models.Transaction{out, in}, models.ReversalRequest{})
+	assert.NoError(t, res.Err)
+	assert.Equal(t, models.REVERSAL_IN, res.Entry.OperationType)
+	assert.Equal(t, out.WalletId, res.Entry.WalletId)
+	assert.True(t, decimal.NewFromInt(10).Equal(res.Balance))
+	mtx.AssertNumberOfCalls(t, "Exec", 4)
+}
+
+func TestProcessReversal_Rejections(t *testing.T) {
+	reversal := deposit(10)
+	reversal.OperationType = models.REVERSAL_IN
+	tooMuch := decimal.NewFromInt(8)
+
+	cases := []struct {
+		original models.Transaction
+		reversed int64
+		balance  int64
+		amount   *decimal.Decimal
+		err      error
+	}{
+		{reversal, 0, 10, nil, ErrNotReversible},
+		{deposit(10), 10, 10, nil, ErrAlreadyReversed},
+		{deposit(10), 3, 10, &tooMuch, ErrReversalExceedsAmount},
+	}
+	for _, tc := range cases {
+		mdb := new(mockDBProvider)
+		mtx := new(mockTxProvider)
+		mdb.On("Begin", mock.Anything).Return(mtx, nil)
+		mtx.On("QueryRow", mock.Anything, isQuery("SELECT COALESCE(SUM(amount)"), mock.Anything).Return(reversedRow(tc.reversed))
+		mtx.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(walletRow(tc.balance, 0))
+		mtx.On("Rollback", mock.Anything).Return(nil)
+
+		res := processReversal(mdb, tc.original, []models.Transaction{tc.original}, models.ReversalRequest{Amount: tc.amount})
+		assert.True(t, errors.Is(res.Err, tc.err), "%v", res.Err)
+		mtx.AssertNotCalled(t, "Exec", mock.Anything, mock.Anything, mock.Anything)
+	}
+}
+
+func TestProcessReversal_SpentDeposit(t *testing.T) {
+	original := deposit(10)
+	mdb := new(mockDBProvider)
+	mtx := new(mockTxProvider)
+	mdb.On("Begin", mock.Anything).Return(mtx, nil)
+	mtx.On("QueryRow", mock.Anything, isQuery("SELECT COALESCE(SUM(amount)"), mock.Anything).Return(reversedRow(0))
+	mtx.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(walletRow(4, 0))
+	mtx.On("Rollback", mock.Anything).Return(nil)
+
+	res := processReversal(mdb, original, []models.Transaction{original}, models.ReversalRequest{})
+	assert.ErrorIs(t, res.Err, ErrInsufficientFunds)
+	assert.Equal(t, "Insufficient funds", res.Msg)
+	mtx.AssertNotCalled(t, "Exec", mock.Anything, mock.Anything, mock.Anything)
+}
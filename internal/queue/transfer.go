@@ -0,0 +1,184 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/idempotency"
+	"wallet-api-server/internal/ledger"
+	"wallet-api-server/internal/models"
+)
+
+type TransferResult struct {
+	TransferId  uuid.UUID
+	FromBalance decimal.Decimal
+	ToBalance   decimal.Decimal
+	Currency    string
+	// Legs are the TRANSFER_OUT and TRANSFER_IN ledger entries
+	Legs []models.Transaction
+	// FromVersion and ToVersion are the balance rows' versions after the transfer
+	FromVersion int64
+	ToVersion   int64
+	Err         error
+	Msg         string
+	Replayed    bool
+}
+
+// Transfer moves funds between two wallets in a single DB transaction while
+// both wallet queues are held, so it is serialized with every other operation on them
+func (qm *QueueManager) Transfer(req models.TransferRequest) TransferResult {
+	from, err := uuid.Parse(req.FromWalletId)
+	if err != nil {
+		return TransferResult{Err: err, Msg: "Invalid fromWalletId format"}
+	}
+	to, err := uuid.Parse(req.ToWalletId)
+	if err != nil {
+		return TransferResult{Err: err, Msg: "Invalid toWalletId format"}
+	}
+
+	var res TransferResult
+	qm.runExclusive([]uuid.UUID{from, to}, func() {
+		res = processTransfer(qm.DB, from, to, req)
+		if res.Err == nil && !res.Replayed {
+			qm.changed(from)
+			qm.changed(to)
+		}
+	})
+	return res
+}
+
+func processTransfer(dbProvider db.DBProvider, from, to uuid.UUID, req models.TransferRequest) TransferResult {
+	if from == to {
+		return TransferResult{Err: ErrSameWallet, Msg: "Cannot transfer to the same wallet"}
+	}
+
+	tx, err := dbProvider.Begin(context.Background())
+	if err != nil {
+		return TransferResult{Err: err, Msg: "Transaction error"}
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			if rollbackErr := tx.Rollback(context.Background()); rollbackErr != nil {
+				log.Printf("Failed to rollback transaction: %v", rollbackErr)
+			}
+		}
+	}()
+
+	var requestHash string
+	if req.IdempotencyKey != "" {
+		payload := req
+		payload.IdempotencyKey = ""
+		requestHash, err = idempotency.Hash(payload)
+		if err != nil {
+			return TransferResult{Err: err, Msg: "Failed to hash request"}
+		}
+		if res, found := replayTransfer(tx, req.TenantId, req.IdempotencyKey, requestHash); found {
+			return res
+		}
+	}
+
+	currency := req.Currency
+	if currency == "" {
+		if currency, err = primaryCurrency(tx, req.TenantId, from); err != nil {
+			return TransferResult{Err: err, Msg: "Failed to read wallet"}
+		}
+	}
+
+	// Row locks are taken in the same order as the queue holds
+	balances := make(map[uuid.UUID]decimal.Decimal, 2)
+	versions := make(map[uuid.UUID]int64, 2)
+	var available decimal.Decimal
+	for _, id := range sortWalletIds([]uuid.UUID{from, to}) {
+		wallet, msg, err := lockWallet(tx, req.TenantId, id, currency)
+		if err != nil {
+			return TransferResult{Currency: currency, Err: err, Msg: msg}
+		}
+		balances[id] = wallet.Balance
+		versions[id] = wallet.Version + 1
+		if id == from {
+			available = wallet.Available
+		}
+	}
+
+	if msg, err := checkLimits(tx, req.TenantId, from, currency, models.TRANSFER_OUT, req.Amount); err != nil {
+		return TransferResult{FromBalance: balances[from], Currency: currency, Err: err, Msg: msg}
+	}
+	if available.LessThan(req.Amount) {
+		return TransferResult{FromBalance: balances[from], Currency: currency, Err: ErrInsufficientFunds, Msg: "Insufficient funds"}
+	}
+	balances[from] = balances[from].Sub(req.Amount)
+	balances[to] = balances[to].Add(req.Amount)
+
+	transferId := uuid.New()
+	now := time.Now().UTC()
+	legs := []models.Transaction{
+		{OperationId: uuid.New(), WalletId: from, OperationType: models.TRANSFER_OUT, Currency: currency, Amount: req.Amount, BalanceAfter: balances[from], TransferId: &transferId, ApiKeyId: req.ApiKeyId, CreatedAt: now},
+		{OperationId: uuid.New(), WalletId: to, OperationType: models.TRANSFER_IN, Currency: currency, Amount: req.Amount, BalanceAfter: balances[to], TransferId: &transferId, ApiKeyId: req.ApiKeyId, CreatedAt: now},
+	}
+	for _, leg := range legs {
+		_, err = tx.Exec(context.Background(), "UPDATE wallets SET balance=$1 WHERE wallet_id=$2 AND currency=$3", leg.BalanceAfter, leg.WalletId, leg.Currency)
+		if err != nil {
+			return TransferResult{Err: err, Msg: "Failed to update balance"}
+		}
+		if err = ledger.Record(context.Background(), tx, leg); err != nil {
+			return TransferResult{Err: err, Msg: "Failed to record transaction"}
+		}
+	}
+
+	if req.IdempotencyKey != "" {
+		if err = idempotency.Save(context.Background(), tx, req.TenantId, req.IdempotencyKey, requestHash, transferId,
+			transferOutcome{FromVersion: versions[from], ToVersion: versions[to]}); err != nil {
+			if errors.Is(err, idempotency.ErrKeyConflict) {
+				return TransferResult{Err: err, Msg: "Idempotency key reused with a different payload"}
+			}
+			return TransferResult{Err: err, Msg: "Failed to store idempotency key"}
+		}
+	}
+
+	err = tx.Commit(context.Background())
+	if err != nil {
+		return TransferResult{Err: err, Msg: "Transaction commit error"}
+	}
+
+	committed = true
+	return TransferResult{TransferId: transferId, FromBalance: balances[from], ToBalance: balances[to], Currency: currency,
+		Legs: legs, FromVersion: versions[from], ToVersion: versions[to]}
+}
+
+// transferOutcome is saved with the idempotency key of a transfer
+type transferOutcome struct {
+	FromVersion int64 `json:"fromVersion"`
+	ToVersion   int64 `json:"toVersion"`
+}
+
+func replayTransfer(tx db.TxProvider, tenantId, key, requestHash string) (TransferResult, bool) {
+	var outcome transferOutcome
+	transferId, found, err := idempotency.Lookup(context.Background(), tx, tenantId, key, requestHash, &outcome)
+	if err != nil {
+		if errors.Is(err, idempotency.ErrKeyConflict) {
+			return TransferResult{Err: err, Msg: "Idempotency key reused with a different payload"}, true
+		}
+		return TransferResult{Err: err, Msg: "Failed to read idempotency key"}, true
+	}
+	if !found {
+		return TransferResult{}, false
+	}
+	legs, err := ledger.GetTransfer(context.Background(), tx, tenantId, transferId)
+	if err == nil && len(legs) != 2 {
+		err = fmt.Errorf("transfer %s has %d ledger entries", transferId, len(legs))
+	}
+	if err != nil {
+		return TransferResult{Err: err, Msg: "Failed to read transfer"}, true
+	}
+	return TransferResult{TransferId: transferId, FromBalance: legs[0].BalanceAfter, ToBalance: legs[1].BalanceAfter, Currency: legs[0].Currency, Legs: legs,
+		FromVersion: outcome.FromVersion, ToVersion: outcome.ToVersion, Replayed: true}, true
+}
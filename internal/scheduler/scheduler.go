@@ -0,0 +1,202 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+
+	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/models"
+	"wallet-api-server/internal/queue"
+)
+
+var (
+	ErrNotFound   = errors.New("scheduled operation not found")
+	ErrNotPending = errors.New("scheduled operation is not pending")
+)
+
+// claimBatchSize bounds how many due operations one instance claims at once
+const claimBatchSize = 100
+
+// Executor runs claimed operations and standing orders, implemented by queue.QueueManager
+type Executor interface {
+	Enqueue(walletId uuid.UUID, req models.WalletOperationRequest) queue.OpResult
+	Transfer(req models.TransferRequest) queue.TransferResult
+}
+
+// claimTimeout is how long an operation may stay claimed before it is assumed
+// that the instance running it died and another one takes it over
+func claimTimeout() time.Duration {
+	viper.AutomaticEnv()
+	viper.SetDefault("SCHEDULER_CLAIM_TIMEOUT", 5*60)
+	timeout := viper.GetInt("SCHEDULER_CLAIM_TIMEOUT")
+	return time.Duration(timeout) * time.Second
+}
+
+const columns = "id, wallet_id, operation_type, amount, currency, execute_at, status, operation_id, balance_after, error, created_at, executed_at, tenant_id, api_key_id"
+
+func scan(row db.RowScanner, s *models.ScheduledOperation) error {
+	return row.Scan(&s.Id, &s.WalletId, &s.OperationType, &s.Amount, &s.Currency, &s.ExecuteAt, &s.Status,
+		&s.OperationId, &s.BalanceAfter, &s.Error, &s.CreatedAt, &s.ExecutedAt, &s.TenantId, &s.ApiKeyId)
+}
+
+func collect(rows db.Rows) ([]models.ScheduledOperation, error) {
+	defer rows.Close()
+	ops := []models.ScheduledOperation{}
+	for rows.Next() {
+		var s models.ScheduledOperation
+		if err := scan(rows, &s); err != nil {
+			return nil, err
+		}
+		ops = append(ops, s)
+	}
+	return ops, rows.Err()
+}
+
+func Create(ctx context.Context, dbProvider db.DBProvider, req models.ScheduleRequest) (models.ScheduledOperation, error) {
+	s := models.ScheduledOperation{
+		Id:            uuid.New(),
+		WalletId:      uuid.MustParse(req.WalletId),
+		OperationType: req.OperationType,
+		Amount:        req.Amount,
+		Currency:      req.Currency,
+		ExecuteAt:     req.ExecuteAt.UTC(),
+		Status:        models.SCHEDULE_PENDING,
+		CreatedAt:     time.Now().UTC(),
+		ApiKeyId:      req.ApiKeyId,
+		TenantId:      req.TenantId,
+	}
+	_, err := dbProvider.Exec(ctx,
+		"INSERT INTO scheduled_operations (id, wallet_id, operation_type, amount, currency, execute_at, status, created_at, tenant_id, api_key_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)",
+		s.Id, s.WalletId, s.OperationType, s.Amount, s.Currency, s.ExecuteAt, s.Status, s.CreatedAt, s.TenantId, s.ApiKeyId)
+	return s, err
+}
+
+// Get returns an operation of the tenant, those of other tenants are not found
+func Get(ctx context.Context, q db.Querier, tenantId string, id uuid.UUID) (models.ScheduledOperation, error) {
+	var s models.ScheduledOperation
+	err := scan(q.QueryRow(ctx, "SELECT "+columns+" FROM scheduled_operations WHERE id=$1 AND tenant_id=$2", id, tenantId), &s)
+	if err != nil && errors.Is(err, db.ErrNoRows) {
+		return s, ErrNotFound
+	}
+	return s, err
+}
+
+// List returns the wallet's scheduled operations by execution time,
+// an empty status selects all of them
+func List(ctx context.Context, q db.Querier, tenantId string, walletId uuid.UUID, status models.ScheduleStatus, limit int) ([]models.ScheduledOperation, error) {
+	query := "SELECT " + columns + " FROM scheduled_operations WHERE wallet_id=$1 AND tenant_id=$2"
+	args := []interface{}{walletId, tenantId}
+	if status != "" {
+		args = append(args, status)
+		query += fmt.Sprintf(" AND status=$%d", len(args))
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(" ORDER BY execute_at, id LIMIT $%d", len(args))
+	rows, err := q.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return collect(rows)
+}
+
+// Cancel withdraws a pending operation. Operations that already run or ran
+// are returned unchanged together with ErrNotPending
+func Cancel(ctx context.Context, dbProvider db.DBProvider, tenantId string, id uuid.UUID) (models.ScheduledOperation, error) {
+	var s models.ScheduledOperation
+	err := scan(dbProvider.QueryRow(ctx,
+		"UPDATE scheduled_operations SET status=$1 WHERE id=$2 AND tenant_id=$3 AND status=$4 RETURNING "+columns,
+		models.SCHEDULE_CANCELLED, id, tenantId, models.SCHEDULE_PENDING), &s)
+	if err == nil || !errors.Is(err, db.ErrNoRows) {
+		return s, err
+	}
+	if s, err = Get(ctx, dbProvider, tenantId, id); err != nil {
+		return s, err
+	}
+	return s, ErrNotPending
+}
+
+// Start runs due operations and standing orders every interval until ctx is cancelled.
+// Any number of server instances may run it, each run is claimed by exactly one of them
+func Start(ctx context.Context, dbProvider db.DBProvider, executor Executor, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// Keep going while full batches come back
+				for runDue(ctx, dbProvider, executor) == claimBatchSize && ctx.Err() == nil {
+				}
+				for runDueStandingOrders(ctx, dbProvider, executor) == claimBatchSize && ctx.Err() == nil {
+				}
+			}
+		}
+	}()
+}
+
+// runDue executes one batch of due operations and returns how many it claimed
+func runDue(ctx context.Context, dbProvider db.DBProvider, executor Executor) int {
+	ops, err := claim(ctx, dbProvider)
+	if err != nil {
+		log.Printf("Failed to claim scheduled operations: %v", err)
+		return 0
+	}
+	for _, s := range ops {
+		// The idempotency key of the request turns a second run of an
+		// operation whose result was not recorded into a replay
+		res := executor.Enqueue(s.WalletId, s.Request())
+		if err := finish(ctx, dbProvider, s.Id, res); err != nil {
+			log.Printf("Failed to record the result of scheduled operation %s: %v", s.Id, err)
+		}
+	}
+	return len(ops)
+}
+
+func claim(ctx context.Context, dbProvider db.DBProvider) ([]models.ScheduledOperation, error) {
+	now := time.Now().UTC()
+	rows, err := dbProvider.Query(ctx, `
+		UPDATE scheduled_operations SET status=$1, claimed_at=$2
+		WHERE id IN (
+			SELECT id FROM scheduled_operations
+			WHERE execute_at <= $2 AND (status=$3 OR (status=$1 AND claimed_at <= $4))
+			ORDER BY execute_at
+			LIMIT $5
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+columns,
+		models.SCHEDULE_RUNNING, now, models.SCHEDULE_PENDING, now.Add(-claimTimeout()), claimBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	ops, err := collect(rows)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(ops, func(i, j int) bool { return ops[i].ExecuteAt.Before(ops[j].ExecuteAt) })
+	return ops, nil
+}
+
+func finish(ctx context.Context, dbProvider db.DBProvider, id uuid.UUID, res queue.OpResult) error {
+	status := models.SCHEDULE_EXECUTED
+	var operationId, balanceAfter, msg interface{}
+	if res.Err != nil {
+		status = models.SCHEDULE_FAILED
+		msg = res.Msg
+	} else {
+		operationId = res.OperationId
+		balanceAfter = res.Balance
+	}
+	_, err := dbProvider.Exec(ctx,
+		"UPDATE scheduled_operations SET status=$1, operation_id=$2, balance_after=$3, error=$4, executed_at=$5 WHERE id=$6 AND status=$7",
+		status, operationId, balanceAfter, msg, time.Now().UTC(), id, models.SCHEDULE_RUNNING)
+	return err
+}
@@ -0,0 +1,96 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/db/dbtest"
+	"wallet-api-server/internal/models"
+	"wallet-api-server/internal/queue"
+)
+
+// fakeExecutor fails withdrawals and records the requests it ran
+type fakeExecutor struct {
+	reqs      []models.WalletOperationRequest
+	transfers []models.TransferRequest
+}
+
+func (e *fakeExecutor) Transfer(req models.TransferRequest) queue.TransferResult {
+	e.transfers = append(e.transfers, req)
+	return queue.TransferResult{TransferId: uuid.New()}
+}
+
+func (e *fakeExecutor) Enqueue(walletId uuid.UUID, req models.WalletOperationRequest) queue.OpResult {
+	e.reqs = append(e.reqs, req)
+	if req.OperationType == models.WITHDRAW {
+		return queue.OpResult{Err: queue.ErrInsufficientFunds, Msg: "Insufficient funds"}
+	}
+	return queue.OpResult{OperationId: uuid.New(), Balance: req.Amount}
+}
+
+var scheduledKey = uuid.New()
+
+func scheduledRow(id uuid.UUID, opType models.OperationType, executeAt time.Time) []interface{} {
+	return dbtest.RowOf(columns, map[string]interface{}{
+		"id": id, "wallet_id": uuid.New(), "operation_type": opType, "amount": decimal.NewFromInt(10), "execute_at": executeAt,
+		"status": models.SCHEDULE_RUNNING, "created_at": time.Now(), "tenant_id": "brand-a", "api_key_id": &scheduledKey,
+	})
+}
+
+func TestRunDue(t *testing.T) {
+	deposit, withdraw := uuid.New(), uuid.New()
+	now := time.Now()
+	mdb := new(dbtest.DB)
+	// RETURNING does not keep the order of execution
+	mdb.On("Query", mock.Anything, mock.Anything, mock.Anything).Return(dbtest.NewRows(
+		scheduledRow(withdraw, models.WITHDRAW, now.Add(-time.Second)),
+		scheduledRow(deposit, models.DEPOSIT, now.Add(-time.Minute)),
+	), nil)
+	mdb.On("Exec", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+	executor := &fakeExecutor{}
+
+	assert.Equal(t, 2, runDue(context.Background(), mdb, executor))
+	if assert.Len(t, executor.reqs, 2) {
+		assert.Equal(t, "scheduled:"+deposit.String(), executor.reqs[0].IdempotencyKey)
+		assert.Equal(t, "scheduled:"+withdraw.String(), executor.reqs[1].IdempotencyKey)
+		// The operation runs in the tenant that scheduled it
+		assert.Equal(t, "brand-a", executor.reqs[0].TenantId)
+		// and is recorded with the key that scheduled it
+		assert.Equal(t, &scheduledKey, executor.reqs[0].ApiKeyId)
+	}
+	mdb.AssertCalled(t, "Exec", mock.Anything, mock.Anything, mock.MatchedBy(func(args []interface{}) bool {
+		return args[0] == models.SCHEDULE_EXECUTED && args[5] == deposit
+	}))
+	mdb.AssertCalled(t, "Exec", mock.Anything, mock.Anything, mock.MatchedBy(func(args []interface{}) bool {
+		return args[0] == models.SCHEDULE_FAILED && args[3] == "Insufficient funds" && args[5] == withdraw
+	}))
+}
+
+func TestCancel_NotPending(t *testing.T) {
+	id := uuid.New()
+	mdb := new(dbtest.DB)
+	current := dbtest.RowOf(columns, map[string]interface{}{"id": id, "status": models.SCHEDULE_EXECUTED})
+	mdb.On("QueryRow", mock.Anything, dbtest.IsQuery("UPDATE scheduled_operations"), mock.Anything).Return(dbtest.ErrRow(db.ErrNoRows))
+	mdb.On("QueryRow", mock.Anything, "SELECT "+columns+" FROM scheduled_operations WHERE id=$1 AND tenant_id=$2",
+		[]interface{}{id, models.DefaultTenant}).Return(dbtest.NewRow(current...))
+
+	s, err := Cancel(context.Background(), mdb, models.DefaultTenant, id)
+	assert.True(t, errors.Is(err, ErrNotPending))
+	assert.Equal(t, models.SCHEDULE_EXECUTED, s.Status)
+}
+
+func TestCancel_NotFound(t *testing.T) {
+	mdb := new(dbtest.DB)
+	mdb.On("QueryRow", mock.Anything, mock.Anything, mock.Anything).Return(dbtest.ErrRow(db.ErrNoRows))
+
+	_, err := Cancel(context.Background(), mdb, models.DefaultTenant, uuid.New())
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
@@ -0,0 +1,339 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+
+	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/models"
+	"wallet-api-server/internal/queue"
+)
+
+var (
+	ErrStandingOrderNotFound = errors.New("standing order not found")
+	ErrStandingOrderStatus   = errors.New("standing order status does not allow the change")
+)
+
+func defaultRetryInterval() int {
+	viper.AutomaticEnv()
+	viper.SetDefault("STANDING_ORDER_RETRY_INTERVAL", 60*60)
+	return viper.GetInt("STANDING_ORDER_RETRY_INTERVAL")
+}
+
+// nextOccurrence returns the first run not before after. Runs happen on day of
+// every month, or on its last day for shorter months, at the time of day of start
+func nextOccurrence(day int, start, after time.Time) time.Time {
+	start = start.UTC()
+	if after.Before(start) {
+		after = start
+	}
+	year, month, _ := after.UTC().Date()
+	for {
+		last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+		run := time.Date(year, month, min(day, last), start.Hour(), start.Minute(), start.Second(), start.Nanosecond(), time.UTC)
+		if !run.Before(after) {
+			return run
+		}
+		month++
+	}
+}
+
+const standingColumns = "id, from_wallet_id, to_wallet_id, amount, currency, day_of_month, start_at, end_at, max_retries, retry_interval, status, due_at, next_run_at, attempt, created_at, tenant_id, api_key_id"
+
+func scanStandingOrder(row db.RowScanner, o *models.StandingOrder) error {
+	return row.Scan(&o.Id, &o.FromWalletId, &o.ToWalletId, &o.Amount, &o.Currency, &o.DayOfMonth, &o.StartAt, &o.EndAt,
+		&o.MaxRetries, &o.RetryIntervalSeconds, &o.Status, &o.DueAt, &o.NextRunAt, &o.Attempt, &o.CreatedAt, &o.TenantId, &o.ApiKeyId)
+}
+
+func collectStandingOrders(rows db.Rows) ([]models.StandingOrder, error) {
+	defer rows.Close()
+	orders := []models.StandingOrder{}
+	for rows.Next() {
+		var o models.StandingOrder
+		if err := scanStandingOrder(rows, &o); err != nil {
+			return nil, err
+		}
+		orders = append(orders, o)
+	}
+	return orders, rows.Err()
+}
+
+// standingOrderResult maps a missing row to ErrStandingOrderNotFound
+func standingOrderResult(o models.StandingOrder, err error) (models.StandingOrder, error) {
+	if err != nil && errors.Is(err, db.ErrNoRows) {
+		return o, ErrStandingOrderNotFound
+	}
+	return o, err
+}
+
+func CreateStandingOrder(ctx context.Context, dbProvider db.DBProvider, req models.StandingOrderRequest) (models.StandingOrder, error) {
+	o := models.StandingOrder{
+		Id:                   uuid.New(),
+		FromWalletId:         uuid.MustParse(req.FromWalletId),
+		ToWalletId:           uuid.MustParse(req.ToWalletId),
+		Amount:               req.Amount,
+		Currency:             req.Currency,
+		DayOfMonth:           req.DayOfMonth,
+		StartAt:              req.StartAt.UTC(),
+		MaxRetries:           req.MaxRetries,
+		RetryIntervalSeconds: req.RetryIntervalSeconds,
+		Status:               models.STANDING_ACTIVE,
+		CreatedAt:            time.Now().UTC(),
+		ApiKeyId:             req.ApiKeyId,
+		TenantId:             req.TenantId,
+	}
+	if o.RetryIntervalSeconds == 0 {
+		o.RetryIntervalSeconds = defaultRetryInterval()
+	}
+	if req.EndAt != nil {
+		end := req.EndAt.UTC()
+		o.EndAt = &end
+	}
+	o.DueAt = nextOccurrence(o.DayOfMonth, o.StartAt, o.StartAt)
+	o.NextRunAt = o.DueAt
+	if o.EndAt != nil && o.DueAt.After(*o.EndAt) {
+		o.Status = models.STANDING_COMPLETED
+	}
+	_, err := dbProvider.Exec(ctx,
+		"INSERT INTO standing_orders ("+standingColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)",
+		o.Id, o.FromWalletId, o.ToWalletId, o.Amount, o.Currency, o.DayOfMonth, o.StartAt, o.EndAt,
+		o.MaxRetries, o.RetryIntervalSeconds, o.Status, o.DueAt, o.NextRunAt, o.Attempt, o.CreatedAt, o.TenantId, o.ApiKeyId)
+	return o, err
+}
+
+// GetStandingOrder returns an order of the tenant, those of other tenants are not found
+func GetStandingOrder(ctx context.Context, q db.Querier, tenantId string, id uuid.UUID) (models.StandingOrder, error) {
+	var o models.StandingOrder
+	err := scanStandingOrder(q.QueryRow(ctx, "SELECT "+standingColumns+" FROM standing_orders WHERE id=$1 AND tenant_id=$2", id, tenantId), &o)
+	return standingOrderResult(o, err)
+}
+
+// ListStandingOrders returns the orders paying from or into the wallet, deleted ones excluded
+func ListStandingOrders(ctx context.Context, q db.Querier, tenantId string, walletId uuid.UUID) ([]models.StandingOrder, error) {
+	rows, err := q.Query(ctx,
+		"SELECT "+standingColumns+" FROM standing_orders WHERE (from_wallet_id=$1 OR to_wallet_id=$1) AND tenant_id=$2 AND status<>$3 ORDER BY created_at, id",
+		walletId, tenantId, models.STANDING_DELETED)
+	if err != nil {
+		return nil, err
+	}
+	return collectStandingOrders(rows)
+}
+
+// ListExecutions returns the execution history of an order of the tenant, newest first
+func ListExecutions(ctx context.Context, q db.Querier, tenantId string, orderId uuid.UUID, limit int) ([]models.StandingOrderExecution, error) {
+	rows, err := q.Query(ctx,
+		"SELECT order_id, due_at, attempt, status, transfer_id, error, executed_at FROM standing_order_executions WHERE order_id=$1 AND tenant_id=$2 ORDER BY id DESC LIMIT $3",
+		orderId, tenantId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	executions := []models.StandingOrderExecution{}
+	for rows.Next() {
+		var e models.StandingOrderExecution
+		if err := rows.Scan(&e.OrderId, &e.DueAt, &e.Attempt, &e.Status, &e.TransferId, &e.Error, &e.ExecutedAt); err != nil {
+			return nil, err
+		}
+		executions = append(executions, e)
+	}
+	return executions, rows.Err()
+}
+
+func PauseStandingOrder(ctx context.Context, dbProvider db.DBProvider, tenantId string, id uuid.UUID) (models.StandingOrder, error) {
+	return changeStandingOrder(ctx, dbProvider, tenantId, id, func(o *models.StandingOrder) bool {
+		if o.Status != models.STANDING_ACTIVE {
+			return false
+		}
+		o.Status = models.STANDING_PAUSED
+		return true
+	})
+}
+
+// ResumeStandingOrder reactivates a paused order. Runs missed while it was
+// paused are skipped, it continues with the next one that is still ahead
+func ResumeStandingOrder(ctx context.Context, dbProvider db.DBProvider, tenantId string, id uuid.UUID) (models.StandingOrder, error) {
+	return changeStandingOrder(ctx, dbProvider, tenantId, id, func(o *models.StandingOrder) bool {
+		if o.Status != models.STANDING_PAUSED {
+			return false
+		}
+		o.Status = models.STANDING_ACTIVE
+		if now := time.Now().UTC(); o.NextRunAt.Before(now) {
+			o.DueAt = nextOccurrence(o.DayOfMonth, o.StartAt, now)
+			o.NextRunAt = o.DueAt
+			o.Attempt = 0
+			if o.EndAt != nil && o.DueAt.After(*o.EndAt) {
+				o.Status = models.STANDING_COMPLETED
+			}
+		}
+		return true
+	})
+}
+
+// DeleteStandingOrder stops an order for good, its execution history is kept
+func DeleteStandingOrder(ctx context.Context, dbProvider db.DBProvider, tenantId string, id uuid.UUID) (models.StandingOrder, error) {
+	return changeStandingOrder(ctx, dbProvider, tenantId, id, func(o *models.StandingOrder) bool {
+		if o.Status == models.STANDING_DELETED {
+			return false
+		}
+		o.Status = models.STANDING_DELETED
+		return true
+	})
+}
+
+// changeStandingOrder applies change to the locked order. When change refuses,
+// the order is returned unchanged together with ErrStandingOrderStatus
+func changeStandingOrder(ctx context.Context, dbProvider db.DBProvider, tenantId string, id uuid.UUID, change func(*models.StandingOrder) bool) (models.StandingOrder, error) {
+	tx, err := dbProvider.Begin(ctx)
+	if err != nil {
+		return models.StandingOrder{}, err
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			if rollbackErr := tx.Rollback(ctx); rollbackErr != nil {
+				log.Printf("Failed to rollback transaction: %v", rollbackErr)
+			}
+		}
+	}()
+
+	var o models.StandingOrder
+	err = scanStandingOrder(tx.QueryRow(ctx, "SELECT "+standingColumns+" FROM standing_orders WHERE id=$1 AND tenant_id=$2 FOR UPDATE", id, tenantId), &o)
+	if err != nil {
+		return standingOrderResult(o, err)
+	}
+	if !change(&o) {
+		return o, ErrStandingOrderStatus
+	}
+	_, err = tx.Exec(ctx, "UPDATE standing_orders SET status=$1, due_at=$2, next_run_at=$3, attempt=$4, updated_at=$5 WHERE id=$6",
+		o.Status, o.DueAt, o.NextRunAt, o.Attempt, time.Now().UTC(), o.Id)
+	if err != nil {
+		return o, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return o, err
+	}
+	committed = true
+	return o, nil
+}
+
+// runDueStandingOrders executes one batch of due standing orders and returns how many it claimed
+func runDueStandingOrders(ctx context.Context, dbProvider db.DBProvider, executor Executor) int {
+	claimedUntil := time.Now().UTC().Add(claimTimeout()).Truncate(time.Microsecond)
+	orders, err := claimStandingOrders(ctx, dbProvider, claimedUntil)
+	if err != nil {
+		log.Printf("Failed to claim standing orders: %v", err)
+		return 0
+	}
+	for _, o := range orders {
+		res := executor.Transfer(o.Request())
+		if err := finishStandingOrder(ctx, dbProvider, o, claimedUntil, res); err != nil {
+			log.Printf("Failed to record the execution of standing order %s: %v", o.Id, err)
+		}
+	}
+	return len(orders)
+}
+
+// claimStandingOrders marks due orders as taken by this instance until claimedUntil.
+// The status stays ACTIVE so that orders can be paused or deleted while they run
+func claimStandingOrders(ctx context.Context, dbProvider db.DBProvider, claimedUntil time.Time) ([]models.StandingOrder, error) {
+	now := time.Now().UTC()
+	rows, err := dbProvider.Query(ctx, `
+		UPDATE standing_orders SET claimed_until=$1
+		WHERE id IN (
+			SELECT id FROM standing_orders
+			WHERE status=$2 AND next_run_at <= $3 AND (claimed_until IS NULL OR claimed_until <= $3)
+			ORDER BY next_run_at
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+standingColumns,
+		claimedUntil, models.STANDING_ACTIVE, now, claimBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	orders, err := collectStandingOrders(rows)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(orders, func(i, j int) bool { return orders[i].NextRunAt.Before(orders[j].NextRunAt) })
+	return orders, nil
+}
+
+// finishStandingOrder records the attempt and schedules the next one: a retry
+// of the same period after insufficient funds while retries are left, the next
+// period otherwise. Nothing is written once the claim was taken over by another instance
+func finishStandingOrder(ctx context.Context, dbProvider db.DBProvider, o models.StandingOrder, claimedUntil time.Time, res queue.TransferResult) error {
+	now := time.Now().UTC()
+	execution := models.StandingOrderExecution{OrderId: o.Id, DueAt: o.DueAt, Attempt: o.Attempt, Status: models.EXECUTION_SUCCEEDED, ExecutedAt: now}
+	if res.Err != nil {
+		execution.Status = models.EXECUTION_FAILED
+		execution.Error = &res.Msg
+	} else {
+		execution.TransferId = &res.TransferId
+	}
+
+	next := o
+	nextPeriod := nextOccurrence(o.DayOfMonth, o.StartAt, o.DueAt.AddDate(0, 0, 1))
+	retryAt := now.Add(time.Duration(o.RetryIntervalSeconds) * time.Second)
+	if errors.Is(res.Err, queue.ErrInsufficientFunds) && o.Attempt < o.MaxRetries && retryAt.Before(nextPeriod) {
+		next.Attempt++
+		next.NextRunAt = retryAt
+	} else {
+		next.Attempt = 0
+		next.DueAt = nextPeriod
+		next.NextRunAt = nextPeriod
+		if o.EndAt != nil && nextPeriod.After(*o.EndAt) {
+			next.Status = models.STANDING_COMPLETED
+		}
+	}
+
+	tx, err := dbProvider.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			if rollbackErr := tx.Rollback(ctx); rollbackErr != nil {
+				log.Printf("Failed to rollback transaction: %v", rollbackErr)
+			}
+		}
+	}()
+
+	// A pause or delete that happened meanwhile is kept, only completion overrides it
+	var id uuid.UUID
+	err = tx.QueryRow(ctx, `
+		UPDATE standing_orders SET due_at=$1, next_run_at=$2, attempt=$3,
+			status=CASE WHEN $4 THEN $5 ELSE status END, claimed_until=NULL, updated_at=$6
+		WHERE id=$7 AND claimed_until=$8
+		RETURNING id`,
+		next.DueAt, next.NextRunAt, next.Attempt, next.Status == models.STANDING_COMPLETED, models.STANDING_COMPLETED, now, o.Id, claimedUntil).Scan(&id)
+	if err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return fmt.Errorf("claim expired before the execution was recorded")
+		}
+		return err
+	}
+	_, err = tx.Exec(ctx,
+		"INSERT INTO standing_order_executions (order_id, due_at, attempt, status, transfer_id, error, executed_at, tenant_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		execution.OrderId, execution.DueAt, execution.Attempt, execution.Status, execution.TransferId, execution.Error, execution.ExecutedAt, o.TenantId)
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
@@ -0,0 +1,148 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"wallet-api-server/internal/db/dbtest"
+	"wallet-api-server/internal/models"
+	"wallet-api-server/internal/queue"
+)
+
+func TestNextOccurrence(t *testing.T) {
+	start := time.Date(2024, 1, 15, 9, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		day   int
+		after time.Time
+		want  time.Time
+	}{
+		{"before start", 20, time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 20, 9, 30, 0, 0, time.UTC)},
+		{"day already passed in the start month", 10, start, time.Date(2024, 2, 10, 9, 30, 0, 0, time.UTC)},
+		{"same day later in the day", 15, start, start},
+		{"clamped to a leap february", 31, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 29, 9, 30, 0, 0, time.UTC)},
+		{"clamped to a short month", 31, time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 4, 30, 9, 30, 0, 0, time.UTC)},
+		{"across the year", 5, time.Date(2024, 12, 6, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 5, 9, 30, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, nextOccurrence(tt.day, start, tt.after))
+		})
+	}
+}
+
+func standingOrder(maxRetries, attempt int) models.StandingOrder {
+	due := time.Now().UTC().Add(-time.Minute)
+	return models.StandingOrder{
+		Id:                   uuid.New(),
+		FromWalletId:         uuid.New(),
+		ToWalletId:           uuid.New(),
+		Amount:               decimal.NewFromInt(10),
+		DayOfMonth:           due.Day(),
+		StartAt:              due.AddDate(0, -1, 0),
+		MaxRetries:           maxRetries,
+		RetryIntervalSeconds: 60,
+		Status:               models.STANDING_ACTIVE,
+		DueAt:                due,
+		NextRunAt:            due,
+		Attempt:              attempt,
+		TenantId:             "brand-a",
+	}
+}
+
+// finishTx records the finish update and the execution insert of one order
+func finishTx(mdb *dbtest.DB, o models.StandingOrder) *dbtest.Tx {
+	mtx := new(dbtest.Tx)
+	mdb.On("Begin", mock.Anything).Return(mtx, nil)
+	mtx.On("QueryRow", mock.Anything, dbtest.IsQuery("UPDATE standing_orders"), mock.Anything).Return(dbtest.NewRow(o.Id))
+	mtx.On("Exec", mock.Anything, dbtest.IsQuery("INSERT INTO standing_order_executions"), mock.MatchedBy(func(args []interface{}) bool {
+		return len(args) == 8 && args[7] == o.TenantId
+	})).Return(nil, nil)
+	mtx.On("Commit", mock.Anything).Return(nil)
+	return mtx
+}
+
+func TestFinishStandingOrder_RetriesInsufficientFunds(t *testing.T) {
+	o := standingOrder(2, 1)
+	mdb := new(dbtest.DB)
+	mtx := finishTx(mdb, o)
+
+	res := queue.TransferResult{Err: queue.ErrInsufficientFunds, Msg: "Insufficient funds"}
+	assert.NoError(t, finishStandingOrder(context.Background(), mdb, o, time.Now(), res))
+	mtx.AssertCalled(t, "QueryRow", mock.Anything, mock.Anything, mock.MatchedBy(func(args []interface{}) bool {
+		return args[0] == o.DueAt && args[1].(time.Time).After(o.DueAt) && args[2] == 2 && args[3] == false
+	}))
+	mtx.AssertCalled(t, "Exec", mock.Anything, mock.Anything, mock.MatchedBy(func(args []interface{}) bool {
+		return args[2] == 1 && args[3] == models.EXECUTION_FAILED && *args[5].(*string) == "Insufficient funds"
+	}))
+}
+
+func TestFinishStandingOrder_AdvancesWhenRetriesRunOut(t *testing.T) {
+	o := standingOrder(2, 2)
+	mdb := new(dbtest.DB)
+	mtx := finishTx(mdb, o)
+
+	res := queue.TransferResult{Err: queue.ErrInsufficientFunds, Msg: "Insufficient funds"}
+	assert.NoError(t, finishStandingOrder(context.Background(), mdb, o, time.Now(), res))
+	next := nextOccurrence(o.DayOfMonth, o.StartAt, o.DueAt.AddDate(0, 0, 1))
+	mtx.AssertCalled(t, "QueryRow", mock.Anything, mock.Anything, mock.MatchedBy(func(args []interface{}) bool {
+		return args[0] == next && args[1] == next && args[2] == 0
+	}))
+}
+
+func TestFinishStandingOrder_CompletesAfterEnd(t *testing.T) {
+	o := standingOrder(0, 0)
+	end := o.DueAt.Add(time.Hour)
+	o.EndAt = &end
+	mdb := new(dbtest.DB)
+	mtx := finishTx(mdb, o)
+
+	res := queue.TransferResult{TransferId: uuid.New()}
+	assert.NoError(t, finishStandingOrder(context.Background(), mdb, o, time.Now(), res))
+	mtx.AssertCalled(t, "QueryRow", mock.Anything, mock.Anything, mock.MatchedBy(func(args []interface{}) bool {
+		return args[3] == true
+	}))
+	mtx.AssertCalled(t, "Exec", mock.Anything, mock.Anything, mock.MatchedBy(func(args []interface{}) bool {
+		return args[3] == models.EXECUTION_SUCCEEDED && *args[4].(*uuid.UUID) == res.TransferId
+	}))
+}
+
+func TestListExecutions(t *testing.T) {
+	orderId, transferId := uuid.New(), uuid.New()
+	due := time.Date(2024, 3, 10, 9, 0, 0, 0, time.UTC)
+	mdb := new(dbtest.DB)
+	rows := dbtest.NewRows([]interface{}{orderId, due, 0, models.EXECUTION_SUCCEEDED, &transferId, nil, due})
+	mdb.On("Query", mock.Anything, dbtest.IsQuery("SELECT order_id, due_at, attempt, status, transfer_id, error, executed_at FROM standing_order_executions"),
+		[]interface{}{orderId, "brand-a", 10}).Return(rows, nil)
+
+	executions, err := ListExecutions(context.Background(), mdb, "brand-a", orderId, 10)
+	assert.NoError(t, err)
+	assert.True(t, rows.Closed)
+	if assert.Len(t, executions, 1) {
+		assert.Equal(t, transferId, *executions[0].TransferId)
+		assert.Nil(t, executions[0].Error)
+	}
+	assert.Contains(t, mdb.Calls[0].Arguments.String(1), "WHERE order_id=$1 AND tenant_id=$2")
+}
+
+func TestStandingOrderRequest_KeyedPerAttempt(t *testing.T) {
+	o := standingOrder(1, 0)
+	first := o.Request()
+	o.Attempt = 1
+	assert.NotEqual(t, first.IdempotencyKey, o.Request().IdempotencyKey)
+	assert.Equal(t, o.FromWalletId.String(), first.FromWalletId)
+}
+
+func TestStandingOrderRequest_CarriesKey(t *testing.T) {
+	o := standingOrder(0, 0)
+	keyId := uuid.New()
+	o.ApiKeyId = &keyId
+	assert.Equal(t, &keyId, o.Request().ApiKeyId)
+	assert.Equal(t, o.TenantId, o.Request().TenantId)
+}
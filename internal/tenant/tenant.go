@@ -0,0 +1,117 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+
+	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/models"
+)
+
+var (
+	ErrTenantNotFound = errors.New("tenant not found")
+	ErrTenantExists   = errors.New("tenant already exists")
+	// ErrOperationNotAllowed is returned for an operation the tenant's configuration does not allow
+	ErrOperationNotAllowed = errors.New("operation not allowed for the tenant")
+)
+
+var idPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// ValidId reports whether id can name a tenant
+func ValidId(id string) bool {
+	return len(id) <= 64 && idPattern.MatchString(id)
+}
+
+// Columns is the select list of a tenant, in the order scan reads it
+const Columns = "id, name, allowed_operations, max_operation_amount, daily_withdrawal, monthly_withdrawal, max_operations, operations_window, cache_ttl, created_at, updated_at"
+
+func scan(row db.RowScanner, t *models.Tenant) error {
+	var allowed []string
+	l := &t.Limits
+	if err := row.Scan(&t.Id, &t.Name, &allowed, &l.MaxOperationAmount, &l.DailyWithdrawal, &l.MonthlyWithdrawal,
+		&l.MaxOperations, &l.OperationsWindowSeconds, &t.CacheTTLSeconds, &t.CreatedAt, &t.UpdatedAt); err != nil {
+		return err
+	}
+	if allowed != nil {
+		t.AllowedOperations = make([]models.TenantOperation, len(allowed))
+		for i, op := range allowed {
+			t.AllowedOperations[i] = models.TenantOperation(op)
+		}
+	}
+	return nil
+}
+
+// allowedOperations is NULL when the tenant may use every operation
+func allowedOperations(ops []models.TenantOperation) []string {
+	if ops == nil {
+		return nil
+	}
+	s := make([]string, len(ops))
+	for i, op := range ops {
+		s[i] = string(op)
+	}
+	return s
+}
+
+func Load(ctx context.Context, q db.Querier, id string) (models.Tenant, error) {
+	var t models.Tenant
+	err := scan(q.QueryRow(ctx, "SELECT "+Columns+" FROM tenants WHERE id=$1", id), &t)
+	if errors.Is(err, db.ErrNoRows) {
+		return t, ErrTenantNotFound
+	}
+	return t, err
+}
+
+func List(ctx context.Context, q db.Querier) ([]models.Tenant, error) {
+	rows, err := q.Query(ctx, "SELECT "+Columns+" FROM tenants ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tenants := []models.Tenant{}
+	for rows.Next() {
+		var t models.Tenant
+		if err := scan(rows, &t); err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, t)
+	}
+	return tenants, rows.Err()
+}
+
+func Create(ctx context.Context, dbProvider db.DBProvider, req models.CreateTenantRequest) (models.Tenant, error) {
+	var t models.Tenant
+	l := req.Limits
+	now := time.Now().UTC()
+	err := scan(dbProvider.QueryRow(ctx, `
+		INSERT INTO tenants (id, name, allowed_operations, max_operation_amount, daily_withdrawal, monthly_withdrawal,
+			max_operations, operations_window, cache_ttl, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $10)
+		ON CONFLICT (id) DO NOTHING
+		RETURNING `+Columns,
+		req.Id, req.Name, allowedOperations(req.AllowedOperations), l.MaxOperationAmount, l.DailyWithdrawal, l.MonthlyWithdrawal,
+		l.MaxOperations, l.OperationsWindowSeconds, req.CacheTTLSeconds, now), &t)
+	if errors.Is(err, db.ErrNoRows) {
+		return t, ErrTenantExists
+	}
+	return t, err
+}
+
+// Update replaces the configuration of a tenant
+func Update(ctx context.Context, dbProvider db.DBProvider, id string, req models.TenantRequest) (models.Tenant, error) {
+	var t models.Tenant
+	l := req.Limits
+	err := scan(dbProvider.QueryRow(ctx, `
+		UPDATE tenants SET name=$2, allowed_operations=$3, max_operation_amount=$4, daily_withdrawal=$5, monthly_withdrawal=$6,
+			max_operations=$7, operations_window=$8, cache_ttl=$9, updated_at=now()
+		WHERE id=$1
+		RETURNING `+Columns,
+		id, req.Name, allowedOperations(req.AllowedOperations), l.MaxOperationAmount, l.DailyWithdrawal, l.MonthlyWithdrawal,
+		l.MaxOperations, l.OperationsWindowSeconds, req.CacheTTLSeconds), &t)
+	if errors.Is(err, db.ErrNoRows) {
+		return t, ErrTenantNotFound
+	}
+	return t, err
+}
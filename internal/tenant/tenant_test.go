@@ -0,0 +1,67 @@
+package tenant
+
+import (
+	"context"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/db/dbtest"
+	"wallet-api-server/internal/models"
+)
+
+func TestValidId(t *testing.T) {
+	for _, id := range []string{"default", "brand-a", "b2"} {
+		assert.True(t, ValidId(id), id)
+	}
+	long := make([]byte, 65)
+	for i := range long {
+		long[i] = 'a'
+	}
+	for _, id := range []string{"", "Brand", "-brand", "brand_a", "brand a", string(long)} {
+		assert.False(t, ValidId(id), id)
+	}
+}
+
+func TestLoad_NotFound(t *testing.T) {
+	mdb := new(dbtest.DB)
+	mdb.On("QueryRow", mock.Anything, "SELECT "+Columns+" FROM tenants WHERE id=$1", []interface{}{"brand-z"}).Return(dbtest.ErrRow(db.ErrNoRows))
+
+	_, err := Load(context.Background(), mdb, "brand-z")
+	assert.ErrorIs(t, err, ErrTenantNotFound)
+}
+
+func TestList(t *testing.T) {
+	limit := decimal.NewFromInt(500)
+	mdb := new(dbtest.DB)
+	rows := dbtest.NewRows(
+		dbtest.RowOf(Columns, map[string]interface{}{"id": "brand-a", "name": "Brand A", "allowed_operations": []string{"deposit"}, "max_operation_amount": limit}),
+		dbtest.RowOf(Columns, map[string]interface{}{"id": "default", "name": "Default"}),
+	)
+	mdb.On("Query", mock.Anything, dbtest.IsQuery("SELECT "+Columns+" FROM tenants"), []interface{}(nil)).Return(rows, nil)
+
+	tenants, err := List(context.Background(), mdb)
+	assert.NoError(t, err)
+	assert.True(t, rows.Closed)
+	if assert.Len(t, tenants, 2) {
+		assert.Equal(t, []models.TenantOperation{"deposit"}, tenants[0].AllowedOperations)
+		assert.True(t, tenants[0].Limits.MaxOperationAmount.Equal(limit))
+		// A NULL list allows every operation
+		assert.Nil(t, tenants[1].AllowedOperations)
+		assert.Nil(t, tenants[1].Limits.MaxOperationAmount)
+	}
+}
+
+func TestCreate_Exists(t *testing.T) {
+	mdb := new(dbtest.DB)
+	mdb.On("QueryRow", mock.Anything, dbtest.IsQuery("INSERT INTO tenants"), mock.Anything).Return(dbtest.ErrRow(db.ErrNoRows))
+
+	_, err := Create(context.Background(), mdb, models.CreateTenantRequest{Id: "brand-a", TenantRequest: models.TenantRequest{Name: "Brand A"}})
+	assert.ErrorIs(t, err, ErrTenantExists)
+	// Omitted allowed operations are stored as NULL, every operation is allowed
+	args := mdb.Calls[0].Arguments.Get(2).([]interface{})
+	assert.Nil(t, args[2])
+}
@@ -0,0 +1,90 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// ErrForbiddenUrl is returned for an endpoint url that does not resolve to public addresses only
+var ErrForbiddenUrl = errors.New("webhook url does not resolve to a public address")
+
+// allowPrivate lets endpoints on private networks and the loopback receive events, for local development
+func allowPrivate() bool {
+	viper.AutomaticEnv()
+	viper.SetDefault("WEBHOOK_ALLOW_PRIVATE", false)
+	return viper.GetBool("WEBHOOK_ALLOW_PRIVATE")
+}
+
+// isPublic reports whether events may be sent to ip: not a loopback, private, shared,
+// link-local, multicast or unspecified address
+func isPublic(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsMulticast() || sharedAddressSpace.Contains(ip) {
+		return false
+	}
+	return true
+}
+
+// sharedAddressSpace is the carrier-grade NAT range of RFC 6598
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// checkUrl resolves the host of an endpoint url and rejects it unless every address is public
+func checkUrl(ctx context.Context, rawUrl string) error {
+	if allowPrivate() {
+		return nil
+	}
+	u, err := url.Parse(rawUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return ErrForbiddenUrl
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return ErrForbiddenUrl
+	}
+	for _, addr := range addrs {
+		if !isPublic(addr.IP) {
+			return ErrForbiddenUrl
+		}
+	}
+	return nil
+}
+
+// checkDial refuses connections to addresses that are not public. It runs on the
+// address actually dialed, so a host that resolves differently after registration is caught too
+func checkDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublic(ip) {
+		return ErrForbiddenUrl
+	}
+	return nil
+}
+
+// newClient returns the client deliveries are sent with. It dials public addresses
+// only, goes through no proxy and does not follow redirects, a 3xx fails the attempt
+func newClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate() {
+		dialer.Control = checkDial
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConnsPerHost: concurrency,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
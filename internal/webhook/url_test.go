@@ -0,0 +1,86 @@
+package webhook
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"wallet-api-server/internal/db/dbtest"
+	"wallet-api-server/internal/models"
+)
+
+func TestIsPublic(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"100.64.0.1", false},
+		{"169.254.169.254", false},
+		{"0.0.0.0", false},
+		{"::1", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.public, isPublic(net.ParseIP(tt.ip)), tt.ip)
+	}
+}
+
+func TestCheckUrl(t *testing.T) {
+	assert.NoError(t, checkUrl(context.Background(), "https://93.184.216.34/hooks"))
+	for _, u := range []string{
+		"http://127.0.0.1:8080/hooks",
+		"http://localhost/hooks",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::1]/hooks",
+		"http://10.0.0.1/hooks",
+		"ftp://93.184.216.34/hooks",
+	} {
+		assert.ErrorIs(t, checkUrl(context.Background(), u), ErrForbiddenUrl, u)
+	}
+
+	viper.Set("WEBHOOK_ALLOW_PRIVATE", true)
+	defer viper.Set("WEBHOOK_ALLOW_PRIVATE", false)
+	assert.NoError(t, checkUrl(context.Background(), "http://127.0.0.1:8080/hooks"))
+}
+
+func TestCreateEndpoint_RejectsPrivateUrl(t *testing.T) {
+	mdb := new(dbtest.DB)
+	_, err := CreateEndpoint(context.Background(), mdb, models.WebhookEndpointRequest{Url: "http://169.254.169.254/latest"})
+	assert.ErrorIs(t, err, ErrForbiddenUrl)
+	mdb.AssertNotCalled(t, "Exec")
+}
+
+func TestNewClient_RefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	_, err := send(context.Background(), newClient(time.Second), claimedDelivery{Url: server.URL})
+	assert.ErrorIs(t, err, ErrForbiddenUrl)
+}
+
+func TestNewClient_DoesNotFollowRedirects(t *testing.T) {
+	viper.Set("WEBHOOK_ALLOW_PRIVATE", true)
+	defer viper.Set("WEBHOOK_ALLOW_PRIVATE", false)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+	server := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusFound))
+	defer server.Close()
+
+	status, err := send(context.Background(), newClient(time.Second), claimedDelivery{Url: server.URL})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusFound, status)
+}
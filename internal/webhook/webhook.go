@@ -0,0 +1,443 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+
+	"wallet-api-server/internal/db"
+	"wallet-api-server/internal/ledger"
+	"wallet-api-server/internal/models"
+)
+
+var (
+	ErrEndpointNotFound = errors.New("webhook endpoint not found")
+	ErrDeliveryNotFound = errors.New("webhook delivery not found")
+)
+
+const (
+	// batchSize bounds how many ledger entries or deliveries one instance takes at once
+	batchSize = 100
+	// concurrency is how many deliveries of a batch are sent in parallel
+	concurrency = 8
+	maxBackoff  = time.Hour
+)
+
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	EventIdHeader   = "X-Webhook-Event-Id"
+	EventTypeHeader = "X-Webhook-Event-Type"
+	DeliveryHeader  = "X-Webhook-Delivery-Id"
+)
+
+func maxAttempts() int {
+	viper.AutomaticEnv()
+	viper.SetDefault("WEBHOOK_MAX_ATTEMPTS", 8)
+	return viper.GetInt("WEBHOOK_MAX_ATTEMPTS")
+}
+
+func backoffBase() time.Duration {
+	viper.AutomaticEnv()
+	viper.SetDefault("WEBHOOK_BACKOFF_BASE", 10)
+	return time.Duration(viper.GetInt("WEBHOOK_BACKOFF_BASE")) * time.Second
+}
+
+func timeout() time.Duration {
+	viper.AutomaticEnv()
+	viper.SetDefault("WEBHOOK_TIMEOUT", 10)
+	return time.Duration(viper.GetInt("WEBHOOK_TIMEOUT")) * time.Second
+}
+
+// backoff is the wait before the next attempt after attempts failed ones,
+// doubling from the base up to an hour
+func backoff(attempts int) time.Duration {
+	d := backoffBase()
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxBackoff)
+}
+
+// Sign returns the signature header value for a payload sent at t. Receivers
+// recompute the HMAC-SHA256 of "<t>.<payload>" with the endpoint secret
+func Sign(secret string, t time.Time, payload []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(payload)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+const endpointColumns = "id, url, wallet_id, active, created_at, tenant_id"
+
+func scanEndpoint(row db.RowScanner, e *models.WebhookEndpoint) error {
+	return row.Scan(&e.Id, &e.Url, &e.WalletId, &e.Active, &e.CreatedAt, &e.TenantId)
+}
+
+// CreateEndpoint registers an endpoint with a new signing secret, the only time the secret is returned
+func CreateEndpoint(ctx context.Context, dbProvider db.DBProvider, req models.WebhookEndpointRequest) (models.WebhookEndpoint, error) {
+	if err := checkUrl(ctx, req.Url); err != nil {
+		return models.WebhookEndpoint{}, err
+	}
+	secret, err := newSecret()
+	if err != nil {
+		return models.WebhookEndpoint{}, err
+	}
+	e := models.WebhookEndpoint{Id: uuid.New(), Url: req.Url, Secret: secret, Active: true, CreatedAt: time.Now().UTC(), TenantId: req.TenantId}
+	if req.WalletId != "" {
+		walletId := uuid.MustParse(req.WalletId)
+		e.WalletId = &walletId
+	}
+	_, err = dbProvider.Exec(ctx, "INSERT INTO webhook_endpoints (id, url, secret, wallet_id, active, created_at, tenant_id) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		e.Id, e.Url, e.Secret, e.WalletId, e.Active, e.CreatedAt, e.TenantId)
+	return e, err
+}
+
+func ListEndpoints(ctx context.Context, q db.Querier, tenantId string) ([]models.WebhookEndpoint, error) {
+	return listEndpoints(ctx, q, "active AND tenant_id=$1", tenantId)
+}
+
+// listEndpoints returns the endpoints matching the condition on webhook_endpoints
+func listEndpoints(ctx context.Context, q db.Querier, where string, args ...interface{}) ([]models.WebhookEndpoint, error) {
+	rows, err := q.Query(ctx, "SELECT "+endpointColumns+" FROM webhook_endpoints WHERE "+where+" ORDER BY created_at, id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	endpoints := []models.WebhookEndpoint{}
+	for rows.Next() {
+		var e models.WebhookEndpoint
+		if err := scanEndpoint(rows, &e); err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, e)
+	}
+	return endpoints, rows.Err()
+}
+
+// DeleteEndpoint deactivates an endpoint, its pending deliveries are given up
+func DeleteEndpoint(ctx context.Context, dbProvider db.DBProvider, tenantId string, id uuid.UUID) error {
+	var deleted uuid.UUID
+	err := dbProvider.QueryRow(ctx, "UPDATE webhook_endpoints SET active=false WHERE id=$1 AND tenant_id=$2 AND active RETURNING id", id, tenantId).Scan(&deleted)
+	if err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return ErrEndpointNotFound
+		}
+		return err
+	}
+	_, err = dbProvider.Exec(ctx, "UPDATE webhook_deliveries SET status=$1, last_error=$2 WHERE endpoint_id=$3 AND tenant_id=$4 AND status=$5",
+		models.DELIVERY_DEAD, "endpoint deleted", id, tenantId, models.DELIVERY_PENDING)
+	return err
+}
+
+const deliveryColumns = "id, endpoint_id, event_id, event_type, status, attempts, next_attempt_at, last_error, last_status_code, created_at, delivered_at"
+
+// deliveryColumnsD are the delivery columns for statements that alias the deliveries as d
+const deliveryColumnsD = "d.id, d.endpoint_id, d.event_id, d.event_type, d.status, d.attempts, d.next_attempt_at, d.last_error, d.last_status_code, d.created_at, d.delivered_at"
+
+func scanDelivery(row db.RowScanner, d *models.WebhookDelivery) error {
+	return row.Scan(&d.Id, &d.EndpointId, &d.EventId, &d.EventType, &d.Status, &d.Attempts, &d.NextAttemptAt,
+		&d.LastError, &d.LastStatusCode, &d.CreatedAt, &d.DeliveredAt)
+}
+
+// ListDeliveries returns the newest deliveries of an endpoint, an empty status selects all of them.
+// Listing the DEAD ones gives the dead-letter list
+func ListDeliveries(ctx context.Context, q db.Querier, tenantId string, endpointId uuid.UUID, status models.DeliveryStatus, limit int) ([]models.WebhookDelivery, error) {
+	query := "SELECT " + deliveryColumns + " FROM webhook_deliveries WHERE endpoint_id=$1 AND tenant_id=$2"
+	args := []interface{}{endpointId, tenantId}
+	if status != "" {
+		args = append(args, status)
+		query += fmt.Sprintf(" AND status=$%d", len(args))
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(" ORDER BY created_at DESC, id LIMIT $%d", len(args))
+	rows, err := q.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		var d models.WebhookDelivery
+		if err := scanDelivery(rows, &d); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// Redeliver schedules a delivery of an active endpoint to be sent again right away
+// with a fresh set of retries, whatever its current status
+func Redeliver(ctx context.Context, dbProvider db.DBProvider, tenantId string, endpointId, id uuid.UUID) (models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	err := scanDelivery(dbProvider.QueryRow(ctx, `
+		UPDATE webhook_deliveries d SET status=$1, attempts=0, next_attempt_at=$2, claimed_until=NULL
+		FROM webhook_endpoints e
+		WHERE d.id=$3 AND d.endpoint_id=$4 AND d.tenant_id=$5 AND e.id=d.endpoint_id AND e.active
+		RETURNING `+deliveryColumnsD,
+		models.DELIVERY_PENDING, time.Now().UTC(), id, endpointId, tenantId), &d)
+	if err != nil && errors.Is(err, db.ErrNoRows) {
+		return d, ErrDeliveryNotFound
+	}
+	return d, err
+}
+
+// Start publishes new ledger entries to the registered endpoints and sends due
+// deliveries every interval until ctx is cancelled. It runs apart from the wallet
+// queues, any number of server instances may run it
+func Start(ctx context.Context, dbProvider db.DBProvider, interval time.Duration) {
+	client := newClient(timeout())
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				for fanOut(ctx, dbProvider) == batchSize && ctx.Err() == nil {
+				}
+				for deliverDue(ctx, dbProvider, client) == batchSize && ctx.Err() == nil {
+				}
+			}
+		}
+	}()
+}
+
+// fanOut turns one batch of unpublished ledger entries into deliveries for every
+// endpoint of the wallet's tenant subscribed to their wallet and returns how many entries it took
+func fanOut(ctx context.Context, dbProvider db.DBProvider) int {
+	n, err := publish(ctx, dbProvider)
+	if err != nil {
+		log.Printf("Failed to publish webhook events: %v", err)
+	}
+	return n
+}
+
+func publish(ctx context.Context, dbProvider db.DBProvider) (int, error) {
+	tx, err := dbProvider.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			if rollbackErr := tx.Rollback(ctx); rollbackErr != nil {
+				log.Printf("Failed to rollback transaction: %v", rollbackErr)
+			}
+		}
+	}()
+
+	entries, err := ledger.ClaimUnpublished(ctx, tx, batchSize)
+	if err != nil || len(entries) == 0 {
+		return 0, err
+	}
+	tenants, err := walletTenants(ctx, tx, entries)
+	if err != nil {
+		return 0, err
+	}
+	endpoints, err := listEndpoints(ctx, tx, "active")
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UTC()
+	ids := make([]int64, len(entries))
+	for i, t := range entries {
+		ids[i] = t.Id
+		event := models.NewBalanceChangedEvent(t)
+		var payload []byte
+		for _, e := range endpoints {
+			if e.TenantId != tenants[t.WalletId] || e.WalletId != nil && *e.WalletId != t.WalletId {
+				continue
+			}
+			if payload == nil {
+				if payload, err = json.Marshal(event); err != nil {
+					return 0, err
+				}
+			}
+			_, err = tx.Exec(ctx, "INSERT INTO webhook_deliveries (id, endpoint_id, event_id, event_type, payload, status, next_attempt_at, created_at, tenant_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+				uuid.New(), e.Id, event.Id, event.Type, string(payload), models.DELIVERY_PENDING, now, now, e.TenantId)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	if err = ledger.MarkPublished(ctx, tx, ids); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	committed = true
+	return len(entries), nil
+}
+
+// walletTenants maps the wallets of the entries to their tenants
+func walletTenants(ctx context.Context, tx db.TxProvider, entries []models.Transaction) (map[uuid.UUID]string, error) {
+	walletIds := make([]uuid.UUID, len(entries))
+	for i, t := range entries {
+		walletIds[i] = t.WalletId
+	}
+	rows, err := tx.Query(ctx, "SELECT DISTINCT wallet_id, tenant_id FROM wallets WHERE wallet_id = ANY($1)", walletIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tenants := make(map[uuid.UUID]string)
+	for rows.Next() {
+		var walletId uuid.UUID
+		var tenantId string
+		if err := rows.Scan(&walletId, &tenantId); err != nil {
+			return nil, err
+		}
+		tenants[walletId] = tenantId
+	}
+	return tenants, rows.Err()
+}
+
+// claimedDelivery is a delivery taken by this instance together with what is needed to send it
+type claimedDelivery struct {
+	models.WebhookDelivery
+	Payload string
+	Url     string
+	Secret  string
+}
+
+// deliverDue sends one batch of due deliveries and returns how many it claimed
+func deliverDue(ctx context.Context, dbProvider db.DBProvider, client *http.Client) int {
+	claimedUntil := time.Now().UTC().Add(2 * timeout()).Truncate(time.Microsecond)
+	deliveries, err := claim(ctx, dbProvider, claimedUntil)
+	if err != nil {
+		log.Printf("Failed to claim webhook deliveries: %v", err)
+		return 0
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for _, d := range deliveries {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(d claimedDelivery) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			statusCode, err := send(ctx, client, d)
+			if err := finish(ctx, dbProvider, d, claimedUntil, statusCode, err); err != nil {
+				log.Printf("Failed to record webhook delivery %s: %v", d.Id, err)
+			}
+		}(d)
+	}
+	wg.Wait()
+	return len(deliveries)
+}
+
+func claim(ctx context.Context, dbProvider db.DBProvider, claimedUntil time.Time) ([]claimedDelivery, error) {
+	now := time.Now().UTC()
+	rows, err := dbProvider.Query(ctx, `
+		UPDATE webhook_deliveries d SET claimed_until=$1
+		FROM webhook_endpoints e
+		WHERE e.id=d.endpoint_id AND d.id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status=$2 AND next_attempt_at <= $3 AND (claimed_until IS NULL OR claimed_until <= $3)
+			ORDER BY next_attempt_at
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+deliveryColumnsD+`, d.payload, e.url, e.secret`,
+		claimedUntil, models.DELIVERY_PENDING, now, batchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var deliveries []claimedDelivery
+	for rows.Next() {
+		var d claimedDelivery
+		if err := rows.Scan(&d.Id, &d.EndpointId, &d.EventId, &d.EventType, &d.Status, &d.Attempts, &d.NextAttemptAt, &d.LastError,
+			&d.LastStatusCode, &d.CreatedAt, &d.DeliveredAt, &d.Payload, &d.Url, &d.Secret); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// send posts the signed event and returns the response status, any non-2xx status is an error
+func send(ctx context.Context, client *http.Client, d claimedDelivery) (int, error) {
+	payload := []byte(d.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.Url, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(d.Secret, time.Now(), payload))
+	req.Header.Set(EventIdHeader, d.EventId.String())
+	req.Header.Set(EventTypeHeader, d.EventType)
+	req.Header.Set(DeliveryHeader, d.Id.String())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drain a little of the body so that the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded with %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// finish records the attempt: delivered, retried after a backoff, or dead once
+// the attempts are used up. Nothing is written once the claim was taken over
+func finish(ctx context.Context, dbProvider db.DBProvider, d claimedDelivery, claimedUntil time.Time, statusCode int, sendErr error) error {
+	now := time.Now().UTC()
+	attempts := d.Attempts + 1
+	status := models.DELIVERY_DELIVERED
+	nextAttemptAt := d.NextAttemptAt
+	var lastError, lastStatusCode, deliveredAt interface{}
+	if statusCode != 0 {
+		lastStatusCode = statusCode
+	}
+	if sendErr != nil {
+		lastError = sendErr.Error()
+		status = models.DELIVERY_PENDING
+		nextAttemptAt = now.Add(backoff(attempts))
+		if attempts >= maxAttempts() {
+			status = models.DELIVERY_DEAD
+		}
+	} else {
+		deliveredAt = now
+	}
+	_, err := dbProvider.Exec(ctx, `
+		UPDATE webhook_deliveries SET status=$1, attempts=$2, next_attempt_at=$3, last_error=$4, last_status_code=$5,
+			delivered_at=$6, claimed_until=NULL
+		WHERE id=$7 AND claimed_until=$8`,
+		status, attempts, nextAttemptAt, lastError, lastStatusCode, deliveredAt, d.Id, claimedUntil)
+	return err
+}
@@ -0,0 +1,138 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"wallet-api-server/internal/db/dbtest"
+	"wallet-api-server/internal/ledger"
+	"wallet-api-server/internal/models"
+)
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, 10*time.Second, backoff(1))
+	assert.Equal(t, 20*time.Second, backoff(2))
+	assert.Equal(t, 80*time.Second, backoff(4))
+	assert.Equal(t, time.Hour, backoff(20))
+}
+
+func TestSend_SignsPayload(t *testing.T) {
+	d := claimedDelivery{Payload: `{"id":"1"}`, Secret: "whsec_test"}
+	d.Id, d.EventId, d.EventType = uuid.New(), uuid.New(), models.EVENT_BALANCE_CHANGED
+	var got *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+	d.Url = server.URL
+
+	status, err := send(context.Background(), server.Client(), d)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, d.Payload, string(body))
+	assert.Equal(t, d.EventId.String(), got.Header.Get(EventIdHeader))
+	assert.Equal(t, d.Id.String(), got.Header.Get(DeliveryHeader))
+
+	// The receiver recomputes the signature from the timestamp it was given
+	signature := got.Header.Get(SignatureHeader)
+	ts, err := strconv.ParseInt(strings.TrimPrefix(strings.Split(signature, ",")[0], "t="), 10, 64)
+	assert.NoError(t, err)
+	assert.Equal(t, Sign("whsec_test", time.Unix(ts, 0), body), signature)
+	assert.NotEqual(t, Sign("other", time.Unix(ts, 0), body), signature)
+}
+
+func TestSend_ErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	status, err := send(context.Background(), server.Client(), claimedDelivery{Url: server.URL})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+}
+
+func TestFinish(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempts int
+		sendErr  error
+		want     models.DeliveryStatus
+	}{
+		{"delivered", 0, nil, models.DELIVERY_DELIVERED},
+		{"retried", 2, io.ErrUnexpectedEOF, models.DELIVERY_PENDING},
+		{"dead after the last attempt", 7, io.ErrUnexpectedEOF, models.DELIVERY_DEAD},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mdb := new(dbtest.DB)
+			mdb.On("Exec", mock.Anything, dbtest.IsQuery("UPDATE webhook_deliveries"), mock.Anything).Return(nil, nil)
+			d := claimedDelivery{}
+			d.Id, d.Attempts, d.NextAttemptAt = uuid.New(), tt.attempts, time.Now()
+
+			assert.NoError(t, finish(context.Background(), mdb, d, time.Now(), 0, tt.sendErr))
+			mdb.AssertCalled(t, "Exec", mock.Anything, mock.Anything, mock.MatchedBy(func(args []interface{}) bool {
+				return args[0] == tt.want && args[1] == tt.attempts+1 && args[6] == d.Id
+			}))
+		})
+	}
+}
+
+func entry(id int64, walletId uuid.UUID) []interface{} {
+	return dbtest.RowOf(ledger.Columns, map[string]interface{}{
+		"id": id, "operation_id": uuid.New(), "wallet_id": walletId, "operation_type": models.DEPOSIT, "currency": "USD",
+		"amount": decimal.NewFromInt(5), "balance_after": decimal.NewFromInt(5), "created_at": time.Now(),
+	})
+}
+
+func TestPublish_FansOutToSubscribedEndpoints(t *testing.T) {
+	walletA, walletB := uuid.New(), uuid.New()
+	all, onlyB, otherTenant := uuid.New(), uuid.New(), uuid.New()
+	mdb := new(dbtest.DB)
+	mtx := new(dbtest.Tx)
+	mdb.On("Begin", mock.Anything).Return(mtx, nil)
+	mtx.On("Query", mock.Anything, dbtest.IsQuery("SELECT "+ledger.Columns+" FROM wallet_transactions WHERE published_at IS NULL"), mock.Anything).Return(dbtest.NewRows(
+		entry(1, walletA), entry(2, walletB),
+	), nil)
+	mtx.On("Query", mock.Anything, dbtest.IsQuery("SELECT DISTINCT wallet_id, tenant_id"), mock.Anything).Return(dbtest.NewRows(
+		[]interface{}{walletA, models.DefaultTenant}, []interface{}{walletB, models.DefaultTenant},
+	), nil)
+	// An endpoint of another tenant gets nothing, even when it names the wallet
+	mtx.On("Query", mock.Anything, dbtest.IsQuery("SELECT "+endpointColumns+" FROM webhook_endpoints"), mock.Anything).Return(dbtest.NewRows(
+		[]interface{}{all, "http://all", nil, true, time.Now(), models.DefaultTenant},
+		[]interface{}{onlyB, "http://b", walletB, true, time.Now(), models.DefaultTenant},
+		[]interface{}{otherTenant, "http://other", walletB, true, time.Now(), "brand-a"},
+	), nil)
+	mtx.On("Exec", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+	mtx.On("Commit", mock.Anything).Return(nil)
+
+	n, err := publish(context.Background(), mdb)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	deliveries := map[uuid.UUID]int{}
+	for _, call := range mtx.Calls {
+		if call.Method == "Exec" && strings.HasPrefix(call.Arguments.String(1), "INSERT INTO webhook_deliveries") {
+			args := call.Arguments.Get(2).([]interface{})
+			deliveries[args[1].(uuid.UUID)]++
+			assert.Equal(t, models.DefaultTenant, args[8])
+		}
+	}
+	assert.Equal(t, map[uuid.UUID]int{all: 2, onlyB: 1}, deliveries)
+	mtx.AssertCalled(t, "Exec", mock.Anything, dbtest.IsQuery("UPDATE wallet_transactions SET published_at"), mock.MatchedBy(func(args []interface{}) bool {
+		return reflect.DeepEqual(args[1], []int64{1, 2})
+	}))
+}